@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// API KEYS
+// ========================================
+//
+// Integrations and machine clients (delivery aggregators, accounting sync,
+// kitchen screens) authenticate with an API key in the X-API-Key header
+// instead of a staff member's token. Each key acts as its own service user,
+// so orders it creates and audit entries it causes are attributed to that
+// user, with the key recorded on the audit entry as well.
+//
+// A key reaches only the routes its scopes list; role permissions don't
+// apply to it. Keys can expire, carry their own rate limit and be rotated
+// with a grace period in which the old secret still works. Only a hash of
+// the secret is stored and the secret is shown once.
+
+// APIKeyHeader carries the API key on machine requests
+const APIKeyHeader = "X-API-Key"
+
+// ServiceRole is the role of the service users behind API keys. It grants
+// no permissions; scopes decide what a key may do.
+const ServiceRole = "service"
+
+// apiKeyPrefix starts every key so leaked keys are easy to recognise
+const apiKeyPrefix = "rpos_"
+
+// API key errors
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyRevoked = errors.New("API key has been revoked")
+	ErrAPIKeyExpired = errors.New("API key has expired")
+)
+
+// APIKeyScope is a named set of routes a key may call, as "METHOD path"
+// with gin route patterns. A trailing "/*" matches every route below.
+type APIKeyScope struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Routes      []string `json:"routes"`
+}
+
+// APIKeyScopes lists the scopes keys can be given
+var APIKeyScopes = []APIKeyScope{
+	{Name: "menu:read", Description: "Read the menu and restaurant settings", Routes: []string{
+		"GET /api/settings",
+		"GET /api/menu/categories",
+		"GET /api/menu/categories/:id",
+		"GET /api/menu/items",
+		"GET /api/menu/items/:id",
+		"GET /api/menu/modifiers",
+		"GET /api/menu/combos",
+	}},
+	{Name: "orders:read", Description: "Read orders, their documents and tables, and replay events", Routes: []string{
+		"GET /api/orders",
+		"GET /api/orders/:id",
+		"GET /api/orders/:id/documents/:kind",
+		"GET /api/tables",
+		"GET /api/tables/:id",
+		"GET /api/events",
+	}},
+	{Name: "orders:write", Description: "Create orders, change their items and update their status", Routes: []string{
+		"POST /api/orders",
+		"PUT /api/orders/:id",
+		"PUT /api/orders/:id/status",
+		"POST /api/orders/:id/items",
+		"PUT /api/orders/:id/items/:itemId",
+	}},
+	{Name: "payments:read", Description: "Read payments", Routes: []string{
+		"GET /api/payments",
+	}},
+	{Name: "reports:read", Description: "Read and export reports", Routes: []string{
+		"GET /api/reports/*",
+	}},
+}
+
+// findAPIKeyScope returns the scope called name
+func findAPIKeyScope(name string) *APIKeyScope {
+	for i := range APIKeyScopes {
+		if APIKeyScopes[i].Name == name {
+			return &APIKeyScopes[i]
+		}
+	}
+	return nil
+}
+
+// allows reports whether the scope covers the request's route
+func (s *APIKeyScope) allows(method, fullPath string) bool {
+	for _, route := range s.Routes {
+		routeMethod, path, _ := strings.Cut(route, " ")
+		if routeMethod != method {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(path, "/*"); ok {
+			if strings.HasPrefix(fullPath, prefix+"/") {
+				return true
+			}
+		} else if path == fullPath {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeList returns the key's scopes
+func (k *APIKey) ScopeList() []string {
+	var scopes []string
+	json.Unmarshal([]byte(k.Scopes), &scopes)
+	return scopes
+}
+
+// Allows reports whether one of the key's scopes covers the route
+func (k *APIKey) Allows(method, fullPath string) bool {
+	for _, name := range k.ScopeList() {
+		if scope := findAPIKeyScope(name); scope != nil && scope.allows(method, fullPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIKeySecret returns a random key
+func newAPIKeySecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// displayPrefix is the part of a key shown in listings
+func displayPrefix(secret string) string {
+	return secret[:len(apiKeyPrefix)+6]
+}
+
+// AuthenticateAPIKey resolves a key, accepting the previous secret of a
+// rotated key until its grace period ends
+func (a *App) AuthenticateAPIKey(secret string) (*APIKey, error) {
+	hash := hashToken(secret)
+	now := time.Now()
+
+	var key APIKey
+	if err := a.DB.Preload("User").
+		Where("key_hash = ? OR (previous_key_hash = ? AND previous_expires_at > ?)", hash, hash, now).
+		First(&key).Error; err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	switch {
+	case key.RevokedAt != nil, key.User == nil, !key.User.IsActive:
+		return nil, ErrAPIKeyRevoked
+	case key.ExpiresAt != nil && !now.Before(*key.ExpiresAt):
+		return nil, ErrAPIKeyExpired
+	}
+	return &key, nil
+}
+
+// authenticateAPIKeyRequest is AuthMiddleware for requests carrying an API
+// key: it checks the key and its scopes and signs the request in as the
+// key's service user
+func (a *App) authenticateAPIKeyRequest(c *gin.Context, secret string) {
+	key, err := a.AuthenticateAPIKey(secret)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+	if !key.Allows(c.Request.Method, c.FullPath()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key scope does not allow this request", "scopes": key.ScopeList()})
+		c.Abort()
+		return
+	}
+
+	// Recording every request would be a write per call; a minute is close enough
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		a.DB.Model(key).UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": c.ClientIP()})
+	}
+
+	c.Set("user_id", key.UserID)
+	c.Set("role", ServiceRole)
+	c.Set("email", key.User.Email)
+	c.Set("api_key_id", key.ID)
+	if !a.attachDevice(c) {
+		return
+	}
+	if key.RateLimitPerMinute > 0 && key.RateLimitBurst > 0 {
+		c.Set("rate_limit", RateLimit{PerMinute: key.RateLimitPerMinute, Burst: key.RateLimitBurst})
+	}
+	c.Next()
+}
+
+// ========================================
+// API KEY HANDLERS
+// ========================================
+
+// APIKeyRequest is the body of API key create and update requests
+type APIKeyRequest struct {
+	Name               string     `json:"name"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute *int       `json:"rate_limit_per_minute"`
+	RateLimitBurst     *int       `json:"rate_limit_burst"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+}
+
+// apply validates the request and copies it onto key
+func (r *APIKeyRequest) apply(key *APIKey) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(r.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range r.Scopes {
+		if findAPIKeyScope(scope) == nil {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	if r.RateLimitPerMinute != nil {
+		if *r.RateLimitPerMinute < 0 {
+			return errors.New("rate_limit_per_minute cannot be negative")
+		}
+		key.RateLimitPerMinute = *r.RateLimitPerMinute
+	}
+	if r.RateLimitBurst != nil {
+		if *r.RateLimitBurst < 0 {
+			return errors.New("rate_limit_burst cannot be negative")
+		}
+		key.RateLimitBurst = *r.RateLimitBurst
+	}
+
+	scopes, _ := json.Marshal(r.Scopes)
+	key.Name = r.Name
+	key.Scopes = string(scopes)
+	key.ExpiresAt = r.ExpiresAt
+	return nil
+}
+
+// HandleGetAPIKeyScopes lists the scopes keys can be given
+func (a *App) HandleGetAPIKeyScopes(c *gin.Context) {
+	c.JSON(http.StatusOK, APIKeyScopes)
+}
+
+// HandleGetAPIKeys lists API keys
+func (a *App) HandleGetAPIKeys(c *gin.Context) {
+	var keys []APIKey
+	if err := a.DB.Order("id ASC").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// HandleCreateAPIKey creates a key and its service user. The secret is only
+// returned here.
+func (a *App) HandleCreateAPIKey(c *gin.Context) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	key := APIKey{CreatedBy: c.GetUint("user_id")}
+	if err := req.apply(&key); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key"})
+		return
+	}
+	// The service user can't sign in: its password is random and discarded
+	password, err := newAPIKeySecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key"})
+		return
+	}
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key"})
+		return
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	user := User{
+		Name:      key.Name,
+		Email:     "api-key-" + hex.EncodeToString(suffix) + "@service.invalid",
+		Password:  passwordHash,
+		Role:      ServiceRole,
+		IsActive:  true,
+		IsService: true,
+	}
+	key.Prefix = displayPrefix(secret)
+	key.KeyHash = hashToken(secret)
+
+	if err := a.DB.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key", Message: err.Error()})
+		return
+	}
+	key.UserID = user.ID
+	if err := a.DB.Create(&key).Error; err != nil {
+		a.DB.Delete(&user)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key", Message: err.Error()})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "api_key_created", Entity: "api_key", EntityID: key.ID, After: key})
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "key": secret})
+}
+
+// HandleUpdateAPIKey changes a key's name, scopes, rate limit or expiry
+func (a *App) HandleUpdateAPIKey(c *gin.Context) {
+	var key APIKey
+	if err := a.DB.First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "API key not found"})
+		return
+	}
+	before := key
+
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := req.apply(&key); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := a.DB.Save(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update API key"})
+		return
+	}
+	a.DB.Model(&User{}).Where("id = ?", key.UserID).Update("name", key.Name)
+	a.Audit(c, AuditEntry{Action: "api_key_updated", Entity: "api_key", EntityID: key.ID, Before: before, After: key})
+
+	c.JSON(http.StatusOK, key)
+}
+
+// RotateAPIKeyRequest is the body of a key rotation
+type RotateAPIKeyRequest struct {
+	GraceMinutes int `json:"grace_minutes"` // how long the old secret keeps working
+}
+
+// HandleRotateAPIKey issues a new secret for a key. The old one keeps
+// working for the grace period so clients can switch over.
+func (a *App) HandleRotateAPIKey(c *gin.Context) {
+	var key APIKey
+	if err := a.DB.First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "API key not found"})
+		return
+	}
+	if key.RevokedAt != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "API key has been revoked"})
+		return
+	}
+	var req RotateAPIKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+			return
+		}
+	}
+	if req.GraceMinutes < 0 || req.GraceMinutes > 7*24*60 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: "grace_minutes must be between 0 and 10080"})
+		return
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to rotate API key"})
+		return
+	}
+	key.PreviousKeyHash = nil
+	key.PreviousExpiresAt = nil
+	if req.GraceMinutes > 0 {
+		previous := key.KeyHash
+		until := time.Now().Add(time.Duration(req.GraceMinutes) * time.Minute)
+		key.PreviousKeyHash = &previous
+		key.PreviousExpiresAt = &until
+	}
+	key.Prefix = displayPrefix(secret)
+	key.KeyHash = hashToken(secret)
+	if err := a.DB.Save(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to rotate API key"})
+		return
+	}
+	a.Audit(c, AuditEntry{
+		Action:   "api_key_rotated",
+		Entity:   "api_key",
+		EntityID: key.ID,
+		Details:  gin.H{"prefix": key.Prefix, "grace_minutes": req.GraceMinutes},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"api_key": key, "key": secret})
+}
+
+// HandleRevokeAPIKey revokes a key for good and deactivates its service user
+func (a *App) HandleRevokeAPIKey(c *gin.Context) {
+	var key APIKey
+	if err := a.DB.First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "API key not found"})
+		return
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		key.PreviousKeyHash = nil
+		key.PreviousExpiresAt = nil
+		if err := a.DB.Save(&key).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke API key"})
+			return
+		}
+		a.DB.Model(&User{}).Where("id = ?", key.UserID).Update("is_active", false)
+		a.Audit(c, AuditEntry{Action: "api_key_revoked", Entity: "api_key", EntityID: key.ID})
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "API key revoked",
+	})
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ========================================
+// AUDIT TRAIL
+// ========================================
+//
+// Changes are recorded by the handlers that make them, through Audit, which
+// adds the acting user, session, API key, device, request ID, IP and user
+// agent. Entries with a before and after state store only the fields that
+// changed. Work outside a request (scheduled jobs, lockouts) uses
+// CreateAuditLog. Mutating requests that finish without recording anything
+// are still logged by LoggingMiddleware under their route.
+//
+// Entries form a hash chain: each one stores the previous entry's hash and
+// a SHA-256 over its own contents and that hash, so editing or deleting an
+// entry breaks verification from that entry on. The newest entry is also
+// recorded in the audit_chain_heads row, which is locked while appending so
+// entries are chained one at a time even across server processes, and which
+// no longer matches if the newest entries are removed.
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// auditedKey marks a request that recorded its own audit entry
+const auditedKey = "audited"
+
+// redactedFields are never stored in diffs, only flagged as changed
+var redactedFields = []string{"password", "secret", "token", "pin_hash", "api_key"}
+
+// AuditEntry is a change to record from a request
+type AuditEntry struct {
+	Action     string
+	Entity     string
+	EntityID   uint
+	Before     interface{} // state before the change, nil for creates
+	After      interface{} // state after the change, nil for deletes
+	Details    interface{} // anything else worth keeping, stored as metadata
+	ApproverID uint        // manager who approved the action, if not the actor
+	UserID     uint        // actor when nobody is signed in yet (PIN login)
+}
+
+// AuditChange is one changed field
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// auditChainHeadID is the ID of the single chain head row
+const auditChainHeadID = 1
+
+// AuditTrail appends hash-chained entries to the audit log
+type AuditTrail struct {
+	db *gorm.DB
+}
+
+// NewAuditTrail creates the audit trail
+func NewAuditTrail(db *gorm.DB) *AuditTrail {
+	return &AuditTrail{db: db}
+}
+
+// Append links entry to the newest entry and stores it. The chain head row
+// is locked until the transaction commits, so there is one writer at a time.
+func (t *AuditTrail) Append(entry *AuditLog) error {
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		head := AuditChainHead{ID: auditChainHeadID}
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&head)
+		if created.Error != nil {
+			return created.Error
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, auditChainHeadID).Error; err != nil {
+			return err
+		}
+		// A new head row continues the chain already in the table
+		if created.RowsAffected == 1 {
+			var last AuditLog
+			if err := tx.Select("id", "hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+			head.EntryID, head.Hash = last.ID, last.Hash
+		}
+
+		// MySQL keeps whole seconds; hash what will be read back
+		entry.CreatedAt = time.Now().Truncate(time.Second)
+		entry.PrevHash = head.Hash
+		entry.Hash = entry.computeHash()
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		return tx.Model(&head).Updates(map[string]interface{}{
+			"entry_id": entry.ID,
+			"hash":     entry.Hash,
+		}).Error
+	})
+}
+
+// AuditVerification is the result of checking the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt *uint  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify walks the chain from the first hashed entry and reports the first
+// entry that doesn't match, or that the newest entries are missing.
+// Entries written before hashing was introduced are skipped.
+func (t *AuditTrail) Verify() (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+	var lastID uint
+	prev := ""
+	started := false
+
+	// Read the head first; entries appended during the walk come after it
+	var head AuditChainHead
+	if err := t.db.Where("id = ?", auditChainHeadID).Limit(1).Find(&head).Error; err != nil {
+		return nil, err
+	}
+	sawHead := head.EntryID == 0
+
+	for {
+		var batch []AuditLog
+		if err := t.db.Where("id > ?", lastID).Order("id ASC").Limit(500).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			if !sawHead {
+				id := head.EntryID
+				result.Valid = false
+				result.BrokenAt = &id
+				result.Reason = "the newest entries were removed"
+			}
+			return result, nil
+		}
+
+		for i := range batch {
+			entry := &batch[i]
+			lastID = entry.ID
+			if !started {
+				if entry.Hash == "" {
+					continue
+				}
+				started = true
+				prev = entry.PrevHash
+			}
+
+			result.Checked++
+			reason := ""
+			switch {
+			case entry.PrevHash != prev:
+				reason = "previous hash doesn't match; an entry before this one was changed or removed"
+			case entry.Hash != entry.computeHash():
+				reason = "contents don't match the hash; this entry was changed"
+			case entry.ID == head.EntryID && entry.Hash != head.Hash:
+				reason = "doesn't match the chain head; this entry was replaced"
+			}
+			if reason != "" {
+				id := entry.ID
+				result.Valid = false
+				result.BrokenAt = &id
+				result.Reason = reason
+				return result, nil
+			}
+			prev = entry.Hash
+			if entry.ID == head.EntryID {
+				sawHead = true
+			}
+		}
+	}
+}
+
+// computeHash hashes the entry's contents with the previous hash. JSON
+// columns are hashed in canonical form since the database may reformat them.
+func (l *AuditLog) computeHash() string {
+	fields := []interface{}{
+		l.PrevHash,
+		l.UserID,
+		uintValue(l.ApproverID),
+		l.Action,
+		l.Entity,
+		uintValue(l.EntityID),
+		canonicalJSON(l.Changes),
+		canonicalJSON(l.Metadata),
+		l.RequestID,
+		uintValue(l.SessionID),
+		l.IPAddress,
+		l.UserAgent,
+		l.CreatedAt.Unix(),
+	}
+	// Added later; left out when unset so older entries still verify
+	if l.APIKeyID != nil {
+		fields = append(fields, "api_key", *l.APIKeyID)
+	}
+	if l.DeviceID != nil {
+		fields = append(fields, "device", *l.DeviceID)
+	}
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ========================================
+// RECORDING
+// ========================================
+
+// Audit records a change made by the current request
+func (a *App) Audit(c *gin.Context, e AuditEntry) {
+	entry := &AuditLog{
+		UserID:    c.GetUint("user_id"),
+		Action:    e.Action,
+		Entity:    e.Entity,
+		Metadata:  a.InterfaceToJSON(e.Details),
+		RequestID: c.GetString("request_id"),
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if e.UserID != 0 {
+		entry.UserID = e.UserID
+	}
+	if e.EntityID != 0 {
+		entry.EntityID = &e.EntityID
+	}
+	if e.ApproverID != 0 && e.ApproverID != entry.UserID {
+		entry.ApproverID = &e.ApproverID
+	}
+	if sessionID := c.GetUint("session_id"); sessionID != 0 {
+		entry.SessionID = &sessionID
+	}
+	if apiKeyID := c.GetUint("api_key_id"); apiKeyID != 0 {
+		entry.APIKeyID = &apiKeyID
+	}
+	entry.DeviceID = deviceIDFromContext(c)
+	if e.Before != nil || e.After != nil {
+		if changes := DiffChanges(e.Before, e.After); len(changes) > 0 {
+			entry.Changes = a.InterfaceToJSON(changes)
+		}
+	}
+
+	c.Set(auditedKey, true)
+	if err := a.AuditTrail.Append(entry); err != nil {
+		log.Printf("Failed to write audit log %s: %v", e.Action, err)
+	}
+}
+
+// CreateAuditLog records an event outside a request. details is stored as
+// metadata.
+func (a *App) CreateAuditLog(userID uint, action, entity string, entityID *uint, details interface{}) {
+	entry := &AuditLog{
+		UserID:   userID,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Metadata: a.InterfaceToJSON(details),
+	}
+	if err := a.AuditTrail.Append(entry); err != nil {
+		log.Printf("Failed to write audit log %s: %v", action, err)
+	}
+}
+
+// DiffChanges returns the fields that differ between before and after, by
+// JSON name. Either side may be nil for creates and deletes. Nested objects
+// (preloaded associations) are left out; secrets and fields stored encrypted
+// are redacted.
+func DiffChanges(before, after interface{}) map[string]AuditChange {
+	from, to := auditFields(before), auditFields(after)
+	changes := map[string]AuditChange{}
+	encrypted := encryptedFields(before)
+	for key := range encryptedFields(after) {
+		encrypted[key] = true
+	}
+
+	for key := range mergeKeys(from, to) {
+		if key == "updated_at" {
+			continue
+		}
+		old, hadOld := from[key]
+		value, hasNew := to[key]
+		if isNested(old) || isNested(value) {
+			continue
+		}
+		if hadOld && hasNew && reflect.DeepEqual(old, value) {
+			continue
+		}
+		if redacted(key) || encrypted[key] {
+			old, value = "[redacted]", "[redacted]"
+		}
+		changes[key] = AuditChange{From: old, To: value}
+	}
+	return changes
+}
+
+// auditFields flattens a value into its JSON fields
+func auditFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// encryptedFields returns the JSON names of a struct's EncryptedString
+// fields, which hold personal data the audit log mustn't copy in plaintext
+func encryptedFields(v interface{}) map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fields
+	}
+	encryptedType := reflect.TypeOf(EncryptedString(""))
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type != encryptedType && field.Type != reflect.PointerTo(encryptedType) {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+func mergeKeys(a, b map[string]interface{}) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	return keys
+}
+
+// isNested reports whether a JSON value is an object or a list of objects
+func isNested(v interface{}) bool {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		for _, item := range value {
+			if _, ok := item.(map[string]interface{}); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func redacted(key string) bool {
+	for _, field := range redactedFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalJSON re-encodes a JSON document with sorted keys and no spacing
+func canonicalJSON(s string) string {
+	if s == "" {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func uintValue(v *uint) uint {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// ========================================
+// AUDIT HANDLERS
+// ========================================
+
+// HandleGetAuditLogs searches the audit log, newest first
+func (a *App) HandleGetAuditLogs(c *gin.Context) {
+	page := getInt(c.DefaultQuery("page", "1"))
+	limit := getInt(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := a.DB.Model(&AuditLog{})
+	for _, column := range []string{"user_id", "approver_id", "entity", "entity_id", "request_id", "session_id", "api_key_id", "device_id"} {
+		if value := c.Query(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	// action=order.void matches exactly, action=order.* by prefix
+	if action := c.Query("action"); action != "" {
+		if strings.HasSuffix(action, "*") {
+			query = query.Where("action LIKE ?", strings.TrimSuffix(action, "*")+"%")
+		} else {
+			query = query.Where("action = ?", action)
+		}
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("action LIKE ? OR changes LIKE ? OR metadata LIKE ?", like, like, like)
+	}
+
+	loc := a.reportLocation()
+	if from := c.Query("from"); from != "" {
+		t, err := parseReportTime(from, loc, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid from", Message: err.Error()})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseReportTime(to, loc, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid to", Message: err.Error()})
+			return
+		}
+		query = query.Where("created_at < ?", t)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []AuditLog
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch audit logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"logs":  logs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// HandleGetAuditLog returns one audit entry
+func (a *App) HandleGetAuditLog(c *gin.Context) {
+	var entry AuditLog
+	if err := a.DB.First(&entry, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Audit log not found"})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// HandleVerifyAuditLogs checks the hash chain of the whole audit log
+func (a *App) HandleVerifyAuditLogs(c *gin.Context) {
+	result, err := a.AuditTrail.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify audit logs", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
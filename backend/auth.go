@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// AUTH SESSIONS
+// ========================================
+//
+// Logging in opens an AuthSession and returns a short-lived access token
+// plus a refresh token. Each refresh token can be used once: refreshing
+// marks it used and issues the next one in the same session. Presenting a
+// used token again means it was copied, so the whole session is revoked.
+// Access tokens carry their session ID and are checked against it on every
+// request, so revoking a session ends its access tokens immediately.
+//
+// Sessions opened on a shared terminal lock after the device's idle
+// timeout; any request or refresh after that is refused.
+
+// Session revocation reasons
+const (
+	RevokedLogout          = "logout"
+	RevokedLogoutAll       = "logout_all"
+	RevokedByAdmin         = "admin"
+	RevokedReuse           = "reuse"
+	RevokedDeactivated     = "deactivated"
+	RevokedLocked          = "locked"
+	RevokedSwitched        = "switched"
+	RevokedPasswordChanged = "password_changed"
+)
+
+// Auth errors
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; session revoked")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrAccountDeactivated  = errors.New("account is deactivated")
+	ErrRoleChanged         = errors.New("role has changed, please sign in again")
+	ErrSessionLocked       = errors.New("session locked after inactivity")
+)
+
+// StartSession opens a session for user and issues its first token pair.
+// device is the terminal the user signed in on, or nil.
+func (a *App) StartSession(user User, c *gin.Context, device *Device) (*LoginResponse, error) {
+	now := time.Now()
+	session := AuthSession{
+		UserID:     user.ID,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		ExpiresAt:  now.Add(a.Config.JWT.RefreshExpiration),
+		LastUsedAt: now,
+	}
+	if device != nil {
+		session.DeviceID = &device.ID
+		session.IdleTimeout = device.AutoLockMinutes
+	}
+
+	var refresh string
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		refresh, err = issueRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a.tokenResponse(user, session, refresh)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair.
+// Reusing a token revokes its session.
+func (a *App) RotateRefreshToken(token string, c *gin.Context) (*LoginResponse, error) {
+	var stored RefreshToken
+	if err := a.DB.Where("token_hash = ?", hashToken(token)).First(&stored).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var session AuthSession
+	if err := a.DB.First(&session, stored.SessionID).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if stored.UsedAt != nil {
+		a.RevokeSession(session.ID, RevokedReuse)
+		return nil, ErrRefreshTokenReused
+	}
+	now := time.Now()
+	if now.After(stored.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+	if session.idle(now) {
+		a.RevokeSession(session.ID, RevokedLocked)
+		return nil, ErrSessionLocked
+	}
+
+	var user User
+	if err := a.DB.First(&user, session.UserID).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if !user.IsActive {
+		a.RevokeSession(session.ID, RevokedDeactivated)
+		return nil, ErrAccountDeactivated
+	}
+
+	var refresh string
+	reused := false
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the token; a concurrent refresh with the same token loses
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", stored.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+
+		session.ExpiresAt = now.Add(a.Config.JWT.RefreshExpiration)
+		session.LastUsedAt = now
+		session.IPAddress = c.ClientIP()
+		session.UserAgent = c.Request.UserAgent()
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"expires_at":   session.ExpiresAt,
+			"last_used_at": session.LastUsedAt,
+			"ip_address":   session.IPAddress,
+			"user_agent":   session.UserAgent,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		refresh, err = issueRefreshToken(tx, session.ID, session.ExpiresAt)
+		return err
+	})
+	if reused {
+		a.RevokeSession(session.ID, RevokedReuse)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a.tokenResponse(user, session, refresh)
+}
+
+// CheckSession verifies that the session behind an access token is still
+// live and that the user is active and still holds the role in the token
+func (a *App) CheckSession(claims *JWTClaims) (*AuthSession, *User, error) {
+	var session AuthSession
+	if err := a.DB.Select("id", "user_id", "device_id", "revoked_at", "idle_timeout", "last_used_at").
+		First(&session, claims.SessionID).Error; err != nil || session.UserID != claims.UserID {
+		return nil, nil, ErrSessionRevoked
+	}
+	if session.RevokedAt != nil {
+		return nil, nil, ErrSessionRevoked
+	}
+	now := time.Now()
+	if session.idle(now) {
+		a.RevokeSession(session.ID, RevokedLocked)
+		return nil, nil, ErrSessionLocked
+	}
+	// Track activity for auto-lock, at most once a minute per session
+	if session.IdleTimeout > 0 && now.Sub(session.LastUsedAt) > time.Minute {
+		a.DB.Model(&session).Update("last_used_at", now)
+	}
+
+	var user User
+	if err := a.DB.Select("id", "role", "is_active", "must_change_password", "totp_enabled").
+		First(&user, claims.UserID).Error; err != nil {
+		return nil, nil, ErrSessionRevoked
+	}
+	if !user.IsActive {
+		return nil, nil, ErrAccountDeactivated
+	}
+	if user.Role != claims.Role {
+		return nil, nil, ErrRoleChanged
+	}
+	return &session, &user, nil
+}
+
+// RevokeSession revokes one session and, with it, its tokens
+func (a *App) RevokeSession(sessionID uint, reason string) error {
+	return a.DB.Model(&AuthSession{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// RevokeUserSessions revokes every open session of a user and returns how
+// many were revoked
+func (a *App) RevokeUserSessions(userID uint, reason string) (int64, error) {
+	result := a.DB.Model(&AuthSession{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason})
+	return result.RowsAffected, result.Error
+}
+
+// PruneAuthSessions deletes sessions that expired or were revoked before
+// cutoff, with their refresh tokens, and expired tokens of live sessions
+func (a *App) PruneAuthSessions(cutoff time.Time) (int64, error) {
+	stale := a.DB.Model(&AuthSession{}).Select("id").
+		Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff)
+	if err := a.DB.Where("session_id IN (?) OR expires_at < ?", stale, time.Now()).
+		Delete(&RefreshToken{}).Error; err != nil {
+		return 0, err
+	}
+	result := a.DB.Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff).Delete(&AuthSession{})
+	return result.RowsAffected, result.Error
+}
+
+// idle reports whether a terminal session has passed its idle timeout
+func (s *AuthSession) idle(now time.Time) bool {
+	return s.IdleTimeout > 0 && now.Sub(s.LastUsedAt) > time.Duration(s.IdleTimeout)*time.Minute
+}
+
+// tokenResponse signs an access token for the session and pairs it with
+// the refresh token
+func (a *App) tokenResponse(user User, session AuthSession, refresh string) (*LoginResponse, error) {
+	access, expiresAt, err := a.GenerateJWTToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:                  access,
+		ExpiresAt:              expiresAt,
+		RefreshToken:           refresh,
+		RefreshExpiresAt:       session.ExpiresAt,
+		User:                   user,
+		PasswordChangeRequired: user.MustChangePassword,
+		TwoFactorSetupRequired: !user.TOTPEnabled && a.TwoFactorRequiredFor(user.Role),
+	}, nil
+}
+
+// issueRefreshToken stores the hash of a new random refresh token and
+// returns the token itself
+func issueRefreshToken(tx *gorm.DB, sessionID uint, expiresAt time.Time) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	err := tx.Create(&RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+	}).Error
+	return token, err
+}
+
+// hashToken returns the hex SHA-256 of a token, as stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
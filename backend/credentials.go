@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// INTEGRATION CREDENTIALS
+// ========================================
+//
+// Provider secrets (WhatsApp, Twilio, SMTP, tax authority) can be stored in
+// the integration_credentials table, encrypted, instead of the environment.
+// A stored value overrides the environment variable for the same setting.
+// Services copy their credentials when they are built, so changes take
+// effect at the next restart.
+
+// credentialFields maps stored credential names to the config they set
+func (c *Config) credentialFields() map[string]*string {
+	return map[string]*string{
+		"whatsapp.access_token": &c.Messaging.CloudAccessToken,
+		"whatsapp.app_secret":   &c.Messaging.CloudAppSecret,
+		"whatsapp.verify_token": &c.Messaging.CloudVerifyToken,
+		"twilio.auth_token":     &c.Messaging.TwilioAuthToken,
+		"messaging.api_key":     &c.Messaging.GenericAPIKey,
+		"messaging.secret":      &c.Messaging.GenericSecret,
+		"smtp.password":         &c.Email.SMTPPassword,
+		"eta.client_secret":     &c.ETA.ClientSecret,
+		"eta.pre_shared_key":    &c.ETA.PreSharedKey,
+		"documents.signing_key": &c.Documents.SigningKey,
+	}
+}
+
+// ApplyStoredCredentials copies stored credentials over the config. It runs
+// before the services that use them are created.
+func (a *App) ApplyStoredCredentials() {
+	var credentials []IntegrationCredential
+	if err := a.DB.Find(&credentials).Error; err != nil {
+		log.Printf("Failed to load stored credentials: %v", err)
+		return
+	}
+	fields := a.Config.credentialFields()
+	for _, credential := range credentials {
+		if field, ok := fields[credential.Name]; ok && credential.Value != "" {
+			*field = credential.Value.String()
+		}
+	}
+}
+
+// maskSecret shows only the last four characters of a secret
+func maskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// HandleGetCredentials lists every credential setting, masked, with where
+// its value comes from
+func (a *App) HandleGetCredentials(c *gin.Context) {
+	var stored []IntegrationCredential
+	if err := a.DB.Find(&stored).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch credentials"})
+		return
+	}
+	byName := map[string]IntegrationCredential{}
+	for _, credential := range stored {
+		byName[credential.Name] = credential
+	}
+
+	fields := a.Config.credentialFields()
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		entry := gin.H{"name": name, "source": "", "value": ""}
+		if credential, ok := byName[name]; ok {
+			entry["source"] = "database"
+			entry["value"] = maskSecret(credential.Value.String())
+			entry["updated_by"] = credential.UpdatedBy
+			entry["updated_at"] = credential.UpdatedAt
+		} else if value := *fields[name]; value != "" {
+			entry["source"] = "environment"
+			entry["value"] = maskSecret(value)
+		}
+		list = append(list, entry)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// HandleSetCredential stores a credential, replacing any stored value
+func (a *App) HandleSetCredential(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := a.Config.credentialFields()[name]; !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Unknown credential"})
+		return
+	}
+	var req SetCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	credential := IntegrationCredential{Name: name}
+	a.DB.Where("name = ?", name).First(&credential)
+	credential.Value = EncryptedString(req.Value)
+	credential.UpdatedBy = c.GetUint("user_id")
+	if err := a.DB.Save(&credential).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save credential"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "credential_set", Entity: "integration_credential", EntityID: credential.ID, Details: gin.H{"name": name}})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Credential saved; restart the server to use it",
+		Data:    gin.H{"name": name, "value": maskSecret(req.Value)},
+	})
+}
+
+// HandleDeleteCredential removes a stored credential so the environment
+// value applies again
+func (a *App) HandleDeleteCredential(c *gin.Context) {
+	var credential IntegrationCredential
+	if err := a.DB.Where("name = ?", c.Param("name")).First(&credential).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Credential not found"})
+		return
+	}
+	if err := a.DB.Delete(&credential).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete credential"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "credential_deleted", Entity: "integration_credential", EntityID: credential.ID, Details: gin.H{"name": credential.Name}})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Credential deleted; restart the server to use the environment value",
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"net/http"
 	"time"
 
@@ -14,22 +15,22 @@ import (
 // DashboardStats holds dashboard statistics
 type DashboardStats struct {
 	TotalRevenue      float64 `json:"total_revenue"`
-	TotalOrders       int     `json:"total_orders"`
-	TotalCustomers    int     `json:"total_customers"`
+	TotalOrders       int64    `json:"total_orders"`
+	TotalCustomers    int64    `json:"total_customers"`
 	AverageOrderValue float64 `json:"average_order_value"`
-	DineInOrders      int     `json:"dine_in_orders"`
-	TakeawayOrders    int     `json:"takeaway_orders"`
-	DeliveryOrders     int     `json:"delivery_orders"`
+	DineInOrders      int64    `json:"dine_in_orders"`
+	TakeawayOrders    int64    `json:"takeaway_orders"`
+	DeliveryOrders     int64    `json:"delivery_orders"`
 	CashPayments      float64 `json:"cash_payments"`
 	CardPayments      float64 `json:"card_payments"`
 	WalletPayments    float64 `json:"wallet_payments"`
-	LowStockItems     int     `json:"low_stock_items"`
-	PendingOrders      int     `json:"pending_orders"`
-	PreparingOrders   int     `json:"preparing_orders"`
-	ReadyOrders        int     `json:"ready_orders"`
-	ServedOrders       int     `json:"served_orders"`
-	ActiveTables       int     `json:"active_tables"`
-	AvailableTables   int     `json:"available_tables"`
+	LowStockItems     int64    `json:"low_stock_items"`
+	PendingOrders      int64    `json:"pending_orders"`
+	PreparingOrders   int64    `json:"preparing_orders"`
+	ReadyOrders        int64    `json:"ready_orders"`
+	ServedOrders       int64    `json:"served_orders"`
+	ActiveTables       int64    `json:"active_tables"`
+	AvailableTables   int64    `json:"available_tables"`
 	HourlyRevenue     []HourlyStat `json:"hourly_revenue"`
 	TrendingItems      []TrendingItem `json:"trending_items"`
 	SlowItems          []SlowItem `json:"slow_items"`
@@ -52,7 +53,8 @@ type TrendingItem struct {
 	Category     string  `json:"category"`
 	SoldCount    int     `json:"sold_count"`
 	Revenue      float64 `json:"revenue"`
-	Growth       float64 `json:"growth"`
+	AveragePrice float64 `json:"average_price"`
+	Growth       float64 `json:"growth"` // revenue change in percent against the previous period
 }
 
 // SlowItem holds slow selling item data
@@ -93,71 +95,7 @@ func (a *App) HandleGetDashboard(c *gin.Context) {
 	startDate := a.getStartDate(c)
 	endDate := a.getEndDate(c)
 
-	stats := DashboardStats{}
-
-	// 1. Total Revenue & Orders
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Select("COALESCE(SUM(total), 0) as total_revenue").
-		Select("COUNT(*) as total_orders").
-		Scan(&stats.TotalRevenue, &stats.TotalOrders)
-
-	// 2. Total Customers
-	a.DB.Model(&Customer{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Count(&stats.TotalCustomers)
-
-	// 3. Average Order Value
-	if stats.TotalOrders > 0 {
-		stats.AverageOrderValue = stats.TotalRevenue / float64(stats.TotalOrders)
-	}
-
-	// 4. Orders by Type
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Where("type = ?", "dine_in").Count(&stats.DineInOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Where("type = ?", "takeaway").Count(&stats.TakeawayOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Where("type = ?", "delivery").Count(&stats.DeliveryOrders)
-
-	// 5. Payments by Method
-	a.DB.Model(&Payment{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("method = ?", "cash").Select("COALESCE(SUM(amount), 0)").Scan(&stats.CashPayments)
-	a.DB.Model(&Payment{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("method = ?", "card").Select("COALESCE(SUM(amount), 0)").Scan(&stats.CardPayments)
-	a.DB.Model(&Payment{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("method = ?", "mobile_wallet").Select("COALESCE(SUM(amount), 0)").Scan(&stats.WalletPayments)
-
-	// 6. Low Stock Items
-	a.DB.Model(&StockItem{}).
-		Where("is_low_stock = ? AND current_stock <= minimum_stock", true).
-		Count(&stats.LowStockItems)
-
-	// 7. Order Status Counts
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "pending").Count(&stats.PendingOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "preparing").Count(&stats.PreparingOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "ready").Count(&stats.ReadyOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "served").Count(&stats.ServedOrders)
-
-	// 8. Table Status
-	a.DB.Model(&Table{}).
-		Where("status = ?", "occupied").Count(&stats.ActiveTables)
-	a.DB.Model(&Table{}).
-		Where("status = ?", "available").Count(&stats.AvailableTables)
+	stats := a.dashboardSummary(startDate, endDate)
 
 	// 9. Hourly Revenue
 	a.DB.Model(&Payment{}).
@@ -186,6 +124,12 @@ func (a *App) HandleGetDashboard(c *gin.Context) {
 		Limit(10).
 		Scan(&itemStats)
 
+	itemIDs := make([]uint, len(itemStats))
+	for i, stat := range itemStats {
+		itemIDs[i] = stat.ItemID
+	}
+	growth := a.itemRevenueGrowth(itemIDs, startDate, endDate)
+
 	for _, stat := range itemStats {
 		var menuItem MenuItem
 		a.DB.First(&menuItem, stat.ItemID)
@@ -196,7 +140,8 @@ func (a *App) HandleGetDashboard(c *gin.Context) {
 			ItemNameAr: menuItem.NameAr,
 			SoldCount:  stat.SoldCount,
 			Revenue:    stat.Revenue,
-			Growth:      stat.Revenue / float64(stat.SoldCount),
+			AveragePrice: reportRatio(stat.Revenue, float64(stat.SoldCount)),
+			Growth:       growth[stat.ItemID],
 		}
 		stats.TrendingItems = append(stats.TrendingItems, trending)
 	}
@@ -268,9 +213,9 @@ func (a *App) HandleGetDashboard(c *gin.Context) {
 
 	for _, stat := range busyHourStats {
 		loadFactor := "Low"
-		if stat.Orders > maxOrders*0.75 {
+		if float64(stat.Orders) > float64(maxOrders)*0.75 {
 			loadFactor = "High"
-		} else if stat.Orders > maxOrders*0.5 {
+		} else if float64(stat.Orders) > float64(maxOrders)*0.5 {
 			loadFactor = "Medium"
 		}
 
@@ -285,6 +230,82 @@ func (a *App) HandleGetDashboard(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// dashboardSummary fills the headline counters of the dashboard for a date range
+func (a *App) dashboardSummary(startDate, endDate time.Time) DashboardStats {
+	stats := DashboardStats{}
+
+	// 1. Total Revenue & Orders
+	var totals struct {
+		TotalRevenue float64
+		TotalOrders  int64
+	}
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
+		Select("COALESCE(SUM(total), 0) as total_revenue, COUNT(*) as total_orders").
+		Scan(&totals)
+	stats.TotalRevenue = totals.TotalRevenue
+	stats.TotalOrders = totals.TotalOrders
+
+	// 2. Total Customers
+	a.DB.Model(&Customer{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Count(&stats.TotalCustomers)
+
+	// 3. Average Order Value
+	if stats.TotalOrders > 0 {
+		stats.AverageOrderValue = stats.TotalRevenue / float64(stats.TotalOrders)
+	}
+
+	// 4. Orders by Type
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
+		Where("type = ?", "dine_in").Count(&stats.DineInOrders)
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
+		Where("type = ?", "takeaway").Count(&stats.TakeawayOrders)
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
+		Where("type = ?", "delivery").Count(&stats.DeliveryOrders)
+
+	// 5. Payments by Method
+	a.DB.Model(&Payment{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("method = ?", "cash").Select("COALESCE(SUM(amount), 0)").Scan(&stats.CashPayments)
+	a.DB.Model(&Payment{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("method = ?", "card").Select("COALESCE(SUM(amount), 0)").Scan(&stats.CardPayments)
+	a.DB.Model(&Payment{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("method = ?", "mobile_wallet").Select("COALESCE(SUM(amount), 0)").Scan(&stats.WalletPayments)
+
+	// 6. Low Stock Items
+	a.DB.Model(&StockItem{}).
+		Where("is_low_stock = ? AND current_stock <= minimum_stock", true).
+		Count(&stats.LowStockItems)
+
+	// 7. Order Status Counts
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("status = ?", "pending").Count(&stats.PendingOrders)
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("status = ?", "preparing").Count(&stats.PreparingOrders)
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("status = ?", "ready").Count(&stats.ReadyOrders)
+	a.DB.Model(&Order{}).
+		Where("created_at >= ? AND created_at < ?", startDate, endDate).
+		Where("status = ?", "served").Count(&stats.ServedOrders)
+
+	// 8. Table Status
+	a.DB.Model(&Table{}).
+		Where("status = ?", "occupied").Count(&stats.ActiveTables)
+	a.DB.Model(&Table{}).
+		Where("status = ?", "available").Count(&stats.AvailableTables)
+
+	return stats
+}
+
 // HandleGetDashboardStats returns dashboard stats (separate endpoint for charts)
 func (a *App) HandleGetDashboardStats(c *gin.Context) {
 	dateFilter := c.Query("date_filter") // "today", "week", "month"
@@ -367,6 +388,12 @@ func (a *App) HandleGetDashboardStats(c *gin.Context) {
 		Limit(10).
 		Scan(&topItems)
 
+	topIDs := make([]uint, len(topItems))
+	for i, item := range topItems {
+		topIDs[i] = item.ItemID
+	}
+	topGrowth := a.itemRevenueGrowth(topIDs, startDate, endDate)
+
 	for _, item := range topItems {
 		var menuItem MenuItem
 		a.DB.First(&menuItem, item.ItemID)
@@ -377,7 +404,8 @@ func (a *App) HandleGetDashboardStats(c *gin.Context) {
 			ItemNameAr: menuItem.NameAr,
 			SoldCount:  item.SoldCount,
 			Revenue:    item.Revenue,
-			Growth:      item.Revenue / float64(item.SoldCount),
+			AveragePrice: reportRatio(item.Revenue, float64(item.SoldCount)),
+			Growth:       topGrowth[item.ItemID],
 		}
 		stats["top_items"] = append(stats["top_items"].([]TrendingItem), trending)
 	}
@@ -385,9 +413,9 @@ func (a *App) HandleGetDashboardStats(c *gin.Context) {
 	// Kitchen load
 	type KitchenLoad struct {
 		Hour       int   `json:"hour"`
-		Pending    int   `json:"pending"`
-		Preparing  int   `json:"preparing"`
-		Ready      int   `json:"ready"`
+		Pending    int64 `json:"pending"`
+		Preparing  int64 `json:"preparing"`
+		Ready      int64 `json:"ready"`
 	}
 
 	var kitchenLoad []KitchenLoad
@@ -399,26 +427,23 @@ func (a *App) HandleGetDashboardStats(c *gin.Context) {
 		Order("hour").
 		Scan(&kitchenLoad)
 
-	for _, hour := range kitchenLoad {
-		var hourStat KitchenLoad
-		hourStat.Hour = hour.Hour
+	for i := range kitchenLoad {
+		hour := &kitchenLoad[i]
 
 		a.DB.Model(&OrderItem{}).
 			Joins("LEFT JOIN orders ON orders.id = order_items.order_id").
 			Where("HOUR(orders.created_at) = ? AND orders.status = ?", hour.Hour, "pending").
-			Count(&hourStat.Pending)
+			Count(&hour.Pending)
 
 		a.DB.Model(&OrderItem{}).
 			Joins("LEFT JOIN orders ON orders.id = order_items.order_id").
 			Where("HOUR(orders.created_at) = ? AND orders.status = ?", hour.Hour, "preparing").
-			Count(&hourStat.Preparing)
+			Count(&hour.Preparing)
 
 		a.DB.Model(&OrderItem{}).
 			Joins("LEFT JOIN orders ON orders.id = order_items.order_id").
 			Where("HOUR(orders.created_at) = ? AND orders.status = ?", hour.Hour, "ready").
-			Count(&hourStat.Ready)
-
-		kitchenLoad = append(kitchenLoad, hourStat)
+			Count(&hour.Ready)
 	}
 
 	stats["kitchen_load"] = kitchenLoad
@@ -481,56 +506,67 @@ func (a *App) HandleGetLowStockDashboard(c *gin.Context) {
 // HELPER FUNCTIONS
 // ========================================
 
-// getStartDate extracts start date from query params
-func (a *App) getStartDate(c *gin.Context) time.Time {
-	dateFilter := c.Query("date_filter")
-	today := time.Now().Truncate(24 * time.Hour)
+// itemRevenueGrowth returns each item's revenue change in percent between
+// [start, end) and the period of the same length before it. Items that did
+// not sell in the earlier period are left out.
+func (a *App) itemRevenueGrowth(itemIDs []uint, start, end time.Time) map[uint]float64 {
+	growth := map[uint]float64{}
+	if len(itemIDs) == 0 {
+		return growth
+	}
 
-	switch dateFilter {
-	case "today":
-		return today
-	case "week":
-		return today.AddDate(-7 * 24 * time.Hour)
-	case "month":
-		return today.AddDate(-30 * 24 * time.Hour)
-	case "year":
-		return today.AddDate(-365 * 24 * time.Hour)
-	default:
-		return today
+	type itemRevenue struct {
+		ItemID   uint
+		Current  float64
+		Previous float64
+	}
+	var rows []itemRevenue
+	previousStart := start.Add(-end.Sub(start))
+	a.DB.Model(&OrderItem{}).
+		Select(`order_items.menu_item_id AS item_id,
+			COALESCE(SUM(CASE WHEN orders.created_at >= ? THEN order_items.quantity * order_items.unit_price END), 0) AS current,
+			COALESCE(SUM(CASE WHEN orders.created_at < ? THEN order_items.quantity * order_items.unit_price END), 0) AS previous`, start, start).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.created_at >= ? AND orders.created_at < ?", previousStart, end).
+		Where("orders.payment_status = ?", "paid").
+		Where("order_items.menu_item_id IN ?", itemIDs).
+		Group("order_items.menu_item_id").
+		Scan(&rows)
+
+	for _, row := range rows {
+		if row.Previous > 0 {
+			growth[row.ItemID] = math.Round((row.Current-row.Previous)/row.Previous*10000) / 100
+		}
 	}
+	return growth
+}
+
+// getStartDate extracts start date from query params
+func (a *App) getStartDate(c *gin.Context) time.Time {
+	start, _ := a.getDateRange(c.Query("date_filter"))
+	return start
 }
 
 // getEndDate extracts end date from query params
 func (a *App) getEndDate(c *gin.Context) time.Time {
-	dateFilter := c.Query("date_filter")
-	today := time.Now().Truncate(24 * time.Hour).Add(24 * time.Hour) // Tomorrow
-
-	switch dateFilter {
-	case "today":
-		return today
-	case "week":
-		return today
-	case "month":
-		return today
-	case "year":
-		return today
-	default:
-		return today
-	}
+	_, end := a.getDateRange(c.Query("date_filter"))
+	return end
 }
 
-// getDateRange returns date range based on filter
+// getDateRange returns date range based on filter, in the restaurant's
+// time zone. Ranges end at the end of today.
 func (a *App) getDateRange(dateFilter string) (time.Time, time.Time) {
-	today := time.Now().Truncate(24 * time.Hour)
+	today := startOfDay(time.Now().In(a.reportLocation()))
+	tomorrow := today.AddDate(0, 0, 1)
 
 	switch dateFilter {
-	case "today":
-		return today, today.Add(24 * time.Hour)
 	case "week":
-		return today.AddDate(-7 * 24 * time.Hour), today
+		return today.AddDate(0, 0, -7), tomorrow
 	case "month":
-		return today.AddDate(-30 * 24 * time.Hour), today
+		return today.AddDate(0, 0, -30), tomorrow
+	case "year":
+		return today.AddDate(0, 0, -365), tomorrow
 	default:
-		return today, today.Add(24 * time.Hour)
+		return today, tomorrow
 	}
 }
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// DEVICES
+// ========================================
+//
+// Registered terminals: POS terminals, kitchen screens, waiter handhelds and
+// customer displays. A manager registers a device and gets a one-time
+// pairing code; the device exchanges the code for its token at
+// POST /api/devices/pair and sends the token in the X-Device-Token header
+// from then on. The token signs staff in by PIN and ties orders, payments
+// and audit entries made on the device to it.
+//
+// Devices report in with heartbeats over the WebSocket. A revoked device's
+// token stops working at once, its sessions end and its WebSocket
+// connections are closed; a lost tablet is blocked by revoking it.
+
+// DeviceTokenHeader carries the device token on terminal requests
+const DeviceTokenHeader = "X-Device-Token"
+
+// Device kinds
+const (
+	DeviceKindPOS             = "pos"
+	DeviceKindKDS             = "kds"
+	DeviceKindHandheld        = "handheld"
+	DeviceKindCustomerDisplay = "customer_display"
+)
+
+// DeviceKinds lists the kinds a device can be registered as
+var DeviceKinds = []string{DeviceKindPOS, DeviceKindKDS, DeviceKindHandheld, DeviceKindCustomerDisplay}
+
+// Device statuses, derived from the device's fields
+const (
+	DeviceStatusPending  = "pending" // registered, waiting to be paired
+	DeviceStatusActive   = "active"
+	DeviceStatusDisabled = "disabled"
+	DeviceStatusRevoked  = "revoked"
+)
+
+// pairingCodeTTL is how long a pairing code can be used
+const pairingCodeTTL = 15 * time.Minute
+
+// pairingAlphabet leaves out characters that are easy to misread
+const pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// deviceOnlineWindow is how recently a connected device must have sent a
+// heartbeat to count as online
+const deviceOnlineWindow = 90 * time.Second
+
+// Device errors
+var (
+	ErrUnknownDevice        = errors.New("device is not registered or has been deactivated")
+	ErrInvalidPairingCode   = errors.New("pairing code is invalid or has expired")
+	ErrDeviceAlreadyRevoked = errors.New("device has been revoked")
+)
+
+// AuthenticateDevice resolves the device token on the request and records
+// that the device was seen
+func (a *App) AuthenticateDevice(c *gin.Context) (*Device, error) {
+	return a.authenticateDeviceToken(c, c.GetHeader(DeviceTokenHeader))
+}
+
+// authenticateDeviceToken resolves a device token sent some other way, as
+// on a WebSocket upgrade
+func (a *App) authenticateDeviceToken(c *gin.Context, token string) (*Device, error) {
+	if token == "" {
+		return nil, ErrUnknownDevice
+	}
+
+	var device Device
+	if err := a.DB.Where("token_hash = ? AND is_active = ? AND revoked_at IS NULL", hashToken(token), true).
+		First(&device).Error; err != nil {
+		return nil, ErrUnknownDevice
+	}
+
+	// A write per request is too much for a busy terminal; a minute is close enough
+	now := time.Now()
+	if device.LastSeenAt == nil || now.Sub(*device.LastSeenAt) > time.Minute {
+		device.LastSeenAt = &now
+		a.DB.Model(&device).UpdateColumns(map[string]interface{}{"last_seen_at": now, "last_ip": c.ClientIP()})
+	}
+	return &device, nil
+}
+
+// attachDevice ties the request to the device in X-Device-Token, if one is
+// sent. A token that no longer works refuses the request, so a revoked
+// device is locked out even with a valid user token.
+func (a *App) attachDevice(c *gin.Context) bool {
+	if c.GetHeader(DeviceTokenHeader) == "" {
+		return true
+	}
+	device, err := a.AuthenticateDevice(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "device_rejected": true})
+		c.Abort()
+		return false
+	}
+	c.Set("device_id", device.ID)
+	return true
+}
+
+// deviceIDFromContext returns the device the request was made on, if any
+func deviceIDFromContext(c *gin.Context) *uint {
+	if deviceID := c.GetUint("device_id"); deviceID != 0 {
+		return &deviceID
+	}
+	return nil
+}
+
+// newDeviceToken returns a random device token
+func newDeviceToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// newPairingCode returns a random code formatted as XXXX-XXXX
+func newPairingCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 9)
+	for i, b := range buf {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, pairingAlphabet[int(b)%len(pairingAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizePairingCode accepts codes typed in lower case, with or without
+// the dash and spaces
+func normalizePairingCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// fillStatus sets the derived Status and Online fields
+func (d *Device) fillStatus(now time.Time) {
+	switch {
+	case d.RevokedAt != nil:
+		d.Status = DeviceStatusRevoked
+	case d.TokenHash == nil:
+		d.Status = DeviceStatusPending
+	case !d.IsActive:
+		d.Status = DeviceStatusDisabled
+	default:
+		d.Status = DeviceStatusActive
+	}
+	d.Online = d.Status == DeviceStatusActive && d.Connected &&
+		d.LastSeenAt != nil && now.Sub(*d.LastSeenAt) < deviceOnlineWindow
+}
+
+// issuePairingCode gives the device a new pairing code, replacing any
+// unused one
+func (a *App) issuePairingCode(device *Device) (string, error) {
+	code, err := newPairingCode()
+	if err != nil {
+		return "", err
+	}
+	hash := hashToken(normalizePairingCode(code))
+	expires := time.Now().Add(pairingCodeTTL)
+	device.PairingCodeHash = &hash
+	device.PairingExpiresAt = &expires
+	return code, a.DB.Model(device).UpdateColumns(map[string]interface{}{
+		"pairing_code_hash":  hash,
+		"pairing_expires_at": expires,
+	}).Error
+}
+
+// DeviceRequest is the body of device create and update requests
+type DeviceRequest struct {
+	Name            string `json:"name"`
+	Kind            string `json:"kind"`
+	Location        string `json:"location"`
+	Station         string `json:"station"`
+	PrinterIDs      []uint `json:"printer_ids"`
+	AutoLockMinutes *int   `json:"auto_lock_minutes"`
+	IsActive        *bool  `json:"is_active"`
+}
+
+// apply validates the request and copies it onto d
+func (r *DeviceRequest) apply(d *Device) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Kind != "" {
+		if !containsString(DeviceKinds, r.Kind) {
+			return errors.New("kind must be one of " + strings.Join(DeviceKinds, ", "))
+		}
+		d.Kind = r.Kind
+	}
+	d.Name = r.Name
+	d.Location = strings.TrimSpace(r.Location)
+	d.Station = strings.TrimSpace(r.Station)
+	if r.AutoLockMinutes != nil {
+		if *r.AutoLockMinutes < 0 {
+			return errors.New("auto_lock_minutes cannot be negative")
+		}
+		d.AutoLockMinutes = *r.AutoLockMinutes
+	}
+	if r.IsActive != nil {
+		d.IsActive = *r.IsActive
+	}
+	return nil
+}
+
+// devicePrinters loads the printers to assign, rejecting unknown IDs
+func (a *App) devicePrinters(ids []uint) ([]Printer, error) {
+	printers := []Printer{}
+	if len(ids) == 0 {
+		return printers, nil
+	}
+	if err := a.DB.Where("id IN ?", ids).Find(&printers).Error; err != nil {
+		return nil, err
+	}
+	if len(printers) != len(ids) {
+		return nil, errors.New("unknown printer in printer_ids")
+	}
+	return printers, nil
+}
+
+// HandleGetDevices lists registered devices
+func (a *App) HandleGetDevices(c *gin.Context) {
+	query := a.DB.Preload("Printers")
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if location := c.Query("location"); location != "" {
+		query = query.Where("location = ?", location)
+	}
+
+	var devices []Device
+	if err := query.Order("location ASC, name ASC").Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch devices"})
+		return
+	}
+	now := time.Now()
+	for i := range devices {
+		devices[i].fillStatus(now)
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// HandleGetDevice returns one device
+func (a *App) HandleGetDevice(c *gin.Context) {
+	var device Device
+	if err := a.DB.Preload("Printers").First(&device, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Device not found"})
+		return
+	}
+	device.fillStatus(time.Now())
+	c.JSON(http.StatusOK, device)
+}
+
+// HandleCreateDevice registers a device and returns its pairing code. The
+// code is only returned here.
+func (a *App) HandleCreateDevice(c *gin.Context) {
+	var req DeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	device := Device{Kind: DeviceKindPOS, AutoLockMinutes: 5, IsActive: true}
+	if err := req.apply(&device); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	printers, err := a.devicePrinters(req.PrinterIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	device.Printers = printers
+	if err := a.DB.Create(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create device", Message: err.Error()})
+		return
+	}
+	code, err := a.issuePairingCode(&device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create pairing code"})
+		return
+	}
+	device.fillStatus(time.Now())
+	a.Audit(c, AuditEntry{Action: "device_registered", Entity: "device", EntityID: device.ID, After: device})
+
+	c.JSON(http.StatusCreated, gin.H{"device": device, "pairing_code": code, "expires_at": device.PairingExpiresAt})
+}
+
+// HandleCreatePairingCode issues a new pairing code, to pair a device that
+// missed its code or to move it to replacement hardware. Pairing again
+// replaces the device's token.
+func (a *App) HandleCreatePairingCode(c *gin.Context) {
+	var device Device
+	if err := a.DB.First(&device, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Device not found"})
+		return
+	}
+	if device.RevokedAt != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Device has been revoked", Message: "register a new device instead"})
+		return
+	}
+	code, err := a.issuePairingCode(&device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create pairing code"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "device_pairing_code_issued", Entity: "device", EntityID: device.ID})
+
+	c.JSON(http.StatusOK, gin.H{"pairing_code": code, "expires_at": device.PairingExpiresAt})
+}
+
+// HandlePairDevice exchanges a pairing code for the device's token and
+// configuration. Called by the device itself before it has a token.
+func (a *App) HandlePairDevice(c *gin.Context) {
+	var req PairDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var device Device
+	if err := a.DB.Preload("Printers").
+		Where("pairing_code_hash = ? AND pairing_expires_at > ? AND revoked_at IS NULL",
+			hashToken(normalizePairingCode(req.Code)), time.Now()).
+		First(&device).Error; err != nil {
+		a.Audit(c, AuditEntry{Action: "device_pairing_failed", Entity: "device"})
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Pairing failed", Message: ErrInvalidPairingCode.Error()})
+		return
+	}
+
+	token, err := newDeviceToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to pair device"})
+		return
+	}
+	now := time.Now()
+	tokenHash := hashToken(token)
+	if err := a.DB.Model(&device).UpdateColumns(map[string]interface{}{
+		"token_hash":         tokenHash,
+		"pairing_code_hash":  nil,
+		"pairing_expires_at": nil,
+		"paired_at":          now,
+		"last_seen_at":       now,
+		"last_ip":            c.ClientIP(),
+		"app_version":        strings.TrimSpace(req.AppVersion),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to pair device"})
+		return
+	}
+	// Whatever ran under the previous token is done
+	a.revokeDeviceSessions(device.ID, RevokedByAdmin)
+	a.WSManager.DisconnectDevice(device.ID, "device was paired again")
+
+	device.TokenHash = &tokenHash
+	device.PairingCodeHash = nil
+	device.PairingExpiresAt = nil
+	device.PairedAt = &now
+	device.LastSeenAt = &now
+	device.AppVersion = strings.TrimSpace(req.AppVersion)
+	device.fillStatus(now)
+	a.Audit(c, AuditEntry{Action: "device_paired", Entity: "device", EntityID: device.ID, Details: gin.H{"app_version": device.AppVersion}})
+
+	c.JSON(http.StatusOK, gin.H{"device": device, "token": token})
+}
+
+// HandleGetOwnDevice returns the calling device's configuration, so it can
+// pick up printer and station changes
+func (a *App) HandleGetOwnDevice(c *gin.Context) {
+	device, err := a.AuthenticateDevice(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unknown device", Message: err.Error()})
+		return
+	}
+	a.DB.Model(device).Association("Printers").Find(&device.Printers)
+	device.fillStatus(time.Now())
+	c.JSON(http.StatusOK, device)
+}
+
+// HandleUpdateDevice updates a device. Deactivating it ends its sessions.
+func (a *App) HandleUpdateDevice(c *gin.Context) {
+	var device Device
+	if err := a.DB.Preload("Printers").First(&device, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Device not found"})
+		return
+	}
+	if device.RevokedAt != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Device has been revoked"})
+		return
+	}
+	before := device
+
+	var req DeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := req.apply(&device); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if req.PrinterIDs != nil {
+		printers, err := a.devicePrinters(req.PrinterIDs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+			return
+		}
+		if err := a.DB.Model(&device).Association("Printers").Replace(printers); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update device"})
+			return
+		}
+		device.Printers = printers
+	}
+	if err := a.DB.Omit("Printers").Save(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update device"})
+		return
+	}
+	if !device.IsActive {
+		a.revokeDeviceSessions(device.ID, RevokedByAdmin)
+		a.WSManager.DisconnectDevice(device.ID, "device was deactivated")
+	}
+	device.fillStatus(time.Now())
+	a.Audit(c, AuditEntry{Action: "device_updated", Entity: "device", EntityID: device.ID, Before: before, After: device})
+
+	c.JSON(http.StatusOK, device)
+}
+
+// HandleRevokeDevice revokes a device for good: its token stops working,
+// its sessions end and its WebSocket connections are closed. The row is
+// kept so orders, sessions and audit entries still resolve.
+func (a *App) HandleRevokeDevice(c *gin.Context) {
+	var device Device
+	if err := a.DB.First(&device, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Device not found"})
+		return
+	}
+	if device.RevokedAt != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: ErrDeviceAlreadyRevoked.Error()})
+		return
+	}
+	now := time.Now()
+	if err := a.DB.Model(&device).UpdateColumns(map[string]interface{}{
+		"revoked_at":         now,
+		"is_active":          false,
+		"token_hash":         nil,
+		"pairing_code_hash":  nil,
+		"pairing_expires_at": nil,
+		"connected":          false,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke device"})
+		return
+	}
+	sessions, _ := a.revokeDeviceSessions(device.ID, RevokedByAdmin)
+	a.WSManager.DisconnectDevice(device.ID, "device was revoked")
+	a.Audit(c, AuditEntry{
+		Action:   "device_revoked",
+		Entity:   "device",
+		EntityID: device.ID,
+		Details:  gin.H{"revoked_sessions": sessions},
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Device revoked",
+	})
+}
+
+// revokeDeviceSessions ends every open session on a device
+func (a *App) revokeDeviceSessions(deviceID uint, reason string) (int64, error) {
+	result := a.DB.Model(&AuthSession{}).
+		Where("device_id = ? AND revoked_at IS NULL", deviceID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason})
+	return result.RowsAffected, result.Error
+}
+
+// ========================================
+// HEARTBEATS
+// ========================================
+
+// RecordDeviceHeartbeat marks the device owning the heartbeat's token as
+// connected and seen. Registered as the WebSocket manager's heartbeat hook.
+func (a *App) RecordDeviceHeartbeat(hb DeviceHeartbeat) (uint, error) {
+	var device Device
+	if hb.DeviceToken == "" || a.DB.Where("token_hash = ? AND is_active = ? AND revoked_at IS NULL",
+		hashToken(hb.DeviceToken), true).First(&device).Error != nil {
+		return 0, ErrUnknownDevice
+	}
+	updates := map[string]interface{}{"last_seen_at": time.Now(), "connected": true}
+	if version := strings.TrimSpace(hb.AppVersion); version != "" {
+		updates["app_version"] = version
+	}
+	a.DB.Model(&device).UpdateColumns(updates)
+	return device.ID, nil
+}
+
+// RecordDeviceDisconnected marks a device whose last WebSocket closed
+func (a *App) RecordDeviceDisconnected(deviceID uint) {
+	a.DB.Model(&Device{}).Where("id = ?", deviceID).UpdateColumn("connected", false)
+}
+
+// ResetDeviceConnections clears connection flags left by a previous run;
+// connections don't survive a restart
+func (a *App) ResetDeviceConnections() {
+	a.DB.Model(&Device{}).Where("connected = ?", true).UpdateColumn("connected", false)
+}
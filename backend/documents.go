@@ -0,0 +1,751 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// ORDER DOCUMENTS
+// ========================================
+//
+// PDF receipts (80mm roll) and A4 tax invoices, rendered from the same
+// ReceiptData the template engine uses. Staff download them through the
+// authenticated API; customers and messaging providers get a signed link
+// that expires after Config.Documents.LinkTTL.
+
+// Document kinds
+const (
+	DocumentReceipt = "receipt"
+	DocumentInvoice = "invoice"
+)
+
+// maxLogoSize caps logos read for documents
+const maxLogoSize = 2 << 20
+
+// ErrLinkExpired is returned for signed links past their expiry
+var ErrLinkExpired = errors.New("link has expired")
+
+// ErrBadSignature is returned for signed links that don't verify
+var ErrBadSignature = errors.New("invalid link signature")
+
+// RenderOrderDocument renders an order as a PDF receipt or invoice
+func (a *App) RenderOrderDocument(orderID uint, kind string) ([]byte, *Order, error) {
+	if kind != DocumentReceipt && kind != DocumentInvoice {
+		return nil, nil, fmt.Errorf("unknown document %q", kind)
+	}
+
+	var order Order
+	if err := a.DB.Preload("Items").Preload("Payments").Preload("Table").First(&order, orderID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+	if settings.CurrencySymbol == "" {
+		settings.CurrencySymbol = "ج.م"
+	}
+
+	fonts, err := LoadPDFFonts(a.Config.Documents.FontPath, a.Config.Documents.BoldFontPath)
+	if err != nil {
+		return nil, &order, err
+	}
+
+	engine := NewReceiptEngine(a.DB)
+	data := engine.BuildData(&order, &settings)
+
+	// Receipts follow the default template's logo and QR switches
+	if kind == DocumentReceipt {
+		tpl := engine.Template(TemplateKindReceipt)
+		if !tpl.ShowLogo {
+			data.Restaurant.Logo = ""
+		}
+		if !tpl.ShowQR {
+			data.QR.Content = ""
+		}
+	}
+
+	var logo *PDFImage
+	if data.Restaurant.Logo != "" {
+		if logo, err = loadLogo(data.Restaurant.Logo); err != nil {
+			log.Printf("Document logo skipped: %v", err)
+		}
+	}
+
+	var pdf []byte
+	if kind == DocumentInvoice {
+		pdf, err = RenderInvoicePDF(data, fonts, logo)
+	} else {
+		pdf, err = RenderReceiptPDF(data, fonts, logo)
+	}
+	return pdf, &order, err
+}
+
+// loadLogo reads the restaurant logo from a data URI or the uploads
+// directory. Logo URLs are only accepted when they point at an uploaded file,
+// which is read from disk; nothing is fetched over the network.
+func loadLogo(src string) (*PDFImage, error) {
+	var data []byte
+	if strings.HasPrefix(src, "data:") {
+		comma := strings.IndexByte(src, ',')
+		if comma < 0 || !strings.Contains(src[:comma], ";base64") {
+			return nil, errors.New("logo data URI must be base64")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(src[comma+1:])
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	} else {
+		path, err := uploadedLogoPath(src)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		data, err = io.ReadAll(io.LimitReader(file, maxLogoSize+1))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) > maxLogoSize {
+		return nil, errors.New("logo is larger than 2MB")
+	}
+	return NewPDFImage(data)
+}
+
+// uploadedLogoPath maps a logo path or URL to a file in ./uploads
+func uploadedLogoPath(src string) (string, error) {
+	path := src
+	if strings.Contains(src, "://") {
+		parsed, err := url.Parse(src)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return "", fmt.Errorf("logo URL %q is not valid", src)
+		}
+		path = filepath.Clean(parsed.Path)
+		if !strings.HasPrefix(path, "/uploads/") {
+			return "", fmt.Errorf("logo URL %q is not an uploaded file", src)
+		}
+	}
+
+	// Uploaded files live in ./uploads; don't read anything else
+	rel := strings.TrimPrefix(strings.TrimPrefix(filepath.Clean(path), "/"), "uploads/")
+	if rel == "." || rel == "uploads" || strings.HasPrefix(rel, "..") || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("logo path %q is outside uploads", src)
+	}
+	return filepath.Join("uploads", rel), nil
+}
+
+// docLayout draws text that follows the document direction: the start
+// side is the right edge for Arabic
+type docLayout struct {
+	pdf         *PDF
+	fonts       *PDFFontFamily
+	rtl         bool
+	arabic      bool
+	left, right float64
+	y           float64
+	size        float64
+}
+
+func (l *docLayout) label(en, ar string) string {
+	if l.arabic {
+		return ar
+	}
+	return en
+}
+
+func (l *docLayout) local(name, nameAr string) string {
+	if l.arabic && nameAr != "" {
+		return nameAr
+	}
+	return name
+}
+
+func (l *docLayout) font(size float64, bold bool) {
+	l.size = size
+	l.pdf.SetFont(l.fonts, size, bold)
+}
+
+// start draws s at the start side of [left, right]
+func (l *docLayout) start(left, right, y float64, s string) {
+	if l.rtl {
+		l.pdf.TextRight(right, y, s)
+	} else {
+		l.pdf.Text(left, y, s)
+	}
+}
+
+// end draws s at the end side of [left, right]
+func (l *docLayout) end(left, right, y float64, s string) {
+	if l.rtl {
+		l.pdf.Text(left, y, s)
+	} else {
+		l.pdf.TextRight(right, y, s)
+	}
+}
+
+// text writes wrapped lines at the start side and advances
+func (l *docLayout) text(s string) {
+	if s == "" {
+		return
+	}
+	for _, line := range l.pdf.Wrap(s, l.right-l.left) {
+		l.y += l.size * 1.3
+		l.start(l.left, l.right, l.y, line)
+	}
+}
+
+// center writes wrapped centered lines and advances
+func (l *docLayout) center(s string) {
+	if s == "" {
+		return
+	}
+	for _, line := range l.pdf.Wrap(s, l.right-l.left) {
+		l.y += l.size * 1.3
+		l.pdf.TextCenter((l.left+l.right)/2, l.y, line)
+	}
+}
+
+// row writes a label at the start side and a value at the end side
+func (l *docLayout) row(label, value string) {
+	valueWidth := l.pdf.TextWidth(value) + l.size
+	lines := l.pdf.Wrap(label, l.right-l.left-valueWidth)
+	for i, line := range lines {
+		l.y += l.size * 1.3
+		l.start(l.left, l.right, l.y, line)
+		if i == 0 {
+			l.end(l.left, l.right, l.y, value)
+		}
+	}
+}
+
+// rule draws a dashed separator and advances
+func (l *docLayout) rule() {
+	l.y += l.size * 0.8
+	l.pdf.DashedLine(l.left, l.y, l.right, l.y, 0.5)
+	l.y += 2
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// RenderReceiptPDF lays out an 80mm receipt; the page is as long as the
+// receipt
+func RenderReceiptPDF(data *ReceiptData, fonts *PDFFontFamily, logo *PDFImage) ([]byte, error) {
+	pdf := NewPDF()
+	width := 80 * mmToPt
+	pdf.AddPage(width, 5000)
+
+	margin := 4 * mmToPt
+	l := &docLayout{pdf: pdf, fonts: fonts, rtl: data.RTL, arabic: data.Language == "ar", left: margin, right: width - margin, y: margin}
+
+	if logo != nil {
+		w, h := fitImage(logo, 40*mmToPt, 20*mmToPt)
+		pdf.Image(logo, (width-w)/2, l.y, w, h)
+		l.y += h + 2
+	}
+
+	l.font(12, true)
+	l.center(l.local(data.Restaurant.Name, data.Restaurant.NameAr))
+	l.font(8, false)
+	l.center(data.Restaurant.Address)
+	l.center(data.Restaurant.Phone)
+	if data.Restaurant.TaxNumber != "" {
+		l.center(l.label("Tax No. ", "رقم التسجيل الضريبي ") + data.Restaurant.TaxNumber)
+	}
+	l.center(data.Restaurant.Header)
+	l.rule()
+
+	l.row(l.label("Order", "طلب"), "#"+data.Order.Number)
+	l.row(l.label("Date", "التاريخ"), data.Order.CreatedAt.Format("2006-01-02 15:04"))
+	if data.Order.Table != "" {
+		l.row(l.label("Table", "طاولة"), data.Order.Table)
+	}
+	if data.Customer != nil && data.Customer.Name != "" {
+		l.row(l.label("Customer", "العميل"), data.Customer.Name)
+	}
+	l.rule()
+
+	for _, item := range data.Items {
+		l.font(9, true)
+		l.row(l.local(item.Name, item.NameAr), money(item.Total))
+		l.font(7.5, false)
+		l.text(fmt.Sprintf("%d × %s", item.Quantity, money(item.UnitPrice)))
+		for _, modifier := range item.Modifiers {
+			l.text("+ " + l.local(modifier.Name, modifier.NameAr))
+		}
+		if item.Notes != "" {
+			l.text("* " + item.Notes)
+		}
+	}
+	l.font(8, false)
+	l.rule()
+
+	l.row(l.label("Subtotal", "المجموع"), money(data.Totals.Subtotal))
+	for _, tax := range data.Taxes {
+		l.row(fmt.Sprintf("%s (%s%%)", l.local(tax.Name, tax.NameAr), strconv.FormatFloat(tax.Rate, 'f', -1, 64)), money(tax.Amount))
+	}
+	if data.Totals.Discount > 0 {
+		l.row(l.label("Discount", "الخصم"), "-"+money(data.Totals.Discount))
+	}
+	l.font(11, true)
+	l.row(l.label("Total", "الإجمالي"), money(data.Totals.Total)+" "+data.Restaurant.CurrencySymbol)
+	l.font(8, false)
+	for _, payment := range data.Payments {
+		l.row(paymentLabel(l, payment.Method), money(payment.Amount))
+	}
+	if data.Totals.Change > 0 {
+		l.row(l.label("Change", "الباقي"), money(data.Totals.Change))
+	}
+	l.rule()
+
+	l.center(data.Restaurant.Footer)
+	l.center(l.label("Thank you!", "شكراً لزيارتكم"))
+
+	if data.QR.Content != "" {
+		if qr, err := EncodeQR(data.QR.Content); err == nil {
+			size := 28 * mmToPt
+			l.y += 6
+			pdf.QR(qr, (width-size)/2, l.y, size)
+			l.y += size
+		}
+	}
+
+	pdf.FitHeight(l.y + margin)
+	return pdf.Bytes()
+}
+
+// RenderInvoicePDF lays out an A4 tax invoice, continuing the item table
+// onto further pages as needed
+func RenderInvoicePDF(data *ReceiptData, fonts *PDFFontFamily, logo *PDFImage) ([]byte, error) {
+	pdf := NewPDF()
+	pdf.AddPage(PageA4Width, PageA4Height)
+
+	margin := 15 * mmToPt
+	l := &docLayout{pdf: pdf, fonts: fonts, rtl: data.RTL, arabic: data.Language == "ar", left: margin, right: PageA4Width - margin, y: margin}
+	bottom := PageA4Height - margin
+
+	// Seller block at the start side, logo at the end side
+	top := l.y
+	if logo != nil {
+		w, h := fitImage(logo, 45*mmToPt, 25*mmToPt)
+		x := l.right - w
+		if l.rtl {
+			x = l.left
+		}
+		pdf.Image(logo, x, top, w, h)
+	}
+	l.font(16, true)
+	l.text(l.local(data.Restaurant.Name, data.Restaurant.NameAr))
+	l.font(9, false)
+	l.text(data.Restaurant.Address)
+	l.text(strings.TrimSpace(data.Restaurant.Phone + "  " + data.Restaurant.Email))
+	if data.Restaurant.TaxNumber != "" {
+		l.text(l.label("Tax registration no.: ", "رقم التسجيل الضريبي: ") + data.Restaurant.TaxNumber)
+	}
+	if l.y < top+25*mmToPt {
+		l.y = top + 25*mmToPt
+	}
+
+	l.y += 14
+	l.font(18, true)
+	l.center(l.label("Tax Invoice", "فاتورة ضريبية"))
+	l.y += 8
+	pdf.Line(l.left, l.y, l.right, l.y, 1, 0)
+	l.y += 4
+
+	// Invoice details and buyer side by side
+	half := (l.right - l.left) / 2
+	details := &docLayout{pdf: pdf, fonts: fonts, rtl: l.rtl, arabic: l.arabic, left: l.left, right: l.left + half - 10, y: l.y}
+	buyer := &docLayout{pdf: pdf, fonts: fonts, rtl: l.rtl, arabic: l.arabic, left: l.left + half + 10, right: l.right, y: l.y}
+	if l.rtl {
+		details.left, details.right, buyer.left, buyer.right = buyer.left, buyer.right, details.left, details.right
+	}
+
+	details.font(9, false)
+	details.row(l.label("Invoice no.", "رقم الفاتورة"), data.Order.Number)
+	details.row(l.label("Issue date", "تاريخ الإصدار"), data.PrintedAt.Format("2006-01-02 15:04"))
+	details.row(l.label("Order date", "تاريخ الطلب"), data.Order.CreatedAt.Format("2006-01-02 15:04"))
+	details.row(l.label("Order type", "نوع الطلب"), orderTypeLabel(l, data.Order.Type))
+	if data.Order.Table != "" {
+		details.row(l.label("Table", "طاولة"), data.Order.Table)
+	}
+
+	buyer.font(9, true)
+	buyer.text(l.label("Bill to", "العميل"))
+	buyer.font(9, false)
+	if data.Customer != nil {
+		buyer.text(data.Customer.Name)
+		buyer.text(data.Customer.Phone)
+		buyer.text(data.Customer.Email)
+		buyer.text(data.Customer.Address)
+	} else {
+		buyer.text(l.label("Walk-in customer", "عميل نقدي"))
+	}
+
+	l.y = maxFloat(details.y, buyer.y) + 18
+
+	// Item table. Columns are listed in reading order and mirrored for RTL.
+	type column struct {
+		title   string
+		width   float64
+		numeric bool
+	}
+	tableWidth := l.right - l.left
+	columns := []column{
+		{"#", 0.06, true},
+		{l.label("Description", "الصنف"), 0.49, false},
+		{l.label("Qty", "الكمية"), 0.1, true},
+		{l.label("Unit price", "سعر الوحدة"), 0.17, true},
+		{l.label("Amount", "القيمة"), 0.18, true},
+	}
+	bounds := func(i int) (float64, float64) {
+		offset := 0.0
+		for _, c := range columns[:i] {
+			offset += c.width * tableWidth
+		}
+		w := columns[i].width * tableWidth
+		if l.rtl {
+			return l.right - offset - w, l.right - offset
+		}
+		return l.left + offset, l.left + offset + w
+	}
+	cell := func(i int, y float64, s string) {
+		left, right := bounds(i)
+		left, right = left+4, right-4
+		if columns[i].numeric {
+			l.end(left, right, y, s)
+		} else {
+			l.start(left, right, y, s)
+		}
+	}
+	header := func() {
+		l.font(9, true)
+		pdf.Rect(l.left, l.y, tableWidth, 18, 0.9)
+		for i, c := range columns {
+			cell(i, l.y+12.5, c.title)
+		}
+		l.y += 18
+	}
+
+	header()
+	for n, item := range data.Items {
+		l.font(9, false)
+		descLeft, descRight := bounds(1)
+		lines := pdf.Wrap(l.local(item.Name, item.NameAr), descRight-descLeft-8)
+		var extra []string
+		for _, modifier := range item.Modifiers {
+			extra = append(extra, "+ "+l.local(modifier.Name, modifier.NameAr))
+		}
+		if item.Notes != "" {
+			extra = append(extra, "* "+item.Notes)
+		}
+		height := float64(len(lines))*12 + float64(len(extra))*10 + 6
+
+		if l.y+height > bottom-20 {
+			pdf.AddPage(PageA4Width, PageA4Height)
+			l.y = margin
+			header()
+			l.font(9, false)
+		}
+
+		y := l.y + 12
+		cell(0, y, strconv.Itoa(n+1))
+		for i, line := range lines {
+			cell(1, y+float64(i)*12, line)
+		}
+		cell(2, y, strconv.Itoa(item.Quantity))
+		cell(3, y, money(item.UnitPrice))
+		cell(4, y, money(item.Total))
+
+		l.font(7.5, false)
+		for i, line := range extra {
+			cell(1, y+float64(len(lines))*12+float64(i)*10, line)
+		}
+		l.y += height
+		pdf.Line(l.left, l.y, l.right, l.y, 0.3, 0.6)
+	}
+
+	// Totals block at the end side, QR code at the start side
+	totalsHeight := float64(4+len(data.Taxes)+len(data.Payments)) * 14
+	qrSize := 32 * mmToPt
+	if l.y+maxFloat(totalsHeight, qrSize)+40 > bottom {
+		pdf.AddPage(PageA4Width, PageA4Height)
+		l.y = margin
+	}
+	l.y += 10
+	blockTop := l.y
+
+	totals := &docLayout{pdf: pdf, fonts: fonts, rtl: l.rtl, arabic: l.arabic, left: l.left + tableWidth*0.55, right: l.right, y: l.y}
+	if l.rtl {
+		totals.left, totals.right = l.left, l.right-tableWidth*0.55
+	}
+	totals.font(9.5, false)
+	totals.row(l.label("Subtotal", "المجموع قبل الضريبة"), money(data.Totals.Subtotal))
+	if data.Totals.Discount > 0 {
+		totals.row(l.label("Discount", "الخصم"), "-"+money(data.Totals.Discount))
+	}
+	for _, tax := range data.Taxes {
+		totals.row(fmt.Sprintf("%s (%s%%)", l.local(tax.Name, tax.NameAr), strconv.FormatFloat(tax.Rate, 'f', -1, 64)), money(tax.Amount))
+	}
+	totals.y += 4
+	pdf.Line(totals.left, totals.y, totals.right, totals.y, 0.8, 0)
+	totals.font(12, true)
+	totals.row(l.label("Total", "الإجمالي"), money(data.Totals.Total)+" "+data.Restaurant.CurrencySymbol)
+	totals.font(9, false)
+	for _, payment := range data.Payments {
+		totals.row(paymentLabel(l, payment.Method), money(payment.Amount))
+	}
+	if data.Totals.Remaining > 0 {
+		totals.row(l.label("Balance due", "المتبقي"), money(data.Totals.Remaining))
+	}
+
+	qrBottom := blockTop
+	if data.QR.Content != "" {
+		if qr, err := EncodeQR(data.QR.Content); err == nil {
+			x := l.left
+			if l.rtl {
+				x = l.right - qrSize
+			}
+			pdf.QR(qr, x, blockTop, qrSize)
+			qrBottom = blockTop + qrSize
+		}
+	}
+
+	l.y = maxFloat(totals.y, qrBottom) + 24
+	l.font(9, false)
+	l.center(data.Restaurant.Footer)
+	l.center(l.label("Thank you for your business", "شكراً لتعاملكم معنا"))
+
+	return pdf.Bytes()
+}
+
+func paymentLabel(l *docLayout, method string) string {
+	switch method {
+	case "cash":
+		return l.label("Cash", "نقدي")
+	case "card":
+		return l.label("Card", "بطاقة")
+	case "wallet":
+		return l.label("Wallet", "محفظة")
+	}
+	return method
+}
+
+func orderTypeLabel(l *docLayout, orderType string) string {
+	switch orderType {
+	case "dine_in":
+		return l.label("Dine in", "صالة")
+	case "takeaway":
+		return l.label("Takeaway", "تيك أواي")
+	case "delivery":
+		return l.label("Delivery", "توصيل")
+	}
+	return orderType
+}
+
+// fitImage scales an image to fit within maxW by maxH
+func fitImage(img *PDFImage, maxW, maxH float64) (float64, float64) {
+	w, h := float64(img.Width), float64(img.Height)
+	scale := maxW / w
+	if h*scale > maxH {
+		scale = maxH / h
+	}
+	return w * scale, h * scale
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// ========================================
+// SIGNED DOCUMENT LINKS
+// ========================================
+
+// documentKeyLabel is the HKDF info that separates the document link key
+// from anything else derived from the JWT secret
+const documentKeyLabel = "restaurant-pos document links v1"
+
+// deriveDocumentKey derives a 32-byte document link key from secret with
+// HKDF-SHA256 (RFC 5869), so links aren't signed with the JWT key itself
+func deriveDocumentKey(secret string) string {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write([]byte(secret))
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(documentKeyLabel))
+	expand.Write([]byte{1})
+	return string(expand.Sum(nil))
+}
+
+// documentSignature signs a document link
+func (a *App) documentSignature(kind string, orderID uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(a.Config.Documents.SigningKey))
+	fmt.Fprintf(mac, "%s:%d:%d", kind, orderID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignDocumentURL returns a public link to an order document that works
+// until ttl has passed
+func (a *App) SignDocumentURL(baseURL, kind string, orderID uint, ttl time.Duration) (string, time.Time) {
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	url := fmt.Sprintf("%s/api/public/documents/%s/%d?expires=%d&signature=%s",
+		strings.TrimRight(baseURL, "/"), kind, orderID, expires.Unix(), a.documentSignature(kind, orderID, expires.Unix()))
+	return url, expires
+}
+
+// VerifyDocumentLink checks a signed link's signature and expiry
+func (a *App) VerifyDocumentLink(kind string, orderID uint, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	expected := a.documentSignature(kind, orderID, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+// publicBaseURL is the configured public URL, or the one the request came
+// in on
+func (a *App) publicBaseURL(c *gin.Context) string {
+	if a.Config.Documents.PublicURL != "" {
+		return a.Config.Documents.PublicURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// ========================================
+// DOCUMENT HANDLERS
+// ========================================
+
+// HandleGetOrderDocument downloads an order's receipt or invoice PDF
+func (a *App) HandleGetOrderDocument(c *gin.Context) {
+	a.serveOrderDocument(c, uint(getInt(c.Param("id"))), c.Param("kind"), "attachment")
+}
+
+// DocumentLinkRequest optionally shortens or extends a link's lifetime
+type DocumentLinkRequest struct {
+	TTLMinutes int `json:"ttl_minutes"`
+}
+
+// HandleCreateDocumentLink returns a signed public link to an order document
+func (a *App) HandleCreateDocumentLink(c *gin.Context) {
+	kind := c.Param("kind")
+	if kind != DocumentReceipt && kind != DocumentInvoice {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Document must be receipt or invoice"})
+		return
+	}
+
+	var order Order
+	if err := a.DB.Select("id").First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+		return
+	}
+
+	var req DocumentLinkRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+			return
+		}
+	}
+	ttl := a.Config.Documents.LinkTTL
+	if req.TTLMinutes > 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+	}
+	if ttl > 24*time.Hour {
+		ttl = 24 * time.Hour
+	}
+
+	url, expires := a.SignDocumentURL(a.publicBaseURL(c), kind, order.ID, ttl)
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Data: gin.H{
+			"url":        url,
+			"expires_at": expires,
+		},
+	})
+}
+
+// HandleGetPublicDocument serves a document through a signed link
+func (a *App) HandleGetPublicDocument(c *gin.Context) {
+	kind := c.Param("kind")
+	orderID := uint(getInt(c.Param("id")))
+
+	switch err := a.VerifyDocumentLink(kind, orderID, c.Query("expires"), c.Query("signature")); err {
+	case nil:
+	case ErrLinkExpired:
+		c.JSON(http.StatusGone, ErrorResponse{Error: "Link expired"})
+		return
+	default:
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Invalid link"})
+		return
+	}
+
+	a.serveOrderDocument(c, orderID, kind, "inline")
+}
+
+// serveOrderDocument renders and writes a document PDF
+func (a *App) serveOrderDocument(c *gin.Context, orderID uint, kind, disposition string) {
+	if kind != DocumentReceipt && kind != DocumentInvoice {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Document must be receipt or invoice"})
+		return
+	}
+
+	pdf, order, err := a.RenderOrderDocument(orderID, kind)
+	if order == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+		return
+	}
+	if errors.Is(err, ErrNoPDFFont) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "PDF output is not configured", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to render document", Message: err.Error()})
+		return
+	}
+
+	filename := kind + "-" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, order.OrderNumber) + ".pdf"
+
+	c.Header("Content-Disposition", fmt.Sprintf(`%s; filename="%s"`, disposition, filename))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUploadedLogoPath(t *testing.T) {
+	allowed := map[string]string{
+		"uploads/logo.png":                          filepath.Join("uploads", "logo.png"),
+		"/uploads/logo.png":                         filepath.Join("uploads", "logo.png"),
+		"./uploads/logo_1.png":                      filepath.Join("uploads", "logo_1.png"),
+		"https://pos.example.com/uploads/brand.png": filepath.Join("uploads", "brand.png"),
+	}
+	for src, want := range allowed {
+		got, err := uploadedLogoPath(src)
+		if err != nil || got != want {
+			t.Errorf("uploadedLogoPath(%q) = %q, %v; want %q", src, got, err, want)
+		}
+	}
+
+	for _, src := range []string{
+		"../etc/passwd",
+		"uploads/../../main.go",
+		"uploads/",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://pos.example.com/static/logo.png",
+		"https://pos.example.com/uploads/../../etc/passwd",
+		"file:///etc/passwd",
+		"ftp://example.com/uploads/logo.png",
+	} {
+		if path, err := uploadedLogoPath(src); err == nil {
+			t.Errorf("uploadedLogoPath(%q) = %q, want an error", src, path)
+		}
+	}
+}
+
+func TestLoadLogoDoesNotFetch(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	for _, src := range []string{server.URL + "/logo.png", server.URL + "/uploads/logo.png"} {
+		if _, err := loadLogo(src); err == nil {
+			t.Errorf("loadLogo(%q) succeeded", src)
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("logo server was requested %d times", n)
+	}
+}
+
+func TestDeriveDocumentKey(t *testing.T) {
+	key := deriveDocumentKey("jwt-secret")
+	if len(key) != sha256.Size {
+		t.Fatalf("key is %d bytes, want %d", len(key), sha256.Size)
+	}
+	if key == "jwt-secret" || key == deriveDocumentKey("other-secret") {
+		t.Error("derived key does not depend on the secret")
+	}
+	if key != deriveDocumentKey("jwt-secret") {
+		t.Error("derivation is not deterministic")
+	}
+
+	// A link signed with the bare JWT secret must not verify
+	expires := time.Now().Add(time.Minute).Unix()
+	mac := hmac.New(sha256.New, []byte("jwt-secret"))
+	fmt.Fprintf(mac, "%s:%d:%d", DocumentReceipt, 7, expires)
+	forged := hex.EncodeToString(mac.Sum(nil))
+
+	a := &App{Config: &Config{}}
+	a.Config.Documents.SigningKey = key
+	if err := a.VerifyDocumentLink(DocumentReceipt, 7, strconv.FormatInt(expires, 10), forged); err != ErrBadSignature {
+		t.Errorf("link signed with the JWT secret: %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestSignedDocumentLink(t *testing.T) {
+	a := &App{Config: &Config{}}
+	a.Config.Documents.SigningKey = deriveDocumentKey("jwt-secret")
+
+	link, _ := a.SignDocumentURL("https://pos.example.com/", DocumentInvoice, 12, time.Minute)
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("bad link %q: %v", link, err)
+	}
+	if parsed.Path != "/api/public/documents/"+DocumentInvoice+"/12" {
+		t.Errorf("link path = %s", parsed.Path)
+	}
+	query := parsed.Query()
+	if err := a.VerifyDocumentLink(DocumentInvoice, 12, query.Get("expires"), query.Get("signature")); err != nil {
+		t.Errorf("fresh link rejected: %v", err)
+	}
+	if err := a.VerifyDocumentLink(DocumentInvoice, 13, query.Get("expires"), query.Get("signature")); err != ErrBadSignature {
+		t.Errorf("link reused for another order: %v", err)
+	}
+
+	expired, _ := a.SignDocumentURL("https://pos.example.com", DocumentInvoice, 12, -time.Minute)
+	parsed, _ = url.Parse(expired)
+	query = parsed.Query()
+	if err := a.VerifyDocumentLink(DocumentInvoice, 12, query.Get("expires"), query.Get("signature")); err != ErrLinkExpired {
+		t.Errorf("expired link: %v, want %v", err, ErrLinkExpired)
+	}
+}
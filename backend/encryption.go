@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// FIELD ENCRYPTION
+// ========================================
+//
+// Integration credentials, webhook signing secrets and customer contact
+// details, including the copies on orders and reservations, are stored
+// encrypted with AES-256-GCM. Keys come from ENCRYPTION_KEYS as comma-separated
+// "id:base64key" pairs; the first encrypts new values and the rest are only
+// kept to read values written before a rotation. Stored values look like
+// "enc:<key id>:<base64 nonce and ciphertext>". Values without that prefix
+// were written before encryption and are read as they are.
+//
+// At startup, values written in plaintext or under an older key are
+// re-encrypted under the current one. To rotate, put the new key first and
+// restart; POST /api/encryption/rotate runs the same pass again. Once it
+// reports nothing failed the old key can be removed.
+//
+// Encrypted columns can't be searched, so customers also store a blind
+// index of their phone number: an HMAC of its E.164 form under
+// BLIND_INDEX_KEY. The same pass rebuilds indexes made with another index
+// key, so changing it only breaks phone lookups until the pass finishes.
+
+// encryptedPrefix marks a value written by FieldCipher
+const encryptedPrefix = "enc:"
+
+// Encryption errors
+var (
+	ErrEncryptionNotConfigured = errors.New("field encryption is not configured")
+	ErrUnknownEncryptionKey    = errors.New("value was encrypted with a key that is no longer configured")
+	ErrMalformedCiphertext     = errors.New("malformed encrypted value")
+)
+
+// fieldCipher encrypts EncryptedString columns. Scanners and valuers have
+// no access to the App, so it is set once in main.
+var fieldCipher *FieldCipher
+
+// FieldCipher encrypts and decrypts column values and computes blind indexes
+type FieldCipher struct {
+	current     string
+	keys        map[string]cipher.AEAD
+	indexKey    []byte
+	countryCode string
+}
+
+// NewFieldCipher creates the cipher from the encryption config. Without
+// configured keys it derives them from the JWT secret, which is only good
+// enough for development.
+func NewFieldCipher(config *Config) (*FieldCipher, error) {
+	spec := config.Encryption.Keys
+	if spec == "" {
+		log.Println("⚠️  ENCRYPTION_KEYS is not set; deriving the encryption key from JWT_SECRET")
+		sum := sha256.Sum256([]byte("field-encryption:" + config.JWT.Secret))
+		spec = "0:" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	f := &FieldCipher{keys: map[string]cipher.AEAD{}, countryCode: config.Messaging.CountryCode}
+	for _, pair := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption keys must be id:base64key pairs")
+		}
+		if _, dup := f.keys[id]; dup {
+			return nil, fmt.Errorf("encryption key %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes, base64 encoded", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		f.keys[id] = aead
+		if f.current == "" {
+			f.current = id
+		}
+	}
+
+	f.indexKey = []byte(config.Encryption.BlindIndexKey)
+	if len(f.indexKey) == 0 {
+		log.Println("⚠️  BLIND_INDEX_KEY is not set; deriving it from JWT_SECRET")
+		sum := sha256.Sum256([]byte("blind-index:" + config.JWT.Secret))
+		f.indexKey = sum[:]
+	}
+	return f, nil
+}
+
+// Encrypt seals plaintext under the current key. Empty strings stay empty.
+func (f *FieldCipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	aead := f.keys[f.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + f.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value written by Encrypt. Plaintext written before
+// encryption is returned unchanged.
+func (f *FieldCipher) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", ErrMalformedCiphertext
+	}
+	aead, ok := f.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownEncryptionKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformedCiphertext
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("encrypted value failed authentication")
+	}
+	return string(plain), nil
+}
+
+// IsCurrent reports whether a stored value is empty or encrypted under the
+// current key
+func (f *FieldCipher) IsCurrent(value string) bool {
+	return value == "" || strings.HasPrefix(value, encryptedPrefix+f.current+":")
+}
+
+// BlindIndex returns a keyed hash of value for equality lookups
+func (f *FieldCipher) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, f.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PhoneIndex returns the blind index of a phone number written in any
+// common form, or "" when there is no number
+func (f *FieldCipher) PhoneIndex(phone string) string {
+	if strings.TrimSpace(phone) == "" {
+		return ""
+	}
+	if e164, err := NormalizePhone(phone, f.countryCode); err == nil {
+		phone = e164
+	}
+	return f.BlindIndex(phone)
+}
+
+// EncryptedString is a string column stored encrypted by fieldCipher
+type EncryptedString string
+
+// String returns the plaintext
+func (s EncryptedString) String() string {
+	return string(s)
+}
+
+// Value implements driver.Valuer
+func (s EncryptedString) Value() (driver.Value, error) {
+	if fieldCipher == nil {
+		return nil, ErrEncryptionNotConfigured
+	}
+	return fieldCipher.Encrypt(string(s))
+}
+
+// Scan implements sql.Scanner
+func (s *EncryptedString) Scan(src interface{}) error {
+	var value string
+	switch v := src.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("cannot scan %T into EncryptedString", src)
+	}
+	if fieldCipher == nil {
+		return ErrEncryptionNotConfigured
+	}
+	plain, err := fieldCipher.Decrypt(value)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plain)
+	return nil
+}
+
+// ========================================
+// CUSTOMER CONTACT DETAILS
+// ========================================
+
+// BeforeSave keeps the phone blind index in step with the phone
+func (c *Customer) BeforeSave(tx *gorm.DB) error {
+	if fieldCipher == nil {
+		return ErrEncryptionNotConfigured
+	}
+	if index := fieldCipher.PhoneIndex(c.Phone.String()); index != "" {
+		c.PhoneIndex = &index
+	}
+	return nil
+}
+
+// FindCustomerByPhone looks a customer up by phone number in any common form
+func FindCustomerByPhone(db *gorm.DB, phone string) (*Customer, error) {
+	index := fieldCipher.PhoneIndex(phone)
+	if index == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var customer Customer
+	if err := db.Where("phone_index = ?", index).First(&customer).Error; err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// ========================================
+// KEY ROTATION
+// ========================================
+
+// EncryptionRotation counts what a rotation pass changed
+type EncryptionRotation struct {
+	Customers    int `json:"customers"`
+	Orders       int `json:"orders"`
+	Reservations int `json:"reservations"`
+	Credentials  int `json:"credentials"`
+	Webhooks     int `json:"webhooks"`
+	Failed       int `json:"failed"`
+}
+
+// rotationBatch is how many rows a rotation pass reads at a time
+const rotationBatch = 200
+
+// RotateEncryption re-encrypts values written under an old key or before
+// encryption, and rebuilds phone indexes made with another index key
+func (a *App) RotateEncryption() (*EncryptionRotation, error) {
+	result := &EncryptionRotation{}
+
+	type customerRow struct {
+		ID         uint
+		Phone      string
+		Email      string
+		Address    string
+		PhoneIndex *string
+	}
+	var lastID uint
+	for {
+		var rows []customerRow
+		if err := a.DB.Model(&Customer{}).Select("id, phone, email, address, phone_index").
+			Where("id > ?", lastID).Order("id ASC").Limit(rotationBatch).Scan(&rows).Error; err != nil {
+			return result, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			lastID = row.ID
+			phone, err1 := fieldCipher.Decrypt(row.Phone)
+			email, err2 := fieldCipher.Decrypt(row.Email)
+			address, err3 := fieldCipher.Decrypt(row.Address)
+			if err := errors.Join(err1, err2, err3); err != nil {
+				log.Printf("Cannot re-encrypt customer %d: %v", row.ID, err)
+				result.Failed++
+				continue
+			}
+			index := fieldCipher.PhoneIndex(phone)
+			if fieldCipher.IsCurrent(row.Phone) && fieldCipher.IsCurrent(row.Email) &&
+				fieldCipher.IsCurrent(row.Address) && row.PhoneIndex != nil && *row.PhoneIndex == index {
+				continue
+			}
+			if err := a.DB.Model(&Customer{}).Where("id = ?", row.ID).UpdateColumns(map[string]interface{}{
+				"phone":       EncryptedString(phone),
+				"email":       EncryptedString(email),
+				"address":     EncryptedString(address),
+				"phone_index": index,
+			}).Error; err != nil {
+				log.Printf("Cannot re-encrypt customer %d: %v", row.ID, err)
+				result.Failed++
+				continue
+			}
+			result.Customers++
+		}
+	}
+
+	passes := []struct {
+		model   interface{}
+		entity  string
+		columns []string
+		count   *int
+	}{
+		{&Order{}, "order", []string{"customer_phone", "customer_address"}, &result.Orders},
+		{&Reservation{}, "reservation", []string{"customer_phone"}, &result.Reservations},
+		{&IntegrationCredential{}, "credential", []string{"value"}, &result.Credentials},
+		{&WebhookSubscription{}, "webhook", []string{"secret"}, &result.Webhooks},
+	}
+	for _, pass := range passes {
+		if err := a.reencryptColumns(pass.model, pass.entity, pass.columns, pass.count, &result.Failed); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// reencryptColumns rewrites the given EncryptedString columns of model's
+// table where any of them isn't under the current key, counting rewritten
+// rows in rotated and rows that can't be read in failed
+func (a *App) reencryptColumns(model interface{}, entity string, columns []string, rotated, failed *int) error {
+	type encryptedRow struct {
+		id     uint
+		values []sql.NullString
+	}
+	var lastID uint
+	for {
+		rows, err := a.DB.Model(model).Select(append([]string{"id"}, columns...)).
+			Where("id > ?", lastID).Order("id ASC").Limit(rotationBatch).Rows()
+		if err != nil {
+			return err
+		}
+		var batch []encryptedRow
+		for rows.Next() {
+			row := encryptedRow{values: make([]sql.NullString, len(columns))}
+			dest := []interface{}{&row.id}
+			for i := range row.values {
+				dest = append(dest, &row.values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, row := range batch {
+			lastID = row.id
+			current := true
+			updates := make(map[string]interface{}, len(columns))
+			var errs []error
+			for i, column := range columns {
+				stored := row.values[i].String
+				current = current && fieldCipher.IsCurrent(stored)
+				plain, err := fieldCipher.Decrypt(stored)
+				errs = append(errs, err)
+				updates[column] = EncryptedString(plain)
+			}
+			if current {
+				continue
+			}
+			err := errors.Join(errs...)
+			if err == nil {
+				err = a.DB.Model(model).Where("id = ?", row.id).UpdateColumns(updates).Error
+			}
+			if err != nil {
+				log.Printf("Cannot re-encrypt %s %d: %v", entity, row.id, err)
+				*failed++
+				continue
+			}
+			*rotated++
+		}
+	}
+}
+
+// HandleRotateEncryption re-encrypts stored values under the current key
+func (a *App) HandleRotateEncryption(c *gin.Context) {
+	result, err := a.RotateEncryption()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to rotate encryption", Message: err.Error()})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "encryption_rotated", Entity: "system", Details: result})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Stored values re-encrypted",
+		Data:    result,
+	})
+}
@@ -0,0 +1,761 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ========================================
+// ESC/POS DRIVER
+// ========================================
+
+// ESC/POS alignment values
+const (
+	AlignLeft   = 0
+	AlignCenter = 1
+	AlignRight  = 2
+)
+
+// TextRasterizer renders a line of text to a monochrome image. It is used
+// for text the printer's code page can't represent, such as Arabic on a
+// printer without PC864; FontRasterizer draws it with a TrueType font.
+type TextRasterizer interface {
+	RasterizeLine(text string, widthDots int, align int) (image.Image, error)
+}
+
+// ESCPOS builds a byte stream of ESC/POS commands for thermal printers
+type ESCPOS struct {
+	// Columns is the number of font A characters per line (48 on 80mm, 32 on 58mm)
+	Columns int
+	// WidthDots is the printable width in dots, used for raster output
+	WidthDots int
+	// CodePage selects the character table for non-ASCII text
+	CodePage CodePage
+	// Rasterizer, when set, prints lines the code page can't encode as images
+	Rasterizer TextRasterizer
+
+	buf       bytes.Buffer
+	align     int
+	widthMult int
+}
+
+// NewESCPOS creates a builder for a printer of the given paper width in mm
+func NewESCPOS(paperWidth int) *ESCPOS {
+	p := &ESCPOS{
+		Columns:   48,
+		WidthDots: 576,
+		CodePage:  CodePagePC864,
+		widthMult: 1,
+	}
+	if paperWidth > 0 && paperWidth <= 58 {
+		p.Columns = 32
+		p.WidthDots = 384
+	}
+	return p
+}
+
+// Bytes returns the command stream built so far
+func (p *ESCPOS) Bytes() []byte {
+	return p.buf.Bytes()
+}
+
+// Init resets the printer and selects the code page
+func (p *ESCPOS) Init() *ESCPOS {
+	p.buf.Write([]byte{0x1B, 0x40})
+	p.buf.Write([]byte{0x1B, 0x74, p.CodePage.Table})
+	p.align = AlignLeft
+	p.widthMult = 1
+	return p
+}
+
+// Align sets text alignment
+func (p *ESCPOS) Align(align int) *ESCPOS {
+	p.align = align
+	p.buf.Write([]byte{0x1B, 0x61, byte(align)})
+	return p
+}
+
+// Bold turns emphasized mode on or off
+func (p *ESCPOS) Bold(on bool) *ESCPOS {
+	p.buf.Write([]byte{0x1B, 0x45, boolByte(on)})
+	return p
+}
+
+// Underline turns underline on or off
+func (p *ESCPOS) Underline(on bool) *ESCPOS {
+	p.buf.Write([]byte{0x1B, 0x2D, boolByte(on)})
+	return p
+}
+
+// Reverse turns white-on-black printing on or off
+func (p *ESCPOS) Reverse(on bool) *ESCPOS {
+	p.buf.Write([]byte{0x1D, 0x42, boolByte(on)})
+	return p
+}
+
+// Size sets the character width and height multipliers (1-8)
+func (p *ESCPOS) Size(width, height int) *ESCPOS {
+	width = clampInt(width, 1, 8)
+	height = clampInt(height, 1, 8)
+	p.widthMult = width
+	p.buf.Write([]byte{0x1D, 0x21, byte((width-1)<<4 | (height - 1))})
+	return p
+}
+
+// Text prints s without a line break. Arabic is shaped and reordered for
+// display; anything the code page can't encode becomes '?'.
+func (p *ESCPOS) Text(s string) *ESCPOS {
+	encoded, _ := p.CodePage.Encode(VisualOrder(ShapeArabic(s)))
+	p.buf.Write(encoded)
+	return p
+}
+
+// Line prints s followed by a line feed. When the code page can't encode
+// the line and a rasterizer is configured, the line is printed as an image.
+func (p *ESCPOS) Line(s string) *ESCPOS {
+	visual := VisualOrder(ShapeArabic(s))
+	encoded, ok := p.CodePage.Encode(visual)
+	if !ok && p.Rasterizer != nil {
+		if img, err := p.Rasterizer.RasterizeLine(s, p.WidthDots, p.align); err == nil {
+			return p.Raster(img)
+		}
+	}
+	p.buf.Write(encoded)
+	p.buf.WriteByte('\n')
+	return p
+}
+
+// Columns2 prints a two-column line, such as an item and its price, padded
+// to the paper width. In right-to-left lines the columns are swapped.
+func (p *ESCPOS) Columns2(left, right string) *ESCPOS {
+	width := p.Columns / p.widthMult
+	left = ShapeArabic(left)
+	right = ShapeArabic(right)
+
+	rightLen := utf8.RuneCountInString(right)
+	maxLeft := width - rightLen - 1
+	if maxLeft < 1 {
+		maxLeft = 1
+	}
+	left = truncateRunes(left, maxLeft)
+	pad := width - utf8.RuneCountInString(left) - rightLen
+	if pad < 1 {
+		pad = 1
+	}
+
+	var line string
+	if isRTL(left) {
+		// Visual order of an RTL line puts the first logical column on the right
+		line = VisualOrder(right) + strings.Repeat(" ", pad) + VisualOrder(left)
+	} else {
+		line = VisualOrder(left) + strings.Repeat(" ", pad) + VisualOrder(right)
+	}
+
+	encoded, ok := p.CodePage.Encode(line)
+	if !ok && p.Rasterizer != nil {
+		if img, err := p.rasterizeColumns(left, right); err == nil {
+			return p.Raster(img)
+		}
+	}
+	p.buf.Write(encoded)
+	p.buf.WriteByte('\n')
+	return p
+}
+
+// rasterizeColumns draws two columns as one image, the first logical column
+// at the start of the line
+func (p *ESCPOS) rasterizeColumns(left, right string) (image.Image, error) {
+	leftAlign, rightAlign := AlignLeft, AlignRight
+	if isRTL(left) {
+		leftAlign, rightAlign = AlignRight, AlignLeft
+	}
+	img, err := p.Rasterizer.RasterizeLine(left, p.WidthDots, leftAlign)
+	if err != nil {
+		return nil, err
+	}
+	other, err := p.Rasterizer.RasterizeLine(right, p.WidthDots, rightAlign)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds().Union(other.Bounds())
+	merged := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			merged.Set(x, y, color.White)
+			for _, src := range []image.Image{img, other} {
+				if !(image.Point{x, y}).In(src.Bounds()) {
+					continue
+				}
+				if gray := color.GrayModel.Convert(src.At(x, y)).(color.Gray); gray.Y < 0x80 {
+					merged.Set(x, y, color.Black)
+				}
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Separator prints a full-width line of ch
+func (p *ESCPOS) Separator(ch string) *ESCPOS {
+	p.buf.WriteString(strings.Repeat(ch, p.Columns/p.widthMult))
+	p.buf.WriteByte('\n')
+	return p
+}
+
+// Feed advances the paper n lines
+func (p *ESCPOS) Feed(n int) *ESCPOS {
+	p.buf.Write([]byte{0x1B, 0x64, byte(clampInt(n, 0, 255))})
+	return p
+}
+
+// Cut feeds past the cutter and cuts the paper
+func (p *ESCPOS) Cut(partial bool) *ESCPOS {
+	mode := byte(0x41)
+	if partial {
+		mode = 0x42
+	}
+	p.buf.Write([]byte{0x1D, 0x56, mode, 0x03})
+	return p
+}
+
+// KickDrawer pulses the cash drawer connector (pin 2)
+func (p *ESCPOS) KickDrawer() *ESCPOS {
+	p.buf.Write([]byte{0x1B, 0x70, 0x00, 0x19, 0xFA})
+	return p
+}
+
+// QRCode prints a QR code with the printer's built-in encoder (GS ( k),
+// module size 1-16 dots
+func (p *ESCPOS) QRCode(data string, size int) *ESCPOS {
+	if len(data) == 0 || len(data) > 7000 {
+		return p
+	}
+	// Model 2, module size, error correction level M
+	p.buf.Write([]byte{0x1D, 0x28, 0x6B, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00})
+	p.buf.Write([]byte{0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x43, byte(clampInt(size, 1, 16))})
+	p.buf.Write([]byte{0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x45, 0x31})
+
+	// Store the data, then print it
+	n := len(data) + 3
+	p.buf.Write([]byte{0x1D, 0x28, 0x6B, byte(n), byte(n >> 8), 0x31, 0x50, 0x30})
+	p.buf.WriteString(data)
+	p.buf.Write([]byte{0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x51, 0x30})
+	p.buf.WriteByte('\n')
+	return p
+}
+
+// Raster prints img as a monochrome raster bit image (GS v 0), scaled down
+// to the paper width if needed, keeping its aspect ratio
+func (p *ESCPOS) Raster(img image.Image) *ESCPOS {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > p.WidthDots && p.WidthDots > 0 {
+		height = height * p.WidthDots / width
+		width = p.WidthDots
+	}
+	if width <= 0 || height <= 0 {
+		return p
+	}
+
+	bytesPerRow := (width + 7) / 8
+	p.buf.Write([]byte{0x1D, 0x76, 0x30, 0x00,
+		byte(bytesPerRow), byte(bytesPerRow >> 8),
+		byte(height), byte(height >> 8)})
+
+	row := make([]byte, bytesPerRow)
+	for y := 0; y < height; y++ {
+		for i := range row {
+			row[i] = 0
+		}
+		for x := 0; x < width; x++ {
+			// Nearest source pixel
+			srcX := bounds.Min.X + x*bounds.Dx()/width
+			srcY := bounds.Min.Y + y*bounds.Dy()/height
+			r, g, b, a := img.At(srcX, srcY).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// Rec. 601 luma; dark pixels print
+			luma := (299*r + 587*g + 114*b) / 1000
+			if luma < 0x8000 {
+				row[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+		p.buf.Write(row)
+	}
+	return p
+}
+
+func boolByte(on bool) byte {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
+
+// ========================================
+// CODE PAGES
+// ========================================
+
+// CodePage maps Unicode to a printer character table
+type CodePage struct {
+	Name string
+	// Table is the ESC t argument selecting this page; it varies between
+	// printer models, so printers can override it
+	Table byte
+	High  map[rune]byte
+}
+
+// Encode converts s to the code page. ok is false if any rune had to be
+// replaced with '?'.
+func (cp CodePage) Encode(s string) ([]byte, bool) {
+	out := make([]byte, 0, len(s))
+	ok := true
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case cp.High != nil && cp.High[r] != 0:
+			out = append(out, cp.High[r])
+		default:
+			out = append(out, '?')
+			ok = false
+		}
+	}
+	return out, ok
+}
+
+// CodePagePC437 is the default US table (ASCII only here)
+var CodePagePC437 = CodePage{Name: "PC437", Table: 0}
+
+// CodePagePC864 is the IBM Arabic table. It holds presentation forms, so
+// text must be shaped with ShapeArabic first.
+var CodePagePC864 = CodePage{
+	Name:  "PC864",
+	Table: 37,
+	High: map[rune]byte{
+		0x00B0: 0x80, 0x00B7: 0x81, 0x2219: 0x82, 0x221A: 0x83, 0x2592: 0x84,
+		0x2500: 0x85, 0x2502: 0x86, 0x253C: 0x87, 0x2524: 0x88, 0x252C: 0x89,
+		0x251C: 0x8A, 0x2534: 0x8B, 0x2510: 0x8C, 0x250C: 0x8D, 0x2514: 0x8E,
+		0x2518: 0x8F, 0x03B2: 0x90, 0x221E: 0x91, 0x03C6: 0x92, 0x00B1: 0x93,
+		0x00BD: 0x94, 0x00BC: 0x95, 0x2248: 0x96, 0x00AB: 0x97, 0x00BB: 0x98,
+		0xFEF7: 0x99, 0xFEF8: 0x9A, 0xFEFB: 0x9D, 0xFEFC: 0x9E,
+		0x00A0: 0xA0, 0x00AD: 0xA1, 0xFE82: 0xA2, 0x00A3: 0xA3, 0x00A4: 0xA4,
+		0xFE84: 0xA5, 0xFE8E: 0xA8, 0xFE8F: 0xA9, 0xFE95: 0xAA, 0xFE99: 0xAB,
+		0x060C: 0xAC, 0xFE9D: 0xAD, 0xFEA1: 0xAE, 0xFEA5: 0xAF,
+		0x0660: 0xB0, 0x0661: 0xB1, 0x0662: 0xB2, 0x0663: 0xB3, 0x0664: 0xB4,
+		0x0665: 0xB5, 0x0666: 0xB6, 0x0667: 0xB7, 0x0668: 0xB8, 0x0669: 0xB9,
+		0xFED1: 0xBA, 0x061B: 0xBB, 0xFEB1: 0xBC, 0xFEB5: 0xBD, 0xFEB9: 0xBE,
+		0x061F: 0xBF, 0x00A2: 0xC0, 0xFE80: 0xC1, 0xFE81: 0xC2, 0xFE83: 0xC3,
+		0xFE85: 0xC4, 0xFECA: 0xC5, 0xFE8B: 0xC6, 0xFE8D: 0xC7, 0xFE91: 0xC8,
+		0xFE93: 0xC9, 0xFE97: 0xCA, 0xFE9B: 0xCB, 0xFE9F: 0xCC, 0xFEA3: 0xCD,
+		0xFEA7: 0xCE, 0xFEA9: 0xCF, 0xFEAB: 0xD0, 0xFEAD: 0xD1, 0xFEAF: 0xD2,
+		0xFEB3: 0xD3, 0xFEB7: 0xD4, 0xFEBB: 0xD5, 0xFEBF: 0xD6, 0xFEC1: 0xD7,
+		0xFEC5: 0xD8, 0xFECB: 0xD9, 0xFECF: 0xDA, 0x00A6: 0xDB, 0x00AC: 0xDC,
+		0x00F7: 0xDD, 0x00D7: 0xDE, 0xFEC9: 0xDF, 0x0640: 0xE0, 0xFED3: 0xE1,
+		0xFED7: 0xE2, 0xFEDB: 0xE3, 0xFEDF: 0xE4, 0xFEE3: 0xE5, 0xFEE7: 0xE6,
+		0xFEEB: 0xE7, 0xFEED: 0xE8, 0xFEEF: 0xE9, 0xFEF3: 0xEA, 0xFEBD: 0xEB,
+		0xFECC: 0xEC, 0xFECE: 0xED, 0xFECD: 0xEE, 0xFEE1: 0xEF, 0xFE7D: 0xF0,
+		0x0651: 0xF1, 0xFEE5: 0xF2, 0xFEE9: 0xF3, 0xFEEC: 0xF4, 0xFEF0: 0xF5,
+		0xFEF2: 0xF6, 0xFED0: 0xF7, 0xFED5: 0xF8, 0xFEF5: 0xF9, 0xFEF6: 0xFA,
+		0xFEDD: 0xFB, 0xFED9: 0xFC, 0xFEF1: 0xFD, 0x25A0: 0xFE,
+	},
+}
+
+func init() {
+	// PC864 lacks most medial and final forms; printers use the initial form
+	// for medial and the isolated form for final, which look alike on paper
+	for _, forms := range arabicForms {
+		if forms[formMedial] != 0 {
+			addFallback(CodePagePC864.High, forms[formMedial], forms[formInitial])
+		}
+		if forms[formFinal] != 0 {
+			addFallback(CodePagePC864.High, forms[formFinal], forms[formIsolated])
+		}
+		if forms[formInitial] != 0 {
+			addFallback(CodePagePC864.High, forms[formInitial], forms[formIsolated])
+		}
+	}
+}
+
+func addFallback(table map[rune]byte, form, fallback rune) {
+	if _, ok := table[form]; ok {
+		return
+	}
+	if b, ok := table[fallback]; ok {
+		table[form] = b
+	}
+}
+
+// CodePageByName returns a supported code page, with table overriding the
+// ESC t number when non-zero
+func CodePageByName(name string, table int) CodePage {
+	cp := CodePagePC864
+	if strings.EqualFold(name, "PC437") {
+		cp = CodePagePC437
+	}
+	if table > 0 {
+		cp.Table = byte(table)
+	}
+	return cp
+}
+
+// ========================================
+// ARABIC SHAPING & BIDI
+// ========================================
+
+const (
+	formIsolated = iota
+	formFinal
+	formInitial
+	formMedial
+)
+
+// arabicForms holds isolated, final, initial and medial presentation forms.
+// Letters with no initial form only join to the preceding letter.
+var arabicForms = map[rune][4]rune{
+	0x0621: {0xFE80, 0, 0, 0},
+	0x0622: {0xFE81, 0xFE82, 0, 0},
+	0x0623: {0xFE83, 0xFE84, 0, 0},
+	0x0624: {0xFE85, 0xFE86, 0, 0},
+	0x0625: {0xFE87, 0xFE88, 0, 0},
+	0x0626: {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	0x0627: {0xFE8D, 0xFE8E, 0, 0},
+	0x0628: {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	0x0629: {0xFE93, 0xFE94, 0, 0},
+	0x062A: {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	0x062B: {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	0x062C: {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	0x062D: {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	0x062E: {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	0x062F: {0xFEA9, 0xFEAA, 0, 0},
+	0x0630: {0xFEAB, 0xFEAC, 0, 0},
+	0x0631: {0xFEAD, 0xFEAE, 0, 0},
+	0x0632: {0xFEAF, 0xFEB0, 0, 0},
+	0x0633: {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	0x0634: {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	0x0635: {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	0x0636: {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	0x0637: {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	0x0638: {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	0x0639: {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	0x063A: {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	0x0641: {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	0x0642: {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	0x0643: {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	0x0644: {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	0x0645: {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	0x0646: {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	0x0647: {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	0x0648: {0xFEED, 0xFEEE, 0, 0},
+	0x0649: {0xFEEF, 0xFEF0, 0, 0},
+	0x064A: {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+}
+
+// lamAlef holds the isolated and final lam-alef ligatures keyed by alef
+var lamAlef = map[rune][2]rune{
+	0x0622: {0xFEF5, 0xFEF6},
+	0x0623: {0xFEF7, 0xFEF8},
+	0x0625: {0xFEF9, 0xFEFA},
+	0x0627: {0xFEFB, 0xFEFC},
+}
+
+const tatweel = 0x0640
+
+// isArabicDiacritic reports harakat, which are dropped for printing
+func isArabicDiacritic(r rune) bool {
+	return r >= 0x064B && r <= 0x0652 || r == 0x0670
+}
+
+// joinsNext reports whether r connects to the following letter
+func joinsNext(r rune) bool {
+	if r == tatweel {
+		return true
+	}
+	forms, ok := arabicForms[r]
+	return ok && forms[formInitial] != 0
+}
+
+// joinsPrev reports whether r connects to the preceding letter
+func joinsPrev(r rune) bool {
+	if r == tatweel {
+		return true
+	}
+	forms, ok := arabicForms[r]
+	return ok && forms[formFinal] != 0
+}
+
+// ShapeArabic replaces Arabic letters with their contextual presentation
+// forms and lam-alef ligatures, keeping logical order
+func ShapeArabic(s string) string {
+	in := make([]rune, 0, len(s))
+	for _, r := range s {
+		if !isArabicDiacritic(r) {
+			in = append(in, r)
+		}
+	}
+
+	out := make([]rune, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		r := in[i]
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+
+		prevJoins := i > 0 && joinsNext(in[i-1])
+
+		// Lam followed by alef becomes a single ligature
+		if r == 0x0644 && i+1 < len(in) {
+			if lig, ok := lamAlef[in[i+1]]; ok {
+				if prevJoins {
+					out = append(out, lig[1])
+				} else {
+					out = append(out, lig[0])
+				}
+				i++
+				continue
+			}
+		}
+
+		nextJoins := i+1 < len(in) && joinsPrev(in[i+1]) && forms[formInitial] != 0
+
+		form := formIsolated
+		switch {
+		case prevJoins && nextJoins:
+			form = formMedial
+		case prevJoins:
+			form = formFinal
+		case nextJoins:
+			form = formInitial
+		}
+		if forms[form] == 0 {
+			form = formIsolated
+		}
+		out = append(out, forms[form])
+	}
+
+	return string(out)
+}
+
+// isRTLRune reports Arabic letters and presentation forms
+func isRTLRune(r rune) bool {
+	return (r >= 0x0600 && r <= 0x06FF && !(r >= 0x0660 && r <= 0x0669)) ||
+		(r >= 0xFB50 && r <= 0xFDFF) || (r >= 0xFE70 && r <= 0xFEFF)
+}
+
+// isLTRRune reports Latin and other left-to-right letters
+func isLTRRune(r rune) bool {
+	return unicode.IsLetter(r) && !isRTLRune(r)
+}
+
+// isRTL reports whether s contains right-to-left text
+func isRTL(s string) bool {
+	for _, r := range s {
+		if isRTLRune(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrored swaps paired punctuation inside right-to-left runs
+var mirrored = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<'}
+
+// Bidi classes used by VisualOrder
+const (
+	bidiNeutral = iota
+	bidiL
+	bidiR
+	bidiEN
+)
+
+// VisualOrder reorders a line containing Arabic into left-to-right display
+// order for printers that have no bidi support. It implements the common
+// case of the Unicode bidi algorithm: an RTL paragraph with embedded LTR
+// runs such as numbers, prices and Latin names.
+func VisualOrder(s string) string {
+	if !isRTL(s) {
+		return s
+	}
+
+	type run struct {
+		rtl   bool
+		runes []rune
+	}
+
+	runes := []rune(s)
+	classes := make([]int, len(runes))
+	for i, r := range runes {
+		switch {
+		case isRTLRune(r):
+			classes[i] = bidiR
+		case isLTRRune(r):
+			classes[i] = bidiL
+		case unicode.IsDigit(r):
+			classes[i] = bidiEN
+		}
+	}
+
+	// Separators inside a number belong to it ("15.00", "1,250")
+	for i := 1; i+1 < len(runes); i++ {
+		if classes[i] == bidiNeutral && strings.ContainsRune(".,:", runes[i]) &&
+			classes[i-1] == bidiEN && classes[i+1] == bidiEN {
+			classes[i] = bidiEN
+		}
+	}
+
+	// Terminators next to a number belong to it ("14%", "-5.00")
+	for i := range runes {
+		if classes[i] != bidiEN {
+			continue
+		}
+		for j := i - 1; j >= 0 && classes[j] == bidiNeutral && strings.ContainsRune("%#$+-°", runes[j]); j-- {
+			classes[j] = bidiEN
+		}
+		for j := i + 1; j < len(runes) && classes[j] == bidiNeutral && strings.ContainsRune("%#$+-°", runes[j]); j++ {
+			classes[j] = bidiEN
+		}
+	}
+
+	// Numbers following Latin text read as part of it ("Pepsi 330ml")
+	lastStrong := bidiR
+	for i, c := range classes {
+		switch c {
+		case bidiL, bidiR:
+			lastStrong = c
+		case bidiEN:
+			if lastStrong == bidiL {
+				classes[i] = bidiL
+			}
+		}
+	}
+
+	// Neutrals take the direction of the surrounding text when both sides
+	// agree, otherwise the paragraph direction; numbers count as RTL here
+	strength := func(c int) int {
+		if c == bidiEN {
+			return bidiR
+		}
+		return c
+	}
+	resolved := make([]bool, len(runes)) // true = rtl
+	for i, c := range classes {
+		if c != bidiNeutral {
+			resolved[i] = c == bidiR
+			continue
+		}
+		prev, next := bidiR, bidiR
+		for j := i - 1; j >= 0; j-- {
+			if classes[j] != bidiNeutral {
+				prev = strength(classes[j])
+				break
+			}
+		}
+		for j := i + 1; j < len(classes); j++ {
+			if classes[j] != bidiNeutral {
+				next = strength(classes[j])
+				break
+			}
+		}
+		resolved[i] = !(prev == bidiL && next == bidiL)
+	}
+
+	var runs []run
+	for i, r := range runes {
+		rtl := resolved[i]
+		if len(runs) == 0 || runs[len(runs)-1].rtl != rtl {
+			runs = append(runs, run{rtl: rtl})
+		}
+		runs[len(runs)-1].runes = append(runs[len(runs)-1].runes, r)
+	}
+
+	var out []rune
+	for i := len(runs) - 1; i >= 0; i-- {
+		if !runs[i].rtl {
+			out = append(out, runs[i].runes...)
+			continue
+		}
+		for j := len(runs[i].runes) - 1; j >= 0; j-- {
+			r := runs[i].runes[j]
+			if m, ok := mirrored[r]; ok {
+				r = m
+			}
+			out = append(out, r)
+		}
+	}
+
+	return string(out)
+}
+
+// ========================================
+// NETWORK TRANSPORT
+// ========================================
+
+// NetworkPrinter sends raw ESC/POS bytes over TCP (JetDirect, port 9100)
+type NetworkPrinter struct {
+	Address string
+	Timeout time.Duration
+}
+
+// NewNetworkPrinter creates a transport for host:port, defaulting to 9100
+func NewNetworkPrinter(host string, port int) *NetworkPrinter {
+	if port == 0 {
+		port = 9100
+	}
+	return &NetworkPrinter{
+		Address: net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+		Timeout: 5 * time.Second,
+	}
+}
+
+// Send writes data to the printer in a single connection
+func (n *NetworkPrinter) Send(data []byte) error {
+	conn, err := net.DialTimeout("tcp", n.Address, n.Timeout)
+	if err != nil {
+		return fmt.Errorf("printer %s unreachable: %w", n.Address, err)
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(n.Timeout))
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write to printer %s: %w", n.Address, err)
+	}
+	return nil
+}
+
+// Ping checks that the printer accepts connections
+func (n *NetworkPrinter) Ping() error {
+	conn, err := net.DialTimeout("tcp", n.Address, n.Timeout)
+	if err != nil {
+		return fmt.Errorf("printer %s unreachable: %w", n.Address, err)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// rasterHeader is the start of a GS v 0 raster bit image command
+var rasterHeader = []byte{0x1D, 0x76, 0x30, 0x00}
+
+// listenPrinter starts a local TCP printer and returns its address and a
+// channel receiving everything written in each connection
+func listenPrinter(t *testing.T) (string, int, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []byte, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			received <- data
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+func testFontRasterizer(t *testing.T) *FontRasterizer {
+	t.Helper()
+	fonts, err := LoadPDFFonts("", "")
+	if errors.Is(err, ErrNoPDFFont) {
+		t.Skip("no TrueType font installed")
+	}
+	if err != nil {
+		t.Fatalf("loading font: %v", err)
+	}
+	return NewFontRasterizer(fonts.Regular, 24)
+}
+
+// darkPixels counts the pixels a printer would burn
+func darkPixels(img image.Image, rect image.Rectangle) int {
+	n := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestNetworkPrinterSend(t *testing.T) {
+	host, port, received := listenPrinter(t)
+
+	doc := NewESCPOS(80).Init().
+		Align(AlignCenter).Bold(true).Line("Table 5").Bold(false).
+		Align(AlignLeft).Columns2("Kofta x2", "120.00").
+		Cut(false)
+	if err := NewNetworkPrinter(host, port).Send(doc.Bytes()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if !bytes.Equal(data, doc.Bytes()) {
+			t.Fatalf("printer received %q, want %q", data, doc.Bytes())
+		}
+		if !bytes.HasPrefix(data, []byte{0x1B, 0x40, 0x1B, 0x74, 37}) {
+			t.Errorf("stream does not start with init and PC864 selection: % x", data[:5])
+		}
+		if !bytes.Contains(data, []byte("Kofta x2                                  120.00\n")) {
+			t.Errorf("columns not padded to 48 characters: %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("printer received nothing")
+	}
+}
+
+func TestNetworkPrinterUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	printer := NewNetworkPrinter(addr.IP.String(), addr.Port)
+	printer.Timeout = time.Second
+	if err := printer.Send([]byte("x")); err == nil {
+		t.Error("send to a closed port succeeded")
+	}
+	if err := printer.Ping(); err == nil {
+		t.Error("ping of a closed port succeeded")
+	}
+}
+
+func TestArabicUsesPC864(t *testing.T) {
+	doc := NewESCPOS(80).Init().Line("شكرا لزيارتكم")
+	data := doc.Bytes()
+
+	if bytes.Contains(data, rasterHeader) {
+		t.Error("PC864 printer got a raster image for Arabic")
+	}
+	if bytes.ContainsRune(data[5:], '?') {
+		t.Errorf("Arabic not encoded in PC864: % x", data)
+	}
+}
+
+func TestArabicRasterFallback(t *testing.T) {
+	rasterizer := testFontRasterizer(t)
+
+	plain := NewESCPOS(80)
+	plain.CodePage = CodePagePC437
+	if data := plain.Init().Line("شكرا").Bytes(); !bytes.Contains(data, []byte("????")) {
+		t.Errorf("without a rasterizer Arabic should print as '?': % x", data)
+	}
+
+	doc := NewESCPOS(80)
+	doc.CodePage = CodePagePC437
+	doc.Rasterizer = rasterizer
+	data := doc.Init().Line("Total").Line("شكرا لزيارتكم").Columns2("كفتة", "120.00").Bytes()
+
+	if !bytes.Contains(data, []byte("Total\n")) {
+		t.Error("ASCII line was not printed as text")
+	}
+	if bytes.Count(data, rasterHeader) != 2 {
+		t.Fatalf("want 2 raster images, got %d", bytes.Count(data, rasterHeader))
+	}
+	at := bytes.Index(data, rasterHeader)
+	header := data[at : at+8]
+	if width, height := int(header[4])|int(header[5])<<8, int(header[6])|int(header[7])<<8; width != 72 || height != 24 {
+		t.Errorf("raster is %d bytes x %d rows, want 72 x 24", width, height)
+	}
+	if bytes.Count(data[at+8:at+8+72*24], []byte{0}) == 72*24 {
+		t.Error("raster image is blank")
+	}
+}
+
+func TestRasterFallbackOverTCP(t *testing.T) {
+	rasterizer := testFontRasterizer(t)
+	host, port, received := listenPrinter(t)
+
+	service := &PrintService{Rasterizer: rasterizer}
+	printer := &Printer{Name: "Bar", IPAddress: host, Port: port, PaperWidth: 58, CodePage: "PC437"}
+	doc := service.newDocument(printer).Line("مشروبات").Cut(false)
+	if err := service.Send(printer, doc.Bytes()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	data := <-received
+	at := bytes.Index(data, rasterHeader)
+	if at < 0 {
+		t.Fatalf("no raster image sent: % x", data)
+	}
+	if data[at+4] != 48 {
+		t.Errorf("58mm raster is %d bytes wide, want 48", data[at+4])
+	}
+}
+
+func TestFontRasterizerFillsOutlines(t *testing.T) {
+	rasterizer := NewFontRasterizer(testFontRasterizer(t).Font, 96)
+
+	img, err := rasterizer.RasterizeLine("O", 96, AlignCenter)
+	if err != nil {
+		t.Fatalf("rasterize: %v", err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != 96 || bounds.Dy() != 96 {
+		t.Fatalf("image is %v, want 96x96", bounds)
+	}
+
+	// The counter of an O is empty and its ring is inked
+	ink := image.Rect(0, 0, 96, 96)
+	if n := darkPixels(img, ink); n < 300 {
+		t.Errorf("only %d dark pixels in an O", n)
+	}
+	centre := image.Rect(44, 46, 52, 54)
+	if n := darkPixels(img, centre); n != 0 {
+		t.Errorf("%d dark pixels inside the O's counter", n)
+	}
+
+	right, err := rasterizer.RasterizeLine("I", 96, AlignRight)
+	if err != nil {
+		t.Fatalf("rasterize: %v", err)
+	}
+	if darkPixels(right, image.Rect(0, 0, 60, 96)) != 0 || darkPixels(right, image.Rect(60, 0, 96, 96)) == 0 {
+		t.Error("right-aligned I is not at the right edge")
+	}
+}
+
+func TestRasterScalesToPaperWidth(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 1152, 100))
+	data := NewESCPOS(80).Raster(img).Bytes()
+
+	i := bytes.Index(data, rasterHeader)
+	if i < 0 || len(data) < i+8 {
+		t.Fatalf("no raster image in % x", data)
+	}
+	header := data[i+4 : i+8]
+	bytesPerRow := int(header[0]) | int(header[1])<<8
+	height := int(header[2]) | int(header[3])<<8
+	if bytesPerRow != 576/8 || height != 50 {
+		t.Errorf("raster is %d bytes x %d rows, want %d x 50", bytesPerRow, height, 576/8)
+	}
+	if got, want := len(data)-i-8, bytesPerRow*height; got != want {
+		t.Errorf("image data is %d bytes, want %d", got, want)
+	}
+	// A zero Gray image is all black, so every dot prints
+	if !bytes.Equal(data[i+8:], bytes.Repeat([]byte{0xFF}, bytesPerRow*height)) {
+		t.Error("scaled black image has blank dots")
+	}
+}
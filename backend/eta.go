@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// ETA E-RECEIPTS
+// ========================================
+
+// E-receipt statuses. Pending receipts wait in the submission queue;
+// submitted ones wait for the authority's validation result.
+const (
+	ETAPending    = "pending"
+	ETASubmitting = "submitting"
+	ETASubmitted  = "submitted"
+	ETAValid      = "valid"
+	ETAInvalid    = "invalid"
+	ETARejected   = "rejected"
+)
+
+// ETA errors
+var (
+	ErrETADisabled      = errors.New("e-receipts are not enabled")
+	ErrETANotConfigured = errors.New("e-receipt issuer details are incomplete")
+	ErrETAOrderNotReady = errors.New("only completed orders can be issued")
+)
+
+// ETAReceiptDocument is an e-receipt in the tax authority's v1.2 format
+type ETAReceiptDocument struct {
+	Header                  ETAReceiptHeader `json:"header"`
+	DocumentType            ETADocumentType  `json:"documentType"`
+	Seller                  ETASeller        `json:"seller"`
+	Buyer                   ETABuyer         `json:"buyer"`
+	ItemData                []ETAItem        `json:"itemData"`
+	TotalSales              float64          `json:"totalSales"`
+	TotalCommercialDiscount float64          `json:"totalCommercialDiscount"`
+	TotalItemsDiscount      float64          `json:"totalItemsDiscount"`
+	NetAmount               float64          `json:"netAmount"`
+	FeesAmount              float64          `json:"feesAmount"`
+	TotalAmount             float64          `json:"totalAmount"`
+	TaxTotals               []ETATaxTotal    `json:"taxTotals"`
+	PaymentMethod           string           `json:"paymentMethod"`
+	Adjustment              float64          `json:"adjustment"`
+	Signatures              []ETASignature   `json:"signatures,omitempty"`
+}
+
+// ETAReceiptHeader identifies the receipt and links it to the previous one
+type ETAReceiptHeader struct {
+	DateTimeIssued   string  `json:"dateTimeIssued"`
+	ReceiptNumber    string  `json:"receiptNumber"`
+	UUID             string  `json:"uuid"`
+	PreviousUUID     string  `json:"previousUUID"`
+	ReferenceOldUUID string  `json:"referenceOldUUID,omitempty"`
+	Currency         string  `json:"currency"`
+	ExchangeRate     float64 `json:"exchangeRate"`
+}
+
+// ETADocumentType is the receipt type: "S" for a sale
+type ETADocumentType struct {
+	ReceiptType string `json:"receiptType"`
+	TypeVersion string `json:"typeVersion"`
+}
+
+// ETASeller is the issuing branch and POS device
+type ETASeller struct {
+	RIN                string           `json:"rin"`
+	CompanyTradeName   string           `json:"companyTradeName"`
+	BranchCode         string           `json:"branchCode"`
+	BranchAddress      ETABranchAddress `json:"branchAddress"`
+	DeviceSerialNumber string           `json:"deviceSerialNumber"`
+	ActivityCode       string           `json:"activityCode"`
+}
+
+// ETABranchAddress is the issuing branch's address
+type ETABranchAddress struct {
+	Country        string `json:"country"`
+	Governate      string `json:"governate"`
+	RegionCity     string `json:"regionCity"`
+	Street         string `json:"street"`
+	BuildingNumber string `json:"buildingNumber"`
+}
+
+// ETABuyer is the customer; "P" is a natural person
+type ETABuyer struct {
+	Type         string `json:"type"`
+	ID           string `json:"id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	MobileNumber string `json:"mobileNumber,omitempty"`
+}
+
+// ETAItem is one receipt line
+type ETAItem struct {
+	InternalCode           string           `json:"internalCode"`
+	Description            string           `json:"description"`
+	ItemType               string           `json:"itemType"`
+	ItemCode               string           `json:"itemCode"`
+	UnitType               string           `json:"unitType"`
+	Quantity               float64          `json:"quantity"`
+	UnitPrice              float64          `json:"unitPrice"`
+	NetSale                float64          `json:"netSale"`
+	TotalSale              float64          `json:"totalSale"`
+	Total                  float64          `json:"total"`
+	CommercialDiscountData []ETADiscount    `json:"commercialDiscountData,omitempty"`
+	ItemDiscountData       []ETADiscount    `json:"itemDiscountData,omitempty"`
+	TaxableItems           []ETATaxableItem `json:"taxableItems"`
+}
+
+// ETADiscount is a discount on one line
+type ETADiscount struct {
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description"`
+}
+
+// ETATaxableItem is one tax on a line
+type ETATaxableItem struct {
+	TaxType string  `json:"taxType"`
+	Amount  float64 `json:"amount"`
+	SubType string  `json:"subType"`
+	Rate    float64 `json:"rate"`
+}
+
+// ETATaxTotal sums one tax type across the receipt
+type ETATaxTotal struct {
+	TaxType string  `json:"taxType"`
+	Amount  float64 `json:"amount"`
+}
+
+// ETASignature is an issuer signature over the canonical serialization
+type ETASignature struct {
+	SignatureType string `json:"signatureType"`
+	Value         string `json:"value"`
+}
+
+// ETA tax codes for restaurant receipts
+const (
+	etaTaxVAT          = "T1"
+	etaSubTypeVAT      = "V009" // general goods and services
+	etaTaxService      = "T9"
+	etaSubTypeService  = "SC01"
+	etaDateTimeLayout  = "2006-01-02T15:04:05Z"
+	etaReceiptVersion  = "1.2"
+	etaDefaultUnitType = "EA"
+	etaDefaultItemType = "EGS"
+)
+
+// etaRound rounds to the five decimals the authority validates with
+func etaRound(v float64) float64 {
+	return math.Round(v*1e5) / 1e5
+}
+
+// etaPaymentMethod maps the POS payment method to the authority's codes
+func etaPaymentMethod(method string) string {
+	switch method {
+	case "cash":
+		return "C"
+	case "card":
+		return "V"
+	default:
+		return "O"
+	}
+}
+
+// ETASerialize returns the canonical form that the receipt UUID and the
+// signature are computed over. Property names are upper-cased and quoted
+// and followed by their value; every array element is preceded by the
+// array's name; scalar values are quoted as they appear in the JSON.
+func ETASerialize(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var b strings.Builder
+	if err := etaSerializeValue(dec, "", &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// etaSerializeValue writes the next value in the token stream; name is the
+// property the value belongs to, used to label array elements
+func etaSerializeValue(dec *json.Decoder, name string, b *strings.Builder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				b.WriteString(`"` + strings.ToUpper(key) + `"`)
+				if err := etaSerializeValue(dec, key, b); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for dec.More() {
+				b.WriteString(`"` + strings.ToUpper(name) + `"`)
+				if err := etaSerializeValue(dec, name, b); err != nil {
+					return err
+				}
+			}
+		}
+		// Consume the closing delimiter
+		_, err := dec.Token()
+		return err
+	case string:
+		b.WriteString(`"` + t + `"`)
+	case json.Number:
+		b.WriteString(`"` + t.String() + `"`)
+	case bool:
+		b.WriteString(`"` + strconv.FormatBool(t) + `"`)
+	case nil:
+		b.WriteString(`""`)
+	}
+	return nil
+}
+
+// ETAReceiptUUID returns the receipt's UUID: the lowercase hex SHA-256 of
+// its canonical serialization with the uuid left empty
+func ETAReceiptUUID(doc *ETAReceiptDocument) (string, error) {
+	unsigned := *doc
+	unsigned.Header.UUID = ""
+	unsigned.Signatures = nil
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+	canonical, err := ETASerialize(data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ETAShareURL returns the public link printed as the receipt QR code
+func ETAShareURL(portalURL string, doc *ETAReceiptDocument) string {
+	return fmt.Sprintf("%s/receipts/search/%s/share/%s#Total:%s,IssuerRIN:%s",
+		strings.TrimRight(portalURL, "/"), doc.Header.UUID, doc.Header.DateTimeIssued,
+		strconv.FormatFloat(doc.TotalAmount, 'f', -1, 64), doc.Seller.RIN)
+}
+
+// BuildETAReceipt maps a completed order to an e-receipt. Line amounts
+// follow what the customer was charged: the order's discount, service
+// charge and VAT are spread over the lines by their share of the sale, and
+// the last line absorbs rounding so the totals match the order.
+func (s *ETAService) BuildETAReceipt(order *Order, settings *RestaurantSettings, menuItems map[uint]MenuItem, issuedAt time.Time, previousUUID string) *ETAReceiptDocument {
+	cfg := s.Config.ETA
+
+	doc := &ETAReceiptDocument{
+		Header: ETAReceiptHeader{
+			DateTimeIssued: issuedAt.UTC().Format(etaDateTimeLayout),
+			ReceiptNumber:  order.OrderNumber,
+			PreviousUUID:   previousUUID,
+			Currency:       "EGP",
+			ExchangeRate:   0,
+		},
+		DocumentType: ETADocumentType{ReceiptType: "S", TypeVersion: etaReceiptVersion},
+		Seller: ETASeller{
+			RIN:              cfg.RIN,
+			CompanyTradeName: cfg.CompanyTradeName,
+			BranchCode:       cfg.BranchCode,
+			BranchAddress: ETABranchAddress{
+				Country:        "EG",
+				Governate:      cfg.Governate,
+				RegionCity:     cfg.RegionCity,
+				Street:         cfg.Street,
+				BuildingNumber: cfg.BuildingNumber,
+			},
+			DeviceSerialNumber: cfg.POSSerial,
+			ActivityCode:       cfg.ActivityCode,
+		},
+		Buyer: ETABuyer{
+			Type:         "P",
+			Name:         order.CustomerName,
+			MobileNumber: order.CustomerPhone.String(),
+		},
+	}
+	if doc.Seller.CompanyTradeName == "" {
+		doc.Seller.CompanyTradeName = settings.Name
+	}
+
+	var lines []OrderItem
+	var gross float64
+	for _, item := range order.Items {
+		if item.Status == "cancelled" || item.Quantity <= 0 {
+			continue
+		}
+		lines = append(lines, item)
+		gross += float64(item.Quantity) * item.UnitPrice
+	}
+
+	discountLeft, serviceLeft, vatLeft := etaRound(order.Discount), etaRound(order.ServiceCharge), etaRound(order.TaxAmount)
+	taxTotals := map[string]float64{}
+
+	for i, item := range lines {
+		totalSale := etaRound(float64(item.Quantity) * item.UnitPrice)
+
+		// Spread order-level amounts by the line's share of the sale
+		var discount, service, vat float64
+		if i == len(lines)-1 {
+			discount, service, vat = discountLeft, serviceLeft, vatLeft
+		} else if gross > 0 {
+			share := totalSale / gross
+			discount = etaRound(order.Discount * share)
+			service = etaRound(order.ServiceCharge * share)
+			vat = etaRound(order.TaxAmount * share)
+		}
+		discountLeft = etaRound(discountLeft - discount)
+		serviceLeft = etaRound(serviceLeft - service)
+		vatLeft = etaRound(vatLeft - vat)
+
+		netSale := etaRound(totalSale - discount)
+		line := ETAItem{
+			InternalCode: fmt.Sprintf("%d", item.MenuItemID),
+			Description:  item.MenuItemName,
+			ItemType:     cfg.DefaultItemType,
+			ItemCode:     cfg.DefaultItemCode,
+			UnitType:     etaDefaultUnitType,
+			Quantity:     float64(item.Quantity),
+			UnitPrice:    etaRound(item.UnitPrice),
+			TotalSale:    totalSale,
+			NetSale:      netSale,
+			TaxableItems: []ETATaxableItem{},
+		}
+		if menuItem, ok := menuItems[item.MenuItemID]; ok {
+			if menuItem.SKU != "" {
+				line.InternalCode = menuItem.SKU
+			}
+			if menuItem.ETAItemType != "" {
+				line.ItemType = menuItem.ETAItemType
+			}
+			if menuItem.ETAItemCode != "" {
+				line.ItemCode = menuItem.ETAItemCode
+			}
+		}
+		if line.ItemType == "" {
+			line.ItemType = etaDefaultItemType
+		}
+		if discount > 0 {
+			line.CommercialDiscountData = []ETADiscount{{Amount: discount, Description: "Order discount"}}
+		}
+		if settings.ServiceCharge > 0 || service > 0 {
+			line.TaxableItems = append(line.TaxableItems, ETATaxableItem{
+				TaxType: etaTaxService,
+				Amount:  service,
+				SubType: etaSubTypeService,
+				Rate:    etaRound(settings.ServiceCharge * 100),
+			})
+			taxTotals[etaTaxService] += service
+		}
+		if settings.TaxRate > 0 || vat > 0 {
+			line.TaxableItems = append(line.TaxableItems, ETATaxableItem{
+				TaxType: etaTaxVAT,
+				Amount:  vat,
+				SubType: etaSubTypeVAT,
+				Rate:    etaRound(settings.TaxRate * 100),
+			})
+			taxTotals[etaTaxVAT] += vat
+		}
+		line.Total = etaRound(netSale + service + vat)
+
+		doc.ItemData = append(doc.ItemData, line)
+		doc.TotalSales += totalSale
+		doc.TotalCommercialDiscount += discount
+		doc.NetAmount += netSale
+		doc.TotalAmount += line.Total
+	}
+
+	doc.TotalSales = etaRound(doc.TotalSales)
+	doc.TotalCommercialDiscount = etaRound(doc.TotalCommercialDiscount)
+	doc.NetAmount = etaRound(doc.NetAmount)
+	doc.TotalAmount = etaRound(doc.TotalAmount)
+	doc.TaxTotals = []ETATaxTotal{}
+	for _, taxType := range []string{etaTaxVAT, etaTaxService} {
+		if amount, ok := taxTotals[taxType]; ok {
+			doc.TaxTotals = append(doc.TaxTotals, ETATaxTotal{TaxType: taxType, Amount: etaRound(amount)})
+		}
+	}
+
+	method := order.PaymentMethod
+	if method == "" && len(order.Payments) > 0 {
+		method = order.Payments[0].Method
+	}
+	doc.PaymentMethod = etaPaymentMethod(method)
+
+	return doc
+}
+
+// ========================================
+// ETA SERVICE
+// ========================================
+
+// ETAService issues e-receipts for completed orders and submits them in
+// issue order. Receipts from one device are chained through previousUUID,
+// so issuing is serialized and submission never skips ahead of a receipt
+// that is waiting to be retried. An issued receipt is a fiscal document the
+// customer already holds, so transport failures are retried indefinitely.
+type ETAService struct {
+	DB           *gorm.DB
+	Config       *Config
+	Client       ETAClient
+	Signer       ETASigner
+	PollInterval time.Duration
+	StatusDelay  time.Duration
+	BatchSize    int
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// NewETAService creates the e-receipt service
+func NewETAService(db *gorm.DB, config *Config) *ETAService {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	client := &ETAHTTPClient{
+		IdentityURL:  config.ETA.IdentityURL,
+		APIURL:       config.ETA.APIURL,
+		ClientID:     config.ETA.ClientID,
+		ClientSecret: config.ETA.ClientSecret,
+		POSSerial:    config.ETA.POSSerial,
+		POSOSVersion: config.ETA.POSOSVersion,
+		PreSharedKey: config.ETA.PreSharedKey,
+		HTTP:         httpClient,
+	}
+
+	service := &ETAService{
+		DB:           db,
+		Config:       config,
+		Client:       client,
+		PollInterval: 10 * time.Second,
+		StatusDelay:  5 * time.Second,
+		BatchSize:    50,
+		wake:         make(chan struct{}, 1),
+	}
+	if config.ETA.SignerURL != "" {
+		service.Signer = &ExternalETASigner{URL: config.ETA.SignerURL, HTTP: httpClient}
+	}
+	return service
+}
+
+// Wake asks the worker to poll now
+func (s *ETAService) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// etaChainBroken lists the statuses of receipts the authority did not
+// record; the next receipt chains to the last one before them
+var etaChainBroken = []string{ETARejected, ETAInvalid}
+
+// Issue creates the e-receipt for a completed order and queues it for
+// submission. Issuing again returns the existing receipt unless that one
+// was rejected, in which case a replacement is chained on.
+func (s *ETAService) Issue(orderID uint) (*ETAReceipt, error) {
+	if !s.Config.ETA.Enabled {
+		return nil, ErrETADisabled
+	}
+	cfg := s.Config.ETA
+	if cfg.RIN == "" || cfg.POSSerial == "" || cfg.ActivityCode == "" {
+		return nil, ErrETANotConfigured
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var order Order
+	if err := s.DB.Preload("Items").Preload("Payments").First(&order, orderID).Error; err != nil {
+		return nil, err
+	}
+	if order.Status != "completed" {
+		return nil, ErrETAOrderNotReady
+	}
+
+	var existing ETAReceipt
+	if err := s.DB.Where("order_id = ? AND status NOT IN ?", order.ID, etaChainBroken).
+		Order("id DESC").First(&existing).Error; err == nil {
+		return &existing, nil
+	}
+
+	var settings RestaurantSettings
+	s.DB.First(&settings)
+
+	menuItems := map[uint]MenuItem{}
+	var ids []uint
+	for _, item := range order.Items {
+		ids = append(ids, item.MenuItemID)
+	}
+	if len(ids) > 0 {
+		var items []MenuItem
+		s.DB.Where("id IN ?", ids).Find(&items)
+		for _, item := range items {
+			menuItems[item.ID] = item
+		}
+	}
+
+	var previous ETAReceipt
+	previousUUID := ""
+	if err := s.DB.Where("device_serial = ? AND status NOT IN ?", cfg.POSSerial, etaChainBroken).
+		Order("id DESC").First(&previous).Error; err == nil {
+		previousUUID = previous.UUID
+	}
+
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	doc := s.BuildETAReceipt(&order, &settings, menuItems, issuedAt, previousUUID)
+	if len(doc.ItemData) == 0 {
+		return nil, fmt.Errorf("order %s has no items to issue", order.OrderNumber)
+	}
+
+	uuid, err := ETAReceiptUUID(doc)
+	if err != nil {
+		return nil, err
+	}
+	doc.Header.UUID = uuid
+
+	if s.Signer != nil {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		canonical, err := ETASerialize(data)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := s.Signer.Sign(canonical)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign e-receipt: %w", err)
+		}
+		doc.Signatures = []ETASignature{{SignatureType: "I", Value: signature}}
+	}
+
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := ETAReceipt{
+		OrderID:       order.ID,
+		ReceiptNumber: doc.Header.ReceiptNumber,
+		UUID:          uuid,
+		PreviousUUID:  previousUUID,
+		DeviceSerial:  cfg.POSSerial,
+		Payload:       string(payload),
+		Total:         doc.TotalAmount,
+		Status:        ETAPending,
+		QRURL:         ETAShareURL(cfg.PortalURL, doc),
+		NextAttemptAt: time.Now(),
+		IssuedAt:      issuedAt,
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&receipt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+			"eta_uuid":   receipt.UUID,
+			"eta_status": receipt.Status,
+			"eta_qr_url": receipt.QRURL,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Wake()
+	return &receipt, nil
+}
+
+// Run submits queued receipts and polls validation results until the
+// process exits
+func (s *ETAService) Run() {
+	// A receipt left mid-submission by a crash is sent again
+	s.DB.Model(&ETAReceipt{}).Where("status = ?", ETASubmitting).Update("status", ETAPending)
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.submitDue()
+		s.refreshSubmitted()
+
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// submitDue claims due receipts in issue order and submits them as one
+// batch. It stops at the first receipt still backing off so the chain is
+// never submitted out of order.
+func (s *ETAService) submitDue() {
+	var pending []ETAReceipt
+	if err := s.DB.Where("status = ?", ETAPending).
+		Order("id ASC").
+		Limit(s.BatchSize).
+		Find(&pending).Error; err != nil {
+		log.Printf("ETA poll failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	var batch []ETAReceipt
+	for _, receipt := range pending {
+		if receipt.NextAttemptAt.After(now) {
+			break
+		}
+		claim := s.DB.Model(&ETAReceipt{}).
+			Where("id = ? AND status = ?", receipt.ID, ETAPending).
+			Update("status", ETASubmitting)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			break
+		}
+		batch = append(batch, receipt)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	documents := make([]json.RawMessage, len(batch))
+	for i := range batch {
+		documents[i] = json.RawMessage(batch[i].Payload)
+	}
+
+	result, err := s.Client.Submit(documents)
+	if err != nil {
+		log.Printf("ETA submission of %d receipts failed: %v", len(batch), err)
+		for i := range batch {
+			s.retry(&batch[i], err.Error())
+		}
+		return
+	}
+
+	accepted := map[string]ETAAcceptedDocument{}
+	for _, doc := range result.AcceptedDocuments {
+		accepted[doc.UUID] = doc
+	}
+	rejected := map[string]ETARejectedDocument{}
+	for _, doc := range result.RejectedDocuments {
+		rejected[doc.UUID] = doc
+	}
+
+	for i := range batch {
+		receipt := &batch[i]
+		if doc, ok := accepted[receipt.UUID]; ok {
+			s.update(receipt, map[string]interface{}{
+				"status":        ETASubmitted,
+				"submission_id": result.SubmissionID,
+				"long_id":       doc.LongID,
+				"attempts":      receipt.Attempts + 1,
+				"submitted_at":  now,
+				"last_error":    "",
+			})
+		} else if doc, ok := rejected[receipt.UUID]; ok {
+			s.update(receipt, map[string]interface{}{
+				"status":        ETARejected,
+				"submission_id": result.SubmissionID,
+				"attempts":      receipt.Attempts + 1,
+				"last_error":    doc.Error.Error(),
+			})
+		} else {
+			s.retry(receipt, "receipt missing from submission response")
+		}
+	}
+}
+
+// retry puts a receipt back in the queue with backoff
+func (s *ETAService) retry(receipt *ETAReceipt, reason string) {
+	receipt.Attempts++
+	s.update(receipt, map[string]interface{}{
+		"status":          ETAPending,
+		"attempts":        receipt.Attempts,
+		"last_error":      reason,
+		"next_attempt_at": time.Now().Add(retryBackoff(receipt.Attempts)),
+	})
+}
+
+// refreshSubmitted asks the authority for the validation result of
+// receipts that were accepted for processing
+func (s *ETAService) refreshSubmitted() {
+	var submitted []ETAReceipt
+	if err := s.DB.Where("status = ? AND submitted_at <= ?", ETASubmitted, time.Now().Add(-s.StatusDelay)).
+		Order("id ASC").
+		Limit(s.BatchSize).
+		Find(&submitted).Error; err != nil {
+		log.Printf("ETA status poll failed: %v", err)
+		return
+	}
+
+	bySubmission := map[string][]*ETAReceipt{}
+	for i := range submitted {
+		id := submitted[i].SubmissionID
+		bySubmission[id] = append(bySubmission[id], &submitted[i])
+	}
+
+	for submissionID, receipts := range bySubmission {
+		details, err := s.Client.SubmissionStatus(submissionID)
+		if err != nil {
+			log.Printf("ETA status check for submission %s failed: %v", submissionID, err)
+			continue
+		}
+
+		statuses := map[string]ETAReceiptStatus{}
+		for _, status := range details.Receipts {
+			statuses[status.UUID] = status
+		}
+		for _, receipt := range receipts {
+			status, ok := statuses[receipt.UUID]
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(status.Status) {
+			case ETAValid:
+				s.update(receipt, map[string]interface{}{"status": ETAValid})
+			case ETAInvalid:
+				var reasons []string
+				for _, e := range status.Errors {
+					reasons = append(reasons, e.Error())
+				}
+				s.update(receipt, map[string]interface{}{
+					"status":     ETAInvalid,
+					"last_error": strings.Join(reasons, "; "),
+				})
+			}
+		}
+	}
+}
+
+// update saves receipt changes and mirrors a status change onto the order
+func (s *ETAService) update(receipt *ETAReceipt, updates map[string]interface{}) {
+	s.DB.Model(&ETAReceipt{}).Where("id = ?", receipt.ID).Updates(updates)
+	if status, ok := updates["status"]; ok {
+		s.DB.Model(&Order{}).
+			Where("id = ? AND eta_uuid = ?", receipt.OrderID, receipt.UUID).
+			Update("eta_status", status)
+	}
+}
+
+// Retry sends a backing-off receipt now, or issues a replacement for one the
+// authority rejected
+func (s *ETAService) Retry(receipt *ETAReceipt) (*ETAReceipt, error) {
+	switch receipt.Status {
+	case ETAPending:
+		s.DB.Model(&ETAReceipt{}).Where("id = ? AND status = ?", receipt.ID, ETAPending).
+			Update("next_attempt_at", time.Now())
+		s.Wake()
+		s.DB.First(receipt, receipt.ID)
+		return receipt, nil
+	case ETARejected, ETAInvalid:
+		return s.Issue(receipt.OrderID)
+	default:
+		return nil, fmt.Errorf("receipt is %s and cannot be retried", receipt.Status)
+	}
+}
+
+// ========================================
+// ETA HANDLERS
+// ========================================
+
+// HandleGetETAReceipts lists e-receipts, newest first
+func (a *App) HandleGetETAReceipts(c *gin.Context) {
+	page := getInt(c.DefaultQuery("page", "1"))
+	limit := getInt(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := a.DB.Model(&ETAReceipt{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var receipts []ETAReceipt
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&receipts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch e-receipts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"receipts": receipts,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// HandleGetETAReceipt returns one e-receipt with the document as submitted
+func (a *App) HandleGetETAReceipt(c *gin.Context) {
+	var receipt ETAReceipt
+	if err := a.DB.First(&receipt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "E-receipt not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"receipt":  receipt,
+		"document": json.RawMessage(receipt.Payload),
+	})
+}
+
+// HandleRetryETAReceipt resends a queued receipt or reissues a rejected one
+func (a *App) HandleRetryETAReceipt(c *gin.Context) {
+	var receipt ETAReceipt
+	if err := a.DB.First(&receipt, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "E-receipt not found"})
+		return
+	}
+
+	retried, err := a.ETA.Retry(&receipt)
+	if err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Failed to retry e-receipt", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "E-receipt queued for submission",
+		Data:    retried,
+	})
+}
+
+// HandleIssueETAReceipt issues the e-receipt for a completed order now
+// instead of waiting for the order.completed event
+func (a *App) HandleIssueETAReceipt(c *gin.Context) {
+	orderID := uint(getInt(c.Param("orderId")))
+
+	receipt, err := a.ETA.Issue(orderID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrETADisabled), errors.Is(err, ErrETANotConfigured):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, ErrETAOrderNotReady):
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorResponse{Error: "Failed to issue e-receipt", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "E-receipt issued",
+		Data:    receipt,
+	})
+}
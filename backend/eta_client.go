@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================================
+// ETA CLIENT
+// ========================================
+
+// ETAClient submits receipts to the tax authority. The HTTP client talks to
+// the real API; tests point it at MockETAServer.
+type ETAClient interface {
+	Submit(receipts []json.RawMessage) (*ETASubmissionResult, error)
+	SubmissionStatus(submissionID string) (*ETASubmissionDetails, error)
+}
+
+// ETASubmissionResult is the response to a receipt submission
+type ETASubmissionResult struct {
+	SubmissionID      string                `json:"submissionId"`
+	AcceptedDocuments []ETAAcceptedDocument `json:"acceptedDocuments"`
+	RejectedDocuments []ETARejectedDocument `json:"rejectedDocuments"`
+}
+
+// ETAAcceptedDocument is a receipt the authority queued for validation
+type ETAAcceptedDocument struct {
+	UUID          string `json:"uuid"`
+	LongID        string `json:"longId"`
+	ReceiptNumber string `json:"receiptNumber"`
+}
+
+// ETARejectedDocument is a receipt that failed structural validation
+type ETARejectedDocument struct {
+	UUID          string   `json:"uuid"`
+	ReceiptNumber string   `json:"receiptNumber"`
+	Error         ETAError `json:"error"`
+}
+
+// ETAError is the authority's error shape, nested for field-level details
+type ETAError struct {
+	Code    string     `json:"code"`
+	Message string     `json:"message"`
+	Target  string     `json:"target,omitempty"`
+	Details []ETAError `json:"details,omitempty"`
+}
+
+// Error flattens the error and its details into one line
+func (e ETAError) Error() string {
+	parts := []string{}
+	if e.Code != "" || e.Message != "" {
+		parts = append(parts, strings.TrimSpace(e.Code+" "+e.Message))
+	}
+	for _, d := range e.Details {
+		text := d.Error()
+		if d.Target != "" {
+			text = d.Target + ": " + text
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ETASubmissionDetails is the validation outcome of a submission
+type ETASubmissionDetails struct {
+	SubmissionID string             `json:"submissionId"`
+	Status       string             `json:"status"`
+	Receipts     []ETAReceiptStatus `json:"receipts"`
+}
+
+// ETAReceiptStatus is one receipt's validation outcome
+type ETAReceiptStatus struct {
+	UUID          string     `json:"uuid"`
+	ReceiptNumber string     `json:"receiptNumber"`
+	Status        string     `json:"status"` // Valid, Invalid or Submitted
+	Errors        []ETAError `json:"errors,omitempty"`
+}
+
+// ETAHTTPClient calls the tax authority's identity and receipt APIs. POS
+// tokens are cached until shortly before they expire.
+type ETAHTTPClient struct {
+	IdentityURL  string
+	APIURL       string
+	ClientID     string
+	ClientSecret string
+	POSSerial    string
+	POSOSVersion string
+	PreSharedKey string
+	HTTP         *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// authenticate returns a cached access token or requests a new one
+func (c *ETAHTTPClient) authenticate() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(c.IdentityURL, "/")+"/connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("posserial", c.POSSerial)
+	req.Header.Set("pososversion", c.POSOSVersion)
+	req.Header.Set("presharedkey", c.PreSharedKey)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("eta authentication failed: status %d: %s", resp.StatusCode, TruncateString(string(body), 500))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("eta authentication returned no token")
+	}
+
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// do sends an authenticated request and decodes a 2xx JSON response into
+// out. A 401 drops the cached token so the next call logs in again.
+func (c *ETAHTTPClient) do(method, path string, payload interface{}, out interface{}) error {
+	token, err := c.authenticate()
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.APIURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var failure struct {
+			Error ETAError `json:"error"`
+		}
+		if json.Unmarshal(respBody, &failure) == nil && failure.Error.Error() != "" {
+			return fmt.Errorf("eta returned status %d: %s", resp.StatusCode, failure.Error.Error())
+		}
+		return fmt.Errorf("eta returned status %d: %s", resp.StatusCode, TruncateString(string(respBody), 500))
+	}
+
+	return json.Unmarshal(respBody, out)
+}
+
+// Submit sends a batch of serialized receipts
+func (c *ETAHTTPClient) Submit(receipts []json.RawMessage) (*ETASubmissionResult, error) {
+	var result ETASubmissionResult
+	err := c.do("POST", "/api/v1/receiptsubmissions", map[string]interface{}{"receipts": receipts}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SubmissionStatus fetches the validation outcome of a submission
+func (c *ETAHTTPClient) SubmissionStatus(submissionID string) (*ETASubmissionDetails, error) {
+	var details ETASubmissionDetails
+	path := "/api/v1/receiptsubmissions/" + url.PathEscape(submissionID) + "/details?PageNo=1&PageSize=100"
+	if err := c.do("GET", path, nil, &details); err != nil {
+		return nil, err
+	}
+	if details.SubmissionID == "" {
+		details.SubmissionID = submissionID
+	}
+	return &details, nil
+}
+
+// ========================================
+// ETA SIGNING
+// ========================================
+
+// ETASigner signs a receipt's canonical serialization. Signing keys usually
+// live on a USB token, so the built-in signer delegates to a local service.
+type ETASigner interface {
+	Sign(canonical string) (string, error)
+}
+
+// ExternalETASigner POSTs {"document": canonical} to a signing service and
+// expects {"signature": "<base64 CAdES-BES>"} back
+type ExternalETASigner struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// Sign returns the signature value for canonical
+func (s *ExternalETASigner) Sign(canonical string) (string, error) {
+	body, err := json.Marshal(map[string]string{"document": canonical})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.HTTP.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("signer returned status %d: %s", resp.StatusCode, TruncateString(string(respBody), 500))
+	}
+
+	var result struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", err
+	}
+	if result.Signature == "" {
+		return "", fmt.Errorf("signer returned no signature")
+	}
+	return result.Signature, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// ========================================
+// MOCK TAX AUTHORITY
+// ========================================
+
+// MockETAServer imitates the tax authority's token and receipt submission
+// endpoints for tests. It recomputes each receipt's UUID, checks
+// the device chain and the totals, and validates accepted receipts
+// immediately.
+type MockETAServer struct {
+	mu          sync.Mutex
+	tokens      map[string]bool
+	heads       map[string]string // device serial -> last accepted UUID
+	receipts    map[string]*ETAReceiptDocument
+	submissions map[string][]ETAReceiptStatus
+
+	// Submissions counts submit calls; FailNext makes the next N fail with
+	// a 503 to exercise retries
+	Submissions int
+	FailNext    int
+}
+
+// NewMockETAServer creates an empty mock authority
+func NewMockETAServer() *MockETAServer {
+	return &MockETAServer{
+		tokens:      map[string]bool{},
+		heads:       map[string]string{},
+		receipts:    map[string]*ETAReceiptDocument{},
+		submissions: map[string][]ETAReceiptStatus{},
+	}
+}
+
+// StartMockETAServer serves a new mock authority on a local port. Point
+// ETAHTTPClient's IdentityURL and APIURL at the returned server's URL.
+func StartMockETAServer() (*httptest.Server, *MockETAServer) {
+	mock := NewMockETAServer()
+	return httptest.NewServer(mock), mock
+}
+
+// Receipt returns an accepted receipt by UUID
+func (m *MockETAServer) Receipt(uuid string) (*ETAReceiptDocument, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.receipts[uuid]
+	return doc, ok
+}
+
+// ServeHTTP routes mock API requests
+func (m *MockETAServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/connect/token":
+		m.handleToken(w, r)
+	case r.Method == "POST" && r.URL.Path == "/api/v1/receiptsubmissions":
+		if m.authorized(w, r) {
+			m.handleSubmit(w, r)
+		}
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/v1/receiptsubmissions/") &&
+		strings.HasSuffix(r.URL.Path, "/details"):
+		if m.authorized(w, r) {
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/receiptsubmissions/"), "/details")
+			m.handleDetails(w, id)
+		}
+	default:
+		mockETAError(w, http.StatusNotFound, "NotFound", "unknown endpoint")
+	}
+}
+
+// handleToken issues a token to any POS that sends credentials and a serial
+func (m *MockETAServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		mockETAError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" ||
+		r.PostForm.Get("client_id") == "" || r.PostForm.Get("client_secret") == "" {
+		mockETAError(w, http.StatusBadRequest, "invalid_client", "client credentials are required")
+		return
+	}
+	if r.Header.Get("posserial") == "" {
+		mockETAError(w, http.StatusBadRequest, "invalid_client", "posserial header is required")
+		return
+	}
+
+	token := mockETAID()
+	m.mu.Lock()
+	m.tokens[token] = true
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+// authorized checks the bearer token and writes a 401 when it's unknown
+func (m *MockETAServer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	m.mu.Lock()
+	ok := m.tokens[token]
+	m.mu.Unlock()
+	if !ok {
+		mockETAError(w, http.StatusUnauthorized, "Unauthorized", "invalid or expired token")
+	}
+	return ok
+}
+
+// handleSubmit validates each receipt and records the accepted ones
+func (m *MockETAServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Submissions++
+	if m.FailNext > 0 {
+		m.FailNext--
+		mockETAError(w, http.StatusServiceUnavailable, "ServiceUnavailable", "try again later")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+	if err != nil {
+		mockETAError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	var request struct {
+		Receipts []ETAReceiptDocument `json:"receipts"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || len(request.Receipts) == 0 {
+		mockETAError(w, http.StatusBadRequest, "BadStructure", "body must contain a receipts array")
+		return
+	}
+
+	result := ETASubmissionResult{
+		SubmissionID:      mockETAID(),
+		AcceptedDocuments: []ETAAcceptedDocument{},
+		RejectedDocuments: []ETARejectedDocument{},
+	}
+	var statuses []ETAReceiptStatus
+
+	for i := range request.Receipts {
+		doc := request.Receipts[i]
+		if problems := m.validate(&doc); len(problems) > 0 {
+			result.RejectedDocuments = append(result.RejectedDocuments, ETARejectedDocument{
+				UUID:          doc.Header.UUID,
+				ReceiptNumber: doc.Header.ReceiptNumber,
+				Error:         ETAError{Code: "ValidationError", Message: "receipt failed validation", Details: problems},
+			})
+			continue
+		}
+
+		m.receipts[doc.Header.UUID] = &doc
+		m.heads[doc.Seller.DeviceSerialNumber] = doc.Header.UUID
+		result.AcceptedDocuments = append(result.AcceptedDocuments, ETAAcceptedDocument{
+			UUID:          doc.Header.UUID,
+			LongID:        mockETAID() + mockETAID(),
+			ReceiptNumber: doc.Header.ReceiptNumber,
+		})
+		statuses = append(statuses, ETAReceiptStatus{
+			UUID:          doc.Header.UUID,
+			ReceiptNumber: doc.Header.ReceiptNumber,
+			Status:        "Valid",
+		})
+	}
+	m.submissions[result.SubmissionID] = statuses
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(result)
+}
+
+// validate applies the checks the authority runs at submission time
+func (m *MockETAServer) validate(doc *ETAReceiptDocument) []ETAError {
+	var problems []ETAError
+	fail := func(target, format string, args ...interface{}) {
+		problems = append(problems, ETAError{Code: "InvalidValue", Target: target, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if doc.Seller.RIN == "" {
+		fail("seller.rin", "issuer RIN is required")
+	}
+	if doc.Seller.DeviceSerialNumber == "" {
+		fail("seller.deviceSerialNumber", "device serial is required")
+	}
+	if _, exists := m.receipts[doc.Header.UUID]; exists {
+		fail("header.uuid", "receipt %s was already submitted", doc.Header.UUID)
+	}
+	if uuid, err := ETAReceiptUUID(doc); err != nil || uuid != doc.Header.UUID {
+		fail("header.uuid", "uuid does not match the receipt content")
+	}
+	if head := m.heads[doc.Seller.DeviceSerialNumber]; doc.Header.PreviousUUID != head {
+		fail("header.previousUUID", "expected previous receipt %q", head)
+	}
+
+	var totalSales, netAmount, totalAmount float64
+	taxTotals := map[string]float64{}
+	for i, item := range doc.ItemData {
+		target := fmt.Sprintf("itemData[%d]", i)
+		if !mockETAEqual(item.TotalSale, item.Quantity*item.UnitPrice) {
+			fail(target+".totalSale", "must equal quantity x unitPrice")
+		}
+		var discount float64
+		for _, d := range item.CommercialDiscountData {
+			discount += d.Amount
+		}
+		if !mockETAEqual(item.NetSale, item.TotalSale-discount) {
+			fail(target+".netSale", "must equal totalSale minus commercial discounts")
+		}
+		total := item.NetSale
+		for _, tax := range item.TaxableItems {
+			total += tax.Amount
+			taxTotals[tax.TaxType] += tax.Amount
+		}
+		for _, d := range item.ItemDiscountData {
+			total -= d.Amount
+		}
+		if !mockETAEqual(item.Total, total) {
+			fail(target+".total", "must equal netSale plus taxes minus item discounts")
+		}
+		totalSales += item.TotalSale
+		netAmount += item.NetSale
+		totalAmount += item.Total
+	}
+	if len(doc.ItemData) == 0 {
+		fail("itemData", "at least one item is required")
+	}
+	if !mockETAEqual(doc.TotalSales, totalSales) {
+		fail("totalSales", "must equal the sum of item totalSale")
+	}
+	if !mockETAEqual(doc.NetAmount, netAmount) {
+		fail("netAmount", "must equal the sum of item netSale")
+	}
+	if !mockETAEqual(doc.TotalAmount, totalAmount+doc.FeesAmount-doc.TotalItemsDiscount+doc.Adjustment) {
+		fail("totalAmount", "must equal the sum of item totals")
+	}
+	for _, tax := range doc.TaxTotals {
+		if !mockETAEqual(tax.Amount, taxTotals[tax.TaxType]) {
+			fail("taxTotals", "%s total does not match the items", tax.TaxType)
+		}
+	}
+	return problems
+}
+
+// handleDetails returns the validation result of a submission
+func (m *MockETAServer) handleDetails(w http.ResponseWriter, id string) {
+	m.mu.Lock()
+	statuses, ok := m.submissions[id]
+	m.mu.Unlock()
+	if !ok {
+		mockETAError(w, http.StatusNotFound, "NotFound", "submission not found")
+		return
+	}
+
+	status := "Valid"
+	if len(statuses) == 0 {
+		status = "Invalid"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ETASubmissionDetails{
+		SubmissionID: id,
+		Status:       status,
+		Receipts:     statuses,
+	})
+}
+
+// mockETAError writes an error in the authority's format
+func mockETAError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": ETAError{Code: code, Message: message},
+	})
+}
+
+// mockETAEqual compares amounts at the authority's rounding tolerance
+func mockETAEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.0001
+}
+
+// mockETAID returns a random identifier
+func mockETAID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return strings.ToUpper(hex.EncodeToString(buf))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestETAService returns a service configured for one POS device whose
+// client talks to a local mock tax authority
+func newTestETAService(t *testing.T) (*ETAService, *ETAHTTPClient, *MockETAServer) {
+	t.Helper()
+	server, mock := StartMockETAServer()
+	t.Cleanup(server.Close)
+
+	config := &Config{}
+	config.ETA.Enabled = true
+	config.ETA.RIN = "123456789"
+	config.ETA.POSSerial = "POS-0001"
+	config.ETA.ActivityCode = "5610"
+	config.ETA.BranchCode = "0"
+	config.ETA.DefaultItemCode = "EG-123456789-1"
+
+	client := &ETAHTTPClient{
+		IdentityURL:  server.URL,
+		APIURL:       server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		POSSerial:    config.ETA.POSSerial,
+		HTTP:         &http.Client{Timeout: 5 * time.Second},
+	}
+	return &ETAService{Config: config, Client: client}, client, mock
+}
+
+func testETAOrder(number string) *Order {
+	return &Order{
+		OrderNumber:   number,
+		Status:        "completed",
+		PaymentMethod: "cash",
+		Discount:      10,
+		ServiceCharge: 21.5,
+		TaxAmount:     33.11,
+		Items: []OrderItem{
+			{MenuItemID: 1, MenuItemName: "Kofta", Quantity: 2, UnitPrice: 120},
+			{MenuItemID: 2, MenuItemName: "Tahini", Quantity: 1, UnitPrice: 35.5},
+			{MenuItemID: 3, MenuItemName: "Cancelled", Quantity: 1, UnitPrice: 50, Status: "cancelled"},
+		},
+	}
+}
+
+// issueTestReceipt builds a receipt chained to previous and sets its UUID
+func issueTestReceipt(t *testing.T, s *ETAService, number, previous string) (*ETAReceiptDocument, json.RawMessage) {
+	t.Helper()
+	settings := &RestaurantSettings{Name: "Test Grill", TaxRate: 0.14, ServiceCharge: 0.1}
+	doc := s.BuildETAReceipt(testETAOrder(number), settings, nil, time.Now(), previous)
+	uuid, err := ETAReceiptUUID(doc)
+	if err != nil {
+		t.Fatalf("uuid: %v", err)
+	}
+	doc.Header.UUID = uuid
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return doc, data
+}
+
+func TestETASerialize(t *testing.T) {
+	got, err := ETASerialize([]byte(`{"header":{"uuid":"","total":12.50},"items":[{"code":"A"},{"code":"B"}],"paid":true}`))
+	if err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	want := `"HEADER""UUID""""TOTAL""12.50""ITEMS""ITEMS""CODE""A""ITEMS""CODE""B""PAID""true"`
+	if got != want {
+		t.Errorf("serialized\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestETAReceiptUUID(t *testing.T) {
+	s, _, _ := newTestETAService(t)
+	doc, _ := issueTestReceipt(t, s, "ORD-1", "")
+
+	if len(doc.Header.UUID) != 64 || strings.ToLower(doc.Header.UUID) != doc.Header.UUID {
+		t.Fatalf("uuid %q is not lowercase hex SHA-256", doc.Header.UUID)
+	}
+
+	signed := *doc
+	signed.Signatures = []ETASignature{{}}
+	if uuid, _ := ETAReceiptUUID(&signed); uuid != doc.Header.UUID {
+		t.Error("signatures changed the uuid")
+	}
+
+	chained := *doc
+	chained.Header.PreviousUUID = strings.Repeat("a", 64)
+	if uuid, _ := ETAReceiptUUID(&chained); uuid == doc.Header.UUID {
+		t.Error("previousUUID is not part of the hash")
+	}
+
+	tampered := *doc
+	tampered.TotalAmount++
+	if uuid, _ := ETAReceiptUUID(&tampered); uuid == doc.Header.UUID {
+		t.Error("total is not part of the hash")
+	}
+}
+
+func TestETABuildReceiptTotals(t *testing.T) {
+	s, _, mock := newTestETAService(t)
+	doc, _ := issueTestReceipt(t, s, "ORD-1", "")
+
+	if len(doc.ItemData) != 2 {
+		t.Fatalf("got %d lines, want the 2 that weren't cancelled", len(doc.ItemData))
+	}
+	if problems := mock.validate(doc); len(problems) > 0 {
+		t.Fatalf("receipt failed validation: %v", ETAError{Details: problems})
+	}
+	order := testETAOrder("ORD-1")
+	if want := etaRound(275.5 - order.Discount + order.ServiceCharge + order.TaxAmount); doc.TotalAmount != want {
+		t.Errorf("total = %v, want %v", doc.TotalAmount, want)
+	}
+}
+
+func TestETASubmitChain(t *testing.T) {
+	s, client, mock := newTestETAService(t)
+
+	first, firstData := issueTestReceipt(t, s, "ORD-1", "")
+	second, secondData := issueTestReceipt(t, s, "ORD-2", first.Header.UUID)
+
+	result, err := client.Submit([]json.RawMessage{firstData, secondData})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if len(result.AcceptedDocuments) != 2 || len(result.RejectedDocuments) != 0 {
+		t.Fatalf("result = %+v", result)
+	}
+	if _, ok := mock.Receipt(second.Header.UUID); !ok {
+		t.Error("second receipt not recorded")
+	}
+
+	details, err := client.SubmissionStatus(result.SubmissionID)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if details.Status != "Valid" || len(details.Receipts) != 2 {
+		t.Errorf("details = %+v", details)
+	}
+
+	// A receipt that skips the head of the chain is rejected
+	_, forked := issueTestReceipt(t, s, "ORD-3", first.Header.UUID)
+	result, err = client.Submit([]json.RawMessage{forked})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if len(result.RejectedDocuments) != 1 || !strings.Contains(result.RejectedDocuments[0].Error.Error(), "header.previousUUID") {
+		t.Errorf("forked chain: %+v", result)
+	}
+}
+
+func TestETASubmitRejectsTampering(t *testing.T) {
+	s, client, _ := newTestETAService(t)
+
+	doc, _ := issueTestReceipt(t, s, "ORD-1", "")
+	doc.TotalAmount += 100
+	data, _ := json.Marshal(doc)
+
+	result, err := client.Submit([]json.RawMessage{data})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if len(result.RejectedDocuments) != 1 {
+		t.Fatalf("tampered receipt accepted: %+v", result)
+	}
+	reason := result.RejectedDocuments[0].Error.Error()
+	if !strings.Contains(reason, "header.uuid") || !strings.Contains(reason, "totalAmount") {
+		t.Errorf("rejection = %s", reason)
+	}
+}
+
+func TestETASubmitTransientFailure(t *testing.T) {
+	s, client, mock := newTestETAService(t)
+	_, data := issueTestReceipt(t, s, "ORD-1", "")
+
+	mock.FailNext = 1
+	if _, err := client.Submit([]json.RawMessage{data}); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("submit during outage: %v", err)
+	}
+	result, err := client.Submit([]json.RawMessage{data})
+	if err != nil || len(result.AcceptedDocuments) != 1 {
+		t.Fatalf("retry: %+v, %v", result, err)
+	}
+	if mock.Submissions != 2 {
+		t.Errorf("authority saw %d submissions, want 2", mock.Submissions)
+	}
+}
+
+func TestETAClientReauthenticates(t *testing.T) {
+	_, client, mock := newTestETAService(t)
+
+	if _, err := client.SubmissionStatus("missing"); err == nil {
+		t.Fatal("unknown submission found")
+	}
+
+	// The authority forgets the token; the client logs in again after a 401
+	mock.mu.Lock()
+	mock.tokens = map[string]bool{}
+	mock.mu.Unlock()
+
+	if _, err := client.SubmissionStatus("missing"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expired token: %v", err)
+	}
+	if _, err := client.SubmissionStatus("missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("after re-authenticating: %v", err)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// EVENT LOG
+// ========================================
+
+// EventLog assigns per-room sequence numbers to WebSocket notifications and
+// keeps a bounded history of them, in memory and in the ws_events table, so
+// clients that drop off can ask for what they missed.
+type EventLog struct {
+	DB *gorm.DB
+
+	// MemorySize is how many recent events are kept in memory per room
+	MemorySize int
+	// MaxReplay is the largest gap served on reconnect; beyond it the
+	// client is told to resync from the REST API. It stays below
+	// clientQueueSize so a replay fits in a new client's send queue.
+	MaxReplay int
+	// Retention is how long events stay in the database
+	Retention time.Duration
+
+	mu     sync.Mutex
+	heads  map[string]uint64
+	recent map[string][]WSEvent
+}
+
+// NewEventLog creates an event log and restores each room's last sequence
+// from the database so numbering continues across restarts
+func NewEventLog(db *gorm.DB) *EventLog {
+	l := &EventLog{
+		DB:         db,
+		MemorySize: 500,
+		MaxReplay:  200,
+		Retention:  24 * time.Hour,
+		heads:      make(map[string]uint64),
+		recent:     make(map[string][]WSEvent),
+	}
+
+	if db != nil {
+		type roomHead struct {
+			Room string
+			Seq  uint64
+		}
+		var heads []roomHead
+		db.Model(&WSEvent{}).Select("room, MAX(seq) as seq").Group("room").Scan(&heads)
+		for _, h := range heads {
+			l.heads[h.Room] = h.Seq
+		}
+	}
+
+	return l
+}
+
+// Head returns the last sequence number issued in room
+func (l *EventLog) Head(room string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.heads[room]
+}
+
+// Append issues the next sequence for room, builds the message with it and
+// records the encoded result in memory. The event is returned for delivery
+// and must then be handed to Persist.
+func (l *EventLog) Append(room string, build func(seq uint64) interface{}) (*WSEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seq := l.heads[room] + 1
+	message := build(seq)
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &WSEvent{
+		Room:      room,
+		Seq:       seq,
+		Type:      eventType(message),
+		Payload:   string(data),
+		CreatedAt: time.Now(),
+	}
+
+	l.heads[room] = seq
+	l.recent[room] = append(l.recent[room], *event)
+	if len(l.recent[room]) > l.MemorySize {
+		l.recent[room] = l.recent[room][len(l.recent[room])-l.MemorySize:]
+	}
+
+	return event, nil
+}
+
+// Persist saves an appended event. It is called without the hub lock held,
+// so events from concurrent broadcasts may be written out of order; readers
+// always order by sequence.
+func (l *EventLog) Persist(event *WSEvent) {
+	if l.DB == nil {
+		return
+	}
+	if err := l.DB.Create(event).Error; err != nil {
+		log.Printf("Failed to persist event %s#%d: %v", event.Room, event.Seq, err)
+	}
+	if event.Seq%500 == 0 {
+		go l.Prune()
+	}
+}
+
+// Since returns the encoded events in room after lastSeq, oldest first.
+// ok is false when the gap can't be replayed and the client must resync.
+// The database is read without the log's lock held.
+func (l *EventLog) Since(room string, lastSeq uint64) ([][]byte, bool) {
+	l.mu.Lock()
+	head := l.heads[room]
+	if lastSeq > head || head-lastSeq > uint64(l.MaxReplay) {
+		l.mu.Unlock()
+		return nil, false
+	}
+	// Serve from memory when the ring still reaches back far enough
+	if events, ok := l.recentSince(room, lastSeq); ok {
+		l.mu.Unlock()
+		return events, true
+	}
+	l.mu.Unlock()
+
+	if l.DB == nil {
+		return nil, false
+	}
+
+	missing := int(head - lastSeq)
+	var stored []WSEvent
+	if err := l.DB.Where("room = ? AND seq > ? AND seq <= ?", room, lastSeq, head).
+		Order("seq ASC").
+		Limit(missing).
+		Find(&stored).Error; err != nil {
+		return nil, false
+	}
+
+	// Anything pruned from the middle of the range means a resync
+	if len(stored) != missing || stored[0].Seq != lastSeq+1 {
+		return nil, false
+	}
+
+	events := make([][]byte, 0, len(stored))
+	for _, event := range stored {
+		events = append(events, []byte(event.Payload))
+	}
+	return events, true
+}
+
+// Recent returns the events in room after lastSeq from memory only. ok is
+// false when the ring no longer reaches back that far.
+func (l *EventLog) Recent(room string, lastSeq uint64) ([][]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lastSeq > l.heads[room] {
+		return nil, false
+	}
+	return l.recentSince(room, lastSeq)
+}
+
+// recentSince collects the events after lastSeq from the in-memory ring.
+// Callers must hold l.mu.
+func (l *EventLog) recentSince(room string, lastSeq uint64) ([][]byte, bool) {
+	head := l.heads[room]
+	if lastSeq == head {
+		return nil, true
+	}
+	recent := l.recent[room]
+	if len(recent) == 0 || recent[0].Seq > lastSeq+1 {
+		return nil, false
+	}
+	events := make([][]byte, 0, head-lastSeq)
+	for _, event := range recent {
+		if event.Seq > lastSeq {
+			events = append(events, []byte(event.Payload))
+		}
+	}
+	return events, true
+}
+
+// Prune deletes persisted events older than the retention window
+func (l *EventLog) Prune() {
+	if l.DB == nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-l.Retention)
+	if err := l.DB.Where("created_at < ?", cutoff).Delete(&WSEvent{}).Error; err != nil {
+		log.Printf("Failed to prune event log: %v", err)
+	}
+}
+
+// eventType extracts the notification type for indexing
+func eventType(message interface{}) string {
+	switch msg := message.(type) {
+	case Notification:
+		return msg.Type
+	case map[string]interface{}:
+		if t, ok := msg["type"].(string); ok {
+			return t
+		}
+	}
+	return ""
+}
+
+// ========================================
+// EVENT LOG HANDLERS
+// ========================================
+
+// HandleGetEvents returns events in a room after a given sequence, for
+// clients that catch up over HTTP instead of the WebSocket subscribe message
+func (a *App) HandleGetEvents(c *gin.Context) {
+	room := c.Query("room")
+	if room == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Missing room"})
+		return
+	}
+	if !a.CanJoinRoom(c.GetString("role"), "", room) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Insufficient permissions", Message: "you can't follow room " + room})
+		return
+	}
+
+	since, err := strconv.ParseUint(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid since", Message: err.Error()})
+		return
+	}
+
+	events, ok := a.WSManager.Events.Since(room, since)
+	head := a.WSManager.Events.Head(room)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Resync required",
+			"room":  room,
+			"seq":   head,
+		})
+		return
+	}
+
+	payloads := make([]json.RawMessage, 0, len(events))
+	for _, event := range events {
+		payloads = append(payloads, json.RawMessage(event))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room":   room,
+		"seq":    head,
+		"events": payloads,
+	})
+}
+
+// ========================================
+// EVENT ACCESS
+// ========================================
+
+// roomPermissions lists the permissions that let a user follow each room;
+// any one of them is enough. Rooms that aren't listed can't be followed.
+var roomPermissions = map[string][]string{
+	"all":       nil,
+	"pos":       {PermOrdersView},
+	"kitchen":   {PermOrdersView},
+	"tables":    {PermOrdersView},
+	"waiters":   {PermOrdersView},
+	"managers":  {PermOrdersVoid, PermOrdersDiscount, PermOrdersReopen, PermDrawerOpen},
+	"dashboard": {PermDashboardView},
+	"inventory": {PermInventoryView},
+	"staff":     {PermStaffView},
+}
+
+// deviceRooms lists the rooms a device may follow before anyone signs in on it
+var deviceRooms = map[string][]string{
+	DeviceKindPOS:             {"all", "pos", "tables", "waiters"},
+	DeviceKindKDS:             {"all", "kitchen"},
+	DeviceKindHandheld:        {"all", "tables", "waiters"},
+	DeviceKindCustomerDisplay: {"all"},
+}
+
+// CanJoinRoom reports whether a user with role, or without a role a device
+// of deviceKind, may follow room
+func (a *App) CanJoinRoom(role, deviceKind, room string) bool {
+	if role == "" {
+		return containsString(deviceRooms[deviceKind], room)
+	}
+	perms, ok := roomPermissions[room]
+	if !ok {
+		return false
+	}
+	if len(perms) == 0 {
+		return true
+	}
+	for _, code := range perms {
+		if a.HasPermission(role, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeWebSocket identifies a WebSocket client by access token or, for
+// a terminal nobody is signed in on, device token, and returns the check
+// for the rooms it may join. Browsers can't set headers on a WebSocket, so
+// the tokens are also read from the token and device_token query
+// parameters. When it returns nil the response has been written.
+func (a *App) AuthorizeWebSocket(c *gin.Context) func(room string) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		token = c.Query("token")
+	}
+	if token != "" {
+		claims, err := a.ValidateJWTToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return nil
+		}
+		_, user, err := a.CheckSession(claims)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return nil
+		}
+		if user.MustChangePassword || (!user.TOTPEnabled && a.TwoFactorRequiredFor(user.Role)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Finish setting up your account first"})
+			return nil
+		}
+		role := user.Role
+		return func(room string) bool { return a.CanJoinRoom(role, "", room) }
+	}
+
+	deviceToken := c.GetHeader(DeviceTokenHeader)
+	if deviceToken == "" {
+		deviceToken = c.Query("device_token")
+	}
+	device, err := a.authenticateDeviceToken(c, deviceToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid token"})
+		return nil
+	}
+	kind := device.Kind
+	return func(room string) bool { return a.CanJoinRoom("", kind, room) }
+}
@@ -0,0 +1,896 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// FORECASTING
+// ========================================
+//
+// Orders, covers, revenue and item quantities are forecast per daypart with
+// a seasonal average:
+//
+//	forecast = weighted mean of the same weekday over the last N weeks
+//	         × recent trend × override factor
+//
+// Recent weeks weigh more. The trend is the daily covers of the last four
+// weeks over the four before, clamped. Days with no paid orders (closed)
+// and days with an override (holidays, events) are left out of the
+// history. Booked reservations set a floor on covers, and the other
+// figures are scaled up with them.
+
+// DefaultDayparts is used when FORECAST_DAYPARTS is not set
+const DefaultDayparts = "breakfast=6-11,lunch=11-16,dinner=16-23,late=23-6"
+
+// Forecast limits
+const (
+	maxForecastDays   = 28
+	forecastTrendDays = 28
+	forecastTrendMin  = 0.75
+	forecastTrendMax  = 1.33
+)
+
+// forecastDateLayout is the format of business dates
+const forecastDateLayout = "2006-01-02"
+
+// Daypart is a named span of hours [Start, End). A daypart with End <= Start
+// runs past midnight.
+type Daypart struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Contains reports whether hour falls in the daypart
+func (d Daypart) Contains(hour int) bool {
+	if d.Start < d.End {
+		return hour >= d.Start && hour < d.End
+	}
+	return hour >= d.Start || hour < d.End
+}
+
+// ParseDayparts parses "name=start-end,..." with whole hours. Dayparts may
+// not overlap; hours outside every daypart are not forecast.
+func ParseDayparts(spec string) ([]Daypart, error) {
+	var dayparts []Daypart
+	var owner [24]string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, hours, ok := strings.Cut(part, "=")
+		from, to, ok2 := strings.Cut(hours, "-")
+		if !ok || !ok2 || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("daypart %q: want name=start-end", part)
+		}
+		start, err1 := strconv.Atoi(strings.TrimSpace(from))
+		end, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || start < 0 || start > 23 || end < 0 || end > 24 || start == end%24 {
+			return nil, fmt.Errorf("daypart %q: hours must be 0-24 and differ", part)
+		}
+
+		d := Daypart{Name: strings.TrimSpace(name), Start: start, End: end % 24}
+		for h := 0; h < 24; h++ {
+			if !d.Contains(h) {
+				continue
+			}
+			if owner[h] != "" {
+				return nil, fmt.Errorf("dayparts %s and %s overlap at %02d:00", owner[h], d.Name, h)
+			}
+			owner[h] = d.Name
+		}
+		for _, other := range dayparts {
+			if other.Name == d.Name {
+				return nil, fmt.Errorf("daypart %s is listed twice", d.Name)
+			}
+		}
+		dayparts = append(dayparts, d)
+	}
+	if len(dayparts) == 0 {
+		return nil, errors.New("no dayparts configured")
+	}
+	return dayparts, nil
+}
+
+// forecastCalendar maps times to business dates and dayparts. A business
+// day starts at the first daypart's start hour, so late-night sales count
+// towards the day before.
+type forecastCalendar struct {
+	loc      *time.Location
+	dayparts []Daypart
+	dayStart int
+}
+
+func (a *App) forecastCalendar() (*forecastCalendar, error) {
+	dayparts, err := ParseDayparts(a.Config.Forecast.Dayparts)
+	if err != nil {
+		return nil, err
+	}
+	return &forecastCalendar{loc: a.reportLocation(), dayparts: dayparts, dayStart: dayparts[0].Start}, nil
+}
+
+// businessDay returns the business date t belongs to, at midnight
+func (c *forecastCalendar) businessDay(t time.Time) time.Time {
+	t = t.In(c.loc)
+	if t.Hour() < c.dayStart {
+		t = t.AddDate(0, 0, -1)
+	}
+	return startOfDay(t)
+}
+
+// start returns when a business date begins
+func (c *forecastCalendar) start(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), c.dayStart, 0, 0, 0, c.loc)
+}
+
+// slot returns the business date and daypart index of t, -1 when t is
+// outside every daypart
+func (c *forecastCalendar) slot(t time.Time) (string, int) {
+	date := c.businessDay(t).Format(forecastDateLayout)
+	hour := t.In(c.loc).Hour()
+	for i, d := range c.dayparts {
+		if d.Contains(hour) {
+			return date, i
+		}
+	}
+	return date, -1
+}
+
+// forecastSlot is what was sold, or is expected to sell, in one daypart
+type forecastSlot struct {
+	orders  float64
+	covers  float64
+	revenue float64
+	items   map[uint]float64
+}
+
+func newForecastSlot() forecastSlot {
+	return forecastSlot{items: map[uint]float64{}}
+}
+
+// add adds weight times o to s
+func (s *forecastSlot) add(o *forecastSlot, weight float64) {
+	s.orders += o.orders * weight
+	s.covers += o.covers * weight
+	s.revenue += o.revenue * weight
+	for id, qty := range o.items {
+		s.items[id] += qty * weight
+	}
+}
+
+// scaled returns s multiplied by f
+func (s *forecastSlot) scaled(f float64) forecastSlot {
+	out := newForecastSlot()
+	out.add(s, f)
+	return out
+}
+
+// ForecastItem is the expected quantity of one menu item
+type ForecastItem struct {
+	MenuItemID uint    `json:"menu_item_id"`
+	Name       string  `json:"name"`
+	NameAr     string  `json:"name_ar"`
+	Quantity   float64 `json:"quantity"`
+}
+
+// DaypartForecast is the forecast for one daypart of one day
+type DaypartForecast struct {
+	Daypart        string         `json:"daypart"`
+	Orders         float64        `json:"orders"`
+	Covers         float64        `json:"covers"`
+	Revenue        float64        `json:"revenue"`
+	ReservedCovers int            `json:"reserved_covers"`
+	Factor         float64        `json:"factor"`
+	Items          []ForecastItem `json:"items,omitempty"`
+}
+
+// DayForecast is the forecast for one business day
+type DayForecast struct {
+	Date     string            `json:"date"`
+	Weekday  string            `json:"weekday"`
+	Override string            `json:"override,omitempty"`
+	Orders   float64           `json:"orders"`
+	Covers   float64           `json:"covers"`
+	Revenue  float64           `json:"revenue"`
+	Dayparts []DaypartForecast `json:"dayparts"`
+}
+
+// SalesForecast is a forecast for consecutive business days
+type SalesForecast struct {
+	GeneratedAt  time.Time     `json:"generated_at"`
+	Timezone     string        `json:"timezone"`
+	HistoryWeeks int           `json:"history_weeks"`
+	HistoryDays  int           `json:"history_days"` // open days the model learned from
+	Trend        float64       `json:"trend"`
+	Dayparts     []Daypart     `json:"dayparts"`
+	Days         []DayForecast `json:"days"`
+}
+
+// forecastOrdersSQL sums paid orders per time bucket; orders without a
+// guest count are one cover
+const forecastOrdersSQL = `SELECT FLOOR(UNIX_TIMESTAMP(o.created_at) / ?) AS bucket,
+	COUNT(*) AS orders,
+	SUM(GREATEST(o.guests, 1)) AS covers,
+	COALESCE(SUM(o.total), 0) AS revenue
+	FROM orders o
+	WHERE o.payment_status = 'paid' AND o.created_at >= ? AND o.created_at < ?
+	GROUP BY bucket`
+
+// forecastItemsSQL sums paid, uncancelled item quantities per time bucket
+const forecastItemsSQL = `SELECT FLOOR(UNIX_TIMESTAMP(o.created_at) / ?) AS bucket,
+	oi.menu_item_id AS item_id,
+	SUM(oi.quantity) AS quantity
+	FROM order_items oi
+	JOIN orders o ON o.id = oi.order_id
+	WHERE o.payment_status = 'paid' AND oi.status <> 'cancelled'
+		AND o.created_at >= ? AND o.created_at < ?
+	GROUP BY bucket, oi.menu_item_id`
+
+// ForecastSales forecasts days business days starting at from (a date in
+// the restaurant's time zone). withItems adds item quantities.
+func (a *App) ForecastSales(from time.Time, days int, withItems bool) (*SalesForecast, error) {
+	if days < 1 || days > maxForecastDays {
+		return nil, fmt.Errorf("days must be between 1 and %d", maxForecastDays)
+	}
+	cal, err := a.forecastCalendar()
+	if err != nil {
+		return nil, err
+	}
+	weeks := a.Config.Forecast.HistoryWeeks
+	if weeks < 1 {
+		weeks = 8
+	}
+
+	// History runs up to the start of the current business day
+	historyEnd := cal.businessDay(time.Now())
+	historyStart := historyEnd.AddDate(0, 0, -7*weeks)
+	history, err := a.forecastHistory(cal, cal.start(historyStart), cal.start(historyEnd), withItems)
+	if err != nil {
+		return nil, err
+	}
+
+	from = startOfDay(from.In(cal.loc))
+	until := from.AddDate(0, 0, days)
+	overrides, err := a.forecastOverrides(historyStart, until)
+	if err != nil {
+		return nil, err
+	}
+	for date := range overrides {
+		delete(history, date)
+	}
+
+	reserved, err := a.reservedCovers(cal, cal.start(from), cal.start(until))
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := &SalesForecast{
+		GeneratedAt:  time.Now(),
+		Timezone:     cal.loc.String(),
+		HistoryWeeks: weeks,
+		HistoryDays:  len(history),
+		Trend:        forecastTrend(history, historyEnd),
+		Dayparts:     cal.dayparts,
+	}
+
+	// Fallback for weekdays with no history: the average open day
+	fallback := make([]forecastSlot, len(cal.dayparts))
+	for p := range fallback {
+		fallback[p] = newForecastSlot()
+		for _, slots := range history {
+			fallback[p].add(&slots[p], 1/float64(len(history)))
+		}
+	}
+
+	var itemIDs []uint
+	seenItem := map[uint]bool{}
+	for day := from; day.Before(until); day = day.AddDate(0, 0, 1) {
+		date := day.Format(forecastDateLayout)
+		dayForecast := DayForecast{Date: date, Weekday: day.Weekday().String()}
+		override := overrides[date]
+		if o := override[""]; o != nil {
+			dayForecast.Override = o.Name
+		}
+
+		for p, daypart := range cal.dayparts {
+			slot := newForecastSlot()
+			var total float64
+			for k := 1; k <= weeks; k++ {
+				past, ok := history[day.AddDate(0, 0, -7*k).Format(forecastDateLayout)]
+				if !ok {
+					continue
+				}
+				weight := float64(weeks - k + 1)
+				slot.add(&past[p], weight)
+				total += weight
+			}
+			if total > 0 {
+				slot = slot.scaled(1 / total)
+			} else {
+				slot = fallback[p].scaled(1)
+			}
+
+			overrideFactor := 1.0
+			if o := override[daypart.Name]; o != nil {
+				overrideFactor = o.Factor
+			} else if o := override[""]; o != nil {
+				overrideFactor = o.Factor
+			}
+			slot = slot.scaled(forecast.Trend * overrideFactor)
+
+			booked := reserved[date][p]
+			if overrideFactor > 0 && float64(booked) > slot.covers {
+				if slot.covers > 0 {
+					slot = slot.scaled(float64(booked) / slot.covers)
+				}
+				slot.covers = float64(booked)
+			}
+
+			dp := DaypartForecast{
+				Daypart:        daypart.Name,
+				Orders:         math.Round(slot.orders*10) / 10,
+				Covers:         math.Round(slot.covers*10) / 10,
+				Revenue:        RoundToDecimal(slot.revenue),
+				ReservedCovers: booked,
+				Factor:         math.Round(overrideFactor*100) / 100,
+			}
+			for id, qty := range slot.items {
+				if qty = math.Round(qty*10) / 10; qty > 0 {
+					dp.Items = append(dp.Items, ForecastItem{MenuItemID: id, Quantity: qty})
+					if !seenItem[id] {
+						seenItem[id] = true
+						itemIDs = append(itemIDs, id)
+					}
+				}
+			}
+			sort.Slice(dp.Items, func(i, j int) bool { return dp.Items[i].Quantity > dp.Items[j].Quantity })
+
+			dayForecast.Orders += dp.Orders
+			dayForecast.Covers += dp.Covers
+			dayForecast.Revenue += dp.Revenue
+			dayForecast.Dayparts = append(dayForecast.Dayparts, dp)
+		}
+		dayForecast.Revenue = RoundToDecimal(dayForecast.Revenue)
+		forecast.Days = append(forecast.Days, dayForecast)
+	}
+
+	if len(itemIDs) > 0 {
+		var menuItems []MenuItem
+		if err := a.DB.Select("id, name, name_ar").Where("id IN ?", itemIDs).Find(&menuItems).Error; err != nil {
+			return nil, err
+		}
+		names := make(map[uint]MenuItem, len(menuItems))
+		for _, m := range menuItems {
+			names[m.ID] = m
+		}
+		for d := range forecast.Days {
+			for p := range forecast.Days[d].Dayparts {
+				items := forecast.Days[d].Dayparts[p].Items
+				for i := range items {
+					items[i].Name = names[items[i].MenuItemID].Name
+					items[i].NameAr = names[items[i].MenuItemID].NameAr
+				}
+			}
+		}
+	}
+	return forecast, nil
+}
+
+// forecastHistory loads paid sales per business date and daypart. Dates
+// without paid orders are treated as closed and left out.
+func (a *App) forecastHistory(cal *forecastCalendar, from, to time.Time, withItems bool) (map[string][]forecastSlot, error) {
+	r := &ReportRange{From: from, To: to, GroupBy: GroupByHour, loc: cal.loc}
+	seconds := r.bucketSeconds()
+	bucketTime := func(result map[string]interface{}) time.Time {
+		return time.Unix(int64(reportFloat(result["bucket"]))*seconds, 0)
+	}
+
+	history := map[string][]forecastSlot{}
+	slotAt := func(date string, p int) *forecastSlot {
+		slots, ok := history[date]
+		if !ok {
+			slots = make([]forecastSlot, len(cal.dayparts))
+			for i := range slots {
+				slots[i] = newForecastSlot()
+			}
+			history[date] = slots
+		}
+		return &slots[p]
+	}
+
+	var orders []map[string]interface{}
+	if err := a.DB.Raw(forecastOrdersSQL, seconds, from, to).Scan(&orders).Error; err != nil {
+		return nil, err
+	}
+	for _, result := range orders {
+		date, p := cal.slot(bucketTime(result))
+		if p < 0 {
+			continue
+		}
+		slot := slotAt(date, p)
+		slot.orders += reportFloat(result["orders"])
+		slot.covers += reportFloat(result["covers"])
+		slot.revenue += reportFloat(result["revenue"])
+	}
+
+	if withItems {
+		var items []map[string]interface{}
+		if err := a.DB.Raw(forecastItemsSQL, seconds, from, to).Scan(&items).Error; err != nil {
+			return nil, err
+		}
+		for _, result := range items {
+			date, p := cal.slot(bucketTime(result))
+			if _, open := history[date]; !open || p < 0 {
+				continue
+			}
+			history[date][p].items[uint(reportFloat(result["item_id"]))] += reportFloat(result["quantity"])
+		}
+	}
+	return history, nil
+}
+
+// forecastTrend compares average daily covers over the last four weeks
+// with the four weeks before
+func forecastTrend(history map[string][]forecastSlot, end time.Time) float64 {
+	recentStart := end.AddDate(0, 0, -forecastTrendDays).Format(forecastDateLayout)
+	priorStart := end.AddDate(0, 0, -2*forecastTrendDays).Format(forecastDateLayout)
+
+	var recent, prior float64
+	var recentDays, priorDays int
+	for date, slots := range history {
+		var covers float64
+		for _, s := range slots {
+			covers += s.covers
+		}
+		switch {
+		case date >= recentStart:
+			recent += covers
+			recentDays++
+		case date >= priorStart:
+			prior += covers
+			priorDays++
+		}
+	}
+	if recentDays == 0 || priorDays == 0 || prior == 0 {
+		return 1
+	}
+	trend := (recent / float64(recentDays)) / (prior / float64(priorDays))
+	return math.Round(math.Max(forecastTrendMin, math.Min(forecastTrendMax, trend))*100) / 100
+}
+
+// forecastOverrides returns overrides by date and daypart ("" for the
+// whole day) for [from, to)
+func (a *App) forecastOverrides(from, to time.Time) (map[string]map[string]*ForecastOverride, error) {
+	var list []ForecastOverride
+	err := a.DB.Where("date >= ? AND date < ?", from.Format(forecastDateLayout), to.Format(forecastDateLayout)).
+		Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	overrides := map[string]map[string]*ForecastOverride{}
+	for i := range list {
+		o := &list[i]
+		if overrides[o.Date] == nil {
+			overrides[o.Date] = map[string]*ForecastOverride{}
+		}
+		overrides[o.Date][o.Daypart] = o
+	}
+	return overrides, nil
+}
+
+// reservedCovers sums party sizes of live reservations per business date
+// and daypart
+func (a *App) reservedCovers(cal *forecastCalendar, from, to time.Time) (map[string]map[int]int, error) {
+	var reservations []Reservation
+	err := a.DB.Select("reservation_time, party_size").
+		Where("reservation_time >= ? AND reservation_time < ?", from, to).
+		Where("status NOT IN ?", []string{"cancelled", "no_show"}).
+		Find(&reservations).Error
+	if err != nil {
+		return nil, err
+	}
+	reserved := map[string]map[int]int{}
+	for _, r := range reservations {
+		date, p := cal.slot(r.ReservationTime)
+		if p < 0 {
+			continue
+		}
+		if reserved[date] == nil {
+			reserved[date] = map[int]int{}
+		}
+		reserved[date][p] += r.PartySize
+	}
+	return reserved, nil
+}
+
+// ========================================
+// PREP LISTS AND STOCK NEEDS
+// ========================================
+
+// PrepItem is one menu item to prepare, per daypart
+type PrepItem struct {
+	MenuItemID uint           `json:"menu_item_id"`
+	Name       string         `json:"name"`
+	NameAr     string         `json:"name_ar"`
+	Dayparts   map[string]int `json:"dayparts"`
+	Total      int            `json:"total"`
+}
+
+// PrepStation is the prep list of one kitchen or bar station
+type PrepStation struct {
+	PrinterID uint       `json:"printer_id,omitempty"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	Items     []PrepItem `json:"items"`
+}
+
+// PrepList splits one day's forecast item quantities between stations,
+// routed like order tickets: by the kitchen and bar printers' categories.
+// Quantities are rounded up.
+func (a *App) PrepList(day *DayForecast) ([]PrepStation, error) {
+	items := map[uint]*PrepItem{}
+	var order Order
+	for _, dp := range day.Dayparts {
+		for _, fi := range dp.Items {
+			qty := int(math.Ceil(fi.Quantity))
+			item, ok := items[fi.MenuItemID]
+			if !ok {
+				item = &PrepItem{MenuItemID: fi.MenuItemID, Name: fi.Name, NameAr: fi.NameAr, Dayparts: map[string]int{}}
+				items[fi.MenuItemID] = item
+				// One synthetic order line per menu item; its ID only has
+				// to be unique for routing
+				order.Items = append(order.Items, OrderItem{ID: uint(len(order.Items) + 1), MenuItemID: fi.MenuItemID})
+			}
+			item.Dayparts[dp.Daypart] += qty
+			item.Total += qty
+		}
+	}
+
+	var printers []Printer
+	if err := a.DB.Where("is_active = ? AND type IN ?", true, []string{"kitchen", "bar"}).Order("id").Find(&printers).Error; err != nil {
+		return nil, err
+	}
+	routes, err := a.RouteOrderItems(&order, printers)
+	if err != nil {
+		return nil, err
+	}
+
+	var stations []PrepStation
+	routed := map[uint]bool{}
+	for _, printer := range printers {
+		station := PrepStation{PrinterID: printer.ID, Name: printer.Name, Type: printer.Type}
+		for _, line := range routes[printer.ID] {
+			station.Items = append(station.Items, *items[line.MenuItemID])
+			routed[line.MenuItemID] = true
+		}
+		if len(station.Items) > 0 {
+			stations = append(stations, station)
+		}
+	}
+	unassigned := PrepStation{Name: "Unassigned", Type: "none"}
+	for _, line := range order.Items {
+		if !routed[line.MenuItemID] {
+			unassigned.Items = append(unassigned.Items, *items[line.MenuItemID])
+		}
+	}
+	if len(unassigned.Items) > 0 {
+		stations = append(stations, unassigned)
+	}
+
+	for i := range stations {
+		sort.Slice(stations[i].Items, func(a, b int) bool {
+			return stations[i].Items[a].Total > stations[i].Items[b].Total
+		})
+	}
+	return stations, nil
+}
+
+// StockNeed is the forecast use of a stock item and how much to reorder
+type StockNeed struct {
+	StockItemID   uint    `json:"stock_item_id"`
+	Name          string  `json:"name"`
+	NameAr        string  `json:"name_ar"`
+	Unit          string  `json:"unit"`
+	CurrentStock  float64 `json:"current_stock"`
+	MinimumStock  float64 `json:"minimum_stock"`
+	ForecastUsage float64 `json:"forecast_usage"`
+	Suggested     float64 `json:"suggested_order"`
+	CostPerUnit   float64 `json:"cost_per_unit"`
+	EstimatedCost float64 `json:"estimated_cost"`
+	Supplier      string  `json:"supplier"`
+}
+
+// StockNeeds turns forecast item quantities into stock usage through the
+// items' recipes. The suggested order keeps stock at its minimum after the
+// forecast period; stock already below minimum is included even without
+// forecast usage.
+func (a *App) StockNeeds(forecast *SalesForecast) ([]StockNeed, error) {
+	sold := map[uint]float64{}
+	for _, day := range forecast.Days {
+		for _, dp := range day.Dayparts {
+			for _, item := range dp.Items {
+				sold[item.MenuItemID] += item.Quantity
+			}
+		}
+	}
+
+	usage := map[uint]float64{}
+	if len(sold) > 0 {
+		ids := make([]uint, 0, len(sold))
+		for id := range sold {
+			ids = append(ids, id)
+		}
+		var lines []RecipeLine
+		if err := a.DB.Where("menu_item_id IN ?", ids).Find(&lines).Error; err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			usage[line.StockItemID] += sold[line.MenuItemID] * line.Quantity
+		}
+	}
+
+	var stock []StockItem
+	if err := a.DB.Order("name").Find(&stock).Error; err != nil {
+		return nil, err
+	}
+	var needs []StockNeed
+	for _, s := range stock {
+		use := usage[s.ID]
+		suggested := math.Max(0, use+s.MinimumStock-s.CurrentStock)
+		if use == 0 && suggested == 0 {
+			continue
+		}
+		suggested = math.Ceil(suggested*100) / 100
+		needs = append(needs, StockNeed{
+			StockItemID:   s.ID,
+			Name:          s.Name,
+			NameAr:        s.NameAr,
+			Unit:          s.Unit,
+			CurrentStock:  s.CurrentStock,
+			MinimumStock:  s.MinimumStock,
+			ForecastUsage: math.Round(use*100) / 100,
+			Suggested:     suggested,
+			CostPerUnit:   s.CostPerUnit,
+			EstimatedCost: RoundToDecimal(suggested * s.CostPerUnit),
+			Supplier:      s.Supplier,
+		})
+	}
+	return needs, nil
+}
+
+// ========================================
+// FORECAST HANDLERS
+// ========================================
+
+// forecastStart reads a YYYY-MM-DD query parameter, defaulting to the next
+// business day
+func (a *App) forecastStart(c *gin.Context, name string) (time.Time, error) {
+	loc := a.reportLocation()
+	if value := c.Query(name); value != "" {
+		day, err := time.ParseInLocation(forecastDateLayout, value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s must be YYYY-MM-DD", name)
+		}
+		return day, nil
+	}
+	cal, err := a.forecastCalendar()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cal.businessDay(time.Now()).AddDate(0, 0, 1), nil
+}
+
+// HandleGetForecast forecasts orders, covers and revenue per daypart for
+// the next days (default 7), with item quantities when items=true
+func (a *App) HandleGetForecast(c *gin.Context) {
+	from, err := a.forecastStart(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	days := getInt(c.DefaultQuery("days", "7"))
+
+	forecast, err := a.ForecastSales(from, days, c.Query("items") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to build forecast", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, forecast)
+}
+
+// HandleGetPrepList returns the prep list per station for one day
+// (default the next business day)
+func (a *App) HandleGetPrepList(c *gin.Context) {
+	day, err := a.forecastStart(c, "date")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	forecast, err := a.ForecastSales(day, 1, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to build forecast", Message: err.Error()})
+		return
+	}
+	stations, err := a.PrepList(&forecast.Days[0])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to build prep list", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":     forecast.Days[0].Date,
+		"forecast": forecast.Days[0],
+		"stations": stations,
+	})
+}
+
+// HandleGetReorderSuggestions suggests stock orders to cover the forecast
+// for the next days (default 7)
+func (a *App) HandleGetReorderSuggestions(c *gin.Context) {
+	from, err := a.forecastStart(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	days := getInt(c.DefaultQuery("days", "7"))
+
+	forecast, err := a.ForecastSales(from, days, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to build forecast", Message: err.Error()})
+		return
+	}
+	needs, err := a.StockNeeds(forecast)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to compute stock needs", Message: err.Error()})
+		return
+	}
+
+	var total float64
+	for _, need := range needs {
+		total += need.EstimatedCost
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":           forecast.Days[0].Date,
+		"days":           days,
+		"items":          needs,
+		"estimated_cost": RoundToDecimal(total),
+	})
+}
+
+// ForecastOverrideRequest creates or updates a forecast override
+type ForecastOverrideRequest struct {
+	Date    string   `json:"date" binding:"required"`
+	Daypart string   `json:"daypart"`
+	Name    string   `json:"name" binding:"required"`
+	NameAr  string   `json:"name_ar"`
+	Factor  *float64 `json:"factor" binding:"required"`
+	Notes   string   `json:"notes"`
+}
+
+// apply validates the request and copies it onto o
+func (r *ForecastOverrideRequest) apply(o *ForecastOverride, dayparts []Daypart) error {
+	if _, err := time.Parse(forecastDateLayout, r.Date); err != nil {
+		return errors.New("date must be YYYY-MM-DD")
+	}
+	if *r.Factor < 0 || *r.Factor > 10 {
+		return errors.New("factor must be between 0 and 10")
+	}
+	if r.Daypart != "" {
+		known := false
+		for _, d := range dayparts {
+			known = known || d.Name == r.Daypart
+		}
+		if !known {
+			return fmt.Errorf("unknown daypart %q", r.Daypart)
+		}
+	}
+
+	o.Date = r.Date
+	o.Daypart = r.Daypart
+	o.Name = r.Name
+	o.NameAr = r.NameAr
+	o.Factor = *r.Factor
+	o.Notes = r.Notes
+	return nil
+}
+
+// HandleGetForecastOverrides lists overrides, from today on unless from
+// is given
+func (a *App) HandleGetForecastOverrides(c *gin.Context) {
+	from := c.DefaultQuery("from", time.Now().In(a.reportLocation()).Format(forecastDateLayout))
+	var overrides []ForecastOverride
+	if err := a.DB.Where("date >= ?", from).Order("date ASC, daypart ASC").Find(&overrides).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch overrides"})
+		return
+	}
+	c.JSON(http.StatusOK, overrides)
+}
+
+// HandleCreateForecastOverride adds a holiday or event override
+func (a *App) HandleCreateForecastOverride(c *gin.Context) {
+	var req ForecastOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	cal, err := a.forecastCalendar()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Invalid daypart configuration", Message: err.Error()})
+		return
+	}
+
+	var override ForecastOverride
+	if err := req.apply(&override, cal.dayparts); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := a.DB.Create(&override).Error; err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Failed to create override", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Override created successfully",
+		Data:    override,
+	})
+}
+
+// HandleUpdateForecastOverride updates an override
+func (a *App) HandleUpdateForecastOverride(c *gin.Context) {
+	var override ForecastOverride
+	if err := a.DB.First(&override, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Override not found"})
+		return
+	}
+
+	var req ForecastOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	cal, err := a.forecastCalendar()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Invalid daypart configuration", Message: err.Error()})
+		return
+	}
+	if err := req.apply(&override, cal.dayparts); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := a.DB.Save(&override).Error; err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Failed to update override", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Override updated successfully",
+		Data:    override,
+	})
+}
+
+// HandleDeleteForecastOverride deletes an override
+func (a *App) HandleDeleteForecastOverride(c *gin.Context) {
+	if err := a.DB.Delete(&ForecastOverride{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete override"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Override deleted successfully",
+	})
+}
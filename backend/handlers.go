@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ========================================
@@ -23,14 +27,24 @@ func (a *App) HandleLogin(c *gin.Context) {
 
 	// Find user by email
 	var user User
-	if err := a.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := a.DB.Where("email = ? AND is_service = ?", req.Email, false).First(&user).Error; err != nil {
+		// Take as long as a wrong password so unknown emails don't stand out
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		a.Audit(c, AuditEntry{Action: "login_failed", Entity: "user", Details: gin.H{"email": req.Email}})
 		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 		return
 	}
 
+	// Refuse attempts on locked accounts or before the retry delay is up
+	now := time.Now()
+	if wait, err := a.checkLoginAllowed(&user, now); err != nil {
+		respondLoginRefused(c, wait, err)
+		return
+	}
+
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		a.respondLoginFailure(c, &user, now, "login_failed")
 		return
 	}
 
@@ -40,54 +54,170 @@ func (a *App) HandleLogin(c *gin.Context) {
 		return
 	}
 
-	// Generate token
-	token, err := a.GenerateToken(fmt.Sprintf("%d", user.ID))
+	// Accounts with 2FA also need a current code or a recovery code
+	if user.TOTPEnabled {
+		err := a.VerifySecondFactor(&user, req.TOTPCode, req.RecoveryCode)
+		switch {
+		case errors.Is(err, ErrTwoFactorRequired):
+			c.JSON(http.StatusUnauthorized, TwoFactorRequiredResponse{
+				Error:             "Two-factor code required",
+				Message:           "send totp_code or recovery_code with your credentials",
+				TwoFactorRequired: true,
+			})
+			return
+		case errors.Is(err, ErrInvalidTwoFactor):
+			a.respondLoginFailure(c, &user, now, "login_2fa_failed")
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify two-factor code"})
+			return
+		}
+		if req.TOTPCode == "" {
+			a.Audit(c, AuditEntry{Action: "2fa_recovery_code_used", Entity: "user", EntityID: user.ID, UserID: user.ID})
+		}
+	}
+
+	// Open a session and issue its tokens
+	resp, err := a.StartSession(user, c, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate token"})
 		return
 	}
 
-	// Update last login
-	a.DB.Model(&user).Update("LastLogin", a.GetCurrentTime())
+	a.recordLoginSuccess(&user, now)
+	a.Audit(c, AuditEntry{Action: "login", Entity: "user", EntityID: user.ID, UserID: user.ID})
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  user,
-	})
+	c.JSON(http.StatusOK, resp)
 }
 
-// HandleLogout handles user logout
+// HandleLogout revokes the session of the given refresh token, or of the
+// bearer access token when no refresh token is sent
 func (a *App) HandleLogout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	c.ShouldBindJSON(&req)
+
+	var sessionID uint
+	if req.RefreshToken != "" {
+		var stored RefreshToken
+		if err := a.DB.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&stored).Error; err == nil {
+			sessionID = stored.SessionID
+		}
+	} else if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		if claims, err := a.ValidateJWTToken(token); err == nil {
+			sessionID = claims.SessionID
+		}
+	}
+	if sessionID == 0 {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
+		return
+	}
+
+	if err := a.RevokeSession(sessionID, RevokedLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to log out"})
+		return
+	}
+
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
 		Message: "Logged out successfully",
 	})
 }
 
-// HandleRefreshToken handles token refresh
+// HandleLogoutAll revokes every session of the current user
+func (a *App) HandleLogoutAll(c *gin.Context) {
+	revoked, err := a.RevokeUserSessions(c.GetUint("user_id"), RevokedLogoutAll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Logged out of all sessions",
+		Data:    gin.H{"revoked": revoked},
+	})
+}
+
+// HandleRefreshToken exchanges a refresh token for a new token pair
 func (a *App) HandleRefreshToken(c *gin.Context) {
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Missing token"})
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
 		return
 	}
 
-	// Validate and refresh token
-	// In production, use proper JWT refresh logic
-	userID, err := a.ValidateToken(token)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
+	resp, err := a.RotateRefreshToken(req.RefreshToken, c)
+	switch {
+	case errors.Is(err, ErrAccountDeactivated):
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Account is deactivated"})
+		return
+	case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenExpired),
+		errors.Is(err, ErrRefreshTokenReused), errors.Is(err, ErrSessionRevoked):
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token", Message: err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleGetSessions lists the current user's open sessions
+func (a *App) HandleGetSessions(c *gin.Context) {
+	var sessions []AuthSession
+	if err := a.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", c.GetUint("user_id"), time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch sessions"})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == c.GetUint("session_id")
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// HandleRevokeSession revokes one of the current user's sessions
+func (a *App) HandleRevokeSession(c *gin.Context) {
+	var session AuthSession
+	if err := a.DB.Where("user_id = ?", c.GetUint("user_id")).First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Session not found"})
+		return
+	}
+	if err := a.RevokeSession(session.ID, RevokedLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Session revoked",
+	})
+}
+
+// HandleRevokeUserSessions signs a staff member out of every device
+func (a *App) HandleRevokeUserSessions(c *gin.Context) {
+	var user User
+	if err := a.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
-	// Generate new token
-	newToken, err := a.GenerateToken(userID)
+	revoked, err := a.RevokeUserSessions(user.ID, RevokedByAdmin)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to refresh token"})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "revoke_sessions", Entity: "user", EntityID: user.ID, Details: gin.H{"revoked": revoked}})
 
-	c.JSON(http.StatusOK, gin.H{"token": newToken})
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Sessions revoked",
+		Data:    gin.H{"revoked": revoked},
+	})
 }
 
 // HandleGetCurrentUser returns current logged in user
@@ -136,10 +266,16 @@ func (a *App) HandleUpdateSettings(c *gin.Context) {
 		return
 	}
 
+	var before RestaurantSettings
+	if settings.ID != 0 {
+		a.DB.First(&before, settings.ID)
+	}
+
 	if err := a.DB.Save(&settings).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update settings"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "settings_updated", Entity: "settings", EntityID: settings.ID, Before: before, After: settings})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -177,6 +313,7 @@ func (a *App) HandleCreateCategory(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create category"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "category_created", Entity: "category", EntityID: category.ID, After: category})
 
 	c.JSON(http.StatusCreated, SuccessResponse{
 		Success: true,
@@ -214,10 +351,12 @@ func (a *App) HandleUpdateCategory(c *gin.Context) {
 		return
 	}
 
+	before := category
 	if err := a.DB.Model(&category).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update category"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "category_updated", Entity: "category", EntityID: category.ID, Before: before, After: category})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -230,10 +369,17 @@ func (a *App) HandleUpdateCategory(c *gin.Context) {
 func (a *App) HandleDeleteCategory(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := a.DB.Delete(&Category{}, id).Error; err != nil {
+	var existing Category
+	if err := a.DB.First(&existing, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Category not found"})
+		return
+	}
+
+	if err := a.DB.Delete(&existing).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete category"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "category_deleted", Entity: "category", EntityID: existing.ID, Before: existing})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -270,6 +416,7 @@ func (a *App) HandleCreateMenuItem(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create menu item"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "menu_item_created", Entity: "menu_item", EntityID: item.ID, After: item})
 
 	c.JSON(http.StatusCreated, SuccessResponse{
 		Success: true,
@@ -307,10 +454,12 @@ func (a *App) HandleUpdateMenuItem(c *gin.Context) {
 		return
 	}
 
+	before := item
 	if err := a.DB.Model(&item).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update menu item"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "menu_item_updated", Entity: "menu_item", EntityID: item.ID, Before: before, After: item})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -323,10 +472,17 @@ func (a *App) HandleUpdateMenuItem(c *gin.Context) {
 func (a *App) HandleDeleteMenuItem(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := a.DB.Delete(&MenuItem{}, id).Error; err != nil {
+	var existing MenuItem
+	if err := a.DB.First(&existing, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Menu item not found"})
+		return
+	}
+
+	if err := a.DB.Delete(&existing).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete menu item"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "menu_item_deleted", Entity: "menu_item", EntityID: existing.ID, Before: existing})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -391,11 +547,13 @@ func (a *App) HandleCreateOrder(c *gin.Context) {
 		OrderNumber:    orderNumber,
 		TableID:        req.TableID,
 		UserID:         user.ID,
+		DeviceID:       deviceIDFromContext(c),
 		Type:           req.Type,
 		Priority:       req.Priority,
+		Guests:         req.Guests,
 		CustomerName:   req.CustomerName,
-		CustomerPhone:  req.CustomerPhone,
-		CustomerAddress: req.CustomerAddress,
+		CustomerPhone:  EncryptedString(req.CustomerPhone),
+		CustomerAddress: EncryptedString(req.CustomerAddress),
 		Notes:          req.Notes,
 		KitchenNotes:   req.KitchenNotes,
 		Status:         "pending",
@@ -423,41 +581,54 @@ func (a *App) HandleCreateOrder(c *gin.Context) {
 	order.ServiceCharge = serviceCharge
 	order.Total = total
 
-	// Create order in DB
-	if err := a.DB.Create(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create order"})
-		return
-	}
-
-	// Create order items
-	for _, itemReq := range req.Items {
-		var menuItem MenuItem
-		a.DB.First(&menuItem, itemReq.MenuItemID)
-
-		orderItem := OrderItem{
-			OrderID:      order.ID,
-			MenuItemID:   itemReq.MenuItemID,
-			MenuItemName: menuItem.Name,
-			Quantity:     itemReq.Quantity,
-			UnitPrice:    menuItem.Price,
-			Modifiers:    itemReq.Modifiers,
-			Notes:        itemReq.Notes,
-			Status:       "pending",
+	// Create order, items and the order.created event in one transaction
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
 		}
 
-		a.DB.Create(&orderItem)
+		// Create order items
+		for _, itemReq := range req.Items {
+			var menuItem MenuItem
+			tx.First(&menuItem, itemReq.MenuItemID)
+
+			orderItem := OrderItem{
+				OrderID:      order.ID,
+				MenuItemID:   itemReq.MenuItemID,
+				MenuItemName: menuItem.Name,
+				Quantity:     itemReq.Quantity,
+				UnitPrice:    menuItem.Price,
+				Modifiers:    itemReq.Modifiers,
+				Notes:        itemReq.Notes,
+				Status:       "pending",
+			}
+
+			if err := tx.Create(&orderItem).Error; err != nil {
+				return err
+			}
+			order.Items = append(order.Items, orderItem)
+
+			// Update item order count
+			tx.Model(&menuItem).UpdateColumn("order_count", gorm.Expr("order_count + 1"))
+		}
 
-		// Update item order count
-		a.DB.Model(&menuItem).UpdateColumn("order_count", gorm.Expr("order_count + 1"))
-	}
+		// Update table status if table is assigned
+		if req.TableID != nil {
+			if err := tx.Model(&Table{}).Where("id = ?", *req.TableID).Updates(map[string]interface{}{
+				"status":           "occupied",
+				"current_order_id": order.ID,
+			}).Error; err != nil {
+				return err
+			}
+		}
 
-	// Update table status if table is assigned
-	if req.TableID != nil {
-		a.DB.Model(&Table{}).Where("id = ?", *req.TableID).Updates(map[string]interface{}{
-			"status":           "occupied",
-			"current_order_id": order.ID,
-		})
+		return a.EmitEvent(tx, "order.created", "order", order.ID, order)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create order"})
+		return
 	}
+	a.Outbox.Wake()
 
 	c.JSON(http.StatusCreated, SuccessResponse{
 		Success: true,
@@ -489,16 +660,53 @@ func (a *App) HandleUpdateOrder(c *gin.Context) {
 		return
 	}
 
-	var updates Order
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var req UpdateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
 		return
 	}
+	updates, err := req.changes()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Nothing to update"})
+		return
+	}
+
+	// Raising the discount above the threshold needs a manager
+	var discount *OverrideAction
+	var approverID uint
+	if req.Discount != nil && *req.Discount > order.Discount &&
+		*req.Discount > order.Subtotal*float64(a.Config.Overrides.DiscountPercent)/100 {
+		discount = &OverrideAction{
+			Action:     "order.discount",
+			Permission: PermOrdersDiscount,
+			Entity:     "order",
+			EntityID:   order.ID,
+			Summary:    fmt.Sprintf("Discount of %.2f on order %s (subtotal %.2f)", *req.Discount, order.OrderNumber, order.Subtotal),
+			Details:    gin.H{"discount": *req.Discount},
+		}
+		var ok bool
+		if approverID, ok = a.RequireApproval(c, *discount); !ok {
+			return
+		}
+	}
 
+	before := order
 	if err := a.DB.Model(&order).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update order"})
 		return
 	}
+	a.Audit(c, AuditEntry{
+		Action:     "order_updated",
+		Entity:     "order",
+		EntityID:   order.ID,
+		Before:     before,
+		After:      order,
+		ApproverID: approverID,
+	})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -507,14 +715,75 @@ func (a *App) HandleUpdateOrder(c *gin.Context) {
 	})
 }
 
-// HandleDeleteOrder deletes an order
+// changes validates the request and returns the columns to update
+func (r *UpdateOrderRequest) changes() (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	if r.Type != nil {
+		if *r.Type != "dine_in" && *r.Type != "takeaway" && *r.Type != "delivery" {
+			return nil, errors.New("type must be one of dine_in, takeaway, delivery")
+		}
+		updates["type"] = *r.Type
+	}
+	if r.Priority != nil {
+		updates["priority"] = *r.Priority
+	}
+	if r.Guests != nil {
+		if *r.Guests < 0 {
+			return nil, errors.New("guests cannot be negative")
+		}
+		updates["guests"] = *r.Guests
+	}
+	if r.CustomerName != nil {
+		updates["customer_name"] = *r.CustomerName
+	}
+	if r.CustomerPhone != nil {
+		updates["customer_phone"] = EncryptedString(*r.CustomerPhone)
+	}
+	if r.CustomerAddress != nil {
+		updates["customer_address"] = EncryptedString(*r.CustomerAddress)
+	}
+	if r.Discount != nil {
+		if *r.Discount < 0 {
+			return nil, errors.New("discount cannot be negative")
+		}
+		updates["discount"] = *r.Discount
+	}
+	if r.Notes != nil {
+		updates["notes"] = *r.Notes
+	}
+	if r.KitchenNotes != nil {
+		updates["kitchen_notes"] = *r.KitchenNotes
+	}
+	return updates, nil
+}
+
+// HandleDeleteOrder deletes an order. Voiding needs orders.void or a
+// manager override.
 func (a *App) HandleDeleteOrder(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := a.DB.Delete(&Order{}, id).Error; err != nil {
+	var order Order
+	if err := a.DB.First(&order, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+		return
+	}
+	act := OverrideAction{
+		Action:     "order.void",
+		Permission: PermOrdersVoid,
+		Entity:     "order",
+		EntityID:   order.ID,
+		Summary:    fmt.Sprintf("Void order %s (total %.2f)", order.OrderNumber, order.Total),
+	}
+	approverID, ok := a.RequireApproval(c, act)
+	if !ok {
+		return
+	}
+
+	if err := a.DB.Delete(&Order{}, order.ID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete order"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: act.Action, Entity: "order", EntityID: order.ID, Before: order, ApproverID: approverID})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -540,6 +809,26 @@ func (a *App) HandleUpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	oldStatus := order.Status
+
+	// Reopening a closed order needs orders.reopen or a manager override
+	var reopen *OverrideAction
+	var approverID uint
+	if (oldStatus == "completed" || oldStatus == "cancelled") && req.Status != oldStatus {
+		reopen = &OverrideAction{
+			Action:     "order.reopen",
+			Permission: PermOrdersReopen,
+			Entity:     "order",
+			EntityID:   order.ID,
+			Summary:    fmt.Sprintf("Reopen %s order %s as %s", oldStatus, order.OrderNumber, req.Status),
+			Details:    gin.H{"status": req.Status},
+		}
+		var ok bool
+		if approverID, ok = a.RequireApproval(c, *reopen); !ok {
+			return
+		}
+	}
+
 	updates := map[string]interface{}{"status": req.Status}
 
 	// Set timestamps based on status
@@ -553,20 +842,56 @@ func (a *App) HandleUpdateOrderStatus(c *gin.Context) {
 		updates["cancelled_at"] = a.GetCurrentTime()
 	}
 
-	if err := a.DB.Model(&order).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update order status"})
-		return
-	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&order).Updates(updates).Error; err != nil {
+			return err
+		}
 
-	// Free table if order is completed or cancelled
-	if req.Status == "completed" || req.Status == "cancelled" {
-		if order.TableID != nil {
-			a.DB.Model(&Table{}).Where("id = ?", *order.TableID).Updates(map[string]interface{}{
-				"status":           "available",
-				"current_order_id": nil,
-			})
+		// Free table if order is completed or cancelled
+		if req.Status == "completed" || req.Status == "cancelled" {
+			if order.TableID != nil {
+				if err := tx.Model(&Table{}).Where("id = ?", *order.TableID).Updates(map[string]interface{}{
+					"status":           "available",
+					"current_order_id": nil,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := a.EmitEvent(tx, "order.status_changed", "order", order.ID, OrderStatusChange{
+			OrderID:   order.ID,
+			OldStatus: oldStatus,
+			NewStatus: req.Status,
+		}); err != nil {
+			return err
 		}
+
+		if req.Status == "completed" {
+			var completed Order
+			tx.Preload("Items").First(&completed, order.ID)
+			return a.EmitEvent(tx, "order.completed", "order", order.ID, completed)
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update order status"})
+		return
 	}
+	a.Outbox.Wake()
+	action := "order_status_changed"
+	if reopen != nil {
+		action = reopen.Action
+	}
+	a.Audit(c, AuditEntry{
+		Action:     action,
+		Entity:     "order",
+		EntityID:   order.ID,
+		Before:     gin.H{"status": oldStatus},
+		After:      gin.H{"status": req.Status},
+		ApproverID: approverID,
+	})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -619,30 +944,96 @@ func (a *App) HandleAddOrderItem(c *gin.Context) {
 	})
 }
 
-// HandleUpdateOrderItem updates an order item
+// HandleUpdateOrderItem updates an order item. Lowering the quantity or
+// price voids part of the item, so it needs orders.void or a manager
+// override like removing it.
 func (a *App) HandleUpdateOrderItem(c *gin.Context) {
 	id := c.Param("id")
 	itemID := c.Param("itemId")
 
-	var updates OrderItem
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var req UpdateOrderItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request"})
 		return
 	}
 
 	var orderItem OrderItem
-	if err := a.DB.Where("order_id = ? AND id = ?", id, itemID).First(&orderItem).Error; err != nil {
+	if err := a.DB.Preload("Order").Where("order_id = ? AND id = ?", id, itemID).First(&orderItem).Error; err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order item not found"})
 		return
 	}
 
+	updates := map[string]interface{}{}
+	quantity, unitPrice := orderItem.Quantity, orderItem.UnitPrice
+	if req.Quantity != nil {
+		if *req.Quantity < 1 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: "quantity must be at least 1; remove the item instead"})
+			return
+		}
+		quantity = *req.Quantity
+		updates["quantity"] = quantity
+	}
+	if req.UnitPrice != nil {
+		if *req.UnitPrice < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: "unit_price cannot be negative"})
+			return
+		}
+		unitPrice = *req.UnitPrice
+		updates["unit_price"] = unitPrice
+	}
+	if req.Modifiers != nil {
+		updates["modifiers"] = *req.Modifiers
+	}
+	if req.Notes != nil {
+		updates["notes"] = *req.Notes
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Nothing to update"})
+		return
+	}
+
+	var approverID uint
+	action := "order_item_updated"
+	if quantity < orderItem.Quantity || unitPrice < orderItem.UnitPrice {
+		orderNumber := ""
+		if orderItem.Order != nil {
+			orderNumber = orderItem.Order.OrderNumber
+		}
+		act := OverrideAction{
+			Action:     "order_item.void",
+			Permission: PermOrdersVoid,
+			Entity:     "order_item",
+			EntityID:   orderItem.ID,
+			Summary: fmt.Sprintf("Reduce %d x %s at %.2f to %d x at %.2f on order %s",
+				orderItem.Quantity, orderItem.MenuItemName, orderItem.UnitPrice, quantity, unitPrice, orderNumber),
+			Details: gin.H{"order_id": orderItem.OrderID, "quantity": quantity, "unit_price": unitPrice},
+		}
+		var ok bool
+		if approverID, ok = a.RequireApproval(c, act); !ok {
+			return
+		}
+		action = act.Action
+	}
+
+	before := orderItem
+	before.Order = nil
 	if err := a.DB.Model(&orderItem).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update order item"})
 		return
 	}
+	orderItem.Order = nil
+	a.Audit(c, AuditEntry{
+		Action:     action,
+		Entity:     "order_item",
+		EntityID:   orderItem.ID,
+		Before:     before,
+		After:      orderItem,
+		Details:    gin.H{"order_id": orderItem.OrderID},
+		ApproverID: approverID,
+	})
 
 	// Recalculate order totals
-	a.recalculateOrderTotal(id)
+	a.recalculateOrderTotal(orderItem.OrderID)
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -650,18 +1041,49 @@ func (a *App) HandleUpdateOrderItem(c *gin.Context) {
 	})
 }
 
-// HandleDeleteOrderItem removes item from order
+// HandleDeleteOrderItem removes item from order. Voiding needs orders.void
+// or a manager override.
 func (a *App) HandleDeleteOrderItem(c *gin.Context) {
 	id := c.Param("id")
 	itemID := c.Param("itemId")
 
-	if err := a.DB.Where("order_id = ? AND id = ?", id, itemID).Delete(&OrderItem{}).Error; err != nil {
+	var orderItem OrderItem
+	if err := a.DB.Preload("Order").Where("order_id = ? AND id = ?", id, itemID).First(&orderItem).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order item not found"})
+		return
+	}
+	orderNumber := ""
+	if orderItem.Order != nil {
+		orderNumber = orderItem.Order.OrderNumber
+	}
+	act := OverrideAction{
+		Action:     "order_item.void",
+		Permission: PermOrdersVoid,
+		Entity:     "order_item",
+		EntityID:   orderItem.ID,
+		Summary:    fmt.Sprintf("Void %d x %s on order %s", orderItem.Quantity, orderItem.MenuItemName, orderNumber),
+		Details:    gin.H{"order_id": orderItem.OrderID},
+	}
+	approverID, ok := a.RequireApproval(c, act)
+	if !ok {
+		return
+	}
+
+	if err := a.DB.Delete(&orderItem).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete order item"})
 		return
 	}
+	a.Audit(c, AuditEntry{
+		Action:     act.Action,
+		Entity:     "order_item",
+		EntityID:   orderItem.ID,
+		Before:     orderItem,
+		Details:    act.Details,
+		ApproverID: approverID,
+	})
 
 	// Recalculate order totals
-	a.recalculateOrderTotal(id)
+	a.recalculateOrderTotal(orderItem.OrderID)
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -697,6 +1119,7 @@ func (a *App) HandleCreateTable(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create table"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "table_created", Entity: "table", EntityID: table.ID, After: table})
 
 	c.JSON(http.StatusCreated, SuccessResponse{
 		Success: true,
@@ -734,10 +1157,12 @@ func (a *App) HandleUpdateTable(c *gin.Context) {
 		return
 	}
 
+	before := table
 	if err := a.DB.Model(&table).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update table"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "table_updated", Entity: "table", EntityID: table.ID, Before: before, After: table})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -750,10 +1175,17 @@ func (a *App) HandleUpdateTable(c *gin.Context) {
 func (a *App) HandleDeleteTable(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := a.DB.Delete(&Table{}, id).Error; err != nil {
+	var existing Table
+	if err := a.DB.First(&existing, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Table not found"})
+		return
+	}
+
+	if err := a.DB.Delete(&existing).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete table"})
 		return
 	}
+	a.Audit(c, AuditEntry{Action: "table_deleted", Entity: "table", EntityID: existing.ID, Before: existing})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -773,10 +1205,24 @@ func (a *App) HandleUpdateTableStatus(c *gin.Context) {
 		return
 	}
 
-	if err := a.DB.Model(&Table{}).Where("id = ?", id).Update("status", req.Status).Error; err != nil {
+	var table Table
+	if err := a.DB.First(&table, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Table not found"})
+		return
+	}
+
+	oldStatus := table.Status
+	if err := a.DB.Model(&table).Update("status", req.Status).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update table status"})
 		return
 	}
+	a.Audit(c, AuditEntry{
+		Action:   "table_status_changed",
+		Entity:   "table",
+		EntityID: table.ID,
+		Before:   gin.H{"status": oldStatus},
+		After:    gin.H{"status": req.Status},
+	})
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Success: true,
@@ -816,6 +1262,141 @@ func (a *App) HandleTransferTable(c *gin.Context) {
 	})
 }
 
+// ========================================
+// PAYMENTS HANDLERS
+// ========================================
+
+// HandleCreatePayment records a payment against an order
+func (a *App) HandleCreatePayment(c *gin.Context) {
+	var req PaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: "amount must be positive"})
+		return
+	}
+
+	userID := a.GetUserIDFromContext(c)
+	var user User
+	a.DB.First(&user, userID)
+
+	payment := Payment{
+		OrderID:      req.OrderID,
+		UserID:       user.ID,
+		DeviceID:     deviceIDFromContext(c),
+		Type:         "sale",
+		Method:       req.Method,
+		Amount:       req.Amount,
+		Reference:    req.Reference,
+		CashTendered: req.CashTendered,
+	}
+	if req.Method == "cash" && req.CashTendered > req.Amount {
+		payment.ChangeAmount = req.CashTendered - req.Amount
+	}
+
+	// The order is read under a row lock so concurrent payments on the same
+	// order each see the other's paid amount
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, req.OrderID).Error; err != nil {
+			return err
+		}
+
+		paid := order.PaidAmount + req.Amount
+		remaining := order.Total - paid
+		paymentStatus := "partial"
+		if remaining <= 0 {
+			remaining = 0
+			paymentStatus = "paid"
+		}
+
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&order).Updates(map[string]interface{}{
+			"paid_amount":       paid,
+			"remaining":         remaining,
+			"payment_status":    paymentStatus,
+			"payment_method":    req.Method,
+			"payment_reference": req.Reference,
+		}).Error; err != nil {
+			return err
+		}
+
+		return a.EmitEvent(tx, "payment.created", "payment", payment.ID, payment)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to record payment"})
+		return
+	}
+	a.Outbox.Wake()
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Payment recorded successfully",
+		Data:    payment,
+	})
+}
+
+// ========================================
+// INVENTORY HANDLERS
+// ========================================
+
+// HandleAddStockMovement records a stock movement and updates the item level
+func (a *App) HandleAddStockMovement(c *gin.Context) {
+	var req struct {
+		StockItemID uint    `json:"stock_item_id" binding:"required"`
+		Type        string  `json:"type" binding:"required"`
+		Quantity    float64 `json:"quantity" binding:"required"`
+		CostPerUnit float64 `json:"cost_per_unit"`
+		Reason      string  `json:"reason"`
+		Reference   string  `json:"reference"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var stockItem StockItem
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		inventory := &InventoryService{DB: tx}
+		if err := inventory.AddStockMovement(req.StockItemID, req.Type, req.Quantity, req.CostPerUnit, req.Reason, req.Reference); err != nil {
+			return err
+		}
+
+		if err := tx.First(&stockItem, req.StockItemID).Error; err != nil {
+			return err
+		}
+
+		return a.EmitEvent(tx, "stock.changed", "stock_item", stockItem.ID, gin.H{
+			"stock_item":    stockItem,
+			"movement_type": req.Type,
+			"quantity":      req.Quantity,
+			"reason":        req.Reason,
+			"reference":     req.Reference,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to add stock movement"})
+		return
+	}
+	a.Outbox.Wake()
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Stock movement recorded",
+		Data:    stockItem,
+	})
+}
+
 // ========================================
 // HELPER FUNCTIONS
 // ========================================
@@ -848,10 +1429,6 @@ func (a *App) recalculateOrderTotal(orderID uint) {
 	})
 }
 
-func (a *App) GetCurrentTimestamp() int64 {
-	return a.DB.NowFunc()().Unix()
-}
-
 func getInt(s string) int {
 	var i int
 	fmt.Sscanf(s, "%d", &i)
@@ -868,22 +1445,12 @@ func (a *App) HandleCreateCombo(c *gin.Context)           {}
 func (a *App) HandleUpdateCombo(c *gin.Context)           {}
 func (a *App) HandleDeleteCombo(c *gin.Context)           {}
 func (a *App) HandleGetPayments(c *gin.Context)           {}
-func (a *App) HandleCreatePayment(c *gin.Context)         {}
 func (a *App) HandleRefundPayment(c *gin.Context)          {}
-func (a *App) HandleDailyReport(c *gin.Context)            {}
-func (a *App) HandleWeeklyReport(c *gin.Context)           {}
-func (a *App) HandleMonthlyReport(c *gin.Context)          {}
-func (a *App) HandleItemsReport(c *gin.Context)            {}
-func (a *App) HandleCategoriesReport(c *gin.Context)        {}
-func (a *App) HandlePaymentsReport(c *gin.Context)         {}
-func (a *App) HandleStaffReport(c *gin.Context)            {}
-func (a *App) HandleExportReport(c *gin.Context)            {}
 func (a *App) HandleGetStockItems(c *gin.Context)          {}
 func (a *App) HandleCreateStockItem(c *gin.Context)        {}
 func (a *App) HandleUpdateStockItem(c *gin.Context)        {}
 func (a *App) HandleDeleteStockItem(c *gin.Context)        {}
 func (a *App) HandleGetStockMovements(c *gin.Context)       {}
-func (a *App) HandleAddStockMovement(c *gin.Context)        {}
 func (a *App) HandleGetLowStockAlerts(c *gin.Context)     {}
 func (a *App) HandleGetStaff(c *gin.Context)               {}
 func (a *App) HandleCreateStaff(c *gin.Context)            {}
@@ -911,23 +1478,3 @@ func (a *App) HandleUpdateDiscount(c *gin.Context)          {}
 func (a *App) HandleDeleteDiscount(c *gin.Context)          {}
 func (a *App) HandleActivateDiscount(c *gin.Context)         {}
 func (a *App) HandleDeactivateDiscount(c *gin.Context)       {}
-func (a *App) HandleGetPrinters(c *gin.Context)            {}
-func (a *App) HandleCreatePrinter(c *gin.Context)            {}
-func (a *App) HandleUpdatePrinter(c *gin.Context)            {}
-func (a *App) HandleDeletePrinter(c *gin.Context)            {}
-func (a *App) HandleTestPrinter(c *gin.Context)             {}
-func (a *App) HandleGetReceiptTemplates(c *gin.Context)     {}
-func (a *App) HandleCreateReceiptTemplate(c *gin.Context)    {}
-func (a *App) HandleUpdateReceiptTemplate(c *gin.Context)    {}
-func (a *App) HandleDeleteReceiptTemplate(c *gin.Context)    {}
-func (a *App) HandleSetDefaultReceiptTemplate(c *gin.Context) {}
-func (a *App) HandlePrintReceipt(c *gin.Context)             {}
-func (a *App) HandlePrintKitchen(c *gin.Context)            {}
-func (a *App) HandlePrintBar(c *gin.Context)                {}
-func (a *App) HandleSendWhatsAppReceipt(c *gin.Context)       {}
-func (a *App) HandleSendWhatsAppDailyReport(c *gin.Context) {}
-func (a *App) HandleTestWhatsApp(c *gin.Context)             {}
-func (a *App) HandleGetDashboard(c *gin.Context)             {}
-func (a *App) HandleGetDashboardStats(c *gin.Context)        {}
-func (a *App) HandleGetRecentOrders(c *gin.Context)          {}
-func (a *App) HandleGetLowStockDashboard(c *gin.Context)     {}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// LOGIN PROTECTION
+// ========================================
+//
+// Failed password sign-ins are counted per account. After
+// Auth.LoginDelayAfter failures each further attempt has to wait, starting
+// at a second and doubling up to a minute; reaching Auth.LoginMaxAttempts
+// locks the account for Auth.LoginLockout. Attempts made while waiting or
+// locked are refused without checking the password, so they can't be used
+// to keep guessing. The sign-in endpoints are also rate limited per IP.
+//
+// Accounts created with known credentials (the seeded admin) must change
+// their password before they can do anything else.
+
+// Seeded credentials
+const (
+	DefaultAdminEmail    = "admin@restaurant.com"
+	DefaultAdminPassword = "admin123"
+)
+
+// maxLoginDelay caps the wait between failed attempts
+const maxLoginDelay = time.Minute
+
+// dummyPasswordHash is checked against when the email is unknown, so the
+// answer takes as long as for a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Login errors
+var (
+	ErrLoginLocked            = errors.New("too many failed sign-in attempts, account temporarily locked")
+	ErrLoginThrottled         = errors.New("too many failed sign-in attempts, wait before trying again")
+	ErrPasswordChangeRequired = errors.New("password change required")
+)
+
+// passwordChangeRoutes are the routes a user who must change their password
+// can still reach
+var passwordChangeRoutes = map[string]bool{
+	"/api/auth/me":          true,
+	"/api/auth/password":    true,
+	"/api/auth/permissions": true,
+	"/api/auth/logout-all":  true,
+}
+
+// loginDelay returns how long to wait after failures failed attempts
+func (a *App) loginDelay(failures int) time.Duration {
+	over := failures - a.Config.Auth.LoginDelayAfter
+	if over < 0 {
+		return 0
+	}
+	if over > 6 {
+		return maxLoginDelay
+	}
+	delay := time.Second << uint(over)
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+// checkLoginAllowed refuses attempts on locked accounts and attempts made
+// before the delay since the last failure has passed, returning how long
+// to wait
+func (a *App) checkLoginAllowed(user *User, now time.Time) (time.Duration, error) {
+	if user.LoginLockedUntil != nil && now.Before(*user.LoginLockedUntil) {
+		return user.LoginLockedUntil.Sub(now), ErrLoginLocked
+	}
+	if user.LastFailedLoginAt != nil {
+		if wait := a.loginDelay(user.FailedLoginAttempts) - now.Sub(*user.LastFailedLoginAt); wait > 0 {
+			return wait, ErrLoginThrottled
+		}
+	}
+	return 0, nil
+}
+
+// recordLoginFailure counts a failed attempt and reports whether it locked
+// the account. The count is incremented in the database and read back, so
+// concurrent failures are all counted toward the lockout.
+func (a *App) recordLoginFailure(user *User, now time.Time) bool {
+	a.DB.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"failed_login_attempts": gorm.Expr("failed_login_attempts + 1"),
+		"last_failed_login_at":  now,
+	})
+	var current User
+	if err := a.DB.Select("failed_login_attempts").First(&current, user.ID).Error; err == nil {
+		user.FailedLoginAttempts = current.FailedLoginAttempts
+	} else {
+		user.FailedLoginAttempts++
+	}
+	user.LastFailedLoginAt = &now
+
+	if user.FailedLoginAttempts < a.Config.Auth.LoginMaxAttempts {
+		return false
+	}
+	until := now.Add(a.Config.Auth.LoginLockout)
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LoginLockedUntil = &until
+	a.DB.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"login_locked_until":    until,
+	})
+	return true
+}
+
+// recordLoginSuccess clears the failure count and stamps the sign-in
+func (a *App) recordLoginSuccess(user *User, now time.Time) {
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LoginLockedUntil = nil
+	user.LastLogin = &now
+	a.DB.Model(user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"login_locked_until":    nil,
+		"last_login":            now,
+	})
+}
+
+// respondLoginFailure records a failed credential check, locking the
+// account once the limit is reached, and answers it
+func (a *App) respondLoginFailure(c *gin.Context, user *User, now time.Time, action string) {
+	if a.recordLoginFailure(user, now) {
+		a.Audit(c, AuditEntry{Action: "login_locked", Entity: "user", EntityID: user.ID, UserID: user.ID})
+		respondLoginRefused(c, a.Config.Auth.LoginLockout, ErrLoginLocked)
+		return
+	}
+	a.Audit(c, AuditEntry{
+		Action:   action,
+		Entity:   "user",
+		EntityID: user.ID,
+		UserID:   user.ID,
+		Details:  gin.H{"attempts": user.FailedLoginAttempts},
+	})
+	c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+}
+
+// respondLoginRefused answers a throttled or locked attempt
+func respondLoginRefused(c *gin.Context, wait time.Duration, err error) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Too many attempts", Message: err.Error()})
+}
+
+// ValidateNewPassword checks a password a user is about to set
+func (a *App) ValidateNewPassword(user *User, password string) error {
+	if !ValidatePassword(password) {
+		return errors.New("password must be at least 8 characters")
+	}
+	if strings.EqualFold(password, user.Email) || password == DefaultAdminPassword {
+		return errors.New("password is too easy to guess")
+	}
+	if CheckPassword(password, user.Password) {
+		return errors.New("new password must differ from the current one")
+	}
+	return nil
+}
+
+// FlagSeededCredentials makes accounts still using the seeded password
+// change it at their next sign-in
+func (a *App) FlagSeededCredentials() {
+	var admin User
+	if a.DB.Where("email = ? AND must_change_password = ?", DefaultAdminEmail, false).
+		First(&admin).Error != nil {
+		return
+	}
+	if CheckPassword(DefaultAdminPassword, admin.Password) {
+		a.DB.Model(&admin).Update("must_change_password", true)
+		fmt.Printf("⚠️  %s still uses the default password; it must be changed at next sign-in\n", DefaultAdminEmail)
+	}
+}
+
+// HandleChangePassword changes the current user's password, ends their
+// other sessions and clears a forced password change
+func (a *App) HandleChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var user User
+	if err := a.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if !CheckPassword(req.CurrentPassword, user.Password) {
+		a.Audit(c, AuditEntry{Action: "password_change_failed", Entity: "user", EntityID: user.ID})
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	}
+	if err := a.ValidateNewPassword(&user, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid password", Message: err.Error()})
+		return
+	}
+
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to change password"})
+		return
+	}
+	now := time.Now()
+	if err := a.DB.Model(&user).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": false,
+		"password_changed_at":  now,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to change password"})
+		return
+	}
+
+	// Keep this session, end the others
+	result := a.DB.Model(&AuthSession{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, c.GetUint("session_id")).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": RevokedPasswordChanged})
+	a.Audit(c, AuditEntry{
+		Action:   "password_changed",
+		Entity:   "user",
+		EntityID: user.ID,
+		Details:  gin.H{"revoked_sessions": result.RowsAffected},
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Password changed",
+	})
+}
+
+// HandleUnlockUser clears a staff member's password and PIN lockouts
+func (a *App) HandleUnlockUser(c *gin.Context) {
+	var user User
+	if err := a.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+
+	if err := a.DB.Model(&user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"login_locked_until":    nil,
+		"failed_pin_attempts":   0,
+		"pin_locked_until":      nil,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to unlock user"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "user_unlocked", Entity: "user", EntityID: user.ID})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "User unlocked",
+	})
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// EMAIL MESSAGES
+// ========================================
+
+// Email queue statuses
+const (
+	EmailPending = "pending"
+	EmailSending = "sending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Email is a message to encode and queue. Text and HTML are sent as
+// multipart/alternative when both are set.
+type Email struct {
+	FromName    string
+	From        string
+	To          []string
+	ReplyTo     string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []EmailAttachment
+}
+
+// EmailAttachment is a file attached to an email
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Encode renders the email as an RFC 5322 message with MIME parts.
+// Non-ASCII headers use RFC 2047 encoded words and attachment names use
+// RFC 2231 parameters, so Arabic subjects and file names survive transit.
+func (e *Email) Encode() ([]byte, error) {
+	if e.From == "" {
+		return nil, errors.New("email has no sender")
+	}
+	if len(e.To) == 0 {
+		return nil, errors.New("email has no recipients")
+	}
+	for _, addr := range append([]string{e.From, e.ReplyTo}, e.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return nil, fmt.Errorf("email address %q contains a line break", addr)
+		}
+	}
+
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		msg.WriteString(name + ": " + value + "\r\n")
+	}
+
+	from := mail.Address{Name: stripLineBreaks(e.FromName), Address: e.From}
+	header("From", from.String())
+	to := make([]string, len(e.To))
+	for i, addr := range e.To {
+		to[i] = (&mail.Address{Address: addr}).String()
+	}
+	header("To", strings.Join(to, ", "))
+	if e.ReplyTo != "" {
+		header("Reply-To", (&mail.Address{Address: e.ReplyTo}).String())
+	}
+	header("Subject", encodeHeaderWords(e.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", newMessageID(e.From))
+	header("MIME-Version", "1.0")
+
+	bodyHeader, body, err := e.body()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Attachments) == 0 {
+		for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if value := bodyHeader.Get(name); value != "" {
+				header(name, value)
+			}
+		}
+		msg.WriteString("\r\n")
+		msg.Write(body)
+		return msg.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&msg)
+	header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	msg.WriteString("\r\n")
+
+	part, err := mixed.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(body)
+
+	for _, attachment := range e.Attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = http.DetectContentType(attachment.Data)
+		}
+		filename := stripLineBreaks(attachment.Filename)
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", mime.FormatMediaType(stripLineBreaks(contentType), map[string]string{"name": filename}))
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		h.Set("Content-Transfer-Encoding", "base64")
+		part, err := mixed.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, attachment.Data)
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// body returns the headers and encoded content of the text and HTML parts
+func (e *Email) body() (textproto.MIMEHeader, []byte, error) {
+	var content bytes.Buffer
+	h := textproto.MIMEHeader{}
+
+	if e.Text != "" && e.HTML != "" {
+		alt := multipart.NewWriter(&content)
+		h.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alt.Boundary()}))
+		for _, p := range []struct{ contentType, content string }{
+			{"text/plain", e.Text},
+			{"text/html", e.HTML},
+		} {
+			ph := textproto.MIMEHeader{}
+			ph.Set("Content-Type", p.contentType+"; charset=utf-8")
+			ph.Set("Content-Transfer-Encoding", "quoted-printable")
+			part, err := alt.CreatePart(ph)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := writeQuotedPrintable(part, p.content); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := alt.Close(); err != nil {
+			return nil, nil, err
+		}
+		return h, content.Bytes(), nil
+	}
+
+	contentType, text := "text/plain", e.Text
+	if e.HTML != "" {
+		contentType, text = "text/html", e.HTML
+	}
+	h.Set("Content-Type", contentType+"; charset=utf-8")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	if err := writeQuotedPrintable(&content, text); err != nil {
+		return nil, nil, err
+	}
+	return h, content.Bytes(), nil
+}
+
+// encodeHeaderWords encodes a header value as RFC 2047 words when it isn't
+// plain ASCII, folding between words to keep lines short. Line breaks in
+// the value become spaces so it can't start another header.
+func encodeHeaderWords(value string) string {
+	encoded := mime.BEncoding.Encode("utf-8", stripLineBreaks(value))
+	return strings.ReplaceAll(encoded, "?= =?", "?=\r\n =?")
+}
+
+// stripLineBreaks replaces CR and LF with spaces in text going into a
+// header
+func stripLineBreaks(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// writeQuotedPrintable writes content with CRLF line endings as
+// quoted-printable
+func writeQuotedPrintable(w io.Writer, content string) error {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\n", "\r\n")
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64Lines writes data as base64 wrapped at 76 characters
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain
+func newMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
+
+// ========================================
+// SMTP CLIENT
+// ========================================
+
+// SMTP connection security
+const (
+	SMTPSecurityNone     = "none"     // plain connection
+	SMTPSecuritySTARTTLS = "starttls" // upgrade with STARTTLS, required
+	SMTPSecurityTLS      = "tls"      // implicit TLS, usually port 465
+)
+
+// SMTPClient sends encoded messages to an SMTP server
+type SMTPClient struct {
+	Host      string
+	Port      int
+	Username  string
+	Password  string
+	Security  string
+	HelloName string
+	Timeout   time.Duration
+}
+
+// Send delivers one message over a new connection
+func (c *SMTPClient) Send(from string, to []string, message []byte) error {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	tlsConfig := &tls.Config{ServerName: c.Host}
+
+	var conn net.Conn
+	var err error
+	if c.Security == SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * c.Timeout))
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	hello := c.HelloName
+	if hello == "" {
+		hello, _ = os.Hostname()
+	}
+	if hello != "" {
+		if err := client.Hello(hello); err != nil {
+			return err
+		}
+	}
+
+	if c.Security == SMTPSecuritySTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if c.Username != "" {
+		ok, mechanisms := client.Extension("AUTH")
+		if !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+		if err := client.Auth(c.auth(mechanisms)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// auth picks PLAIN when offered, then LOGIN, then CRAM-MD5
+func (c *SMTPClient) auth(mechanisms string) smtp.Auth {
+	offered := strings.Fields(strings.ToUpper(mechanisms))
+	has := func(name string) bool {
+		for _, m := range offered {
+			if m == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("PLAIN"):
+		return smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	case has("LOGIN"):
+		return &loginAuth{username: c.Username, password: c.Password, host: c.Host}
+	default:
+		return smtp.CRAMMD5Auth(c.Username, c.Password)
+	}
+}
+
+// loginAuth implements AUTH LOGIN, which some providers offer instead of
+// PLAIN. Like PlainAuth it refuses to send credentials without TLS except
+// to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	local := server.Name == "localhost" || server.Name == "127.0.0.1" || server.Name == "::1"
+	if !server.TLS && !local {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+// ========================================
+// EMAIL QUEUE
+// ========================================
+
+// EmailQueue stores encoded messages and sends them in the background,
+// retrying temporary failures with backoff. Messages are encoded when
+// queued, so retries resend exactly what was accepted.
+type EmailQueue struct {
+	DB           *gorm.DB
+	Client       *SMTPClient
+	From         string
+	FromName     string
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+
+	wake chan struct{}
+}
+
+// NewEmailQueue creates the mail queue from the email config
+func NewEmailQueue(db *gorm.DB, config *Config) *EmailQueue {
+	client := &SMTPClient{
+		Host:     config.Email.SMTPHost,
+		Port:     config.Email.SMTPPort,
+		Username: config.Email.SMTPUser,
+		Password: config.Email.SMTPPassword,
+		Security: config.Email.Security,
+		Timeout:  30 * time.Second,
+	}
+	return &EmailQueue{
+		DB:           db,
+		Client:       client,
+		From:         config.Email.SMTPFrom,
+		FromName:     config.Email.FromName,
+		PollInterval: 10 * time.Second,
+		BatchSize:    20,
+		MaxAttempts:  6,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue encodes the email and queues it for delivery. The queue's sender
+// is used when the email has none.
+func (q *EmailQueue) Enqueue(email *Email) (*EmailMessage, error) {
+	if email.From == "" {
+		email.From, email.FromName = q.From, q.FromName
+	}
+	for _, addr := range email.To {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid recipient %q", addr)
+		}
+	}
+
+	data, err := email.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	message := EmailMessage{
+		Sender:        email.From,
+		Recipients:    strings.Join(email.To, ","),
+		Subject:       TruncateString(email.Subject, 500),
+		Message:       string(data),
+		Size:          len(data),
+		Status:        EmailPending,
+		MaxAttempts:   q.MaxAttempts,
+		NextAttemptAt: time.Now(),
+	}
+	if err := q.DB.Create(&message).Error; err != nil {
+		return nil, err
+	}
+
+	q.Wake()
+	return &message, nil
+}
+
+// Wake asks the worker to poll now
+func (q *EmailQueue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due messages until the process exits
+func (q *EmailQueue) Run() {
+	// Messages left mid-send by a crash are sent again
+	q.DB.Model(&EmailMessage{}).Where("status = ?", EmailSending).Update("status", EmailPending)
+
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		var due []EmailMessage
+		if err := q.DB.Where("status = ? AND next_attempt_at <= ?", EmailPending, time.Now()).
+			Order("id ASC").
+			Limit(q.BatchSize).
+			Find(&due).Error; err != nil {
+			log.Printf("Email queue poll failed: %v", err)
+		}
+
+		for i := range due {
+			claim := q.DB.Model(&EmailMessage{}).
+				Where("id = ? AND status = ?", due[i].ID, EmailPending).
+				Update("status", EmailSending)
+			if claim.Error != nil || claim.RowsAffected == 0 {
+				continue
+			}
+			q.Attempt(&due[i])
+		}
+
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// Attempt sends one message and records the outcome. Permanent SMTP
+// errors (5xx) fail the message right away; anything else is retried.
+func (q *EmailQueue) Attempt(message *EmailMessage) {
+	err := q.Client.Send(message.Sender, strings.Split(message.Recipients, ","), []byte(message.Message))
+	message.Attempts++
+
+	updates := map[string]interface{}{"attempts": message.Attempts}
+	if err == nil {
+		now := time.Now()
+		message.Status = EmailSent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	} else {
+		message.LastError = err.Error()
+		updates["last_error"] = message.LastError
+
+		var smtpErr *textproto.Error
+		permanent := errors.As(err, &smtpErr) && smtpErr.Code >= 500
+		if permanent || message.Attempts >= message.MaxAttempts {
+			message.Status = EmailFailed
+		} else {
+			message.Status = EmailPending
+			updates["next_attempt_at"] = time.Now().Add(retryBackoff(message.Attempts))
+		}
+	}
+	updates["status"] = message.Status
+
+	q.DB.Model(&EmailMessage{}).Where("id = ?", message.ID).Updates(updates)
+}
+
+// ========================================
+// EMAIL HANDLERS
+// ========================================
+
+// HandleGetEmailQueue lists queued and sent emails, newest first
+func (a *App) HandleGetEmailQueue(c *gin.Context) {
+	page := getInt(c.DefaultQuery("page", "1"))
+	limit := getInt(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := a.DB.Model(&EmailMessage{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var messages []EmailMessage
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch emails"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"emails": messages,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+// HandleRetryEmail requeues a failed email
+func (a *App) HandleRetryEmail(c *gin.Context) {
+	var message EmailMessage
+	if err := a.DB.First(&message, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Email not found"})
+		return
+	}
+	if message.Status != EmailFailed {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Only failed emails can be retried"})
+		return
+	}
+
+	a.DB.Model(&message).Updates(map[string]interface{}{
+		"status":          EmailPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	a.Mailer.Wake()
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Email queued for delivery",
+	})
+}
+
+// HandleSendTestEmail queues a short message to check the SMTP settings
+func (a *App) HandleSendTestEmail(c *gin.Context) {
+	var req struct {
+		To string `json:"to" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if !a.Config.Email.Enabled {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Email is disabled"})
+		return
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+
+	message, err := a.Mailer.Enqueue(&Email{
+		To:      []string{req.To},
+		Subject: fmt.Sprintf("%s - رسالة تجريبية / Test email", settings.Name),
+		Text:    "Email delivery is working.\r\nتم إعداد البريد الإلكتروني بنجاح.",
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue email", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Test email queued",
+		Data:    message,
+	})
+}
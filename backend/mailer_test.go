@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendTestEmail encodes email and delivers it to a local SMTP listener
+func sendTestEmail(t *testing.T, email *Email) ReceivedEmail {
+	t.Helper()
+	server, err := StartMockSMTPServer()
+	if err != nil {
+		t.Fatalf("starting SMTP server: %v", err)
+	}
+	defer server.Close()
+
+	message, err := email.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	client := &SMTPClient{
+		Host:      server.Host(),
+		Port:      server.Port(),
+		Username:  "pos@example.com",
+		Password:  "secret",
+		Security:  SMTPSecurityNone,
+		HelloName: "pos.test",
+		Timeout:   5 * time.Second,
+	}
+	if err := client.Send(email.From, email.To, message); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	received := server.Messages()
+	if len(received) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(received))
+	}
+	return received[0]
+}
+
+// mimePart is a decoded part of a multipart body
+type mimePart struct {
+	header   textproto.MIMEHeader
+	filename string
+	body     []byte
+}
+
+// readMIMEParts reads the parts of a multipart body in order. Quoted-printable
+// is decoded by the reader; base64 is decoded here.
+func readMIMEParts(t *testing.T, contentType string, body io.Reader) []mimePart {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		t.Fatalf("content type %q is not multipart: %v", contentType, err)
+	}
+	var parts []mimePart
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		var content io.Reader = part
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			content = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatalf("reading part body: %v", err)
+		}
+		parts = append(parts, mimePart{header: part.Header, filename: part.FileName(), body: data})
+	}
+}
+
+func TestEmailDeliveredWithAttachment(t *testing.T) {
+	pdf := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte{0xFF, 0x00, 0x7F}, 200)...)
+	email := &Email{
+		FromName: "مطعم الاختبار",
+		From:     "pos@example.com",
+		To:       []string{"customer@example.com"},
+		Subject:  "إيصال الطلب ORD-1",
+		Text:     "شكرا لزيارتكم\nTotal: 120.00",
+		HTML:     "<p>شكرا لزيارتكم</p>",
+		Attachments: []EmailAttachment{
+			{Filename: "إيصال-ORD-1.pdf", ContentType: "application/pdf", Data: pdf},
+		},
+	}
+	received := sendTestEmail(t, email)
+
+	if received.From != "pos@example.com" || len(received.To) != 1 || received.To[0] != "customer@example.com" {
+		t.Errorf("envelope = %s -> %v", received.From, received.To)
+	}
+	if received.User != "pos@example.com" {
+		t.Errorf("authenticated as %q", received.User)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(received.Data))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	decoder := new(mime.WordDecoder)
+	if subject, err := decoder.DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != email.Subject {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+	if from, err := msg.Header.AddressList("From"); err != nil || from[0].Name != email.FromName {
+		t.Errorf("from = %v, %v", from, err)
+	}
+
+	parts := readMIMEParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	if len(parts) != 2 {
+		t.Fatalf("got %d top-level parts, want body and attachment", len(parts))
+	}
+
+	alternatives := readMIMEParts(t, parts[0].header.Get("Content-Type"), bytes.NewReader(parts[0].body))
+	if len(alternatives) != 2 {
+		t.Fatalf("got %d alternatives, want text and HTML", len(alternatives))
+	}
+	if text := alternatives[0]; !strings.HasPrefix(text.header.Get("Content-Type"), "text/plain") || string(text.body) != "شكرا لزيارتكم\r\nTotal: 120.00" {
+		t.Errorf("text part %q: %q", text.header.Get("Content-Type"), text.body)
+	}
+	if html := alternatives[1]; !strings.HasPrefix(html.header.Get("Content-Type"), "text/html") || string(html.body) != email.HTML {
+		t.Errorf("html part %q: %q", html.header.Get("Content-Type"), html.body)
+	}
+
+	attachment := parts[1]
+	if attachment.filename != "إيصال-ORD-1.pdf" {
+		t.Errorf("attachment name = %q", attachment.filename)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(attachment.header.Get("Content-Type")); mediaType != "application/pdf" {
+		t.Errorf("attachment type = %q", mediaType)
+	}
+	if !bytes.Equal(attachment.body, pdf) {
+		t.Errorf("attachment changed in transit: %d bytes, want %d", len(attachment.body), len(pdf))
+	}
+}
+
+func TestEmailDotStuffing(t *testing.T) {
+	email := &Email{
+		From:    "pos@example.com",
+		To:      []string{"customer@example.com"},
+		Subject: "Dots",
+		Text:    "line one\n.\n..leading dots",
+	}
+	received := sendTestEmail(t, email)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(received.Data))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	// DATA ends the message with a line break of its own
+	body, _ := io.ReadAll(msg.Body)
+	if string(body) != "line one\r\n.\r\n..leading dots\r\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestEmailHeaderInjection(t *testing.T) {
+	email := &Email{
+		FromName: "POS\r\nBcc: victim@example.com",
+		From:     "pos@example.com",
+		To:       []string{"customer@example.com"},
+		Subject:  "Receipt\r\nBcc: victim@example.com\nX-Injected: 1",
+		Text:     "hello",
+		Attachments: []EmailAttachment{
+			{Filename: "a.pdf\r\nX-Injected: 1", ContentType: "application/pdf\r\nX-Injected: 1", Data: []byte("%PDF")},
+		},
+	}
+	message, err := email.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	for _, line := range strings.Split(string(message), "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(string(message), "\r\n", ""), "\n") || strings.Contains(strings.ReplaceAll(string(message), "\r\n", ""), "\r") {
+		t.Error("bare CR or LF in message")
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	if subject := msg.Header.Get("Subject"); subject != "Receipt  Bcc: victim@example.com X-Injected: 1" {
+		t.Errorf("subject = %q", subject)
+	}
+
+	for _, bad := range []*Email{
+		{From: "pos@example.com\r\nBcc: victim@example.com", To: []string{"a@example.com"}},
+		{From: "pos@example.com", To: []string{"a@example.com\nBcc: victim@example.com"}},
+		{From: "pos@example.com", To: []string{"a@example.com"}, ReplyTo: "r@example.com\r\nX-Injected: 1"},
+	} {
+		if _, err := bad.Encode(); err == nil {
+			t.Errorf("address with a line break accepted: %+v", bad)
+		}
+	}
+}
+
+func TestSMTPRejectedRecipient(t *testing.T) {
+	server, err := StartMockSMTPServer()
+	if err != nil {
+		t.Fatalf("starting SMTP server: %v", err)
+	}
+	defer server.Close()
+	server.RejectRecipients["gone@example.com"] = true
+
+	email := &Email{From: "pos@example.com", To: []string{"gone@example.com"}, Subject: "x", Text: "x"}
+	message, _ := email.Encode()
+	client := &SMTPClient{Host: server.Host(), Port: server.Port(), Security: SMTPSecurityNone, Timeout: 5 * time.Second}
+
+	if err := client.Send(email.From, email.To, message); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("send to rejected recipient: %v", err)
+	}
+	if n := len(server.Messages()); n != 0 {
+		t.Errorf("server accepted %d messages", n)
+	}
+}
@@ -1,20 +1,16 @@
 package main
 
 import (
-	"context"
-	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	_ "github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -26,7 +22,16 @@ type App struct {
 	Config              *Config
 	WSManager           *WebSocketManager
 	NotificationService *NotificationService
-	Runtime             *wails.Runtime
+	Outbox              *OutboxDispatcher
+	Webhooks            *WebhookService
+	PrintQueue          *PrintQueue
+	ETA                 *ETAService
+	Mailer              *EmailQueue
+	Messaging           *MessagingGateway
+	Scheduler           *Scheduler
+	Permissions         *PermissionCache
+	AuditTrail          *AuditTrail
+	RateLimits          RateLimitStore
 }
 
 // Config structure
@@ -39,12 +44,14 @@ type Config struct {
 		Name     string
 	}
 	Server struct {
-		Port int
-		Host string
+		Port           int
+		Host           string
+		TrustedProxies []string // proxies whose X-Forwarded-For is believed; none by default
 	}
 	JWT struct {
-		Secret     string
-		Expiration time.Duration
+		Secret            string
+		Expiration        time.Duration // access token lifetime
+		RefreshExpiration time.Duration // refresh token lifetime, renewed on every rotation
 	}
 	WhatsApp struct {
 		APIURL  string
@@ -58,6 +65,101 @@ type Config struct {
 		SMTPPassword string
 		SMTPFrom     string
 		Enabled       bool
+		FromName     string
+		Security     string // "starttls", "tls" or "none"
+	}
+	Documents struct {
+		PublicURL    string
+		FontPath     string
+		BoldFontPath string
+		SigningKey   string
+		LinkTTL      time.Duration
+	}
+	ETA struct {
+		Enabled          bool
+		IdentityURL      string
+		APIURL           string
+		PortalURL        string
+		ClientID         string
+		ClientSecret     string
+		POSSerial        string
+		POSOSVersion     string
+		PreSharedKey     string
+		SignerURL        string
+		RIN              string
+		CompanyTradeName string
+		BranchCode       string
+		ActivityCode     string
+		Governate        string
+		RegionCity       string
+		Street           string
+		BuildingNumber   string
+		DefaultItemType  string
+		DefaultItemCode  string
+	}
+	Encryption struct {
+		Keys          string // "id:base64key,..."; the first encrypts, the rest only decrypt
+		BlindIndexKey string
+	}
+	Messaging struct {
+		WhatsAppProvider   string // "cloud", "twilio" or "generic"
+		SMSProvider        string
+		SMSEnabled         bool
+		CloudBaseURL       string
+		CloudAPIVersion    string
+		CloudPhoneNumberID string
+		CloudAccessToken   string
+		CloudAppSecret     string
+		CloudVerifyToken   string
+		TwilioAccountSID   string
+		TwilioAuthToken    string
+		TwilioWhatsAppFrom string
+		TwilioSMSFrom      string
+		GenericURL         string
+		GenericAPIKey      string
+		GenericSecret      string
+		RatePerSecond      int
+		PerRecipientHourly int
+		CountryCode        string
+		DocumentLinkTTL    time.Duration
+	}
+	Scheduler struct {
+		Enabled       bool
+		BackupDir     string
+		ManagerPhones []string
+	}
+	Forecast struct {
+		Dayparts     string // "name=start-end,..." in whole hours
+		HistoryWeeks int
+	}
+	Auth struct {
+		PINMinLength   int
+		PINMaxAttempts int           // failed PIN attempts before lockout
+		PINLockout     time.Duration // how long a locked account or device stays locked
+
+		LoginMaxAttempts int           // failed password attempts before lockout
+		LoginDelayAfter  int           // failed attempts before each retry has to wait
+		LoginLockout     time.Duration // how long a locked account stays locked
+
+		TOTPIssuer        string   // shown in authenticator apps
+		TOTPRequiredRoles []string // roles that must use 2FA
+	}
+	WebSocket struct {
+		AllowedOrigins []string // browser origins besides the server's own that may connect
+	}
+	Overrides struct {
+		TTL             time.Duration // how long a manager has to decide, and the requester to use the approval
+		DiscountPercent int           // discounts above this share of the subtotal need approval
+	}
+	RateLimit struct {
+		Enabled       bool
+		Backend       string // "memory" or "database"
+		IPPerMinute   int    // all requests from one IP
+		IPBurst       int
+		UserPerMinute int // API requests of one signed-in user
+		UserBurst     int
+		AuthPerMinute int // sign-in and refresh attempts from one IP
+		AuthBurst     int
 	}
 }
 
@@ -80,18 +182,21 @@ func LoadConfig() *Config {
 			Name:     getEnv("DB_NAME", "restaurant_pos"),
 		},
 		Server: struct {
-			Port int
-			Host string
+			Port           int
+			Host           string
+			TrustedProxies []string
 		}{
 			Port: getEnvInt("SERVER_PORT", 8080),
 			Host: getEnv("SERVER_HOST", "0.0.0.0"),
 		},
 		JWT: struct {
-			Secret     string
-			Expiration time.Duration
+			Secret            string
+			Expiration        time.Duration
+			RefreshExpiration time.Duration
 		}{
-			Secret:     getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-			Expiration: time.Hour * 24,
+			Secret:            getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+			Expiration:        time.Duration(getEnvInt("JWT_ACCESS_TTL_MINUTES", 15)) * time.Minute,
+			RefreshExpiration: time.Duration(getEnvInt("JWT_REFRESH_TTL_HOURS", 24*30)) * time.Hour,
 		},
 		WhatsApp: struct {
 			APIURL  string
@@ -109,6 +214,8 @@ func LoadConfig() *Config {
 			SMTPPassword string
 			SMTPFrom     string
 			Enabled       bool
+			FromName     string
+			Security     string
 		}{
 			SMTPHost:     getEnv("SMTP_HOST", ""),
 			SMTPPort:     getEnvInt("SMTP_PORT", 587),
@@ -119,9 +226,122 @@ func LoadConfig() *Config {
 		},
 	}
 
+	defaultSecurity := SMTPSecuritySTARTTLS
+	if config.Email.SMTPPort == 465 {
+		defaultSecurity = SMTPSecurityTLS
+	}
+	config.Email.FromName = getEnv("SMTP_FROM_NAME", "")
+	config.Email.Security = getEnv("SMTP_SECURITY", defaultSecurity)
+
+	config.Documents.PublicURL = getEnv("PUBLIC_URL", "")
+	config.Documents.FontPath = getEnv("PDF_FONT", "")
+	config.Documents.BoldFontPath = getEnv("PDF_FONT_BOLD", "")
+	config.Documents.SigningKey = getEnv("DOCUMENT_SIGNING_KEY", "")
+	config.Documents.LinkTTL = time.Duration(getEnvInt("DOCUMENT_LINK_TTL_MINUTES", 15)) * time.Minute
+
+	config.ETA.Enabled = getEnv("ETA_ENABLED", "false") == "true"
+	config.ETA.IdentityURL = getEnv("ETA_IDENTITY_URL", "https://id.preprod.eta.gov.eg")
+	config.ETA.APIURL = getEnv("ETA_API_URL", "https://api.preprod.invoicing.eta.gov.eg")
+	config.ETA.PortalURL = getEnv("ETA_PORTAL_URL", "https://preprod.invoicing.eta.gov.eg")
+	config.ETA.ClientID = getEnv("ETA_CLIENT_ID", "")
+	config.ETA.ClientSecret = getEnv("ETA_CLIENT_SECRET", "")
+	config.ETA.POSSerial = getEnv("ETA_POS_SERIAL", "")
+	config.ETA.POSOSVersion = getEnv("ETA_POS_OS_VERSION", "windows")
+	config.ETA.PreSharedKey = getEnv("ETA_PRESHARED_KEY", "")
+	config.ETA.SignerURL = getEnv("ETA_SIGNER_URL", "")
+	config.ETA.RIN = getEnv("ETA_RIN", "")
+	config.ETA.CompanyTradeName = getEnv("ETA_COMPANY_TRADE_NAME", "")
+	config.ETA.BranchCode = getEnv("ETA_BRANCH_CODE", "0")
+	config.ETA.ActivityCode = getEnv("ETA_ACTIVITY_CODE", "")
+	config.ETA.Governate = getEnv("ETA_GOVERNATE", "")
+	config.ETA.RegionCity = getEnv("ETA_REGION_CITY", "")
+	config.ETA.Street = getEnv("ETA_STREET", "")
+	config.ETA.BuildingNumber = getEnv("ETA_BUILDING_NUMBER", "")
+	config.ETA.DefaultItemType = getEnv("ETA_DEFAULT_ITEM_TYPE", "EGS")
+	config.ETA.DefaultItemCode = getEnv("ETA_DEFAULT_ITEM_CODE", "")
+
+	// WHATSAPP_API_URL predates provider selection and implies the generic adapter
+	defaultProvider := "cloud"
+	if config.WhatsApp.APIURL != "" {
+		defaultProvider = "generic"
+	}
+	config.Messaging.WhatsAppProvider = getEnv("WHATSAPP_PROVIDER", defaultProvider)
+	config.Messaging.SMSProvider = getEnv("SMS_PROVIDER", "twilio")
+	config.Messaging.SMSEnabled = getEnv("SMS_ENABLED", "false") == "true"
+	config.Messaging.CloudBaseURL = getEnv("WHATSAPP_CLOUD_URL", "https://graph.facebook.com")
+	config.Messaging.CloudAPIVersion = getEnv("WHATSAPP_CLOUD_API_VERSION", "v19.0")
+	config.Messaging.CloudPhoneNumberID = getEnv("WHATSAPP_PHONE_NUMBER_ID", "")
+	config.Messaging.CloudAccessToken = getEnv("WHATSAPP_ACCESS_TOKEN", "")
+	config.Messaging.CloudAppSecret = getEnv("WHATSAPP_APP_SECRET", "")
+	config.Messaging.CloudVerifyToken = getEnv("WHATSAPP_VERIFY_TOKEN", "")
+	config.Messaging.TwilioAccountSID = getEnv("TWILIO_ACCOUNT_SID", "")
+	config.Messaging.TwilioAuthToken = getEnv("TWILIO_AUTH_TOKEN", "")
+	config.Messaging.TwilioWhatsAppFrom = getEnv("TWILIO_WHATSAPP_FROM", "")
+	config.Messaging.TwilioSMSFrom = getEnv("TWILIO_SMS_FROM", "")
+	config.Messaging.GenericURL = getEnv("MESSAGING_API_URL", config.WhatsApp.APIURL)
+	config.Messaging.GenericAPIKey = getEnv("MESSAGING_API_KEY", config.WhatsApp.APIKey)
+	config.Messaging.GenericSecret = getEnv("MESSAGING_CALLBACK_SECRET", "")
+	config.Messaging.RatePerSecond = getEnvInt("MESSAGING_RATE_PER_SECOND", 10)
+	config.Messaging.PerRecipientHourly = getEnvInt("MESSAGING_PER_RECIPIENT_HOURLY", 10)
+	config.Messaging.CountryCode = getEnv("PHONE_COUNTRY_CODE", "20")
+	config.Messaging.DocumentLinkTTL = time.Duration(getEnvInt("MESSAGING_LINK_TTL_HOURS", 72)) * time.Hour
+
+	config.Encryption.Keys = getEnv("ENCRYPTION_KEYS", "")
+	config.Encryption.BlindIndexKey = getEnv("BLIND_INDEX_KEY", "")
+
+	config.Scheduler.Enabled = getEnv("SCHEDULER_ENABLED", "true") == "true"
+	config.Scheduler.BackupDir = getEnv("BACKUP_DIR", "")
+	for _, phone := range strings.Split(getEnv("MANAGER_PHONES", ""), ",") {
+		if phone = strings.TrimSpace(phone); phone != "" {
+			config.Scheduler.ManagerPhones = append(config.Scheduler.ManagerPhones, phone)
+		}
+	}
+
+	config.Forecast.Dayparts = getEnv("FORECAST_DAYPARTS", DefaultDayparts)
+	config.Forecast.HistoryWeeks = getEnvInt("FORECAST_HISTORY_WEEKS", 8)
+
+	config.Auth.PINMinLength = getEnvInt("PIN_MIN_LENGTH", 4)
+	config.Auth.PINMaxAttempts = getEnvInt("PIN_MAX_ATTEMPTS", 5)
+	config.Auth.PINLockout = time.Duration(getEnvInt("PIN_LOCKOUT_MINUTES", 15)) * time.Minute
+	config.Auth.LoginMaxAttempts = getEnvInt("LOGIN_MAX_ATTEMPTS", 10)
+	config.Auth.LoginDelayAfter = getEnvInt("LOGIN_DELAY_AFTER", 3)
+	config.Auth.LoginLockout = time.Duration(getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute
+	config.Auth.TOTPIssuer = getEnv("TOTP_ISSUER", "Restaurant POS")
+	for _, role := range strings.Split(getEnv("TOTP_REQUIRED_ROLES", ""), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			config.Auth.TOTPRequiredRoles = append(config.Auth.TOTPRequiredRoles, role)
+		}
+	}
+
+	config.Server.TrustedProxies = getEnvList("TRUSTED_PROXIES")
+	config.WebSocket.AllowedOrigins = getEnvList("WS_ALLOWED_ORIGINS")
+
+	config.Overrides.TTL = time.Duration(getEnvInt("OVERRIDE_TTL_MINUTES", 5)) * time.Minute
+	config.Overrides.DiscountPercent = getEnvInt("OVERRIDE_DISCOUNT_PERCENT", 10)
+
+	config.RateLimit.Enabled = getEnv("RATE_LIMIT_ENABLED", "true") == "true"
+	config.RateLimit.Backend = getEnv("RATE_LIMIT_BACKEND", "memory")
+	config.RateLimit.IPPerMinute = getEnvInt("RATE_LIMIT_IP_PER_MINUTE", 600)
+	config.RateLimit.IPBurst = getEnvInt("RATE_LIMIT_IP_BURST", 100)
+	config.RateLimit.UserPerMinute = getEnvInt("RATE_LIMIT_USER_PER_MINUTE", 300)
+	config.RateLimit.UserBurst = getEnvInt("RATE_LIMIT_USER_BURST", 60)
+	config.RateLimit.AuthPerMinute = getEnvInt("RATE_LIMIT_AUTH_PER_MINUTE", 10)
+	config.RateLimit.AuthBurst = getEnvInt("RATE_LIMIT_AUTH_BURST", 5)
+
 	return config
 }
 
+// getEnvList reads a comma-separated list, skipping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -194,6 +414,12 @@ func (a *App) AutoMigrate() error {
 
 	err := a.DB.AutoMigrate(
 		&User{},
+		&Role{},
+		&Permission{},
+		&AuthSession{},
+		&RefreshToken{},
+		&Device{},
+		&ManagerOverride{},
 		&RestaurantSettings{},
 		&Category{},
 		&MenuItem{},
@@ -207,6 +433,8 @@ func (a *App) AutoMigrate() error {
 		&Payment{},
 		&StockItem{},
 		&StockMovement{},
+		&RecipeLine{},
+		&ForecastOverride{},
 		&Shift{},
 		&Customer{},
 		&LoyaltyTransaction{},
@@ -215,6 +443,22 @@ func (a *App) AutoMigrate() error {
 		&Printer{},
 		&DailyReport{},
 		&AuditLog{},
+		&AuditChainHead{},
+		&RateLimitBucket{},
+		&RecoveryCode{},
+		&IntegrationCredential{},
+		&APIKey{},
+		&WSEvent{},
+		&OutboxEvent{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&PrintJob{},
+		&ETAReceipt{},
+		&EmailMessage{},
+		&MessageTemplate{},
+		&MessageLog{},
+		&ScheduledJob{},
+		&JobRun{},
 	)
 
 	if err != nil {
@@ -227,29 +471,74 @@ func (a *App) AutoMigrate() error {
 
 // SetupRoutes configures all routes
 func (a *App) SetupRoutes() {
+	// Client IPs feed rate limits, lockouts and the audit log, so
+	// X-Forwarded-For is only believed from configured proxies
+	if err := a.Server.SetTrustedProxies(a.Config.Server.TrustedProxies); err != nil {
+		log.Printf("⚠️  Ignoring TRUSTED_PROXIES: %v", err)
+		a.Server.SetTrustedProxies(nil)
+	}
+	a.Server.Use(a.RequestIDMiddleware(), a.RateLimitMiddleware(), a.LoggingMiddleware())
+	signIn := a.RateLimitGroup("auth", RateLimit{PerMinute: a.Config.RateLimit.AuthPerMinute, Burst: a.Config.RateLimit.AuthBurst})
+	setPIN := a.RateLimitGroup("pin", RateLimit{PerMinute: a.Config.RateLimit.AuthPerMinute, Burst: a.Config.RateLimit.AuthBurst})
+
 	api := a.Server.Group("/api")
 	{
 		// WebSocket endpoint
-		a.Server.GET("/api/ws", a.WSManager.HandleWebSocket)
+		a.Server.GET("/api/ws", a.WSManager.HandleWebSocket())
 
 		// Authentication
 		auth := api.Group("/auth")
 		{
-			auth.POST("/login", a.HandleLogin)
+			auth.POST("/login", signIn, a.HandleLogin)
 			auth.POST("/logout", a.HandleLogout)
-			auth.POST("/refresh", a.HandleRefreshToken)
+			auth.POST("/refresh", signIn, a.HandleRefreshToken)
+			auth.PUT("/password", a.AuthMiddleware(), a.HandleChangePassword)
+
+			// Two-factor authentication
+			auth.GET("/2fa", a.AuthMiddleware(), a.HandleGetTwoFactor)
+			auth.POST("/2fa/setup", a.AuthMiddleware(), a.HandleSetupTwoFactor)
+			auth.POST("/2fa/enable", a.AuthMiddleware(), a.HandleEnableTwoFactor)
+			auth.POST("/2fa/disable", a.AuthMiddleware(), a.HandleDisableTwoFactor)
+			auth.POST("/2fa/recovery-codes", a.AuthMiddleware(), a.HandleRegenerateRecoveryCodes)
 			auth.GET("/me", a.AuthMiddleware(), a.HandleGetCurrentUser)
+			auth.POST("/logout-all", a.AuthMiddleware(), a.HandleLogoutAll)
+			auth.GET("/sessions", a.AuthMiddleware(), a.HandleGetSessions)
+			auth.DELETE("/sessions/:id", a.AuthMiddleware(), a.HandleRevokeSession)
+
+			// Shared terminals, authenticated by the X-Device-Token header
+			auth.GET("/pin/users", a.HandleGetPINUsers)
+			auth.POST("/pin-login", signIn, a.HandlePINLogin)
+			auth.PUT("/pin", a.AuthMiddleware(), setPIN, a.HandleSetOwnPIN)
+			auth.GET("/permissions", a.AuthMiddleware(), a.HandleGetMyPermissions)
 		}
 
+		// Device enrollment, before the device has a token, and its own
+		// configuration after
+		api.POST("/devices/pair", signIn, a.HandlePairDevice)
+		api.GET("/devices/self", a.HandleGetOwnDevice)
+
+		// Signed document links for customers and messaging providers
+		api.GET("/public/documents/:kind/:id", a.HandleGetPublicDocument)
+
+		// Delivery receipts and replies from messaging providers
+		api.GET("/public/messaging/callback/:provider", a.HandleVerifyMessagingCallback)
+		api.POST("/public/messaging/callback/:provider", a.HandleMessagingCallback)
+
 		// Protected routes
 		protected := api.Group("")
-		protected.Use(a.AuthMiddleware())
+		protected.Use(a.AuthMiddleware(), a.RateLimitGroup("api", RateLimit{
+			PerMinute: a.Config.RateLimit.UserPerMinute,
+			Burst:     a.Config.RateLimit.UserBurst,
+		}))
+		// Reads every terminal needs (settings, menu, tables, reservations,
+		// discounts) only require sign-in; everything else names a permission
+		can := a.RequirePermission
 		{
 			// Settings
 			settings := protected.Group("/settings")
 			{
 				settings.GET("", a.HandleGetSettings)
-				settings.PUT("", a.HandleUpdateSettings)
+				settings.PUT("", can(PermSettingsEdit), a.HandleUpdateSettings)
 			}
 
 			// Menu
@@ -258,74 +547,78 @@ func (a *App) SetupRoutes() {
 				categories := menu.Group("/categories")
 				{
 					categories.GET("", a.HandleGetCategories)
-					categories.POST("", a.HandleCreateCategory)
+					categories.POST("", can(PermMenuEdit), a.HandleCreateCategory)
 					categories.GET("/:id", a.HandleGetCategory)
-					categories.PUT("/:id", a.HandleUpdateCategory)
-					categories.DELETE("/:id", a.HandleDeleteCategory)
+					categories.PUT("/:id", can(PermMenuEdit), a.HandleUpdateCategory)
+					categories.DELETE("/:id", can(PermMenuEdit), a.HandleDeleteCategory)
 				}
 
 				items := menu.Group("/items")
 				{
 					items.GET("", a.HandleGetMenuItems)
-					items.POST("", a.HandleCreateMenuItem)
+					items.POST("", can(PermMenuEdit), a.HandleCreateMenuItem)
 					items.GET("/:id", a.HandleGetMenuItem)
-					items.PUT("/:id", a.HandleUpdateMenuItem)
-					items.DELETE("/:id", a.HandleDeleteMenuItem)
+					items.PUT("/:id", can(PermMenuEdit), a.HandleUpdateMenuItem)
+					items.DELETE("/:id", can(PermMenuEdit), a.HandleDeleteMenuItem)
+					items.GET("/:id/recipe", can(PermMenuEdit), a.HandleGetRecipe)
+					items.PUT("/:id/recipe", can(PermMenuEdit), a.HandleUpdateRecipe)
 				}
 
 				modifiers := menu.Group("/modifiers")
 				{
 					modifiers.GET("", a.HandleGetModifiers)
-					modifiers.POST("", a.HandleCreateModifier)
-					modifiers.PUT("/:id", a.HandleUpdateModifier)
-					modifiers.DELETE("/:id", a.HandleDeleteModifier)
+					modifiers.POST("", can(PermMenuEdit), a.HandleCreateModifier)
+					modifiers.PUT("/:id", can(PermMenuEdit), a.HandleUpdateModifier)
+					modifiers.DELETE("/:id", can(PermMenuEdit), a.HandleDeleteModifier)
 				}
 
 				combos := menu.Group("/combos")
 				{
 					combos.GET("", a.HandleGetCombos)
-					combos.POST("", a.HandleCreateCombo)
-					combos.PUT("/:id", a.HandleUpdateCombo)
-					combos.DELETE("/:id", a.HandleDeleteCombo)
+					combos.POST("", can(PermMenuEdit), a.HandleCreateCombo)
+					combos.PUT("/:id", can(PermMenuEdit), a.HandleUpdateCombo)
+					combos.DELETE("/:id", can(PermMenuEdit), a.HandleDeleteCombo)
 				}
 			}
 
 			// Orders
 			orders := protected.Group("/orders")
 			{
-				orders.GET("", a.HandleGetOrders)
-				orders.POST("", a.HandleCreateOrder)
-				orders.GET("/:id", a.HandleGetOrder)
-				orders.PUT("/:id", a.HandleUpdateOrder)
-				orders.DELETE("/:id", a.HandleDeleteOrder)
-				orders.PUT("/:id/status", a.HandleUpdateOrderStatus)
-				orders.POST("/:id/items", a.HandleAddOrderItem)
-				orders.PUT("/:id/items/:itemId", a.HandleUpdateOrderItem)
-				orders.DELETE("/:id/items/:itemId", a.HandleDeleteOrderItem)
+				orders.GET("", can(PermOrdersView), a.HandleGetOrders)
+				orders.POST("", can(PermOrdersCreate), a.HandleCreateOrder)
+				orders.GET("/:id", can(PermOrdersView), a.HandleGetOrder)
+				orders.PUT("/:id", can(PermOrdersEdit), a.HandleUpdateOrder)
+				orders.DELETE("/:id", can(PermOrdersEdit), a.HandleDeleteOrder)
+				orders.PUT("/:id/status", can(PermOrdersStatus), a.HandleUpdateOrderStatus)
+				orders.POST("/:id/items", can(PermOrdersEdit), a.HandleAddOrderItem)
+				orders.PUT("/:id/items/:itemId", can(PermOrdersEdit), a.HandleUpdateOrderItem)
+				orders.DELETE("/:id/items/:itemId", can(PermOrdersEdit), a.HandleDeleteOrderItem)
+				orders.GET("/:id/documents/:kind", can(PermOrdersView), a.HandleGetOrderDocument)
+				orders.POST("/:id/documents/:kind/link", can(PermMessagingSend), a.HandleCreateDocumentLink)
 			}
 
 			// Tables
 			tables := protected.Group("/tables")
 			{
 				tables.GET("", a.HandleGetTables)
-				tables.POST("", a.HandleCreateTable)
+				tables.POST("", can(PermTablesManage), a.HandleCreateTable)
 				tables.GET("/:id", a.HandleGetTable)
-				tables.PUT("/:id", a.HandleUpdateTable)
-				tables.DELETE("/:id", a.HandleDeleteTable)
-				tables.PUT("/:id/status", a.HandleUpdateTableStatus)
-				tables.PUT("/:id/transfer", a.HandleTransferTable)
+				tables.PUT("/:id", can(PermTablesManage), a.HandleUpdateTable)
+				tables.DELETE("/:id", can(PermTablesManage), a.HandleDeleteTable)
+				tables.PUT("/:id/status", can(PermTablesEdit), a.HandleUpdateTableStatus)
+				tables.PUT("/:id/transfer", can(PermTablesEdit), a.HandleTransferTable)
 			}
 
 			// Payments
 			payments := protected.Group("/payments")
 			{
-				payments.GET("", a.HandleGetPayments)
-				payments.POST("", a.HandleCreatePayment)
-				payments.POST("/refund", a.HandleRefundPayment)
+				payments.GET("", can(PermPaymentsView), a.HandleGetPayments)
+				payments.POST("", can(PermPaymentsCreate), a.HandleCreatePayment)
+				payments.POST("/refund", can(PermPaymentsRefund), a.HandleRefundPayment)
 			}
 
 			// Reports
-			reports := protected.Group("/reports")
+			reports := protected.Group("/reports", can(PermReportsView))
 			{
 				reports.GET("/daily", a.HandleDailyReport)
 				reports.GET("/weekly", a.HandleWeeklyReport)
@@ -335,109 +628,257 @@ func (a *App) SetupRoutes() {
 				reports.GET("/payments", a.HandlePaymentsReport)
 				reports.GET("/staff", a.HandleStaffReport)
 				reports.GET("/export", a.HandleExportReport)
+				reports.GET("/menu-engineering", a.HandleMenuEngineeringReport)
+				reports.GET("/menu-engineering/trend", a.HandleMenuEngineeringTrend)
 			}
 
 			// Inventory
 			inventory := protected.Group("/inventory")
 			{
-				inventory.GET("/items", a.HandleGetStockItems)
-				inventory.POST("/items", a.HandleCreateStockItem)
-				inventory.PUT("/items/:id", a.HandleUpdateStockItem)
-				inventory.DELETE("/items/:id", a.HandleDeleteStockItem)
-				inventory.GET("/movements", a.HandleGetStockMovements)
-				inventory.POST("/movements", a.HandleAddStockMovement)
-				inventory.GET("/alerts", a.HandleGetLowStockAlerts)
+				inventory.GET("/items", can(PermInventoryView), a.HandleGetStockItems)
+				inventory.POST("/items", can(PermInventoryEdit), a.HandleCreateStockItem)
+				inventory.PUT("/items/:id", can(PermInventoryEdit), a.HandleUpdateStockItem)
+				inventory.DELETE("/items/:id", can(PermInventoryEdit), a.HandleDeleteStockItem)
+				inventory.GET("/movements", can(PermInventoryView), a.HandleGetStockMovements)
+				inventory.POST("/movements", can(PermInventoryEdit), a.HandleAddStockMovement)
+				inventory.GET("/alerts", can(PermInventoryView), a.HandleGetLowStockAlerts)
+				inventory.GET("/reorder-suggestions", can(PermInventoryView), a.HandleGetReorderSuggestions)
 			}
 
 			// Staff
 			staff := protected.Group("/staff")
 			{
-				staff.GET("", a.HandleGetStaff)
-				staff.POST("", a.HandleCreateStaff)
-				staff.PUT("/:id", a.HandleUpdateStaff)
-				staff.DELETE("/:id", a.HandleDeleteStaff)
-				staff.POST("/:id/shift/start", a.HandleStartShift)
-				staff.POST("/:id/shift/end", a.HandleEndShift)
-				staff.GET("/shifts", a.HandleGetShifts)
+				staff.GET("", can(PermStaffView), a.HandleGetStaff)
+				staff.POST("", can(PermStaffManage), a.HandleCreateStaff)
+				staff.PUT("/:id", can(PermStaffManage), a.HandleUpdateStaff)
+				staff.DELETE("/:id", can(PermStaffManage), a.HandleDeleteStaff)
+				staff.POST("/:id/shift/start", can(PermStaffManage), a.HandleStartShift)
+				staff.POST("/:id/shift/end", can(PermStaffManage), a.HandleEndShift)
+				staff.GET("/shifts", can(PermStaffView), a.HandleGetShifts)
+				staff.POST("/:id/revoke-sessions", can(PermStaffManage), a.HandleRevokeUserSessions)
+				staff.POST("/:id/unlock", can(PermStaffManage), a.HandleUnlockUser)
+				staff.POST("/:id/reset-2fa", can(PermStaffManage), a.HandleResetTwoFactor)
+				staff.PUT("/:id/pin", can(PermStaffManage), setPIN, a.HandleSetUserPIN)
 			}
 
 			// Customers
 			customers := protected.Group("/customers")
 			{
-				customers.GET("", a.HandleGetCustomers)
-				customers.POST("", a.HandleCreateCustomer)
-				customers.GET("/:id", a.HandleGetCustomer)
-				customers.PUT("/:id", a.HandleUpdateCustomer)
-				customers.DELETE("/:id", a.HandleDeleteCustomer)
-				customers.POST("/:id/points", a.HandleAddLoyaltyPoints)
-				customers.GET("/:id/history", a.HandleGetCustomerHistory)
+				customers.GET("", can(PermCustomersView), a.HandleGetCustomers)
+				customers.POST("", can(PermCustomersEdit), a.HandleCreateCustomer)
+				customers.GET("/:id", can(PermCustomersView), a.HandleGetCustomer)
+				customers.PUT("/:id", can(PermCustomersEdit), a.HandleUpdateCustomer)
+				customers.DELETE("/:id", can(PermCustomersEdit), a.HandleDeleteCustomer)
+				customers.POST("/:id/points", can(PermCustomersEdit), a.HandleAddLoyaltyPoints)
+				customers.GET("/:id/history", can(PermCustomersView), a.HandleGetCustomerHistory)
 			}
 
 			// Reservations
 			reservations := protected.Group("/reservations")
 			{
 				reservations.GET("", a.HandleGetReservations)
-				reservations.POST("", a.HandleCreateReservation)
+				reservations.POST("", can(PermReservationsManage), a.HandleCreateReservation)
 				reservations.GET("/:id", a.HandleGetReservation)
-				reservations.PUT("/:id", a.HandleUpdateReservation)
-				reservations.DELETE("/:id", a.HandleDeleteReservation)
-				reservations.PUT("/:id/status", a.HandleUpdateReservationStatus)
+				reservations.PUT("/:id", can(PermReservationsManage), a.HandleUpdateReservation)
+				reservations.DELETE("/:id", can(PermReservationsManage), a.HandleDeleteReservation)
+				reservations.PUT("/:id/status", can(PermReservationsManage), a.HandleUpdateReservationStatus)
 			}
 
 			// Discounts
 			discounts := protected.Group("/discounts")
 			{
 				discounts.GET("", a.HandleGetDiscounts)
-				discounts.POST("", a.HandleCreateDiscount)
-				discounts.PUT("/:id", a.HandleUpdateDiscount)
-				discounts.DELETE("/:id", a.HandleDeleteDiscount)
-				discounts.POST("/:id/activate", a.HandleActivateDiscount)
-				discounts.POST("/:id/deactivate", a.HandleDeactivateDiscount)
+				discounts.POST("", can(PermDiscountsManage), a.HandleCreateDiscount)
+				discounts.PUT("/:id", can(PermDiscountsManage), a.HandleUpdateDiscount)
+				discounts.DELETE("/:id", can(PermDiscountsManage), a.HandleDeleteDiscount)
+				discounts.POST("/:id/activate", can(PermDiscountsManage), a.HandleActivateDiscount)
+				discounts.POST("/:id/deactivate", can(PermDiscountsManage), a.HandleDeactivateDiscount)
 			}
 
 			// Printers
 			printers := protected.Group("/printers")
 			{
-				printers.GET("", a.HandleGetPrinters)
-				printers.POST("", a.HandleCreatePrinter)
-				printers.PUT("/:id", a.HandleUpdatePrinter)
-				printers.DELETE("/:id", a.HandleDeletePrinter)
-				printers.POST("/:id/test", a.HandleTestPrinter)
+				printers.GET("", can(PermPrintUse), a.HandleGetPrinters)
+				printers.POST("", can(PermPrintersManage), a.HandleCreatePrinter)
+				printers.PUT("/:id", can(PermPrintersManage), a.HandleUpdatePrinter)
+				printers.DELETE("/:id", can(PermPrintersManage), a.HandleDeletePrinter)
+				printers.POST("/:id/test", can(PermPrintersManage), a.HandleTestPrinter)
 			}
 
 			// Receipt Templates
 			templates := protected.Group("/receipt-templates")
 			{
-				templates.GET("", a.HandleGetReceiptTemplates)
-				templates.POST("", a.HandleCreateReceiptTemplate)
-				templates.PUT("/:id", a.HandleUpdateReceiptTemplate)
-				templates.DELETE("/:id", a.HandleDeleteReceiptTemplate)
-				templates.POST("/:id/set-default", a.HandleSetDefaultReceiptTemplate)
+				templates.GET("", can(PermPrintersManage), a.HandleGetReceiptTemplates)
+				templates.POST("", can(PermPrintersManage), a.HandleCreateReceiptTemplate)
+				templates.PUT("/:id", can(PermPrintersManage), a.HandleUpdateReceiptTemplate)
+				templates.DELETE("/:id", can(PermPrintersManage), a.HandleDeleteReceiptTemplate)
+				templates.POST("/:id/set-default", can(PermPrintersManage), a.HandleSetDefaultReceiptTemplate)
+				templates.POST("/preview", can(PermPrintersManage), a.HandlePreviewReceiptTemplate)
+				templates.GET("/:id/preview", can(PermPrintersManage), a.HandlePreviewReceiptTemplate)
 			}
 
 			// Print Actions
 			print := protected.Group("/print")
 			{
-				print.POST("/receipt/:orderId", a.HandlePrintReceipt)
-				print.POST("/kitchen/:orderId", a.HandlePrintKitchen)
-				print.POST("/bar/:orderId", a.HandlePrintBar)
+				print.POST("/receipt/:orderId", can(PermPrintUse), a.HandlePrintReceipt)
+				print.POST("/kitchen/:orderId", can(PermPrintUse), a.HandlePrintKitchen)
+				print.POST("/bar/:orderId", can(PermPrintUse), a.HandlePrintBar)
+				print.POST("/drawer", can(PermPrintUse), a.HandleOpenDrawer)
+				print.GET("/jobs", can(PermPrintUse), a.HandleGetPrintJobs)
+				print.GET("/jobs/:id", can(PermPrintUse), a.HandleGetPrintJob)
+				print.POST("/jobs/:id/reprint", can(PermPrintUse), a.HandleReprintJob)
+				print.POST("/jobs/:id/cancel", can(PermPrintUse), a.HandleCancelPrintJob)
 			}
 
 			// WhatsApp
 			whatsapp := protected.Group("/whatsapp")
 			{
-				whatsapp.POST("/receipt/:orderId", a.HandleSendWhatsAppReceipt)
-				whatsapp.POST("/daily-report", a.HandleSendWhatsAppDailyReport)
-				whatsapp.POST("/test", a.HandleTestWhatsApp)
+				whatsapp.POST("/receipt/:orderId", can(PermMessagingSend), a.HandleSendWhatsAppReceipt)
+				whatsapp.POST("/daily-report", can(PermReportsView), a.HandleSendWhatsAppDailyReport)
+				whatsapp.POST("/test", can(PermMessagingManage), a.HandleTestWhatsApp)
+			}
+
+			// Messaging
+			messaging := protected.Group("/messaging")
+			{
+				messaging.GET("/logs", can(PermMessagingManage), a.HandleGetMessageLogs)
+				messaging.GET("/templates", can(PermMessagingManage), a.HandleGetMessageTemplates)
+				messaging.POST("/templates", can(PermMessagingManage), a.HandleCreateMessageTemplate)
+				messaging.PUT("/templates/:id", can(PermMessagingManage), a.HandleUpdateMessageTemplate)
+				messaging.DELETE("/templates/:id", can(PermMessagingManage), a.HandleDeleteMessageTemplate)
+				messaging.POST("/opt-out", can(PermMessagingManage), a.HandleSetMessagingOptOut)
+			}
+
+			// Forecasting
+			forecast := protected.Group("/forecast")
+			{
+				forecast.GET("", can(PermForecastView), a.HandleGetForecast)
+				forecast.GET("/prep-list", can(PermForecastView), a.HandleGetPrepList)
+				forecast.GET("/overrides", can(PermForecastView), a.HandleGetForecastOverrides)
+				forecast.POST("/overrides", can(PermForecastEdit), a.HandleCreateForecastOverride)
+				forecast.PUT("/overrides/:id", can(PermForecastEdit), a.HandleUpdateForecastOverride)
+				forecast.DELETE("/overrides/:id", can(PermForecastEdit), a.HandleDeleteForecastOverride)
+			}
+
+			// Devices
+			devices := protected.Group("/devices", can(PermDevicesManage))
+			{
+				devices.GET("", a.HandleGetDevices)
+				devices.POST("", a.HandleCreateDevice)
+				devices.GET("/:id", a.HandleGetDevice)
+				devices.PUT("/:id", a.HandleUpdateDevice)
+				devices.DELETE("/:id", a.HandleRevokeDevice)
+				devices.POST("/:id/pairing-code", a.HandleCreatePairingCode)
+			}
+
+			// Roles and permissions
+			roles := protected.Group("/roles", can(PermRolesManage))
+			{
+				roles.GET("", a.HandleGetRoles)
+				roles.POST("", a.HandleCreateRole)
+				roles.PUT("/:id", a.HandleUpdateRole)
+				roles.DELETE("/:id", a.HandleDeleteRole)
+			}
+			protected.GET("/permissions", can(PermRolesManage), a.HandleGetPermissions)
+
+			// Audit trail
+			audit := protected.Group("/audit-logs", can(PermAuditView))
+			{
+				audit.GET("", a.HandleGetAuditLogs)
+				audit.GET("/verify", a.HandleVerifyAuditLogs)
+				audit.GET("/:id", a.HandleGetAuditLog)
+			}
+
+			// Manager overrides; approval is checked against the action's
+			// permission in the handlers
+			overrides := protected.Group("/overrides")
+			{
+				overrides.GET("", a.HandleGetOverrides)
+				overrides.GET("/:id", a.HandleGetOverride)
+				overrides.POST("/:id/approve", a.HandleApproveOverride)
+				overrides.POST("/:id/deny", a.HandleDenyOverride)
+				overrides.POST("/:id/approve-pin", a.HandleApproveOverridePIN)
+			}
+
+			// Scheduler
+			scheduler := protected.Group("/scheduler")
+			{
+				scheduler.GET("/jobs", can(PermSystemManage), a.HandleGetScheduledJobs)
+				scheduler.PUT("/jobs/:id", can(PermSystemManage), a.HandleUpdateScheduledJob)
+				scheduler.POST("/jobs/:id/run", can(PermSystemManage), a.HandleRunScheduledJob)
+				scheduler.GET("/runs", can(PermSystemManage), a.HandleGetJobRuns)
+			}
+
+			// Event replay
+			protected.GET("/events", a.HandleGetEvents)
+
+			// API keys for integrations and machine clients
+			apiKeys := protected.Group("/api-keys")
+			{
+				apiKeys.GET("", can(PermAPIKeysManage), a.HandleGetAPIKeys)
+				apiKeys.GET("/scopes", can(PermAPIKeysManage), a.HandleGetAPIKeyScopes)
+				apiKeys.POST("", can(PermAPIKeysManage), a.HandleCreateAPIKey)
+				apiKeys.PUT("/:id", can(PermAPIKeysManage), a.HandleUpdateAPIKey)
+				apiKeys.POST("/:id/rotate", can(PermAPIKeysManage), a.HandleRotateAPIKey)
+				apiKeys.DELETE("/:id", can(PermAPIKeysManage), a.HandleRevokeAPIKey)
+			}
+
+			// Stored provider credentials
+			credentials := protected.Group("/integrations/credentials")
+			{
+				credentials.GET("", can(PermIntegrationsManage), a.HandleGetCredentials)
+				credentials.PUT("/:name", can(PermIntegrationsManage), a.HandleSetCredential)
+				credentials.DELETE("/:name", can(PermIntegrationsManage), a.HandleDeleteCredential)
+			}
+
+			// Field encryption
+			protected.POST("/encryption/rotate", can(PermSystemManage), a.HandleRotateEncryption)
+
+			// Outbox
+			outbox := protected.Group("/outbox")
+			{
+				outbox.GET("", can(PermIntegrationsManage), a.HandleGetOutboxEvents)
+				outbox.GET("/stats", can(PermIntegrationsManage), a.HandleGetOutboxStats)
+				outbox.POST("/:id/retry", can(PermIntegrationsManage), a.HandleRetryOutboxEvent)
+			}
+
+			// Webhooks
+			webhooks := protected.Group("/webhooks")
+			{
+				webhooks.GET("", can(PermIntegrationsManage), a.HandleGetWebhooks)
+				webhooks.POST("", can(PermIntegrationsManage), a.HandleCreateWebhook)
+				webhooks.PUT("/:id", can(PermIntegrationsManage), a.HandleUpdateWebhook)
+				webhooks.DELETE("/:id", can(PermIntegrationsManage), a.HandleDeleteWebhook)
+				webhooks.POST("/:id/test", can(PermIntegrationsManage), a.HandleTestWebhook)
+				webhooks.POST("/:id/rotate-secret", can(PermIntegrationsManage), a.HandleRotateWebhookSecret)
+				webhooks.GET("/:id/deliveries", can(PermIntegrationsManage), a.HandleGetWebhookDeliveries)
+				webhooks.POST("/:id/deliveries/:deliveryId/redeliver", can(PermIntegrationsManage), a.HandleRedeliverWebhook)
+			}
+
+			// Outgoing email
+			email := protected.Group("/email")
+			{
+				email.GET("/queue", can(PermIntegrationsManage), a.HandleGetEmailQueue)
+				email.POST("/queue/:id/retry", can(PermIntegrationsManage), a.HandleRetryEmail)
+				email.POST("/test", can(PermIntegrationsManage), a.HandleSendTestEmail)
+			}
+
+			// Tax authority e-receipts
+			eta := protected.Group("/eta")
+			{
+				eta.GET("/receipts", can(PermIntegrationsManage), a.HandleGetETAReceipts)
+				eta.GET("/receipts/:id", can(PermIntegrationsManage), a.HandleGetETAReceipt)
+				eta.POST("/receipts/:id/retry", can(PermIntegrationsManage), a.HandleRetryETAReceipt)
+				eta.POST("/orders/:orderId/issue", can(PermPaymentsCreate), a.HandleIssueETAReceipt)
 			}
 
 			// Dashboard
 			dashboard := protected.Group("/dashboard")
 			{
-				dashboard.GET("", a.HandleGetDashboard)
-				dashboard.GET("/stats", a.HandleGetDashboardStats)
-				dashboard.GET("/recent-orders", a.HandleGetRecentOrders)
-				dashboard.GET("/low-stock", a.HandleGetLowStockDashboard)
+				dashboard.GET("", can(PermDashboardView), a.HandleGetDashboard)
+				dashboard.GET("/stats", can(PermDashboardView), a.HandleGetDashboardStats)
+				dashboard.GET("/recent-orders", can(PermDashboardView), a.HandleGetRecentOrders)
+				dashboard.GET("/low-stock", can(PermDashboardView), a.HandleGetLowStockDashboard)
 			}
 		}
 	}
@@ -458,7 +899,7 @@ func (a *App) Start() error {
 	addr := fmt.Sprintf("%s:%d", a.Config.Server.Host, a.Config.Server.Port)
 	log.Printf("🚀 Server starting on %s", addr)
 	log.Printf("📊 Database: %s", a.Config.Database.Name)
-	log.Printf("🌐 JWT Expiration: %v", a.Config.JWT.Expiration)
+	log.Printf("🌐 JWT Expiration: %v (refresh %v)", a.Config.JWT.Expiration, a.Config.JWT.RefreshExpiration)
 	log.Printf("📱 WhatsApp: %v", a.Config.WhatsApp.Enabled)
 	log.Printf("📧 Email: %v", a.Config.Email.Enabled)
 
@@ -475,95 +916,50 @@ func (a *App) HandleHealthCheck(c *gin.Context) {
 	})
 }
 
-// AuthMiddleware validates JWT tokens
-func (a *App) AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization header"})
-			c.Abort()
-			return
-		}
-
-		// Remove "Bearer " prefix
-		if len(token) > 7 && token[:7] == "Bearer " {
-			token = token[7:]
-		}
-
-		// Validate token
-		claims, err := a.ValidateJWTToken(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		// Set user ID in context
-		c.Set("user_id", claims.UserID)
-		c.Set("role", claims.Role)
-		c.Set("email", claims.Email)
-
-		c.Next()
-	}
-}
-
-// ValidateJWTToken validates a JWT token
-func (a *App) ValidateJWTToken(tokenString string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(a.Config.JWT.Secret), nil
-	})
+// Main entry point
+func main() {
+	app := NewApp()
 
+	// Encrypted columns need the cipher before anything touches the database
+	cipher, err := NewFieldCipher(app.Config)
 	if err != nil {
-		return nil, err
+		log.Fatalf("❌ Invalid encryption config: %v", err)
 	}
+	fieldCipher = cipher
 
-	// Extract claims
-	if claims, ok := token.Claims.(*JWTClaims); ok && claims.Valid(time.Now()) {
-		return claims, nil
+	// Initialize database
+	if err := app.InitDatabase(); err != nil {
+		log.Fatalf("❌ Failed to initialize database: %v", err)
 	}
 
-	return nil, fmt.Errorf("invalid token")
-}
-
-// GenerateJWTToken generates a JWT token
-func (a *App) GenerateJWTToken(userID uint, email, role string) (string, error) {
-	claims := &JWTClaims{
-		UserID:    userID,
-		Email:     email,
-		Role:      role,
-		ExpiresAt: time.Now().Add(a.Config.JWT.Expiration),
-		IssuedAt:  time.Now(),
-	}
+	// Stored provider secrets override the environment
+	app.ApplyStoredCredentials()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(a.Config.JWT.Secret))
-	if err != nil {
-		return "", err
+	// Document links get their own key; a derived one is only for development
+	if app.Config.Documents.SigningKey == "" {
+		log.Println("⚠️  DOCUMENT_SIGNING_KEY is not set; deriving the document link key from JWT_SECRET")
+		app.Config.Documents.SigningKey = deriveDocumentKey(app.Config.JWT.Secret)
 	}
 
-	return tokenString, nil
-}
-
-// GetUserIDFromContext extracts user ID from context
-func (a *App) GetUserIDFromContext(c *gin.Context) string {
-	if userID, exists := c.Get("user_id"); exists {
-		if idStr, ok := userID.(uint); ok {
-			return fmt.Sprintf("%d", idStr)
+	// Encrypt values written before encryption or under a retired key
+	go func() {
+		result, err := app.RotateEncryption()
+		if err != nil {
+			log.Printf("Failed to re-encrypt stored values: %v", err)
+		} else if result.Customers+result.Credentials+result.Failed > 0 {
+			log.Printf("🔐 Re-encrypted %d customers and %d credentials (%d failed)",
+				result.Customers, result.Credentials, result.Failed)
 		}
-	}
-	return ""
-}
-
-// Main entry point
-func main() {
-	app := NewApp()
+	}()
 
-	// Create WebSocket manager
-	wsManager := NewWebSocketManager()
+	// Create WebSocket manager with a replayable event log; devices report
+	// in over it
+	wsManager := NewWebSocketManager(NewEventLog(app.DB))
+	wsManager.Authorize = app.AuthorizeWebSocket
+	wsManager.AllowedOrigins = app.Config.WebSocket.AllowedOrigins
+	wsManager.OnHeartbeat = app.RecordDeviceHeartbeat
+	wsManager.OnDeviceGone = app.RecordDeviceDisconnected
+	app.ResetDeviceConnections()
 
 	// Create notification service
 	notificationService := NewNotificationService(wsManager, app.DB)
@@ -572,14 +968,49 @@ func main() {
 	app.WSManager = wsManager
 	app.NotificationService = notificationService
 
-	// Initialize database
-	if err := app.InitDatabase(); err != nil {
-		log.Fatalf("❌ Failed to initialize database: %v", err)
+	// Create outbox dispatcher for domain events
+	app.Outbox = NewOutboxDispatcher(app.DB)
+	app.Webhooks = NewWebhookService(app.DB)
+	app.PrintQueue = NewPrintQueue(app.DB, wsManager)
+	app.ETA = NewETAService(app.DB, app.Config)
+	app.Mailer = NewEmailQueue(app.DB, app.Config)
+	app.Messaging = NewMessagingGateway(app.DB, app.Config)
+	app.Scheduler = NewScheduler(app.DB)
+	app.Permissions = NewPermissionCache(app.DB)
+	app.AuditTrail = NewAuditTrail(app.DB)
+	rateLimits, err := NewRateLimitStore(app.Config.RateLimit.Backend, app.DB)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
 	}
+	app.RateLimits = rateLimits
+	app.RegisterOutboxHandlers()
 
 	// Start WebSocket manager in goroutine
 	go wsManager.Run()
 
+	// Start delivering outbox events and webhooks
+	go app.Outbox.Run()
+	go app.Webhooks.Run()
+
+	// Start print workers
+	go app.PrintQueue.Run()
+
+	// Start sending queued email
+	if app.Config.Email.Enabled {
+		go app.Mailer.Run()
+	}
+
+	// Start submitting e-receipts
+	if app.Config.ETA.Enabled {
+		go app.ETA.Run()
+	}
+
+	// Start scheduled jobs (reports, sweeps, reminders, backups)
+	app.RegisterScheduledJobs()
+	if app.Config.Scheduler.Enabled {
+		go app.Scheduler.Run()
+	}
+
 	// Start periodic dashboard updates (every 30 seconds)
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -608,77 +1039,9 @@ func main() {
 	}
 }
 
-// getDashboardStatsInternal gets dashboard stats (internal helper)
+// GetDashboardStatsInternal gets today's dashboard stats for the broadcast ticker
 func (a *App) GetDashboardStatsInternal() (DashboardStats, error) {
-	stats := DashboardStats{}
-
-	// Get date range (default today)
 	startDate := time.Now().Truncate(24 * time.Hour)
 	endDate := startDate.Add(24 * time.Hour)
-
-	// 1. Total Revenue & Orders
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Select("COALESCE(SUM(total), 0) as total_revenue").
-		Select("COUNT(*) as total_orders").
-		Scan(&stats.TotalRevenue, &stats.TotalOrders)
-
-	// 2. Total Customers
-	a.DB.Model(&Customer{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Count(&stats.TotalCustomers)
-
-	// 3. Average Order Value
-	if stats.TotalOrders > 0 {
-		stats.AverageOrderValue = stats.TotalRevenue / float64(stats.TotalOrders)
-	}
-
-	// 4. Orders by Type
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Where("type = ?", "dine_in").Count(&stats.DineInOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Where("type = ?", "takeaway").Count(&stats.TakeawayOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ? AND payment_status = ?", startDate, endDate, "paid").
-		Where("type = ?", "delivery").Count(&stats.DeliveryOrders)
-
-	// 5. Payments by Method
-	a.DB.Model(&Payment{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("method = ?", "cash").Select("COALESCE(SUM(amount), 0)").Scan(&stats.CashPayments)
-	a.DB.Model(&Payment{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("method = ?", "card").Select("COALESCE(SUM(amount), 0)").Scan(&stats.CardPayments)
-	a.DB.Model(&Payment{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("method = ?", "mobile_wallet").Select("COALESCE(SUM(amount), 0)").Scan(&stats.WalletPayments)
-
-	// 6. Low Stock Items
-	a.DB.Model(&StockItem{}).
-		Where("is_low_stock = ? AND current_stock <= minimum_stock", true).
-		Count(&stats.LowStockItems)
-
-	// 7. Order Status Counts
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "pending").Count(&stats.PendingOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "preparing").Count(&stats.PreparingOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "ready").Count(&stats.ReadyOrders)
-	a.DB.Model(&Order{}).
-		Where("created_at >= ? AND created_at < ?", startDate, endDate).
-		Where("status = ?", "served").Count(&stats.ServedOrders)
-
-	// 8. Table Status
-	a.DB.Model(&Table{}).
-		Where("status = ?", "occupied").Count(&stats.ActiveTables)
-	a.DB.Model(&Table{}).
-		Where("status = ?", "available").Count(&stats.AvailableTables)
-
-	return stats, nil
+	return a.dashboardSummary(startDate, endDate), nil
 }
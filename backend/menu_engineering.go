@@ -0,0 +1,924 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// MENU ENGINEERING
+// ========================================
+//
+// Kasavana-Smith menu engineering: within a group of items (a category, or
+// the whole menu) an item is popular when its share of units sold is at
+// least 70% of an even share (1/N), and profitable when its contribution
+// margin per unit is at least the group's sales-weighted average. The two
+// tests give four classes:
+//
+//	star       popular, high margin   keep and feature
+//	plowhorse  popular, low margin    reprice or re-cost
+//	puzzle     unpopular, high margin reposition or promote
+//	dog        unpopular, low margin  replace or remove
+//
+// Unit cost is the item's recipe cost at current stock prices, or its
+// CostPrice when it has no recipe. Historical costs are not tracked, so
+// trends over long ranges use today's costs throughout.
+
+// Menu engineering classes
+const (
+	MenuClassStar      = "star"
+	MenuClassPlowhorse = "plowhorse"
+	MenuClassPuzzle    = "puzzle"
+	MenuClassDog       = "dog"
+)
+
+// Menu engineering scopes: classify within each category or across the menu
+const (
+	MenuScopeCategory = "category"
+	MenuScopeMenu     = "menu"
+)
+
+// Where a unit cost came from
+const (
+	CostSourceRecipe    = "recipe"
+	CostSourceCostPrice = "cost_price"
+	CostSourceNone      = "none"
+)
+
+// menuPopularityFactor is the share of an even mix an item needs to count
+// as popular
+const menuPopularityFactor = 0.7
+
+// maxMenuTrendPeriods caps the number of periods in a trend
+const maxMenuTrendPeriods = 120
+
+var menuClassOrder = map[string]int{
+	MenuClassStar:      0,
+	MenuClassPlowhorse: 1,
+	MenuClassPuzzle:    2,
+	MenuClassDog:       3,
+}
+
+var menuClassLabels = map[string][2]string{
+	MenuClassStar:      {"Star", "نجمة"},
+	MenuClassPlowhorse: {"Plowhorse", "حصان الحرث"},
+	MenuClassPuzzle:    {"Puzzle", "لغز"},
+	MenuClassDog:       {"Dog", "كلب"},
+}
+
+var menuEngineeringLabels = map[string][2]string{
+	"item":              {"Item", "الصنف"},
+	"category":          {"Category", "الفئة"},
+	"class":             {"Class", "التصنيف"},
+	"previous_class":    {"Previous class", "التصنيف السابق"},
+	"quantity":          {"Sold", "المباع"},
+	"mix_percent":       {"Mix %", "نسبة المزيج %"},
+	"menu_price":        {"Menu price", "سعر القائمة"},
+	"average_price":     {"Average price", "متوسط السعر"},
+	"unit_cost":         {"Unit cost", "تكلفة الوحدة"},
+	"food_cost_percent": {"Food cost %", "نسبة التكلفة %"},
+	"unit_margin":       {"Unit margin", "هامش الوحدة"},
+	"total_margin":      {"Total margin", "إجمالي الهامش"},
+	"revenue":           {"Revenue", "الإيرادات"},
+	"total":             {"Total", "الإجمالي"},
+	"whole_menu":        {"Whole menu", "القائمة كاملة"},
+	"title":             {"Menu engineering", "هندسة القائمة"},
+	"trend_title":       {"Menu engineering trend", "اتجاه هندسة القائمة"},
+}
+
+// MenuEngineeringItem is one menu item's popularity, margin and class
+type MenuEngineeringItem struct {
+	MenuItemID      uint    `json:"menu_item_id"`
+	Name            string  `json:"name"`
+	NameAr          string  `json:"name_ar"`
+	CategoryID      uint    `json:"category_id"`
+	Category        string  `json:"category"`
+	CategoryAr      string  `json:"category_ar"`
+	Quantity        float64 `json:"quantity"`
+	Revenue         float64 `json:"revenue"`
+	MenuPrice       float64 `json:"menu_price"`
+	AveragePrice    float64 `json:"average_price"`
+	UnitCost        float64 `json:"unit_cost"`
+	CostSource      string  `json:"cost_source"`
+	FoodCostPercent float64 `json:"food_cost_percent"`
+	UnitMargin      float64 `json:"unit_margin"`
+	TotalMargin     float64 `json:"total_margin"`
+	MixPercent      float64 `json:"mix_percent"`
+	HighPopularity  bool    `json:"high_popularity"`
+	HighMargin      bool    `json:"high_margin"`
+	Class           string  `json:"class"`
+	PreviousClass   string  `json:"previous_class,omitempty"`
+}
+
+// MenuEngineeringGroup holds the thresholds and totals of one
+// classification group
+type MenuEngineeringGroup struct {
+	CategoryID          uint           `json:"category_id"`
+	Name                string         `json:"name"`
+	NameAr              string         `json:"name_ar"`
+	Items               int            `json:"items"`
+	Quantity            float64        `json:"quantity"`
+	Revenue             float64        `json:"revenue"`
+	TotalMargin         float64        `json:"total_margin"`
+	PopularityThreshold float64        `json:"popularity_threshold"` // mix %
+	AverageMargin       float64        `json:"average_margin"`
+	Classes             map[string]int `json:"classes"`
+}
+
+// MenuEngineeringReport is a menu engineering analysis of one period
+type MenuEngineeringReport struct {
+	Range   ReportRange            `json:"range"`
+	Compare *ReportRange           `json:"compare,omitempty"`
+	Scope   string                 `json:"scope"`
+	Classes map[string]int         `json:"classes"`
+	Groups  []MenuEngineeringGroup `json:"groups"`
+	Items   []MenuEngineeringItem  `json:"items"`
+}
+
+// MenuEngineeringTrendItem is one item's class and figures per period
+type MenuEngineeringTrendItem struct {
+	MenuItemID uint      `json:"menu_item_id"`
+	Name       string    `json:"name"`
+	NameAr     string    `json:"name_ar"`
+	Category   string    `json:"category"`
+	CategoryAr string    `json:"category_ar"`
+	Classes    []string  `json:"classes"`
+	Quantity   []float64 `json:"quantity"`
+	MixPercent []float64 `json:"mix_percent"`
+	UnitMargin []float64 `json:"unit_margin"`
+}
+
+// MenuEngineeringTrend is the classification of every item per period
+type MenuEngineeringTrend struct {
+	Range   ReportRange                `json:"range"`
+	Scope   string                     `json:"scope"`
+	Periods []string                   `json:"periods"`
+	Items   []MenuEngineeringTrendItem `json:"items"`
+}
+
+// menuItemSales is what one item sold in a period
+type menuItemSales struct {
+	quantity float64
+	revenue  float64
+}
+
+// menuEngineeringSalesSQL sums paid, uncancelled sales per menu item
+const menuEngineeringSalesSQL = `SELECT oi.menu_item_id AS item_id,
+	SUM(oi.quantity) AS quantity,
+	COALESCE(SUM(oi.quantity * oi.unit_price), 0) AS revenue
+	FROM order_items oi
+	JOIN orders o ON o.id = oi.order_id
+	WHERE o.payment_status = 'paid' AND oi.status <> 'cancelled'
+		AND o.created_at >= ? AND o.created_at < ?
+	GROUP BY oi.menu_item_id`
+
+// menuEngineeringTrendSQL is menuEngineeringSalesSQL per time bucket
+const menuEngineeringTrendSQL = `SELECT FLOOR(UNIX_TIMESTAMP(o.created_at) / ?) AS bucket,
+	oi.menu_item_id AS item_id,
+	SUM(oi.quantity) AS quantity,
+	COALESCE(SUM(oi.quantity * oi.unit_price), 0) AS revenue
+	FROM order_items oi
+	JOIN orders o ON o.id = oi.order_id
+	WHERE o.payment_status = 'paid' AND oi.status <> 'cancelled'
+		AND o.created_at >= ? AND o.created_at < ?
+	GROUP BY bucket, oi.menu_item_id`
+
+// MenuEngineering classifies the menu over r and, when compare is set,
+// records each item's class in the comparison period
+func (a *App) MenuEngineering(r, compare *ReportRange, scope string, categoryID uint) (*MenuEngineeringReport, error) {
+	sales, err := a.menuItemSales(r)
+	if err != nil {
+		return nil, err
+	}
+	base, err := a.menuEngineeringBase(categoryID, sales)
+	if err != nil {
+		return nil, err
+	}
+
+	items, groups := classifyMenu(base, sales, scope)
+	report := &MenuEngineeringReport{
+		Range:   *r,
+		Compare: compare,
+		Scope:   scope,
+		Classes: map[string]int{},
+		Groups:  groups,
+		Items:   items,
+	}
+	for _, item := range items {
+		report.Classes[item.Class]++
+	}
+
+	if compare != nil {
+		previousSales, err := a.menuItemSales(compare)
+		if err != nil {
+			return nil, err
+		}
+		previous, _ := classifyMenu(base, previousSales, scope)
+		classes := make(map[uint]string, len(previous))
+		for _, item := range previous {
+			classes[item.MenuItemID] = item.Class
+		}
+		for i := range report.Items {
+			report.Items[i].PreviousClass = classes[report.Items[i].MenuItemID]
+		}
+	}
+	return report, nil
+}
+
+// MenuEngineeringTrend classifies the menu separately in each GroupBy
+// period of r
+func (a *App) MenuEngineeringTrend(r *ReportRange, scope string, categoryID uint) (*MenuEngineeringTrend, error) {
+	buckets := r.Buckets()
+	if len(buckets) > maxMenuTrendPeriods {
+		return nil, fmt.Errorf("range has %d periods, at most %d are allowed", len(buckets), maxMenuTrendPeriods)
+	}
+
+	var results []map[string]interface{}
+	if err := a.DB.Raw(menuEngineeringTrendSQL, r.bucketSeconds(), r.From, r.To).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+
+	position := make(map[int64]int, len(buckets))
+	periods := make([]map[uint]menuItemSales, len(buckets))
+	for i, start := range buckets {
+		position[start.Unix()] = i
+		periods[i] = map[uint]menuItemSales{}
+	}
+	total := map[uint]menuItemSales{}
+	seconds := r.bucketSeconds()
+	for _, result := range results {
+		start := r.bucketStart(time.Unix(int64(reportFloat(result["bucket"]))*seconds, 0))
+		i, ok := position[start.Unix()]
+		if !ok {
+			continue
+		}
+		id := uint(reportFloat(result["item_id"]))
+		s := periods[i][id]
+		s.quantity += reportFloat(result["quantity"])
+		s.revenue += reportFloat(result["revenue"])
+		periods[i][id] = s
+		total[id] = menuItemSales{total[id].quantity + reportFloat(result["quantity"]), total[id].revenue + reportFloat(result["revenue"])}
+	}
+
+	base, err := a.menuEngineeringBase(categoryID, total)
+	if err != nil {
+		return nil, err
+	}
+
+	trend := &MenuEngineeringTrend{Range: *r, Scope: scope}
+	index := make(map[uint]int, len(base))
+	for i, item := range base {
+		index[item.MenuItemID] = i
+		trend.Items = append(trend.Items, MenuEngineeringTrendItem{
+			MenuItemID: item.MenuItemID,
+			Name:       item.Name,
+			NameAr:     item.NameAr,
+			Category:   item.Category,
+			CategoryAr: item.CategoryAr,
+			Classes:    make([]string, len(buckets)),
+			Quantity:   make([]float64, len(buckets)),
+			MixPercent: make([]float64, len(buckets)),
+			UnitMargin: make([]float64, len(buckets)),
+		})
+	}
+	for p, start := range buckets {
+		trend.Periods = append(trend.Periods, r.BucketLabel(start))
+		items, _ := classifyMenu(base, periods[p], scope)
+		for _, item := range items {
+			t := &trend.Items[index[item.MenuItemID]]
+			t.Classes[p] = item.Class
+			t.Quantity[p] = item.Quantity
+			t.MixPercent[p] = item.MixPercent
+			t.UnitMargin[p] = item.UnitMargin
+		}
+	}
+	return trend, nil
+}
+
+// menuItemSales returns units sold and revenue per menu item over r
+func (a *App) menuItemSales(r *ReportRange) (map[uint]menuItemSales, error) {
+	var results []map[string]interface{}
+	if err := a.DB.Raw(menuEngineeringSalesSQL, r.From, r.To).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	sales := make(map[uint]menuItemSales, len(results))
+	for _, result := range results {
+		sales[uint(reportFloat(result["item_id"]))] = menuItemSales{
+			quantity: reportFloat(result["quantity"]),
+			revenue:  reportFloat(result["revenue"]),
+		}
+	}
+	return sales, nil
+}
+
+// menuEngineeringBase loads the items to classify, with names and unit
+// costs: every sellable item, plus modifier-only items that did sell
+func (a *App) menuEngineeringBase(categoryID uint, sales map[uint]menuItemSales) ([]MenuEngineeringItem, error) {
+	sold := make([]uint, 0, len(sales))
+	for id := range sales {
+		sold = append(sold, id)
+	}
+
+	query := a.DB.Preload("Category")
+	if len(sold) > 0 {
+		query = query.Where("(is_modifier_only = ? OR id IN ?)", false, sold)
+	} else {
+		query = query.Where("is_modifier_only = ?", false)
+	}
+	if categoryID != 0 {
+		query = query.Where("category_id = ?", categoryID)
+	}
+	var menuItems []MenuItem
+	if err := query.Find(&menuItems).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(menuItems))
+	for i, item := range menuItems {
+		ids[i] = item.ID
+	}
+	recipeCosts, err := a.recipeCosts(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	base := make([]MenuEngineeringItem, 0, len(menuItems))
+	for _, item := range menuItems {
+		row := MenuEngineeringItem{
+			MenuItemID: item.ID,
+			Name:       item.Name,
+			NameAr:     item.NameAr,
+			CategoryID: item.CategoryID,
+			Category:   item.Category.Name,
+			CategoryAr: item.Category.NameAr,
+			MenuPrice:  item.Price,
+			CostSource: CostSourceNone,
+		}
+		if cost, ok := recipeCosts[item.ID]; ok {
+			row.UnitCost, row.CostSource = RoundToDecimal(cost), CostSourceRecipe
+		} else if item.CostPrice > 0 {
+			row.UnitCost, row.CostSource = item.CostPrice, CostSourceCostPrice
+		}
+		base = append(base, row)
+	}
+	return base, nil
+}
+
+// classifyMenu fills sales figures into a copy of base and classifies each
+// item within its group
+func classifyMenu(base []MenuEngineeringItem, sales map[uint]menuItemSales, scope string) ([]MenuEngineeringItem, []MenuEngineeringGroup) {
+	items := make([]MenuEngineeringItem, len(base))
+	groupIndex := map[uint]int{}
+	var groups []MenuEngineeringGroup
+	for i, item := range base {
+		s := sales[item.MenuItemID]
+		item.Quantity = s.quantity
+		item.Revenue = s.revenue
+		item.AveragePrice = item.MenuPrice
+		if s.quantity > 0 {
+			item.AveragePrice = s.revenue / s.quantity
+		}
+		item.UnitMargin = item.AveragePrice - item.UnitCost
+		item.TotalMargin = item.UnitMargin * item.Quantity
+		item.FoodCostPercent = reportRatio(item.UnitCost*100, item.AveragePrice)
+		items[i] = item
+
+		key := uint(0)
+		if scope == MenuScopeCategory {
+			key = item.CategoryID
+		}
+		g, ok := groupIndex[key]
+		if !ok {
+			g = len(groups)
+			groupIndex[key] = g
+			group := MenuEngineeringGroup{CategoryID: key, Classes: map[string]int{}}
+			if scope == MenuScopeCategory {
+				group.Name, group.NameAr = item.Category, item.CategoryAr
+			} else {
+				group.Name, group.NameAr = menuEngineeringLabels["whole_menu"][0], menuEngineeringLabels["whole_menu"][1]
+			}
+			groups = append(groups, group)
+		}
+		groups[g].Items++
+		groups[g].Quantity += item.Quantity
+		groups[g].Revenue += item.Revenue
+		groups[g].TotalMargin += item.TotalMargin
+	}
+
+	// Average margin is weighted by units sold; with no sales at all it
+	// falls back to the plain mean of menu margins
+	plainMargin := make([]float64, len(groups))
+	for _, item := range items {
+		g := groupIndex[menuGroupKey(item, scope)]
+		plainMargin[g] += item.UnitMargin
+	}
+	for g := range groups {
+		groups[g].PopularityThreshold = menuPopularityFactor * 100 / float64(groups[g].Items)
+		if groups[g].Quantity > 0 {
+			groups[g].AverageMargin = groups[g].TotalMargin / groups[g].Quantity
+		} else {
+			groups[g].AverageMargin = plainMargin[g] / float64(groups[g].Items)
+		}
+	}
+
+	for i := range items {
+		item := &items[i]
+		group := &groups[groupIndex[menuGroupKey(*item, scope)]]
+		if group.Quantity > 0 {
+			item.MixPercent = item.Quantity * 100 / group.Quantity
+		}
+		item.HighPopularity = item.Quantity > 0 && item.MixPercent >= group.PopularityThreshold
+		item.HighMargin = item.UnitMargin >= group.AverageMargin
+		switch {
+		case item.HighPopularity && item.HighMargin:
+			item.Class = MenuClassStar
+		case item.HighPopularity:
+			item.Class = MenuClassPlowhorse
+		case item.HighMargin:
+			item.Class = MenuClassPuzzle
+		default:
+			item.Class = MenuClassDog
+		}
+		group.Classes[item.Class]++
+
+		item.AveragePrice = RoundToDecimal(item.AveragePrice)
+		item.UnitMargin = RoundToDecimal(item.UnitMargin)
+		item.TotalMargin = RoundToDecimal(item.TotalMargin)
+		item.MixPercent = RoundToDecimal(item.MixPercent)
+	}
+	for g := range groups {
+		groups[g].PopularityThreshold = RoundToDecimal(groups[g].PopularityThreshold)
+		groups[g].AverageMargin = RoundToDecimal(groups[g].AverageMargin)
+		groups[g].TotalMargin = RoundToDecimal(groups[g].TotalMargin)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if scope == MenuScopeCategory && a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.Class != b.Class {
+			return menuClassOrder[a.Class] < menuClassOrder[b.Class]
+		}
+		return a.TotalMargin > b.TotalMargin
+	})
+	return items, groups
+}
+
+func menuGroupKey(item MenuEngineeringItem, scope string) uint {
+	if scope == MenuScopeCategory {
+		return item.CategoryID
+	}
+	return 0
+}
+
+// ========================================
+// RECIPES
+// ========================================
+
+// recipeCosts returns the cost of one unit of each item that has a recipe,
+// at current stock prices
+func (a *App) recipeCosts(menuItemIDs []uint) (map[uint]float64, error) {
+	costs := map[uint]float64{}
+	if len(menuItemIDs) == 0 {
+		return costs, nil
+	}
+	var results []struct {
+		MenuItemID uint
+		Cost       float64
+	}
+	err := a.DB.Model(&RecipeLine{}).
+		Select("recipe_lines.menu_item_id, COALESCE(SUM(recipe_lines.quantity * stock_items.cost_per_unit), 0) AS cost").
+		Joins("JOIN stock_items ON stock_items.id = recipe_lines.stock_item_id").
+		Where("recipe_lines.menu_item_id IN ?", menuItemIDs).
+		Group("recipe_lines.menu_item_id").
+		Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		costs[r.MenuItemID] = r.Cost
+	}
+	return costs, nil
+}
+
+// RecipeLineRequest is one line of a recipe update
+type RecipeLineRequest struct {
+	StockItemID uint    `json:"stock_item_id" binding:"required"`
+	Quantity    float64 `json:"quantity" binding:"required,gt=0"`
+}
+
+// HandleGetRecipe returns a menu item's recipe and its unit cost
+func (a *App) HandleGetRecipe(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid menu item ID"})
+		return
+	}
+	a.respondRecipe(c, uint(id))
+}
+
+// HandleUpdateRecipe replaces a menu item's recipe
+func (a *App) HandleUpdateRecipe(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid menu item ID"})
+		return
+	}
+
+	var req []RecipeLineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var item MenuItem
+	if err := a.DB.First(&item, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Menu item not found"})
+		return
+	}
+
+	seen := map[uint]bool{}
+	stockIDs := make([]uint, 0, len(req))
+	for _, line := range req {
+		if seen[line.StockItemID] {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Duplicate stock item", Message: fmt.Sprint(line.StockItemID)})
+			return
+		}
+		seen[line.StockItemID] = true
+		stockIDs = append(stockIDs, line.StockItemID)
+	}
+	if len(stockIDs) > 0 {
+		var found int64
+		a.DB.Model(&StockItem{}).Where("id IN ?", stockIDs).Count(&found)
+		if int(found) != len(stockIDs) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Unknown stock item"})
+			return
+		}
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("menu_item_id = ?", item.ID).Delete(&RecipeLine{}).Error; err != nil {
+			return err
+		}
+		for _, line := range req {
+			recipe := RecipeLine{MenuItemID: item.ID, StockItemID: line.StockItemID, Quantity: line.Quantity}
+			if err := tx.Create(&recipe).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save recipe", Message: err.Error()})
+		return
+	}
+	a.respondRecipe(c, item.ID)
+}
+
+func (a *App) respondRecipe(c *gin.Context, menuItemID uint) {
+	var item MenuItem
+	if err := a.DB.First(&item, menuItemID).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Menu item not found"})
+		return
+	}
+
+	var lines []RecipeLine
+	a.DB.Preload("StockItem").Where("menu_item_id = ?", menuItemID).Order("id").Find(&lines)
+
+	cost, source := item.CostPrice, CostSourceCostPrice
+	if len(lines) > 0 {
+		cost, source = 0, CostSourceRecipe
+		for _, line := range lines {
+			if line.StockItem != nil {
+				cost += line.Quantity * line.StockItem.CostPerUnit
+			}
+		}
+	} else if cost == 0 {
+		source = CostSourceNone
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"menu_item_id": menuItemID,
+		"lines":        lines,
+		"unit_cost":    RoundToDecimal(cost),
+		"cost_source":  source,
+		"unit_margin":  RoundToDecimal(item.Price - cost),
+	})
+}
+
+// ========================================
+// MENU ENGINEERING HANDLERS
+// ========================================
+
+// menuEngineeringParams reads scope and category_id
+func menuEngineeringParams(c *gin.Context) (string, uint, error) {
+	scope := c.DefaultQuery("scope", MenuScopeCategory)
+	if scope != MenuScopeCategory && scope != MenuScopeMenu {
+		return "", 0, errors.New("scope must be category or menu")
+	}
+	var categoryID uint
+	if v := c.Query("category_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return "", 0, errors.New("invalid category_id")
+		}
+		categoryID = uint(id)
+	}
+	return scope, categoryID, nil
+}
+
+// HandleMenuEngineeringReport classifies menu items into stars,
+// plowhorses, puzzles and dogs for a period (default the last 30 days)
+func (a *App) HandleMenuEngineeringReport(c *gin.Context) {
+	r, compare, err := a.parseReportRange(c, "last_30_days", "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid report range", Message: err.Error()})
+		return
+	}
+	scope, categoryID, err := menuEngineeringParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", ReportFormatJSON))
+	if !validReportFormats[format] {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Format must be json, csv, xlsx or pdf"})
+		return
+	}
+
+	report, err := a.MenuEngineering(r, compare, scope, categoryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to run report", Message: err.Error()})
+		return
+	}
+	if format == ReportFormatJSON {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	settings, lang := a.exportLanguage(c)
+	table := &menuEngineeringTable{report: report, lang: lang}
+	a.writeExport(c, reportFilename("menu-engineering", report.Range, format), format, table, func() *PDFTable {
+		return table.PDF(settings.Name, settings.NameAr)
+	})
+}
+
+// HandleMenuEngineeringTrend classifies menu items per week (or day or
+// month) over a period, default the last 12 weeks
+func (a *App) HandleMenuEngineeringTrend(c *gin.Context) {
+	r, _, err := a.parseReportRange(c, "last_12_weeks", GroupByWeek)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid report range", Message: err.Error()})
+		return
+	}
+	if r.GroupBy == GroupByHour {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "group_by must be day, week or month"})
+		return
+	}
+	scope, categoryID, err := menuEngineeringParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", ReportFormatJSON))
+	if !validReportFormats[format] {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Format must be json, csv, xlsx or pdf"})
+		return
+	}
+
+	trend, err := a.MenuEngineeringTrend(r, scope, categoryID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to run report", Message: err.Error()})
+		return
+	}
+	if format == ReportFormatJSON {
+		c.JSON(http.StatusOK, trend)
+		return
+	}
+
+	settings, lang := a.exportLanguage(c)
+	table := &menuTrendTable{trend: trend, lang: lang}
+	a.writeExport(c, reportFilename("menu-engineering-trend", trend.Range, format), format, table, func() *PDFTable {
+		return table.PDF(settings.Name, settings.NameAr)
+	})
+}
+
+// ========================================
+// MENU ENGINEERING EXPORT
+// ========================================
+
+// menuEngineeringTable is a menu engineering report as an export table
+type menuEngineeringTable struct {
+	report *MenuEngineeringReport
+	lang   string
+}
+
+func (t *menuEngineeringTable) label(key string) string {
+	return reportLabel(menuEngineeringLabels, key, t.lang)
+}
+
+func (t *menuEngineeringTable) local(name, nameAr string) string {
+	if t.lang == "ar" && nameAr != "" {
+		return nameAr
+	}
+	return name
+}
+
+func (t *menuEngineeringTable) Title() string { return t.label("title") }
+func (t *menuEngineeringTable) RTL() bool     { return t.lang == "ar" }
+
+// menuEngineeringMetrics are the numeric columns and their decimals
+var menuEngineeringMetrics = []struct {
+	key      string
+	decimals int
+}{
+	{"quantity", 0},
+	{"mix_percent", 2},
+	{"menu_price", 2},
+	{"average_price", 2},
+	{"unit_cost", 2},
+	{"food_cost_percent", 2},
+	{"unit_margin", 2},
+	{"total_margin", 2},
+	{"revenue", 2},
+}
+
+func (t *menuEngineeringTable) labelColumns() []string {
+	columns := []string{"item", "category", "class"}
+	if t.report.Compare != nil {
+		columns = append(columns, "previous_class")
+	}
+	return columns
+}
+
+// Headers returns the localized column headings
+func (t *menuEngineeringTable) Headers() []string {
+	var headers []string
+	for _, key := range t.labelColumns() {
+		headers = append(headers, t.label(key))
+	}
+	for _, m := range menuEngineeringMetrics {
+		headers = append(headers, t.label(m.key))
+	}
+	return headers
+}
+
+func menuEngineeringValues(item *MenuEngineeringItem) []float64 {
+	return []float64{item.Quantity, item.MixPercent, item.MenuPrice, item.AveragePrice,
+		item.UnitCost, item.FoodCostPercent, item.UnitMargin, item.TotalMargin, item.Revenue}
+}
+
+// Each calls fn for every item and then for a totals row
+func (t *menuEngineeringTable) Each(fn func(cells []XLSXCell) error) error {
+	var quantity, margin, revenue float64
+	for i := range t.report.Items {
+		item := &t.report.Items[i]
+		quantity += item.Quantity
+		margin += item.TotalMargin
+		revenue += item.Revenue
+
+		cells := []XLSXCell{
+			{Text: t.local(item.Name, item.NameAr)},
+			{Text: t.local(item.Category, item.CategoryAr)},
+			{Text: reportLabel(menuClassLabels, item.Class, t.lang)},
+		}
+		if t.report.Compare != nil {
+			cells = append(cells, XLSXCell{Text: reportLabel(menuClassLabels, item.PreviousClass, t.lang)})
+		}
+		for j, v := range menuEngineeringValues(item) {
+			cells = append(cells, XLSXCell{Number: v, Numeric: true, Decimals: menuEngineeringMetrics[j].decimals})
+		}
+		if err := fn(cells); err != nil {
+			return err
+		}
+	}
+
+	// Only the additive columns have a total
+	totals := map[string]float64{"quantity": quantity, "total_margin": margin, "revenue": revenue}
+	total := make([]XLSXCell, len(t.labelColumns()), len(t.labelColumns())+len(menuEngineeringMetrics))
+	total[0] = XLSXCell{Text: t.label("total"), Bold: true}
+	for _, m := range menuEngineeringMetrics {
+		cell := XLSXCell{Bold: true}
+		if v, ok := totals[m.key]; ok {
+			cell = XLSXCell{Number: v, Numeric: true, Decimals: m.decimals, Bold: true}
+		}
+		total = append(total, cell)
+	}
+	return fn(total)
+}
+
+// PDF lays the report out with class counts in the heading
+func (t *menuEngineeringTable) PDF(restaurant, restaurantAr string) *PDFTable {
+	table := &PDFTable{
+		Title:    t.local(restaurant, restaurantAr),
+		Subtitle: t.Title(),
+		Heading:  []string{reportRangeText(t.report.Range, t.lang)},
+		Headers:  t.Headers(),
+		Labels:   len(t.labelColumns()),
+		RTL:      t.RTL(),
+	}
+	if t.report.Compare != nil {
+		table.Heading = append(table.Heading, reportLabel(reportLabels, "compared_with", t.lang)+": "+reportRangeText(*t.report.Compare, t.lang))
+	}
+	var counts []string
+	for _, class := range []string{MenuClassStar, MenuClassPlowhorse, MenuClassPuzzle, MenuClassDog} {
+		counts = append(counts, fmt.Sprintf("%s: %d", reportLabel(menuClassLabels, class, t.lang), t.report.Classes[class]))
+	}
+	table.Heading = append(table.Heading, strings.Join(counts, "   "))
+
+	t.Each(func(cells []XLSXCell) error {
+		row := make([]string, len(cells))
+		for i, cell := range cells {
+			row[i] = cell.Text
+			if cell.Numeric {
+				row[i] = formatReportNumber(cell.Number, cell.Decimals)
+			}
+		}
+		if cells[0].Bold {
+			table.Summary = append(table.Summary, row)
+		} else {
+			table.Rows = append(table.Rows, row)
+		}
+		return nil
+	})
+	return table
+}
+
+// menuTrendTable is a menu engineering trend as an export table: one row
+// per item with its class in each period
+type menuTrendTable struct {
+	trend *MenuEngineeringTrend
+	lang  string
+}
+
+func (t *menuTrendTable) Title() string {
+	return reportLabel(menuEngineeringLabels, "trend_title", t.lang)
+}
+
+func (t *menuTrendTable) RTL() bool { return t.lang == "ar" }
+
+// Headers returns the item and category headings and one per period
+func (t *menuTrendTable) Headers() []string {
+	headers := []string{
+		reportLabel(menuEngineeringLabels, "item", t.lang),
+		reportLabel(menuEngineeringLabels, "category", t.lang),
+	}
+	return append(headers, t.trend.Periods...)
+}
+
+// Each calls fn for every item
+func (t *menuTrendTable) Each(fn func(cells []XLSXCell) error) error {
+	for _, item := range t.trend.Items {
+		name, category := item.Name, item.Category
+		if t.lang == "ar" {
+			if item.NameAr != "" {
+				name = item.NameAr
+			}
+			if item.CategoryAr != "" {
+				category = item.CategoryAr
+			}
+		}
+		cells := []XLSXCell{{Text: name}, {Text: category}}
+		for _, class := range item.Classes {
+			cells = append(cells, XLSXCell{Text: reportLabel(menuClassLabels, class, t.lang)})
+		}
+		if err := fn(cells); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PDF lays the trend out with every column as text
+func (t *menuTrendTable) PDF(restaurant, restaurantAr string) *PDFTable {
+	table := &PDFTable{
+		Title:    restaurant,
+		Subtitle: t.Title(),
+		Heading:  []string{reportRangeText(t.trend.Range, t.lang)},
+		Headers:  t.Headers(),
+		Labels:   len(t.Headers()),
+		RTL:      t.RTL(),
+	}
+	if t.lang == "ar" && restaurantAr != "" {
+		table.Title = restaurantAr
+	}
+	t.Each(func(cells []XLSXCell) error {
+		row := make([]string, len(cells))
+		for i, cell := range cells {
+			row[i] = cell.Text
+		}
+		table.Rows = append(table.Rows, row)
+		return nil
+	})
+	return table
+}
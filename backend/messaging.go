@@ -0,0 +1,915 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// MESSAGING GATEWAY
+// ========================================
+
+// Messaging channels
+const (
+	ChannelWhatsApp = "whatsapp"
+	ChannelSMS      = "sms"
+)
+
+// Message log statuses. Blocked messages were never sent because the
+// customer opted out or hit the per-recipient limit.
+const (
+	MessageQueued    = "queued"
+	MessageSent      = "sent"
+	MessageDelivered = "delivered"
+	MessageRead      = "read"
+	MessageFailed    = "failed"
+	MessageBlocked   = "blocked"
+)
+
+// Messaging errors
+var (
+	ErrNoMessagingProvider  = errors.New("no messaging provider is configured for this channel")
+	ErrRecipientOptedOut    = errors.New("recipient has opted out of messages on this channel")
+	ErrRecipientRateLimited = errors.New("too many messages to this recipient, try again later")
+	ErrInvalidPhone         = errors.New("invalid phone number")
+)
+
+// messageStatusRank orders delivery statuses so late callbacks never move a
+// message backwards
+var messageStatusRank = map[string]int{
+	MessageQueued:    0,
+	MessageSent:      1,
+	MessageDelivered: 2,
+	MessageRead:      3,
+}
+
+// Keywords customers reply with to stop or resume messages
+var (
+	optOutKeywords = []string{"stop", "unsubscribe", "cancel", "end", "quit", "إلغاء", "الغاء", "توقف"}
+	optInKeywords  = []string{"start", "unstop", "subscribe", "اشتراك"}
+)
+
+// MessageRequest is a message to send to one phone number. Params fill the
+// approved template for Kind when one exists; otherwise Body is sent as
+// free text.
+type MessageRequest struct {
+	Channel  string
+	To       string
+	Kind     string
+	Body     string
+	Params   map[string]string
+	MediaURL string
+	Filename string
+	OrderID  *uint
+}
+
+// MessagingGateway sends WhatsApp and SMS messages through the configured
+// provider for each channel, honoring opt-outs and rate limits and logging
+// every message
+type MessagingGateway struct {
+	DB                 *gorm.DB
+	Providers          map[string]MessagingProvider
+	CountryCode        string
+	PerRecipientHourly int
+
+	limiter *rateLimiter
+}
+
+// NewMessagingGateway creates the gateway with the providers selected in
+// the config
+func NewMessagingGateway(db *gorm.DB, config *Config) *MessagingGateway {
+	cfg := config.Messaging
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+	callbackURL := func(provider string) string {
+		if config.Documents.PublicURL == "" {
+			return ""
+		}
+		return strings.TrimRight(config.Documents.PublicURL, "/") + "/api/public/messaging/callback/" + provider
+	}
+
+	build := func(name string) MessagingProvider {
+		switch name {
+		case "cloud":
+			return &WhatsAppCloudProvider{
+				BaseURL:       cfg.CloudBaseURL,
+				APIVersion:    cfg.CloudAPIVersion,
+				PhoneNumberID: cfg.CloudPhoneNumberID,
+				AccessToken:   cfg.CloudAccessToken,
+				AppSecret:     cfg.CloudAppSecret,
+				HTTP:          httpClient,
+			}
+		case "twilio":
+			return &TwilioProvider{
+				BaseURL:      "https://api.twilio.com",
+				AccountSID:   cfg.TwilioAccountSID,
+				AuthToken:    cfg.TwilioAuthToken,
+				WhatsAppFrom: cfg.TwilioWhatsAppFrom,
+				SMSFrom:      cfg.TwilioSMSFrom,
+				CallbackURL:  callbackURL("twilio"),
+				HTTP:         httpClient,
+			}
+		case "generic":
+			return &GenericHTTPProvider{
+				URL:         cfg.GenericURL,
+				APIKey:      cfg.GenericAPIKey,
+				Secret:      cfg.GenericSecret,
+				CallbackURL: callbackURL("generic"),
+				HTTP:        httpClient,
+			}
+		}
+		if name != "" {
+			log.Printf("Unknown messaging provider %q", name)
+		}
+		return nil
+	}
+
+	gateway := &MessagingGateway{
+		DB:                 db,
+		Providers:          map[string]MessagingProvider{},
+		CountryCode:        cfg.CountryCode,
+		PerRecipientHourly: cfg.PerRecipientHourly,
+		limiter:            newRateLimiter(float64(cfg.RatePerSecond), float64(cfg.RatePerSecond*2)),
+	}
+	if config.WhatsApp.Enabled {
+		if p := build(cfg.WhatsAppProvider); p != nil {
+			gateway.Providers[ChannelWhatsApp] = p
+		}
+	}
+	if cfg.SMSEnabled {
+		if p := build(cfg.SMSProvider); p != nil {
+			gateway.Providers[ChannelSMS] = p
+		}
+	}
+	return gateway
+}
+
+// Provider returns the provider registered under name, for callbacks
+func (g *MessagingGateway) Provider(name string) MessagingProvider {
+	for _, p := range g.Providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Send delivers one message and returns its log entry. Opted-out and
+// rate-limited recipients are logged as blocked and not sent.
+func (g *MessagingGateway) Send(req *MessageRequest) (*MessageLog, error) {
+	if req.Channel == "" {
+		req.Channel = ChannelWhatsApp
+	}
+	provider, ok := g.Providers[req.Channel]
+	if !ok {
+		return nil, ErrNoMessagingProvider
+	}
+
+	to, err := NormalizePhone(req.To, g.CountryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := MessageLog{
+		Channel:   req.Channel,
+		Provider:  provider.Name(),
+		Recipient: to,
+		OrderID:   req.OrderID,
+		Kind:      req.Kind,
+		Body:      req.Body,
+		Status:    MessageQueued,
+	}
+
+	customer := g.findCustomer(to)
+	if customer != nil {
+		entry.CustomerID = &customer.ID
+	}
+
+	block := func(reason error) (*MessageLog, error) {
+		entry.Status = MessageBlocked
+		entry.Error = reason.Error()
+		g.DB.Create(&entry)
+		return &entry, reason
+	}
+	if customer != nil && customer.OptedOut(req.Channel) {
+		return block(ErrRecipientOptedOut)
+	}
+	if g.PerRecipientHourly > 0 {
+		var recent int64
+		g.DB.Model(&MessageLog{}).
+			Where("recipient = ? AND status <> ? AND created_at >= ?", to, MessageBlocked, time.Now().Add(-time.Hour)).
+			Count(&recent)
+		if int(recent) >= g.PerRecipientHourly {
+			return block(ErrRecipientRateLimited)
+		}
+	}
+
+	outbound := &OutboundMessage{
+		Channel:  req.Channel,
+		To:       to,
+		Body:     req.Body,
+		MediaURL: req.MediaURL,
+		Filename: req.Filename,
+	}
+	if tpl := g.template(req.Kind, req.Channel); tpl != nil {
+		outbound.Template = tpl.Render(req.Params)
+		entry.TemplateName = tpl.ExternalName
+	} else if outbound.Body == "" {
+		return nil, fmt.Errorf("message has no body and no approved %s template", req.Kind)
+	}
+
+	if err := g.DB.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+
+	g.limiter.Wait()
+	messageID, err := provider.Send(outbound)
+	if err != nil {
+		entry.Status = MessageFailed
+		entry.Error = err.Error()
+		g.DB.Model(&entry).Updates(map[string]interface{}{"status": entry.Status, "error": entry.Error})
+		return &entry, fmt.Errorf("%s: %w", provider.Name(), err)
+	}
+
+	now := time.Now()
+	entry.Status = MessageSent
+	entry.ProviderMessageID = messageID
+	entry.SentAt = &now
+	g.DB.Model(&entry).Updates(map[string]interface{}{
+		"status":              entry.Status,
+		"provider_message_id": messageID,
+		"sent_at":             now,
+	})
+	return &entry, nil
+}
+
+// template returns the approved, active template for kind on channel
+func (g *MessagingGateway) template(kind, channel string) *MessageTemplate {
+	if kind == "" {
+		return nil
+	}
+	var tpl MessageTemplate
+	if err := g.DB.Where("kind = ? AND channel = ? AND status = ? AND is_active = ?", kind, channel, "approved", true).
+		First(&tpl).Error; err != nil {
+		return nil
+	}
+	return &tpl
+}
+
+// Render resolves the template's placeholder keys against params
+func (t *MessageTemplate) Render(params map[string]string) *OutboundTemplate {
+	var keys []string
+	json.Unmarshal([]byte(t.Params), &keys)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = params[key]
+		if values[i] == "" {
+			// Providers reject empty template parameters
+			values[i] = "-"
+		}
+	}
+	return &OutboundTemplate{Name: t.ExternalName, Language: t.Language, Params: values}
+}
+
+// OptedOut reports whether the customer refuses messages on channel
+func (c *Customer) OptedOut(channel string) bool {
+	if channel == ChannelSMS {
+		return c.SMSOptOut
+	}
+	return c.WhatsAppOptOut
+}
+
+// findCustomer looks a customer up by any common form of the number
+func (g *MessagingGateway) findCustomer(e164 string) *Customer {
+	customer, err := FindCustomerByPhone(g.DB, e164)
+	if err != nil {
+		return nil
+	}
+	return customer
+}
+
+// SetOptOut records a customer's opt-out or opt-in for a channel ("all"
+// for both). Unknown numbers get a customer record so the choice sticks.
+func (g *MessagingGateway) SetOptOut(phone, channel string, optedOut bool) (*Customer, error) {
+	e164, err := NormalizePhone(phone, g.CountryCode)
+	if err != nil {
+		return nil, err
+	}
+
+	customer := g.findCustomer(e164)
+	if customer == nil {
+		customer = &Customer{Name: e164, Phone: EncryptedString(localPhone(e164, g.CountryCode))}
+		if err := g.DB.Create(customer).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if channel == ChannelWhatsApp || channel == "all" {
+		updates["whatsapp_opt_out"] = optedOut
+	}
+	if channel == ChannelSMS || channel == "all" {
+		updates["sms_opt_out"] = optedOut
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("unknown channel %q", channel)
+	}
+	if optedOut {
+		updates["opted_out_at"] = time.Now()
+	}
+
+	if err := g.DB.Model(customer).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	g.DB.First(customer, customer.ID)
+	return customer, nil
+}
+
+// ApplyCallback records delivery statuses and opt-out replies
+func (g *MessagingGateway) ApplyCallback(callback *MessagingCallback) {
+	for _, update := range callback.Statuses {
+		var entry MessageLog
+		if update.ProviderMessageID == "" ||
+			g.DB.Where("provider_message_id = ?", update.ProviderMessageID).First(&entry).Error != nil {
+			continue
+		}
+
+		at := update.Timestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+		updates := map[string]interface{}{}
+		switch update.Status {
+		case MessageFailed:
+			updates["status"] = MessageFailed
+			updates["error"] = update.Error
+		case MessageSent, MessageDelivered, MessageRead:
+			if entry.Status == MessageFailed || messageStatusRank[update.Status] <= messageStatusRank[entry.Status] {
+				continue
+			}
+			updates["status"] = update.Status
+			if update.Status == MessageDelivered || (update.Status == MessageRead && entry.DeliveredAt == nil) {
+				updates["delivered_at"] = at
+			}
+			if update.Status == MessageRead {
+				updates["read_at"] = at
+			}
+		default:
+			continue
+		}
+		g.DB.Model(&entry).Updates(updates)
+	}
+
+	for _, inbound := range callback.Inbound {
+		keyword := strings.ToLower(strings.TrimSpace(inbound.Body))
+		channel := inbound.Channel
+		if channel == "" {
+			channel = ChannelWhatsApp
+		}
+		switch {
+		case containsString(optOutKeywords, keyword):
+			if _, err := g.SetOptOut(inbound.From, channel, true); err != nil {
+				log.Printf("Failed to record opt-out for %s: %v", inbound.From, err)
+			}
+		case containsString(optInKeywords, keyword):
+			if _, err := g.SetOptOut(inbound.From, channel, false); err != nil {
+				log.Printf("Failed to record opt-in for %s: %v", inbound.From, err)
+			}
+		}
+	}
+}
+
+// NormalizePhone converts a local or international number to E.164,
+// assuming countryCode for numbers with a leading 0
+func NormalizePhone(phone, countryCode string) (string, error) {
+	phone = strings.TrimPrefix(strings.TrimSpace(phone), "whatsapp:")
+	var digits strings.Builder
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case strings.HasPrefix(number, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = "+" + number[2:]
+	case strings.HasPrefix(number, "0"):
+		number = "+" + countryCode + number[1:]
+	case strings.HasPrefix(number, countryCode) && len(number) > 10:
+		number = "+" + number
+	default:
+		number = "+" + countryCode + number
+	}
+
+	if n := len(number) - 1; n < 8 || n > 15 {
+		return "", ErrInvalidPhone
+	}
+	return number, nil
+}
+
+// localPhone returns the national form (leading 0) for domestic numbers
+func localPhone(e164, countryCode string) string {
+	if strings.HasPrefix(e164, "+"+countryCode) {
+		return "0" + strings.TrimPrefix(e164, "+"+countryCode)
+	}
+	return e164
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a token bucket shared by all sends, keeping us under the
+// provider's throughput limits
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond, burst float64) *rateLimiter {
+	if perSecond <= 0 {
+		perSecond = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: perSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait blocks until a token is available
+func (l *rateLimiter) Wait() {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// ========================================
+// MESSAGE CONTENT
+// ========================================
+
+// ReceiptMessage builds the WhatsApp receipt for an order: the receipt
+// template as text, plus the parameters for an approved "receipt" template.
+// documentURL, when set, links the PDF receipt.
+func ReceiptMessage(engine *ReceiptEngine, order *Order, settings *RestaurantSettings, documentURL string) (string, map[string]string) {
+	data := engine.BuildData(order, settings)
+
+	body, err := engine.RenderWhatsApp(engine.Template(TemplateKindReceipt), data)
+	if err != nil {
+		log.Printf("Receipt template failed for WhatsApp, using built-in: %v", err)
+		body, _ = engine.RenderWhatsApp(builtinTemplate(TemplateKindReceipt), data)
+	}
+	if documentURL != "" {
+		body += "\n\n" + documentURL
+	}
+
+	return body, map[string]string{
+		"restaurant_name": settings.Name,
+		"customer_name":   order.CustomerName,
+		"order_number":    order.OrderNumber,
+		"total":           fmt.Sprintf("%.2f", order.Total),
+		"currency":        settings.CurrencySymbol,
+		"receipt_url":     documentURL,
+	}
+}
+
+// DailyReportMessage formats the daily sales report and its template
+// parameters
+func DailyReportMessage(report *DailyReport, settings *RestaurantSettings) (string, map[string]string) {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("*📊 تقرير يومي - %s*\n\n", report.ReportDate.Format("2006-01-02")))
+	sb.WriteString(fmt.Sprintf("📈 إجمالي الإيرادات: %s%.2f\n", settings.CurrencySymbol, report.TotalRevenue))
+	sb.WriteString(fmt.Sprintf("📦 عدد الطلبات: %d\n", report.TotalOrders))
+	sb.WriteString(fmt.Sprintf("🍽️ طلبات صالون: %d\n", report.DineInOrders))
+	sb.WriteString(fmt.Sprintf("📦 طلبات تاك أواي: %d\n", report.TakeawayOrders))
+	sb.WriteString(fmt.Sprintf("🚗 طلبات دليفري: %d\n", report.DeliveryOrders))
+
+	sb.WriteString("\n")
+	sb.WriteString("💰 المدفوعات:\n")
+	sb.WriteString(fmt.Sprintf("  كاش: %s%.2f\n", settings.CurrencySymbol, report.CashPayments))
+	sb.WriteString(fmt.Sprintf("  بطاقة: %s%.2f\n", settings.CurrencySymbol, report.CardPayments))
+	sb.WriteString(fmt.Sprintf("  محفظة: %s%.2f\n", settings.CurrencySymbol, report.WalletPayments))
+
+	return sb.String(), map[string]string{
+		"restaurant_name": settings.Name,
+		"date":            report.ReportDate.Format("2006-01-02"),
+		"revenue":         fmt.Sprintf("%.2f", report.TotalRevenue),
+		"orders":          fmt.Sprintf("%d", report.TotalOrders),
+		"currency":        settings.CurrencySymbol,
+	}
+}
+
+// ========================================
+// MESSAGING HANDLERS
+// ========================================
+
+// messagingStatus maps gateway errors to HTTP statuses
+func messagingStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNoMessagingProvider):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrRecipientOptedOut):
+		return http.StatusConflict
+	case errors.Is(err, ErrRecipientRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrInvalidPhone):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// sendMessage sends through the gateway and writes the response
+func (a *App) sendMessage(c *gin.Context, req *MessageRequest, success string) {
+	entry, err := a.Messaging.Send(req)
+	if err != nil {
+		c.JSON(messagingStatus(err), ErrorResponse{Error: "Failed to send message", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: success,
+		Data:    entry,
+	})
+}
+
+// HandleSendWhatsAppReceipt sends an order's receipt to the customer, or
+// to the phone in the request body
+func (a *App) HandleSendWhatsAppReceipt(c *gin.Context) {
+	var req struct {
+		Phone   string `json:"phone"`
+		Channel string `json:"channel"`
+	}
+	c.ShouldBindJSON(&req)
+
+	var order Order
+	if err := a.DB.Preload("Items").Preload("Payments").First(&order, c.Param("orderId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+		return
+	}
+
+	phone := req.Phone
+	if phone == "" {
+		phone = order.CustomerPhone.String()
+	}
+	if phone == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "No phone number for this order"})
+		return
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+
+	link, _ := a.SignDocumentURL(a.publicBaseURL(c), DocumentReceipt, order.ID, a.Config.Messaging.DocumentLinkTTL)
+	body, params := ReceiptMessage(NewReceiptEngine(a.DB), &order, &settings, link)
+
+	a.sendMessage(c, &MessageRequest{
+		Channel: req.Channel,
+		To:      phone,
+		Kind:    "receipt",
+		Body:    body,
+		Params:  params,
+		OrderID: &order.ID,
+	}, "Receipt sent")
+}
+
+// HandleSendWhatsAppDailyReport sends a day's sales summary to a manager
+func (a *App) HandleSendWhatsAppDailyReport(c *gin.Context) {
+	var req struct {
+		Phone   string `json:"phone" binding:"required"`
+		Date    string `json:"date"`
+		Channel string `json:"channel"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	date := time.Now()
+	if req.Date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid date", Message: err.Error()})
+			return
+		}
+		date = parsed
+	}
+
+	reports := &ReportService{DB: a.DB}
+	report, err := reports.GenerateDailyReport(date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate report", Message: err.Error()})
+		return
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+
+	body, params := DailyReportMessage(report, &settings)
+	a.sendMessage(c, &MessageRequest{
+		Channel: req.Channel,
+		To:      req.Phone,
+		Kind:    "daily_report",
+		Body:    body,
+		Params:  params,
+	}, "Daily report sent")
+}
+
+// HandleTestWhatsApp sends a test message to check the provider settings
+func (a *App) HandleTestWhatsApp(c *gin.Context) {
+	var req struct {
+		Phone   string `json:"phone" binding:"required"`
+		Message string `json:"message"`
+		Channel string `json:"channel"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+
+	body := req.Message
+	if body == "" {
+		body = fmt.Sprintf("✅ %s: تم إعداد الرسائل بنجاح / Messaging is working", settings.Name)
+	}
+
+	a.sendMessage(c, &MessageRequest{
+		Channel: req.Channel,
+		To:      req.Phone,
+		Kind:    "test",
+		Body:    body,
+		Params:  map[string]string{"restaurant_name": settings.Name},
+	}, "Test message sent")
+}
+
+// HandleGetMessageLogs lists sent messages, newest first
+func (a *App) HandleGetMessageLogs(c *gin.Context) {
+	page := getInt(c.DefaultQuery("page", "1"))
+	limit := getInt(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := a.DB.Model(&MessageLog{})
+	for _, field := range []string{"status", "channel", "kind", "order_id", "customer_id"} {
+		if value := c.Query(field); value != "" {
+			query = query.Where(field+" = ?", value)
+		}
+	}
+	if phone := c.Query("recipient"); phone != "" {
+		if e164, err := NormalizePhone(phone, a.Messaging.CountryCode); err == nil {
+			query = query.Where("recipient = ?", e164)
+		}
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []MessageLog
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch message log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages": logs,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// MessageTemplateRequest is the body for creating or updating a template
+type MessageTemplateRequest struct {
+	Kind         string   `json:"kind" binding:"required"`
+	Channel      string   `json:"channel" binding:"required"`
+	ExternalName string   `json:"external_name" binding:"required"`
+	Language     string   `json:"language"`
+	Params       []string `json:"params"`
+	Status       string   `json:"status"`
+	IsActive     *bool    `json:"is_active"`
+}
+
+// apply validates the request and copies it onto tpl
+func (r *MessageTemplateRequest) apply(tpl *MessageTemplate) error {
+	if r.Channel != ChannelWhatsApp && r.Channel != ChannelSMS {
+		return fmt.Errorf("channel must be %q or %q", ChannelWhatsApp, ChannelSMS)
+	}
+	switch r.Status {
+	case "":
+		r.Status = "approved"
+	case "approved", "pending", "rejected":
+	default:
+		return fmt.Errorf("unknown status %q", r.Status)
+	}
+	if r.Language == "" {
+		r.Language = "ar"
+	}
+	params, _ := json.Marshal(r.Params)
+	if r.Params == nil {
+		params = []byte("[]")
+	}
+
+	tpl.Kind = r.Kind
+	tpl.Channel = r.Channel
+	tpl.ExternalName = r.ExternalName
+	tpl.Language = r.Language
+	tpl.Params = string(params)
+	tpl.Status = r.Status
+	tpl.IsActive = r.IsActive == nil || *r.IsActive
+	return nil
+}
+
+// HandleGetMessageTemplates lists message templates
+func (a *App) HandleGetMessageTemplates(c *gin.Context) {
+	var templates []MessageTemplate
+	if err := a.DB.Order("kind ASC, channel ASC").Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch templates"})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// HandleCreateMessageTemplate registers an approved provider template
+func (a *App) HandleCreateMessageTemplate(c *gin.Context) {
+	var req MessageTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var tpl MessageTemplate
+	if err := req.apply(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := a.DB.Create(&tpl).Error; err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Failed to create template", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Template created successfully",
+		Data:    tpl,
+	})
+}
+
+// HandleUpdateMessageTemplate updates a template
+func (a *App) HandleUpdateMessageTemplate(c *gin.Context) {
+	var tpl MessageTemplate
+	if err := a.DB.First(&tpl, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Template not found"})
+		return
+	}
+
+	var req MessageTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := req.apply(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := a.DB.Save(&tpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update template", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Template updated successfully",
+		Data:    tpl,
+	})
+}
+
+// HandleDeleteMessageTemplate deletes a template
+func (a *App) HandleDeleteMessageTemplate(c *gin.Context) {
+	if err := a.DB.Delete(&MessageTemplate{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Template deleted successfully",
+	})
+}
+
+// HandleSetMessagingOptOut opts a phone number out of (or back into)
+// messages on a channel
+func (a *App) HandleSetMessagingOptOut(c *gin.Context) {
+	var req struct {
+		Phone    string `json:"phone" binding:"required"`
+		Channel  string `json:"channel"`
+		OptedOut bool   `json:"opted_out"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = "all"
+	}
+
+	customer, err := a.Messaging.SetOptOut(req.Phone, req.Channel, req.OptedOut)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to update opt-out", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Messaging preferences updated",
+		Data:    customer,
+	})
+}
+
+// HandleMessagingCallback receives delivery statuses and replies from a
+// provider. Requests are authenticated by the provider's signature.
+func (a *App) HandleMessagingCallback(c *gin.Context) {
+	provider := a.Messaging.Provider(c.Param("provider"))
+	if provider == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Unknown provider"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request"})
+		return
+	}
+
+	callbackURL := a.publicBaseURL(c) + c.Request.URL.RequestURI()
+	callback, err := provider.ParseCallback(c.Request, body, callbackURL)
+	if errors.Is(err, ErrBadCallbackSignature) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Invalid signature"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid callback", Message: err.Error()})
+		return
+	}
+
+	a.Messaging.ApplyCallback(callback)
+	c.Status(http.StatusOK)
+}
+
+// HandleVerifyMessagingCallback answers the WhatsApp Cloud API webhook
+// verification handshake
+func (a *App) HandleVerifyMessagingCallback(c *gin.Context) {
+	token := a.Config.Messaging.CloudVerifyToken
+	if c.Query("hub.mode") != "subscribe" || token == "" || c.Query("hub.verify_token") != token {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	c.String(http.StatusOK, c.Query("hub.challenge"))
+}
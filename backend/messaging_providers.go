@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================================
+// MESSAGING PROVIDERS
+// ========================================
+
+// ErrBadCallbackSignature is returned for status callbacks that fail
+// verification
+var ErrBadCallbackSignature = errors.New("invalid callback signature")
+
+// MessagingProvider sends messages through one provider and understands
+// its delivery callbacks
+type MessagingProvider interface {
+	Name() string
+	Send(msg *OutboundMessage) (string, error)
+	ParseCallback(r *http.Request, body []byte, callbackURL string) (*MessagingCallback, error)
+}
+
+// OutboundMessage is a message ready for a provider. When Template is set
+// the provider sends the approved template instead of Body.
+type OutboundMessage struct {
+	Channel  string
+	To       string // E.164, e.g. +201001234567
+	Body     string
+	Template *OutboundTemplate
+	MediaURL string
+	Filename string
+}
+
+// OutboundTemplate is an approved template with its positional parameters
+type OutboundTemplate struct {
+	Name     string
+	Language string
+	Params   []string
+}
+
+// MessagingCallback is what a provider reported in one callback request
+type MessagingCallback struct {
+	Statuses []MessageStatusUpdate
+	Inbound  []InboundMessage
+}
+
+// MessageStatusUpdate is a delivery status for a sent message
+type MessageStatusUpdate struct {
+	ProviderMessageID string
+	Status            string // sent, delivered, read or failed
+	Error             string
+	Timestamp         time.Time
+}
+
+// InboundMessage is a message a customer sent back, used for opt-outs
+type InboundMessage struct {
+	Channel string
+	From    string
+	Body    string
+}
+
+// providerRequest sends a request and returns the body of a 2xx response
+func providerRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("provider returned status %d: %s", resp.StatusCode, TruncateString(string(body), 300))
+	}
+	return body, nil
+}
+
+// ========================================
+// WHATSAPP CLOUD API
+// ========================================
+
+// WhatsAppCloudProvider sends through Meta's WhatsApp Cloud API
+type WhatsAppCloudProvider struct {
+	BaseURL       string // https://graph.facebook.com
+	APIVersion    string
+	PhoneNumberID string
+	AccessToken   string
+	AppSecret     string
+	HTTP          *http.Client
+}
+
+// Name identifies the provider in logs and callback URLs
+func (p *WhatsAppCloudProvider) Name() string { return "whatsapp_cloud" }
+
+// Send posts a text, document or template message
+func (p *WhatsAppCloudProvider) Send(msg *OutboundMessage) (string, error) {
+	payload := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"recipient_type":    "individual",
+		"to":                strings.TrimPrefix(msg.To, "+"),
+	}
+
+	switch {
+	case msg.Template != nil:
+		parameters := make([]map[string]string, len(msg.Template.Params))
+		for i, value := range msg.Template.Params {
+			parameters[i] = map[string]string{"type": "text", "text": value}
+		}
+		template := map[string]interface{}{
+			"name":     msg.Template.Name,
+			"language": map[string]string{"code": msg.Template.Language},
+		}
+		if len(parameters) > 0 {
+			template["components"] = []map[string]interface{}{{"type": "body", "parameters": parameters}}
+		}
+		payload["type"] = "template"
+		payload["template"] = template
+	case msg.MediaURL != "":
+		payload["type"] = "document"
+		payload["document"] = map[string]string{"link": msg.MediaURL, "filename": msg.Filename, "caption": msg.Body}
+	default:
+		payload["type"] = "text"
+		payload["text"] = map[string]interface{}{"body": msg.Body, "preview_url": false}
+	}
+
+	data, _ := json.Marshal(payload)
+	endpoint := fmt.Sprintf("%s/%s/%s/messages", strings.TrimRight(p.BaseURL, "/"), p.APIVersion, p.PhoneNumberID)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.AccessToken)
+
+	body, err := providerRequest(p.HTTP, req)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || len(result.Messages) == 0 {
+		return "", fmt.Errorf("unexpected WhatsApp response: %s", TruncateString(string(body), 300))
+	}
+	return result.Messages[0].ID, nil
+}
+
+// ParseCallback verifies X-Hub-Signature-256 and reads statuses and
+// inbound messages from a webhook notification
+func (p *WhatsAppCloudProvider) ParseCallback(r *http.Request, body []byte, callbackURL string) (*MessagingCallback, error) {
+	if p.AppSecret != "" {
+		mac := hmac.New(sha256.New, []byte(p.AppSecret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Hub-Signature-256"))) {
+			return nil, ErrBadCallbackSignature
+		}
+	}
+
+	var notification struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Statuses []struct {
+						ID        string `json:"id"`
+						Status    string `json:"status"`
+						Timestamp string `json:"timestamp"`
+						Errors    []struct {
+							Code  int    `json:"code"`
+							Title string `json:"title"`
+						} `json:"errors"`
+					} `json:"statuses"`
+					Messages []struct {
+						From string `json:"from"`
+						Type string `json:"type"`
+						Text struct {
+							Body string `json:"body"`
+						} `json:"text"`
+					} `json:"messages"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+
+	callback := &MessagingCallback{}
+	for _, entry := range notification.Entry {
+		for _, change := range entry.Changes {
+			for _, s := range change.Value.Statuses {
+				update := MessageStatusUpdate{ProviderMessageID: s.ID, Status: s.Status}
+				if ts, err := strconv.ParseInt(s.Timestamp, 10, 64); err == nil {
+					update.Timestamp = time.Unix(ts, 0)
+				}
+				for _, e := range s.Errors {
+					update.Error = fmt.Sprintf("%d %s", e.Code, e.Title)
+				}
+				callback.Statuses = append(callback.Statuses, update)
+			}
+			for _, m := range change.Value.Messages {
+				if m.Type == "text" {
+					callback.Inbound = append(callback.Inbound, InboundMessage{Channel: "whatsapp", From: "+" + m.From, Body: m.Text.Body})
+				}
+			}
+		}
+	}
+	return callback, nil
+}
+
+// ========================================
+// TWILIO
+// ========================================
+
+// TwilioProvider sends WhatsApp and SMS messages through Twilio's
+// Messages API. Templates are Twilio Content SIDs.
+type TwilioProvider struct {
+	BaseURL      string // https://api.twilio.com
+	AccountSID   string
+	AuthToken    string
+	WhatsAppFrom string // e.g. +14155238886
+	SMSFrom      string
+	CallbackURL  string
+	HTTP         *http.Client
+}
+
+// Name identifies the provider in logs and callback URLs
+func (p *TwilioProvider) Name() string { return "twilio" }
+
+// address adds Twilio's whatsapp: prefix on the WhatsApp channel
+func (p *TwilioProvider) address(channel, number string) string {
+	if channel == ChannelWhatsApp {
+		return "whatsapp:" + number
+	}
+	return number
+}
+
+// Send creates a message resource
+func (p *TwilioProvider) Send(msg *OutboundMessage) (string, error) {
+	from := p.SMSFrom
+	if msg.Channel == ChannelWhatsApp {
+		from = p.WhatsAppFrom
+	}
+	if from == "" {
+		return "", fmt.Errorf("no Twilio sender configured for %s", msg.Channel)
+	}
+
+	form := url.Values{
+		"To":   {p.address(msg.Channel, msg.To)},
+		"From": {p.address(msg.Channel, from)},
+	}
+	if msg.Template != nil {
+		variables := map[string]string{}
+		for i, value := range msg.Template.Params {
+			variables[strconv.Itoa(i+1)] = value
+		}
+		encoded, _ := json.Marshal(variables)
+		form.Set("ContentSid", msg.Template.Name)
+		form.Set("ContentVariables", string(encoded))
+	} else {
+		form.Set("Body", msg.Body)
+		if msg.MediaURL != "" {
+			form.Set("MediaUrl", msg.MediaURL)
+		}
+	}
+	if p.CallbackURL != "" {
+		form.Set("StatusCallback", p.CallbackURL)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(p.BaseURL, "/"), p.AccountSID)
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.AccountSID, p.AuthToken)
+
+	body, err := providerRequest(p.HTTP, req)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		SID string `json:"sid"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.SID == "" {
+		return "", fmt.Errorf("unexpected Twilio response: %s", TruncateString(string(body), 300))
+	}
+	return result.SID, nil
+}
+
+// ParseCallback verifies X-Twilio-Signature and reads a status callback or
+// an incoming message
+func (p *TwilioProvider) ParseCallback(r *http.Request, body []byte, callbackURL string) (*MessagingCallback, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the full URL followed by each POST parameter
+	// name and value, sorted by name
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	signed := callbackURL
+	for _, k := range keys {
+		for _, v := range form[k] {
+			signed += k + v
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(p.AuthToken))
+	mac.Write([]byte(signed))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Twilio-Signature"))) {
+		return nil, ErrBadCallbackSignature
+	}
+
+	callback := &MessagingCallback{}
+	if status := form.Get("MessageStatus"); status != "" {
+		update := MessageStatusUpdate{ProviderMessageID: form.Get("MessageSid"), Timestamp: time.Now()}
+		switch status {
+		case "sent", "delivered", "read":
+			update.Status = status
+		case "failed", "undelivered":
+			update.Status = MessageFailed
+			update.Error = strings.TrimSpace(form.Get("ErrorCode") + " " + form.Get("ErrorMessage"))
+		}
+		if update.Status != "" {
+			callback.Statuses = append(callback.Statuses, update)
+		}
+	} else if from := form.Get("From"); from != "" {
+		channel := ChannelSMS
+		if strings.HasPrefix(from, "whatsapp:") {
+			channel = ChannelWhatsApp
+		}
+		callback.Inbound = append(callback.Inbound, InboundMessage{
+			Channel: channel,
+			From:    strings.TrimPrefix(from, "whatsapp:"),
+			Body:    form.Get("Body"),
+		})
+	}
+	return callback, nil
+}
+
+// ========================================
+// GENERIC HTTP PROVIDER
+// ========================================
+
+// GenericHTTPProvider posts messages as JSON to any gateway that accepts
+//
+//	{"channel", "to", "body", "template": {"name", "language", "params"},
+//	 "media_url", "filename", "callback_url"}
+//
+// and answers with {"id": "..."}. Callbacks are JSON {"id", "status",
+// "error"} or {"channel", "from", "body"}, signed with the same
+// X-Webhook-Signature scheme as our outbound webhooks.
+type GenericHTTPProvider struct {
+	URL         string
+	APIKey      string
+	Secret      string
+	CallbackURL string
+	HTTP        *http.Client
+}
+
+// Name identifies the provider in logs and callback URLs
+func (p *GenericHTTPProvider) Name() string { return "generic" }
+
+// Send posts the message
+func (p *GenericHTTPProvider) Send(msg *OutboundMessage) (string, error) {
+	payload := map[string]interface{}{
+		"channel": msg.Channel,
+		"to":      msg.To,
+		"body":    msg.Body,
+	}
+	if msg.Template != nil {
+		payload["template"] = map[string]interface{}{
+			"name":     msg.Template.Name,
+			"language": msg.Template.Language,
+			"params":   msg.Template.Params,
+		}
+	}
+	if msg.MediaURL != "" {
+		payload["media_url"] = msg.MediaURL
+		payload["filename"] = msg.Filename
+	}
+	if p.CallbackURL != "" {
+		payload["callback_url"] = p.CallbackURL
+	}
+
+	data, _ := json.Marshal(payload)
+	req, err := http.NewRequest("POST", p.URL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	body, err := providerRequest(p.HTTP, req)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		ID        string `json:"id"`
+		MessageID string `json:"message_id"`
+	}
+	json.Unmarshal(body, &result)
+	if result.ID == "" {
+		result.ID = result.MessageID
+	}
+	return result.ID, nil
+}
+
+// ParseCallback verifies the signature when a secret is set and reads a
+// status or inbound message
+func (p *GenericHTTPProvider) ParseCallback(r *http.Request, body []byte, callbackURL string) (*MessagingCallback, error) {
+	if p.Secret != "" {
+		header := r.Header.Get("X-Webhook-Signature")
+		var timestamp int64
+		for _, field := range strings.Split(header, ",") {
+			if strings.HasPrefix(field, "t=") {
+				timestamp, _ = strconv.ParseInt(strings.TrimPrefix(field, "t="), 10, 64)
+			}
+		}
+		expected := SignWebhookPayload(p.Secret, timestamp, body)
+		if timestamp == 0 || !hmac.Equal([]byte(expected), []byte(header)) {
+			return nil, ErrBadCallbackSignature
+		}
+		if age := time.Since(time.Unix(timestamp, 0)); age > 10*time.Minute || age < -10*time.Minute {
+			return nil, ErrBadCallbackSignature
+		}
+	}
+
+	var event struct {
+		ID      string `json:"id"`
+		Status  string `json:"status"`
+		Error   string `json:"error"`
+		Channel string `json:"channel"`
+		From    string `json:"from"`
+		Body    string `json:"body"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	callback := &MessagingCallback{}
+	if event.ID != "" && event.Status != "" {
+		callback.Statuses = append(callback.Statuses, MessageStatusUpdate{
+			ProviderMessageID: event.ID,
+			Status:            event.Status,
+			Error:             event.Error,
+			Timestamp:         time.Now(),
+		})
+	} else if event.From != "" {
+		callback.Inbound = append(callback.Inbound, InboundMessage{Channel: event.Channel, From: event.From, Body: event.Body})
+	}
+	return callback, nil
+}
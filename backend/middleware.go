@@ -1,13 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,9 +17,14 @@ import (
 // MIDDLEWARE
 // ========================================
 
-// AuthMiddleware validates JWT tokens
+// AuthMiddleware validates JWT tokens and the session they belong to
 func (a *App) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			a.authenticateAPIKeyRequest(c, key)
+			return
+		}
+
 		token := c.GetHeader("Authorization")
 		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authorization header"})
@@ -38,42 +45,38 @@ func (a *App) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Set user ID in context
-		c.Set("user_id", claims.UserID)
-		c.Set("role", claims.Role)
-		c.Set("email", claims.Email)
-
-		c.Next()
-	}
-}
-
-// RBACMiddleware enforces role-based access control
-func (a *App) RBACMiddleware(allowedRoles ...string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userRole, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		// Reject tokens of revoked sessions, deactivated users and stale roles
+		session, user, err := a.CheckSession(claims)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		role := userRole.(string)
-
-		// Check if user role is allowed
-		allowed := false
-		for _, allowedRole := range allowedRoles {
-			if role == allowedRole || role == "super_admin" {
-				allowed = true
-				break
-			}
+		// A forced password change comes before anything else, then 2FA
+		// enrollment for roles that require it
+		if user.MustChangePassword && !passwordChangeRoutes[c.FullPath()] {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrPasswordChangeRequired.Error(), "password_change_required": true})
+			c.Abort()
+			return
 		}
-
-		if !allowed {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		if !user.TOTPEnabled && a.TwoFactorRequiredFor(user.Role) && !twoFactorSetupRoutes[c.FullPath()] {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrTwoFactorEnforced.Error(), "two_factor_setup_required": true})
 			c.Abort()
 			return
 		}
 
+		// Set user ID in context
+		c.Set("user_id", claims.UserID)
+		c.Set("role", claims.Role)
+		c.Set("email", claims.Email)
+		c.Set("session_id", claims.SessionID)
+		if session.DeviceID != nil {
+			c.Set("device_id", *session.DeviceID)
+		} else if !a.attachDevice(c) {
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -83,7 +86,8 @@ func (a *App) CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID, X-Device-Token, X-Override-ID, X-API-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
 		if c.Request.Method == "OPTIONS" {
@@ -95,50 +99,65 @@ func (a *App) CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// LoggingMiddleware logs all requests
-func (a *App) LoggingMiddleware() gin.HandlerFunc {
+// RequestIDMiddleware tags each request with an ID, taken from the
+// X-Request-ID header when the client sends a usable one, and echoes it back
+func (a *App) RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		start := time.Now()
-
-		// Log request
-		method := c.Request.Method
-		path := c.Request.URL.Path
-		userID, _ := c.Get("user_id")
-
-		// Process request
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			buf := make([]byte, 16)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
 		c.Next()
+	}
+}
 
-		// Log response
-		latency := time.Since(start)
-		status := c.Writer.Status()
-
-		// Create audit log (for critical operations)
-		if userID != nil && (method == "POST" || method == "PUT" || method == "DELETE") {
-			a.CreateAuditLog(userID.(uint), method+" "+path, "", nil, nil)
+// validRequestID accepts up to 64 letters, digits, dashes and underscores
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
 		}
-
-		fmt.Printf("[%s] %s %s - Status: %d - Latency: %v - UserID: %v",
-			start.Format("2006-01-02 15:04:05"),
-			method,
-			path,
-			status,
-			latency,
-			userID)
 	}
+	return true
 }
 
-// RateLimitMiddleware implements basic rate limiting
-func (a *App) RateLimitMiddleware() gin.HandlerFunc {
+// LoggingMiddleware audits signed-in mutating requests whose handler didn't
+// record an entry of its own, by route and status
+func (a *App) LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Simplified rate limiting
-		// In production, use redis or in-memory store
+		start := time.Now()
+		method := c.Request.Method
+
+		// Process request
 		c.Next()
+
+		if method != "POST" && method != "PUT" && method != "PATCH" && method != "DELETE" {
+			return
+		}
+		if c.GetUint("user_id") == 0 || c.GetBool(auditedKey) {
+			return
+		}
+		a.Audit(c, AuditEntry{
+			Action: method + " " + c.FullPath(),
+			Details: gin.H{
+				"path":       c.Request.URL.Path,
+				"status":     c.Writer.Status(),
+				"latency_ms": time.Since(start).Milliseconds(),
+			},
+		})
 	}
 }
 
 // ValidateJWTToken validates a JWT token and returns claims
 func (a *App) ValidateJWTToken(tokenString string) (*JWTClaims, error) {
-	// Parse token
+	// Parse token; expiry is checked by the parser
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// Validate signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -154,32 +173,38 @@ func (a *App) ValidateJWTToken(tokenString string) (*JWTClaims, error) {
 	}
 
 	// Extract claims
-	if claims, ok := token.Claims.(*JWTClaims); ok && claims.Valid(time.Now()) {
+	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid && claims.SessionID != 0 {
 		return claims, nil
 	}
 
 	return nil, fmt.Errorf("invalid token")
 }
 
-// GenerateJWTToken generates a new JWT token
-func (a *App) GenerateJWTToken(userID uint, email, role string) (string, error) {
+// GenerateJWTToken generates a short-lived access token for a session
+func (a *App) GenerateJWTToken(user User, sessionID uint) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(a.Config.JWT.Expiration)
+
 	// Create claims
 	claims := &JWTClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		ExpiresAt: time.Now().Add(a.Config.JWT.Expiration),
-		IssuedAt:  time.Now(),
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
 	}
 
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(a.Config.JWT.Secret))
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return tokenString, nil
+	return tokenString, expiresAt, nil
 }
 
 // JWTClaims represents JWT token claims
@@ -187,30 +212,10 @@ type JWTClaims struct {
 	UserID    uint   `json:"user_id"`
 	Email     string `json:"email"`
 	Role      string `json:"role"`
-	ExpiresAt int64  `json:"exp"`
-	IssuedAt  int64  `json:"iat"`
+	SessionID uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// ========================================
-// AUDIT LOGGING
-// ========================================
-
-// CreateAuditLog creates an audit log entry
-func (a *App) CreateAuditLog(userID uint, action, entity string, entityID *uint, changes interface{}) {
-	log := &AuditLog{
-		UserID:    userID,
-		Action:    action,
-		Entity:    entity,
-		EntityID:  entityID,
-		Changes:   a.InterfaceToJSON(changes),
-		IPAddress: a.GetClientIP(a.Server),
-		UserAgent: a.GetUserAgent(a.Server),
-	}
-
-	a.DB.Create(log)
-}
-
 // ========================================
 // VALIDATION
 // ========================================
@@ -242,13 +247,16 @@ func CheckPassword(password, hash string) bool {
 // HELPERS
 // ========================================
 
-// InterfaceToJSON converts interface to JSON string
+// InterfaceToJSON converts interface to JSON string, or "" for nil
 func (a *App) InterfaceToJSON(data interface{}) string {
 	if data == nil {
 		return ""
 	}
-	// Simplified - in production use json.Marshal
-	return fmt.Sprintf("%v", data)
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
 }
 
 // GetClientIP extracts client IP address
@@ -346,17 +354,25 @@ func (a *App) SeedData() {
 		a.DB.Create(&settings)
 	}
 
-	// Seed default user (password: admin123)
-	if a.DB.Where("email = ?", "admin@restaurant.com").First(&User{}).RowsAffected == 0 {
-		hashedPassword, _ := HashPassword("admin123")
+	// Seed permissions and default roles
+	if err := a.SeedRoles(); err != nil {
+		fmt.Printf("Failed to seed roles: %v\n", err)
+	}
+
+	// Seed default user; its password has to be changed at first sign-in
+	if a.DB.Where("email = ?", DefaultAdminEmail).First(&User{}).RowsAffected == 0 {
+		hashedPassword, _ := HashPassword(DefaultAdminPassword)
 		user := User{
-			Email:    "admin@restaurant.com",
-			Password: hashedPassword,
-			Name:     "Admin User",
-			Role:     "super_admin",
-			IsActive: true,
+			Email:              DefaultAdminEmail,
+			Password:           hashedPassword,
+			Name:               "Admin User",
+			Role:               "super_admin",
+			IsActive:           true,
+			MustChangePassword: true,
 		}
 		a.DB.Create(&user)
+	} else {
+		a.FlagSeededCredentials()
 	}
 
 	// Seed default categories
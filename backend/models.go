@@ -15,13 +15,189 @@ type User struct {
 	Password  string    `json:"-" gorm:"not null"` // Never send password in JSON
 	Name      string    `json:"name" gorm:"not null"`
 	NameAr    string    `json:"name_ar"`
-	Role      string    `json:"role" gorm:"not null;default:'staff'"`
+	Role      string    `json:"role" gorm:"size:50;not null;default:'cashier'"`
 	Phone     string    `json:"phone"`
 	Avatar    string    `json:"avatar"`
 	IsActive  bool      `json:"is_active" gorm:"default:true"`
 	LastLogin *time.Time `json:"last_login"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// PIN login on shared terminals; PINs are unique within a location
+	Location          string     `json:"location" gorm:"size:100;index"`
+	PINHash           string     `json:"-"`
+	PINSetAt          *time.Time `json:"pin_set_at"`
+	FailedPINAttempts int        `json:"-" gorm:"default:0"`
+	PINLockedUntil    *time.Time `json:"pin_locked_until"`
+
+	// Password sign-in protection
+	FailedLoginAttempts int        `json:"-" gorm:"default:0"`
+	LastFailedLoginAt   *time.Time `json:"-"`
+	LoginLockedUntil    *time.Time `json:"login_locked_until"`
+	MustChangePassword  bool       `json:"must_change_password" gorm:"default:false"`
+	PasswordChangedAt   *time.Time `json:"password_changed_at"`
+
+	// TOTP two-factor authentication; the secret is set on enrollment and
+	// only used for sign-in once enabled
+	TOTPSecret    string     `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabled   bool       `json:"totp_enabled" gorm:"column:totp_enabled;default:false"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at" gorm:"column:totp_enabled_at"`
+	TOTPLastStep  int64      `json:"-" gorm:"column:totp_last_step;default:0"`
+
+	// Service users act for an API key and can't sign in
+	IsService bool `json:"is_service" gorm:"default:false"`
+}
+
+// RecoveryCode model - a single-use 2FA recovery code, stored as its SHA-256 hash
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"size:64;not null;index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// APIKey model - a credential for an integration or machine client. The
+// key acts as its own service user; only a hash of the secret is stored.
+type APIKey struct {
+	ID                 uint       `json:"id" gorm:"primaryKey"`
+	Name               string     `json:"name" gorm:"size:100;not null"`
+	Prefix             string     `json:"prefix" gorm:"size:20;not null"`
+	KeyHash            string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	PreviousKeyHash    *string    `json:"-" gorm:"size:64;index"`
+	PreviousExpiresAt  *time.Time `json:"previous_expires_at"`
+	Scopes             string     `json:"scopes" gorm:"type:json;not null"` // JSON array of scope names
+	UserID             uint       `json:"user_id" gorm:"not null;index"`
+	User               *User      `json:"-" gorm:"foreignKey:UserID"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute" gorm:"default:0"` // 0 uses the per-user limit
+	RateLimitBurst     int        `json:"rate_limit_burst" gorm:"default:0"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+	LastUsedAt         *time.Time `json:"last_used_at"`
+	LastUsedIP         string     `json:"last_used_ip" gorm:"size:45"`
+	RevokedAt          *time.Time `json:"revoked_at"`
+	CreatedBy          uint       `json:"created_by"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// IntegrationCredential model - a provider secret, stored encrypted
+type IntegrationCredential struct {
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	Name      string          `json:"name" gorm:"size:100;uniqueIndex;not null"`
+	Value     EncryptedString `json:"-" gorm:"type:text;not null"`
+	UpdatedBy uint            `json:"updated_by"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Role model - a named set of permissions; User.Role holds the name
+type Role struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"size:50;uniqueIndex;not null"`
+	NameAr      string       `json:"name_ar"`
+	Description string       `json:"description"`
+	IsSystem    bool         `json:"is_system" gorm:"default:false"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+
+	UserCount int `json:"user_count" gorm:"-"`
+}
+
+// Permission model - an action a role can be allowed, e.g. orders.void
+type Permission struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Code        string `json:"code" gorm:"size:100;uniqueIndex;not null"`
+	Category    string `json:"category" gorm:"size:50"`
+	Description string `json:"description"`
+}
+
+// AuthSession model - one signed-in device; its refresh tokens rotate
+// within the session and revoking it ends every token issued for it
+type AuthSession struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"not null;index"`
+	DeviceID      *uint      `json:"device_id" gorm:"index"`
+	IdleTimeout   int        `json:"idle_timeout"` // minutes without a request before the session locks; 0 never
+	IPAddress     string     `json:"ip_address"`
+	UserAgent     string     `json:"user_agent" gorm:"type:text"`
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"index"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	Current bool `json:"current" gorm:"-"` // the session making the request
+}
+
+// Device model - a registered terminal: POS, kitchen screen, waiter
+// handheld or customer display. TokenHash is set once the device is paired.
+type Device struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	Name              string     `json:"name" gorm:"not null"`
+	Kind              string     `json:"kind" gorm:"size:20;not null;default:'pos';index"` // pos, kds, handheld, customer_display
+	Location          string     `json:"location" gorm:"size:100;index"`
+	Station           string     `json:"station" gorm:"size:50"` // kitchen station a KDS shows, e.g. "grill"
+	Printers          []Printer  `json:"printers,omitempty" gorm:"many2many:device_printers;"`
+	TokenHash         *string    `json:"-" gorm:"size:64;uniqueIndex"`
+	PairingCodeHash   *string    `json:"-" gorm:"size:64;index"`
+	PairingExpiresAt  *time.Time `json:"pairing_expires_at"`
+	PairedAt          *time.Time `json:"paired_at"`
+	AutoLockMinutes   int        `json:"auto_lock_minutes" gorm:"default:5"`
+	FailedPINAttempts int        `json:"failed_pin_attempts" gorm:"default:0"`
+	PINLockedUntil    *time.Time `json:"pin_locked_until"`
+	IsActive          bool       `json:"is_active" gorm:"default:true"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	AppVersion        string     `json:"app_version" gorm:"size:50"`
+	Connected         bool       `json:"connected" gorm:"default:false"` // has an open WebSocket
+	LastSeenAt        *time.Time `json:"last_seen_at"`
+	LastIP            string     `json:"last_ip" gorm:"size:45"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	Status string `json:"status" gorm:"-"` // pending, active, disabled or revoked
+	Online bool   `json:"online" gorm:"-"` // connected with a recent heartbeat
+}
+
+// ManagerOverride model - a request for a supervisor to approve one
+// sensitive action
+type ManagerOverride struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Action      string     `json:"action" gorm:"size:50;not null;index"`
+	Permission  string     `json:"permission" gorm:"size:100;not null"`
+	Entity      string     `json:"entity" gorm:"size:50"`
+	EntityID    uint       `json:"entity_id"`
+	Summary     string     `json:"summary"`
+	Details     string     `json:"details" gorm:"type:json"`
+	Fingerprint string     `json:"-" gorm:"size:64;not null;index"`
+	Status      string     `json:"status" gorm:"size:20;not null;default:'pending';index"` // pending, approved, denied, used
+	RequestedBy uint       `json:"requested_by" gorm:"not null;index"`
+	SessionID   uint       `json:"session_id"`
+	DeviceID    *uint      `json:"device_id"`
+	DecidedBy   *uint      `json:"decided_by"`
+	Method      string     `json:"method" gorm:"size:10"` // "pin" or "remote"
+	Reason      string     `json:"reason"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// RefreshToken model - a single-use refresh token, stored as its SHA-256 hash
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	SessionID uint       `json:"session_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RateLimitBucket model - a token bucket of the database rate limit store
+type RateLimitBucket struct {
+	Key       string    `gorm:"column:bucket_key;primaryKey;size:191"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"index"`
 }
 
 // RestaurantSettings model
@@ -33,11 +209,13 @@ type RestaurantSettings struct {
 	Address          string    `json:"address"`
 	Phone            string    `json:"phone"`
 	Email            string    `json:"email"`
+	TaxNumber        string    `json:"tax_number"`
 	Currency         string    `json:"currency" gorm:"default:'EGP'"`
 	CurrencySymbol   string    `json:"currency_symbol" gorm:"default:'ج.م'"`
 	TaxRate          float64   `json:"tax_rate" gorm:"default:0.14"`
 	ServiceCharge    float64   `json:"service_charge" gorm:"default:0.10"`
 	Language         string    `json:"language" gorm:"default:'ar'"`
+	Timezone         string    `json:"timezone" gorm:"default:'Africa/Cairo'"` // IANA zone used for reports
 	ThemeColor       string    `json:"theme_color" gorm:"default:'#10b981'"`
 	IsOpen           bool      `json:"is_open" gorm:"default:true"`
 	ReceiptHeader    string    `json:"receipt_header" gorm:"type:text"`
@@ -88,6 +266,8 @@ type MenuItem struct {
 	PreparationTime int     `json:"preparation_time"` // in minutes
 	IsModifierOnly  bool    `json:"is_modifier_only" gorm:"default:false"`
 	OrderCount      int     `json:"order_count" gorm:"default:0"`
+	ETAItemType     string  `json:"eta_item_type" gorm:"column:eta_item_type"` // "EGS" or "GS1"
+	ETAItemCode     string  `json:"eta_item_code" gorm:"column:eta_item_code"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 }
@@ -158,13 +338,14 @@ type Table struct {
 type Reservation struct {
 	ID             uint       `json:"id" gorm:"primaryKey"`
 	CustomerName    string     `json:"customer_name" gorm:"not null"`
-	CustomerPhone   string     `json:"customer_phone" gorm:"not null"`
+	CustomerPhone   EncryptedString `json:"customer_phone" gorm:"size:255;not null"`
 	TableID        *uint      `json:"table_id"`
 	Table          *Table     `json:"table,omitempty" gorm:"foreignKey:TableID"`
 	PartySize       int        `json:"party_size" gorm:"not null"`
 	ReservationTime time.Time  `json:"reservation_time" gorm:"not null"`
 	Status         string     `json:"status" gorm:"not null;default:'pending'"`
 	Notes          string     `json:"notes"`
+	ReminderSentAt *time.Time `json:"reminder_sent_at"`
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
 }
@@ -176,12 +357,14 @@ type Order struct {
 	TableID         *uint        `json:"table_id"`
 	Table           *Table       `json:"table,omitempty" gorm:"foreignKey:TableID"`
 	UserID          uint         `json:"user_id" gorm:"not null"`
+	DeviceID        *uint        `json:"device_id" gorm:"index"` // terminal the order was taken on
 	Type            string       `json:"type" gorm:"not null;default:'dine_in'"`
 	Status          string       `json:"status" gorm:"not null;default:'pending'"`
 	Priority        string       `json:"priority" gorm:"not null;default:'normal'"`
+	Guests          int          `json:"guests" gorm:"default:0"` // covers; 0 when not recorded
 	CustomerName    string       `json:"customer_name"`
-	CustomerPhone   string       `json:"customer_phone"`
-	CustomerAddress string       `json:"customer_address"`
+	CustomerPhone   EncryptedString `json:"customer_phone" gorm:"size:255"`
+	CustomerAddress EncryptedString `json:"customer_address" gorm:"type:text"`
 	Subtotal        float64      `json:"subtotal" gorm:"default:0"`
 	TaxAmount       float64      `json:"tax_amount" gorm:"default:0"`
 	ServiceCharge   float64      `json:"service_charge" gorm:"default:0"`
@@ -199,6 +382,9 @@ type Order struct {
 	Notes           string       `json:"notes" gorm:"type:text"`
 	KitchenNotes    string       `json:"kitchen_notes" gorm:"type:text"`
 	VoiceNoteURL    string       `json:"voice_note_url"`
+	ETAUUID         string       `json:"eta_uuid" gorm:"column:eta_uuid;size:64"`
+	ETAStatus       string       `json:"eta_status" gorm:"column:eta_status"`
+	ETAQRURL        string       `json:"eta_qr_url" gorm:"column:eta_qr_url;size:500"`
 	Items           []OrderItem  `json:"items,omitempty" gorm:"foreignKey:OrderID"`
 	Payments        []Payment    `json:"payments,omitempty" gorm:"foreignKey:OrderID"`
 }
@@ -225,6 +411,7 @@ type Payment struct {
 	OrderID         uint      `json:"order_id" gorm:"not null"`
 	Order           *Order    `json:"order,omitempty" gorm:"foreignKey:OrderID"`
 	UserID          uint      `json:"user_id" gorm:"not null"`
+	DeviceID        *uint     `json:"device_id" gorm:"index"`
 	Type            string    `json:"type" gorm:"not null;default:'sale'"`
 	Method          string    `json:"method" gorm:"not null;default:'cash'"`
 	Amount          float64   `json:"amount" gorm:"not null"`
@@ -247,6 +434,7 @@ type StockItem struct {
 	Supplier      string     `json:"supplier"`
 	IsLowStock    bool       `json:"is_low_stock" gorm:"default:false"`
 	LastReorderAt *time.Time `json:"last_reorder_at"`
+	ExpiryDate    *time.Time `json:"expiry_date" gorm:"index"` // earliest expiry of the stock on hand
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 	Movements     []StockMovement `json:"movements,omitempty" gorm:"foreignKey:StockItemID"`
@@ -265,6 +453,31 @@ type StockMovement struct {
 	CreatedAt   time.Time  `json:"created_at"`
 }
 
+// RecipeLine model - stock used by one unit of a menu item
+type RecipeLine struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	MenuItemID  uint       `json:"menu_item_id" gorm:"not null;uniqueIndex:idx_recipe_item_stock"`
+	StockItemID uint       `json:"stock_item_id" gorm:"not null;uniqueIndex:idx_recipe_item_stock"`
+	StockItem   *StockItem `json:"stock_item,omitempty" gorm:"foreignKey:StockItemID"`
+	Quantity    float64    `json:"quantity" gorm:"type:decimal(10,3);not null"` // in the stock item's unit
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ForecastOverride model - a holiday or event that scales the sales
+// forecast for a business date, or one daypart of it
+type ForecastOverride struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Date      string    `json:"date" gorm:"size:10;not null;uniqueIndex:idx_forecast_override"` // YYYY-MM-DD in the restaurant's time zone
+	Daypart   string    `json:"daypart" gorm:"size:50;not null;default:'';uniqueIndex:idx_forecast_override"`
+	Name      string    `json:"name" gorm:"not null"`
+	NameAr    string    `json:"name_ar"`
+	Factor    float64   `json:"factor" gorm:"not null;default:1"` // 0 closed, 1.5 half again as busy
+	Notes     string    `json:"notes" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // Shift model
 type Shift struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
@@ -280,14 +493,18 @@ type Shift struct {
 type Customer struct {
 	ID            uint              `json:"id" gorm:"primaryKey"`
 	Name          string            `json:"name" gorm:"not null"`
-	Phone         string            `json:"phone" gorm:"uniqueIndex;not null"`
-	Email         string            `json:"email"`
-	Address       string            `json:"address"`
+	Phone         EncryptedString   `json:"phone" gorm:"size:255;not null"`
+	PhoneIndex    *string           `json:"-" gorm:"size:64;uniqueIndex"`
+	Email         EncryptedString   `json:"email" gorm:"type:text"`
+	Address       EncryptedString   `json:"address" gorm:"type:text"`
 	Points        int               `json:"points" gorm:"default:0"`
 	TotalSpent    float64           `json:"total_spent" gorm:"default:0"`
 	VisitsCount   int               `json:"visits_count" gorm:"default:0"`
 	Birthday      *time.Time        `json:"birthday"`
 	Preferences   string            `json:"preferences" gorm:"type:json"`
+	WhatsAppOptOut bool             `json:"whatsapp_opt_out" gorm:"column:whatsapp_opt_out;default:false"`
+	SMSOptOut     bool              `json:"sms_opt_out" gorm:"column:sms_opt_out;default:false"`
+	OptedOutAt    *time.Time        `json:"opted_out_at"`
 	CreatedAt     time.Time         `json:"created_at"`
 	UpdatedAt     time.Time         `json:"updated_at"`
 	LoyaltyTransactions []LoyaltyTransaction `json:"loyalty_transactions,omitempty" gorm:"foreignKey:CustomerID"`
@@ -329,8 +546,10 @@ type Discount struct {
 type ReceiptTemplate struct {
 	ID           uint      `json:"id" gorm:"primaryKey"`
 	Name         string    `json:"name" gorm:"not null"`
+	Kind         string    `json:"kind" gorm:"not null;default:'receipt'"` // "receipt", "kitchen"
 	IsDefault    bool      `json:"is_default" gorm:"default:false"`
 	TemplateHTML string    `json:"template_html" gorm:"type:text;not null"`
+	TemplateText string    `json:"template_text" gorm:"type:text"` // thermal printers and WhatsApp
 	CSSStyles    string    `json:"css_styles" gorm:"type:text"`
 	ShowLogo     bool      `json:"show_logo" gorm:"default:true"`
 	ShowQR        bool      `json:"show_qr" gorm:"default:false"`
@@ -348,6 +567,14 @@ type Printer struct {
 	IsDefault       bool      `json:"is_default" gorm:"default:false"`
 	IsActive        bool      `json:"is_active" gorm:"default:true"`
 	PrintCategories string    `json:"print_categories" gorm:"type:json"`
+	PaperWidth      int       `json:"paper_width" gorm:"default:80"` // mm: 58 or 80
+	CodePage        string    `json:"code_page" gorm:"default:'PC864'"`
+	CodePageTable   int       `json:"code_page_table" gorm:"default:0"` // ESC t number, 0 = driver default
+	OpenDrawer      bool      `json:"open_drawer" gorm:"default:false"` // kick the cash drawer on cash receipts
+	BackupPrinterID *uint     `json:"backup_printer_id"`
+	Status          string    `json:"status" gorm:"default:'unknown'"` // "unknown", "online", "offline"
+	LastError       string    `json:"last_error" gorm:"type:text"`
+	LastSeenAt      *time.Time `json:"last_seen_at"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 }
@@ -356,13 +583,13 @@ type Printer struct {
 type DailyReport struct {
 	ID               uint      `json:"id" gorm:"primaryKey"`
 	ReportDate       time.Time `json:"report_date" gorm:"uniqueIndex;not null"`
-	TotalOrders      int       `json:"total_orders" gorm:"default:0"`
+	TotalOrders      int64     `json:"total_orders" gorm:"default:0"`
 	TotalRevenue     float64   `json:"total_revenue" gorm:"default:0"`
 	TotalCost        float64   `json:"total_cost" gorm:"default:0"`
 	GrossProfit      float64   `json:"gross_profit" gorm:"default:0"`
-	DineInOrders    int       `json:"dine_in_orders" gorm:"default:0"`
-	TakeawayOrders   int       `json:"takeaway_orders" gorm:"default:0"`
-	DeliveryOrders   int       `json:"delivery_orders" gorm:"default:0"`
+	DineInOrders    int64     `json:"dine_in_orders" gorm:"default:0"`
+	TakeawayOrders   int64     `json:"takeaway_orders" gorm:"default:0"`
+	DeliveryOrders   int64     `json:"delivery_orders" gorm:"default:0"`
 	CashPayments     float64   `json:"cash_payments" gorm:"default:0"`
 	CardPayments     float64   `json:"card_payments" gorm:"default:0"`
 	WalletPayments   float64   `json:"wallet_payments" gorm:"default:0"`
@@ -372,35 +599,298 @@ type DailyReport struct {
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
-// AuditLog model
+// AuditLog model - one hash-chained audit entry. Changes holds the changed
+// fields as {"field": {"from": .., "to": ..}}; UserID 0 is the system.
 type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"not null;index"`
+	ApproverID *uint     `json:"approver_id" gorm:"index"`
+	Action     string    `json:"action" gorm:"size:100;not null;index"`
+	Entity     string    `json:"entity" gorm:"size:100;index:idx_audit_entity"`
+	EntityID   *uint     `json:"entity_id" gorm:"index:idx_audit_entity"`
+	Changes    string    `json:"changes" gorm:"type:json;default:null"`
+	Metadata   string    `json:"metadata" gorm:"type:json;default:null"`
+	RequestID  string    `json:"request_id" gorm:"size:64;index"`
+	SessionID  *uint     `json:"session_id"`
+	APIKeyID   *uint     `json:"api_key_id" gorm:"column:api_key_id;index"`
+	DeviceID   *uint     `json:"device_id" gorm:"index"`
+	IPAddress  string    `json:"ip_address" gorm:"size:45"`
+	UserAgent  string    `json:"user_agent" gorm:"type:text"`
+	PrevHash   string    `json:"prev_hash" gorm:"size:64"`
+	Hash       string    `json:"hash" gorm:"size:64"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// AuditChainHead model - the newest entry of the audit hash chain, in a
+// single row that is locked while an entry is appended
+type AuditChainHead struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
-	UserID    uint      `json:"user_id" gorm:"not null"`
-	Action    string    `json:"action" gorm:"not null"`
-	Entity    string    `json:"entity"`
-	EntityID  *uint     `json:"entity_id"`
-	Changes   string    `json:"changes" gorm:"type:json"`
-	Metadata  string    `json:"metadata" gorm:"type:json"`
-	IPAddress string    `json:"ip_address"`
-	UserAgent string    `json:"user_agent" gorm:"type:text"`
-	CreatedAt time.Time `json:"created_at"`
+	EntryID   uint      `json:"entry_id"`
+	Hash      string    `json:"hash" gorm:"size:64"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WSEvent model - a sequenced WebSocket notification kept for replay
+type WSEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Room      string    `json:"room" gorm:"not null;uniqueIndex:idx_room_seq"`
+	Seq       uint64    `json:"seq" gorm:"not null;uniqueIndex:idx_room_seq"`
+	Type      string    `json:"type"`
+	Payload   string    `json:"payload" gorm:"type:json;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// OutboxEvent model - a domain event awaiting delivery to one destination
+type OutboxEvent struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	EventType     string     `json:"event_type" gorm:"not null;index"`
+	AggregateType string     `json:"aggregate_type" gorm:"not null"`
+	AggregateID   uint       `json:"aggregate_id" gorm:"not null"`
+	Destination   string     `json:"destination" gorm:"not null"`
+	Payload       string     `json:"payload" gorm:"type:json;not null"`
+	Status        string     `json:"status" gorm:"not null;default:'pending';index:idx_outbox_due"`
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts   int        `json:"max_attempts" gorm:"default:10"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index:idx_outbox_due"`
+	LockedAt      *time.Time `json:"locked_at"`
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// WebhookSubscription model - a partner endpoint notified of domain events
+type WebhookSubscription struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"not null"`
+	URL         string    `json:"url" gorm:"not null"`
+	EventTypes  string    `json:"event_types" gorm:"type:json;not null"` // JSON array, "*" for all
+	Secret      EncryptedString `json:"-" gorm:"type:text;not null"`      // HMAC signing secret
+	Description string    `json:"description"`
+	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery model - one event sent to one subscription
+type WebhookDelivery struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	SubscriptionID uint       `json:"subscription_id" gorm:"not null;index"`
+	OutboxEventID  *uint      `json:"outbox_event_id" gorm:"index"`
+	EventType      string     `json:"event_type" gorm:"not null"`
+	Payload        string     `json:"payload" gorm:"type:json;not null"`
+	Status         string     `json:"status" gorm:"not null;default:'pending';index:idx_webhook_due"`
+	Attempts       int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts    int        `json:"max_attempts" gorm:"default:8"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index:idx_webhook_due"`
+	LockedAt       *time.Time `json:"locked_at"`
+	ResponseCode   int        `json:"response_code"`
+	ResponseBody   string     `json:"response_body" gorm:"type:text"`
+	DurationMs     int64      `json:"duration_ms"`
+	LastError      string     `json:"last_error" gorm:"type:text"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// PrintJob model - rendered ESC/POS output queued for a printer
+type PrintJob struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	PrinterID      uint       `json:"printer_id" gorm:"not null;index:idx_print_jobs_due"`
+	Printer        *Printer   `json:"printer,omitempty" gorm:"foreignKey:PrinterID"`
+	FailedOverFrom *uint      `json:"failed_over_from"`
+	OrderID        *uint      `json:"order_id" gorm:"index"`
+	Kind           string     `json:"kind" gorm:"not null"` // "receipt", "kitchen", "bar", "test", "drawer"
+	Reference      string     `json:"reference" gorm:"index"`
+	ReprintOf      *uint      `json:"reprint_of"`
+	Data           []byte     `json:"-" gorm:"type:mediumblob;not null"`
+	Status         string     `json:"status" gorm:"not null;default:'pending';index:idx_print_jobs_due"`
+	Attempts       int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts    int        `json:"max_attempts" gorm:"default:4"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index:idx_print_jobs_due"`
+	LockedAt       *time.Time `json:"locked_at"`
+	LastError      string     `json:"last_error" gorm:"type:text"`
+	PrintedAt      *time.Time `json:"printed_at"`
+	RequestedBy    uint       `json:"requested_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ETAReceipt model - an e-receipt issued to the Egyptian Tax Authority for
+// an order. Receipts from one POS device form a chain through PreviousUUID.
+type ETAReceipt struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	OrderID       uint       `json:"order_id" gorm:"not null;index"`
+	ReceiptNumber string     `json:"receipt_number" gorm:"not null"`
+	UUID          string     `json:"uuid" gorm:"column:uuid;size:64;uniqueIndex;not null"`
+	PreviousUUID  string     `json:"previous_uuid" gorm:"column:previous_uuid;size:64"`
+	DeviceSerial  string     `json:"device_serial" gorm:"size:100;not null;index"`
+	Payload       string     `json:"-" gorm:"type:mediumtext;not null"`
+	Total         float64    `json:"total"`
+	Status        string     `json:"status" gorm:"not null;default:'pending';index:idx_eta_receipts_due"`
+	SubmissionID  string     `json:"submission_id" gorm:"index"`
+	LongID        string     `json:"long_id"`
+	QRURL         string     `json:"qr_url" gorm:"column:qr_url;size:500"`
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index:idx_eta_receipts_due"`
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	IssuedAt      time.Time  `json:"issued_at"`
+	SubmittedAt   *time.Time `json:"submitted_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// EmailMessage model - an encoded email waiting in the outgoing mail queue
+type EmailMessage struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Sender        string     `json:"sender" gorm:"not null"`
+	Recipients    string     `json:"recipients" gorm:"type:text;not null"` // comma separated
+	Subject       string     `json:"subject" gorm:"size:500"`
+	Message       string     `json:"-" gorm:"type:mediumtext;not null"`
+	Size          int        `json:"size"`
+	Status        string     `json:"status" gorm:"not null;default:'pending';index:idx_email_due"`
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	MaxAttempts   int        `json:"max_attempts" gorm:"default:6"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index:idx_email_due"`
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// MessageTemplate model - a provider-approved message template used for
+// business-initiated WhatsApp and SMS messages
+type MessageTemplate struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Kind         string    `json:"kind" gorm:"not null;uniqueIndex:idx_message_template"` // receipt, daily_report, test
+	Channel      string    `json:"channel" gorm:"not null;uniqueIndex:idx_message_template"`
+	ExternalName string    `json:"external_name" gorm:"not null"` // template name or Twilio Content SID
+	Language     string    `json:"language" gorm:"default:'ar'"`
+	Params       string    `json:"params" gorm:"type:json"` // ordered placeholder keys
+	Status       string    `json:"status" gorm:"not null;default:'approved'"`
+	IsActive     bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// MessageLog model - one outbound WhatsApp or SMS message and its delivery
+// status as reported by the provider
+type MessageLog struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	Channel           string     `json:"channel" gorm:"not null"`
+	Provider          string     `json:"provider"`
+	Recipient         string     `json:"recipient" gorm:"not null;index"`
+	CustomerID        *uint      `json:"customer_id" gorm:"index"`
+	OrderID           *uint      `json:"order_id" gorm:"index"`
+	Kind              string     `json:"kind"`
+	TemplateName      string     `json:"template_name"`
+	Body              string     `json:"body" gorm:"type:text"`
+	Status            string     `json:"status" gorm:"not null;default:'queued'"`
+	ProviderMessageID string     `json:"provider_message_id" gorm:"index"`
+	Error             string     `json:"error" gorm:"type:text"`
+	SentAt            *time.Time `json:"sent_at"`
+	DeliveredAt       *time.Time `json:"delivered_at"`
+	ReadAt            *time.Time `json:"read_at"`
+	CreatedAt         time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// ScheduledJob model - a background job and its cron schedule. LockedBy
+// and LockedUntil record which backend instance is running it.
+type ScheduledJob struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Name        string     `json:"name" gorm:"uniqueIndex;not null"`
+	Description string     `json:"description"`
+	Schedule    string     `json:"schedule" gorm:"not null"`
+	Params      string     `json:"params" gorm:"type:json"`
+	IsActive    bool       `json:"is_active" gorm:"default:true"`
+	NextRunAt   time.Time  `json:"next_run_at" gorm:"index"`
+	LastRunAt   *time.Time `json:"last_run_at"`
+	LastStatus  string     `json:"last_status"`
+	LastError   string     `json:"last_error" gorm:"type:text"`
+	LockedBy    string     `json:"locked_by"`
+	LockedUntil *time.Time `json:"locked_until"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// JobRun model - one execution of a scheduled job
+
+type JobRun struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	JobID       uint       `json:"job_id" gorm:"not null;index"`
+	JobName     string     `json:"job_name" gorm:"not null"`
+	TriggeredBy string     `json:"triggered_by"` // schedule or manual
+	Status      string     `json:"status" gorm:"not null;default:'running'"`
+	Instance    string     `json:"instance"`
+	Output      string     `json:"output" gorm:"type:text"`
+	Error       string     `json:"error" gorm:"type:text"`
+	StartedAt   time.Time  `json:"started_at" gorm:"index"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	DurationMs  int64      `json:"duration_ms"`
 }
 
 // Request/Response DTOs
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required"`
+	Email        string `json:"email" binding:"required,email"`
+	Password     string `json:"password" binding:"required"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	User             User      `json:"user"`
+
+	// Set while the user has to change their password; until then only
+	// PUT /auth/password is allowed
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
+	// Set while the user's role requires 2FA and they haven't enrolled;
+	// until then only the /auth/2fa enrollment endpoints are allowed
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+// TwoFactorRequiredResponse asks the client to repeat the sign-in with a
+// TOTP or recovery code
+type TwoFactorRequiredResponse struct {
+	Error             string `json:"error"`
+	Message           string `json:"message,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type SetCredentialRequest struct {
+	Value string `json:"value" binding:"required"`
+}
+
+type PairDeviceRequest struct {
+	Code       string `json:"code" binding:"required"`
+	AppVersion string `json:"app_version"`
+}
+
+type PINLoginRequest struct {
+	PIN          string `json:"pin" binding:"required"`
+	UserID       uint   `json:"user_id"` // optional; without it the PIN alone picks the user
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 type CreateOrderRequest struct {
 	TableID         *uint                 `json:"table_id"`
 	Type            string                 `json:"type"`
 	Priority        string                 `json:"priority"`
+	Guests          int                    `json:"guests"`
 	CustomerName    string                 `json:"customer_name"`
 	CustomerPhone   string                 `json:"customer_phone"`
 	CustomerAddress string                 `json:"customer_address"`
@@ -416,6 +906,28 @@ type CreateOrderItemRequest struct {
 	Notes      string `json:"notes"`
 }
 
+// UpdateOrderRequest holds the order fields staff may edit. Status changes go
+// through the status endpoint and totals follow from the items.
+type UpdateOrderRequest struct {
+	Type            *string  `json:"type"`
+	Priority        *string  `json:"priority"`
+	Guests          *int     `json:"guests"`
+	CustomerName    *string  `json:"customer_name"`
+	CustomerPhone   *string  `json:"customer_phone"`
+	CustomerAddress *string  `json:"customer_address"`
+	Discount        *float64 `json:"discount"`
+	Notes           *string  `json:"notes"`
+	KitchenNotes    *string  `json:"kitchen_notes"`
+}
+
+// UpdateOrderItemRequest holds the order item fields staff may edit
+type UpdateOrderItemRequest struct {
+	Quantity  *int     `json:"quantity"`
+	UnitPrice *float64 `json:"unit_price"`
+	Modifiers *string  `json:"modifiers"`
+	Notes     *string  `json:"notes"`
+}
+
 type PaymentRequest struct {
 	OrderID       uint    `json:"order_id" binding:"required"`
 	Method        string  `json:"method" binding:"required"`
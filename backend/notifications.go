@@ -1,10 +1,7 @@
 package main
 
 import (
-	"context"
-	"fmt"
-
-	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // ========================================
@@ -136,6 +133,19 @@ func (n *NotificationService) SendLowStockAlert(stockItem StockItem) error {
 	return nil
 }
 
+// SendStockExpiryAlert sends the stock items that expire soon to managers
+func (n *NotificationService) SendStockExpiryAlert(items []StockItem) error {
+	notification := map[string]interface{}{
+		"type":      "stock_expiry",
+		"action":    "alert",
+		"data":      items,
+		"timestamp": getCurrentTime(),
+	}
+
+	n.WebSocket.SendToRoom("managers", notification)
+	return nil
+}
+
 // SendNewCustomerNotification sends new customer notification
 func (n *NotificationService) SendNewCustomerNotification(customer Customer) error {
 	notification := map[string]interface{}{
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// TRANSACTIONAL OUTBOX
+// ========================================
+
+// Outbox event statuses
+const (
+	OutboxPending    = "pending"
+	OutboxProcessing = "processing"
+	OutboxDelivered  = "delivered"
+	OutboxDead       = "dead"
+)
+
+// OutboxHandler delivers outbox events of the given types to one destination.
+// An Events entry of "*" matches every event type.
+type OutboxHandler struct {
+	Name    string
+	Events  []string
+	Deliver func(event *OutboxEvent) error
+}
+
+// handles reports whether h subscribes to eventType
+func (h OutboxHandler) handles(eventType string) bool {
+	for _, e := range h.Events {
+		if e == "*" || e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// OutboxDispatcher writes domain events inside business transactions and
+// delivers them afterwards, so a crash after commit never loses a message
+type OutboxDispatcher struct {
+	DB           *gorm.DB
+	Handlers     []OutboxHandler
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	// StaleAfter releases events left in processing by a crashed worker
+	StaleAfter time.Duration
+
+	wake chan struct{}
+}
+
+// NewOutboxDispatcher creates an outbox dispatcher
+func NewOutboxDispatcher(db *gorm.DB) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		DB:           db,
+		PollInterval: 5 * time.Second,
+		BatchSize:    50,
+		MaxAttempts:  10,
+		StaleAfter:   5 * time.Minute,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Register adds a delivery destination
+func (o *OutboxDispatcher) Register(handler OutboxHandler) {
+	o.Handlers = append(o.Handlers, handler)
+}
+
+// Enqueue records eventType for every handler subscribed to it. tx must be
+// the transaction that carries the business change.
+func (o *OutboxDispatcher) Enqueue(tx *gorm.DB, eventType, aggregateType string, aggregateID uint, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	now := time.Now()
+	for _, handler := range o.Handlers {
+		if !handler.handles(eventType) {
+			continue
+		}
+
+		event := OutboxEvent{
+			EventType:     eventType,
+			AggregateType: aggregateType,
+			AggregateID:   aggregateID,
+			Destination:   handler.Name,
+			Payload:       string(data),
+			Status:        OutboxPending,
+			MaxAttempts:   o.MaxAttempts,
+			NextAttemptAt: now,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return fmt.Errorf("failed to enqueue %s event: %w", eventType, err)
+		}
+	}
+
+	return nil
+}
+
+// Wake asks the dispatcher to poll now instead of waiting for the next tick.
+// Call it after committing a transaction that enqueued events.
+func (o *OutboxDispatcher) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls for due events until the process exits
+func (o *OutboxDispatcher) Run() {
+	ticker := time.NewTicker(o.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.releaseStale()
+		for o.dispatchBatch() == o.BatchSize {
+			// Keep draining while batches come back full
+		}
+
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// dispatchBatch delivers up to BatchSize due events and returns how many
+// were claimed
+func (o *OutboxDispatcher) dispatchBatch() int {
+	var events []OutboxEvent
+	if err := o.DB.Where("status = ? AND next_attempt_at <= ?", OutboxPending, time.Now()).
+		Order("id ASC").
+		Limit(o.BatchSize).
+		Find(&events).Error; err != nil {
+		log.Printf("Outbox poll failed: %v", err)
+		return 0
+	}
+
+	for i := range events {
+		o.dispatch(&events[i])
+	}
+
+	return len(events)
+}
+
+// dispatch claims and delivers a single event
+func (o *OutboxDispatcher) dispatch(event *OutboxEvent) {
+	// Claim the row so another instance polling the same table skips it
+	now := time.Now()
+	claim := o.DB.Model(&OutboxEvent{}).
+		Where("id = ? AND status = ?", event.ID, OutboxPending).
+		Updates(map[string]interface{}{"status": OutboxProcessing, "locked_at": now})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+
+	handler, ok := o.handler(event.Destination)
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for %q", event.Destination)
+	} else {
+		err = safeDeliver(handler, event)
+	}
+
+	if err == nil {
+		o.DB.Model(&OutboxEvent{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+			"status":       OutboxDelivered,
+			"attempts":     event.Attempts + 1,
+			"delivered_at": time.Now(),
+			"last_error":   "",
+			"locked_at":    nil,
+		})
+		return
+	}
+
+	attempts := event.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":   attempts,
+		"last_error": err.Error(),
+		"locked_at":  nil,
+	}
+	if attempts >= event.MaxAttempts {
+		updates["status"] = OutboxDead
+		log.Printf("Outbox event %d (%s -> %s) moved to dead letter: %v", event.ID, event.EventType, event.Destination, err)
+	} else {
+		updates["status"] = OutboxPending
+		updates["next_attempt_at"] = time.Now().Add(retryBackoff(attempts))
+	}
+	o.DB.Model(&OutboxEvent{}).Where("id = ?", event.ID).Updates(updates)
+}
+
+// releaseStale returns events stuck in processing to the queue
+func (o *OutboxDispatcher) releaseStale() {
+	o.DB.Model(&OutboxEvent{}).
+		Where("status = ? AND locked_at < ?", OutboxProcessing, time.Now().Add(-o.StaleAfter)).
+		Updates(map[string]interface{}{"status": OutboxPending, "locked_at": nil})
+}
+
+// handler looks up a registered handler by name
+func (o *OutboxDispatcher) handler(name string) (OutboxHandler, bool) {
+	for _, h := range o.Handlers {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return OutboxHandler{}, false
+}
+
+// safeDeliver runs a handler, turning a panic into a delivery error
+func safeDeliver(handler OutboxHandler, event *OutboxEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler.Deliver(event)
+}
+
+// retryBackoff returns the delay before retry number attempt: exponential
+// from 5 seconds, capped at an hour, with up to 20% jitter
+func retryBackoff(attempt int) time.Duration {
+	delay := 5 * time.Second
+	for i := 1; i < attempt && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// ========================================
+// DOMAIN EVENTS
+// ========================================
+
+// EmitEvent records a domain event in tx for asynchronous delivery
+func (a *App) EmitEvent(tx *gorm.DB, eventType, aggregateType string, aggregateID uint, payload interface{}) error {
+	if a.Outbox == nil {
+		return nil
+	}
+	return a.Outbox.Enqueue(tx, eventType, aggregateType, aggregateID, payload)
+}
+
+// RegisterOutboxHandlers wires the built-in delivery destinations
+func (a *App) RegisterOutboxHandlers() {
+	a.Outbox.Register(OutboxHandler{
+		Name:    "websocket",
+		Events:  []string{"order.created", "order.status_changed", "payment.created"},
+		Deliver: a.deliverToNotifications,
+	})
+
+	a.Outbox.Register(OutboxHandler{
+		Name:    "printing",
+		Events:  []string{"order.created"},
+		Deliver: a.deliverToPrinters,
+	})
+
+	a.Outbox.Register(OutboxHandler{
+		Name:    "webhooks",
+		Events:  WebhookEventTypes,
+		Deliver: a.Webhooks.Fanout,
+	})
+
+	if a.Config.ETA.Enabled {
+		a.Outbox.Register(OutboxHandler{
+			Name:   "eta",
+			Events: []string{"order.completed"},
+			Deliver: func(event *OutboxEvent) error {
+				var order Order
+				if err := json.Unmarshal([]byte(event.Payload), &order); err != nil {
+					return err
+				}
+				_, err := a.ETA.Issue(order.ID)
+				return err
+			},
+		})
+	}
+
+	templates := NewReceiptEngine(a.DB)
+
+	a.Outbox.Register(OutboxHandler{
+		Name:   "whatsapp",
+		Events: []string{"order.completed"},
+		Deliver: func(event *OutboxEvent) error {
+			var order Order
+			if err := json.Unmarshal([]byte(event.Payload), &order); err != nil {
+				return err
+			}
+			if !a.Config.WhatsApp.Enabled || order.CustomerPhone == "" {
+				return nil
+			}
+
+			var settings RestaurantSettings
+			a.DB.First(&settings)
+
+			var link string
+			if a.Config.Documents.PublicURL != "" {
+				link, _ = a.SignDocumentURL(a.Config.Documents.PublicURL, DocumentReceipt, order.ID, a.Config.Messaging.DocumentLinkTTL)
+			}
+			body, params := ReceiptMessage(templates, &order, &settings, link)
+			_, err := a.Messaging.Send(&MessageRequest{
+				Channel: ChannelWhatsApp,
+				To:      order.CustomerPhone.String(),
+				Kind:    "receipt",
+				Body:    body,
+				Params:  params,
+				OrderID: &order.ID,
+			})
+			// The customer's choice and bad numbers won't change on retry
+			if errors.Is(err, ErrRecipientOptedOut) || errors.Is(err, ErrInvalidPhone) || errors.Is(err, ErrNoMessagingProvider) {
+				return nil
+			}
+			return err
+		},
+	})
+
+	email := &EmailService{
+		Queue:     a.Mailer,
+		Enabled:   a.Config.Email.Enabled,
+		Templates: templates,
+	}
+	a.Outbox.Register(OutboxHandler{
+		Name:   "email",
+		Events: []string{"order.completed"},
+		Deliver: func(event *OutboxEvent) error {
+			var order Order
+			if err := json.Unmarshal([]byte(event.Payload), &order); err != nil {
+				return err
+			}
+			if !email.Enabled || order.CustomerPhone == "" {
+				return nil
+			}
+
+			customer, err := FindCustomerByPhone(a.DB, order.CustomerPhone.String())
+			if err != nil || customer.Email == "" {
+				return nil
+			}
+
+			var settings RestaurantSettings
+			a.DB.First(&settings)
+
+			// Attach the PDF receipt when a font is available for it
+			var attachments []EmailAttachment
+			if pdf, _, err := a.RenderOrderDocument(order.ID, DocumentReceipt); err == nil {
+				attachments = append(attachments, EmailAttachment{
+					Filename:    fmt.Sprintf("receipt-%s.pdf", order.OrderNumber),
+					ContentType: "application/pdf",
+					Data:        pdf,
+				})
+			} else if !errors.Is(err, ErrNoPDFFont) {
+				log.Printf("Failed to render PDF receipt for order %s: %v", order.OrderNumber, err)
+			}
+			return email.SendReceiptByEmail(&order, &settings, customer.Email.String(), attachments...)
+		},
+	})
+}
+
+// deliverToNotifications pushes an outbox event to WebSocket clients
+func (a *App) deliverToNotifications(event *OutboxEvent) error {
+	switch event.EventType {
+	case "order.created":
+		var order Order
+		if err := json.Unmarshal([]byte(event.Payload), &order); err != nil {
+			return err
+		}
+		if err := a.NotificationService.SendOrderNotification(order.ID, order); err != nil {
+			return err
+		}
+		return a.NotificationService.SendKitchenTicketNotification(order)
+
+	case "order.status_changed":
+		var change OrderStatusChange
+		if err := json.Unmarshal([]byte(event.Payload), &change); err != nil {
+			return err
+		}
+		return a.NotificationService.SendOrderStatusUpdate(change.OrderID, change.OldStatus, change.NewStatus)
+
+	case "payment.created":
+		var payment Payment
+		if err := json.Unmarshal([]byte(event.Payload), &payment); err != nil {
+			return err
+		}
+		return a.NotificationService.SendPaymentNotification(payment.OrderID, payment)
+	}
+
+	return nil
+}
+
+// OrderStatusChange is the payload of order.status_changed events
+type OrderStatusChange struct {
+	OrderID   uint   `json:"order_id"`
+	OldStatus string `json:"old_status"`
+	NewStatus string `json:"new_status"`
+}
+
+// ========================================
+// OUTBOX HANDLERS
+// ========================================
+
+// HandleGetOutboxEvents lists outbox events; status=dead gives the dead-letter view
+func (a *App) HandleGetOutboxEvents(c *gin.Context) {
+	var events []OutboxEvent
+
+	query := a.DB.Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if destination := c.Query("destination"); destination != "" {
+		query = query.Where("destination = ?", destination)
+	}
+	if eventType := c.Query("event_type"); eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+
+	page := c.DefaultQuery("page", "1")
+	limit := c.DefaultQuery("limit", "50")
+	query = query.Offset((getInt(page) - 1) * getInt(limit)).Limit(getInt(limit))
+
+	if err := query.Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch outbox events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// HandleGetOutboxStats returns event counts per destination and status
+func (a *App) HandleGetOutboxStats(c *gin.Context) {
+	type OutboxStat struct {
+		Destination string `json:"destination"`
+		Status      string `json:"status"`
+		Count       int64  `json:"count"`
+	}
+
+	var stats []OutboxStat
+	if err := a.DB.Model(&OutboxEvent{}).
+		Select("destination, status, COUNT(*) as count").
+		Group("destination, status").
+		Scan(&stats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch outbox stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// HandleRetryOutboxEvent puts a dead or pending event back at the front of the queue
+func (a *App) HandleRetryOutboxEvent(c *gin.Context) {
+	id := c.Param("id")
+
+	var event OutboxEvent
+	if err := a.DB.First(&event, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Outbox event not found"})
+		return
+	}
+
+	if event.Status == OutboxDelivered || event.Status == OutboxProcessing {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Event is " + event.Status})
+		return
+	}
+
+	if err := a.DB.Model(&event).Updates(map[string]interface{}{
+		"status":          OutboxPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to retry outbox event"})
+		return
+	}
+
+	a.Outbox.Wake()
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Event queued for retry",
+	})
+}
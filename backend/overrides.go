@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// MANAGER OVERRIDES
+// ========================================
+//
+// Sensitive actions (voids, large discounts, reopening closed orders,
+// no-sale drawer opens) each need a permission. A user who has it goes
+// ahead; anyone else gets a 403 carrying a pending ManagerOverride, and
+// the managers room is prompted over the WebSocket. A manager approves
+// remotely, or on the same terminal by entering their PIN. The client then
+// repeats the original request with the override's ID in X-Override-ID;
+// the approval is bound to that session and exact request and can be used
+// once.
+
+// OverrideHeader carries the ID of an approved override on the retried request
+const OverrideHeader = "X-Override-ID"
+
+// Override statuses
+const (
+	OverridePending  = "pending"
+	OverrideApproved = "approved"
+	OverrideDenied   = "denied"
+	OverrideUsed     = "used"
+)
+
+// ErrOverrideNotPending is returned when deciding an override that was
+// already decided or has expired
+var ErrOverrideNotPending = errors.New("override is no longer pending")
+
+// OverrideAction describes a sensitive action. Details identify exactly
+// what is being done, so an approval can't be reused for something else.
+type OverrideAction struct {
+	Action     string
+	Permission string
+	Entity     string
+	EntityID   uint
+	Summary    string
+	Details    gin.H
+}
+
+// fingerprint hashes what the action does
+func (act OverrideAction) fingerprint() string {
+	details, _ := json.Marshal(act.Details)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s", act.Action, act.Entity, act.EntityID, details)))
+	return hex.EncodeToString(sum[:])
+}
+
+// OverrideRequiredResponse is returned when an action needs approval
+type OverrideRequiredResponse struct {
+	Error    string          `json:"error"`
+	Message  string          `json:"message"`
+	Override ManagerOverride `json:"override"`
+}
+
+// RequireApproval decides whether act may go ahead. It returns the ID of
+// the user who authorised it: the caller when their role has the
+// permission, otherwise the approving manager. When it returns false the
+// response has been written.
+func (a *App) RequireApproval(c *gin.Context, act OverrideAction) (uint, bool) {
+	userID := c.GetUint("user_id")
+	if a.HasPermission(c.GetString("role"), act.Permission) {
+		return userID, true
+	}
+
+	sessionID := c.GetUint("session_id")
+	fingerprint := act.fingerprint()
+	now := time.Now()
+
+	if id := c.GetHeader(OverrideHeader); id != "" {
+		var o ManagerOverride
+		if err := a.DB.Where("requested_by = ? AND session_id = ?", userID, sessionID).
+			First(&o, id).Error; err != nil || o.Fingerprint != fingerprint {
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Invalid override", Message: "the override does not match this request"})
+			return 0, false
+		}
+
+		switch {
+		case o.Status == OverrideDenied:
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Override denied", Message: o.Reason})
+		case o.Status == OverrideUsed:
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Override already used"})
+		case now.After(o.ExpiresAt):
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Override expired"})
+		case o.Status == OverridePending:
+			a.respondOverrideRequired(c, o)
+		default:
+			// Approved: claim it so it can't be replayed
+			result := a.DB.Model(&ManagerOverride{}).
+				Where("id = ? AND status = ?", o.ID, OverrideApproved).
+				Updates(map[string]interface{}{"status": OverrideUsed, "used_at": now})
+			if result.Error != nil || result.RowsAffected == 0 {
+				c.JSON(http.StatusForbidden, ErrorResponse{Error: "Override already used"})
+				return 0, false
+			}
+			return *o.DecidedBy, true
+		}
+		return 0, false
+	}
+
+	// Asking again before a decision returns the same pending request
+	var o ManagerOverride
+	err := a.DB.Where("fingerprint = ? AND session_id = ? AND status = ? AND expires_at > ?",
+		fingerprint, sessionID, OverridePending, now).First(&o).Error
+	if err != nil {
+		details, _ := json.Marshal(act.Details)
+		o = ManagerOverride{
+			Action:      act.Action,
+			Permission:  act.Permission,
+			Entity:      act.Entity,
+			EntityID:    act.EntityID,
+			Summary:     act.Summary,
+			Details:     string(details),
+			Fingerprint: fingerprint,
+			Status:      OverridePending,
+			RequestedBy: userID,
+			SessionID:   sessionID,
+			ExpiresAt:   now.Add(a.Config.Overrides.TTL),
+		}
+		if deviceID := c.GetUint("device_id"); deviceID != 0 {
+			o.DeviceID = &deviceID
+		}
+		if err := a.DB.Create(&o).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to request approval"})
+			return 0, false
+		}
+		a.notifyOverride("requested", o)
+	}
+
+	a.respondOverrideRequired(c, o)
+	return 0, false
+}
+
+func (a *App) respondOverrideRequired(c *gin.Context, o ManagerOverride) {
+	c.JSON(http.StatusForbidden, OverrideRequiredResponse{
+		Error:    "Approval required",
+		Message:  o.Summary,
+		Override: o,
+	})
+}
+
+// notifyOverride tells managers about a new request and terminals about a decision
+func (a *App) notifyOverride(action string, o ManagerOverride) {
+	if a.WSManager == nil {
+		return
+	}
+	notification := Notification{
+		Type:      "override",
+		Action:    action,
+		Data:      o,
+		UserID:    o.RequestedBy,
+		Timestamp: getCurrentTime(),
+	}
+	a.WSManager.SendToRoom("managers", notification)
+	if action != "requested" {
+		a.WSManager.SendToRoom("pos", notification)
+	}
+}
+
+// decideOverride approves or denies a pending override
+func (a *App) decideOverride(c *gin.Context, o *ManagerOverride, deciderID uint, status, method, reason string) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":     status,
+		"decided_by": deciderID,
+		"method":     method,
+		"reason":     reason,
+		"decided_at": now,
+	}
+	// The requester gets a fresh window to repeat the request
+	if status == OverrideApproved {
+		updates["expires_at"] = now.Add(a.Config.Overrides.TTL)
+	}
+
+	result := a.DB.Model(&ManagerOverride{}).
+		Where("id = ? AND status = ? AND expires_at > ?", o.ID, OverridePending, now).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOverrideNotPending
+	}
+
+	a.DB.First(o, o.ID)
+	a.Audit(c, AuditEntry{
+		Action:   "override_" + status,
+		Entity:   "override",
+		EntityID: o.ID,
+		Details: gin.H{
+			"action":       o.Action,
+			"requested_by": o.RequestedBy,
+			"decided_by":   deciderID,
+			"method":       method,
+		},
+	})
+	a.notifyOverride(status, *o)
+	return nil
+}
+
+// ========================================
+// OVERRIDE HANDLERS
+// ========================================
+
+// canSeeOverride reports whether the current user requested o or could approve it
+func (a *App) canSeeOverride(c *gin.Context, o *ManagerOverride) bool {
+	return o.RequestedBy == c.GetUint("user_id") || a.HasPermission(c.GetString("role"), o.Permission)
+}
+
+// loadOverride loads the override in :id, writing a 404 if it's missing or
+// not visible to the current user
+func (a *App) loadOverride(c *gin.Context) (*ManagerOverride, bool) {
+	var o ManagerOverride
+	if err := a.DB.First(&o, c.Param("id")).Error; err != nil || !a.canSeeOverride(c, &o) {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Override not found"})
+		return nil, false
+	}
+	return &o, true
+}
+
+// HandleGetOverrides lists pending overrides the current user requested or
+// could approve
+func (a *App) HandleGetOverrides(c *gin.Context) {
+	var pending []ManagerOverride
+	if err := a.DB.Where("status = ? AND expires_at > ?", OverridePending, time.Now()).
+		Order("created_at ASC").Find(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch overrides"})
+		return
+	}
+
+	visible := []ManagerOverride{}
+	for i := range pending {
+		if a.canSeeOverride(c, &pending[i]) {
+			visible = append(visible, pending[i])
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// HandleGetOverride returns one override, so a terminal can poll for the decision
+func (a *App) HandleGetOverride(c *gin.Context) {
+	o, ok := a.loadOverride(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, o)
+}
+
+// HandleApproveOverride approves an override remotely
+func (a *App) HandleApproveOverride(c *gin.Context) {
+	a.decideRemotely(c, OverrideApproved)
+}
+
+// HandleDenyOverride denies an override; requesters can withdraw their own
+func (a *App) HandleDenyOverride(c *gin.Context) {
+	a.decideRemotely(c, OverrideDenied)
+}
+
+func (a *App) decideRemotely(c *gin.Context, status string) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&req)
+
+	o, ok := a.loadOverride(c)
+	if !ok {
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	withdrawing := status == OverrideDenied && o.RequestedBy == userID
+	if !withdrawing && (o.RequestedBy == userID || !a.HasPermission(c.GetString("role"), o.Permission)) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Insufficient permissions", Message: "you can't approve this request"})
+		return
+	}
+
+	a.respondDecision(c, o, userID, status, "remote", req.Reason)
+}
+
+// HandleApproveOverridePIN approves an override on the requesting terminal
+// with a manager's PIN. Failed PINs count towards the usual PIN lockout.
+func (a *App) HandleApproveOverridePIN(c *gin.Context) {
+	var req PINLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	o, ok := a.loadOverride(c)
+	if !ok {
+		return
+	}
+	if o.RequestedBy != c.GetUint("user_id") || o.SessionID != c.GetUint("session_id") {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "PIN approval must be entered on the requesting terminal"})
+		return
+	}
+
+	var device Device
+	if o.DeviceID == nil || a.DB.Where("is_active = ?", true).First(&device, *o.DeviceID).Error != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "PIN approval needs a registered terminal", Message: "ask a manager to approve remotely"})
+		return
+	}
+
+	approver, err := a.PINLogin(&device, req.PIN, req.UserID)
+	switch {
+	case errors.Is(err, ErrPINLocked):
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Locked", Message: err.Error()})
+		return
+	case errors.Is(err, ErrInvalidPIN):
+		a.Audit(c, AuditEntry{Action: "override_pin_failed", Entity: "override", EntityID: o.ID, Details: gin.H{"user_id": req.UserID}})
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify PIN"})
+		return
+	}
+	if approver.ID == o.RequestedBy || !a.HasPermission(approver.Role, o.Permission) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Insufficient permissions", Message: approver.Name + " can't approve this request"})
+		return
+	}
+	a.clearPINFailures(approver, &device)
+
+	a.respondDecision(c, o, approver.ID, OverrideApproved, "pin", "")
+}
+
+func (a *App) respondDecision(c *gin.Context, o *ManagerOverride, userID uint, status, method, reason string) {
+	if err := a.decideOverride(c, o, userID, status, method, reason); err != nil {
+		if errors.Is(err, ErrOverrideNotPending) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Override already decided or expired"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update override"})
+		return
+	}
+	c.JSON(http.StatusOK, o)
+}
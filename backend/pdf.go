@@ -0,0 +1,963 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ========================================
+// PDF WRITER
+// ========================================
+//
+// A small PDF 1.4 writer for receipts and invoices: text, lines, rectangles,
+// images and QR codes. Text uses an embedded TrueType font as a Type0 font
+// with Identity-H encoding, so any glyph in the font can be drawn,
+// including Arabic presentation forms. Arabic is shaped with ShapeArabic
+// and laid out with VisualOrder before being drawn left to right, the same
+// way the ESC/POS renderer handles it. Fonts are subset to the glyphs a
+// document uses.
+//
+// Coordinates are in points from the top-left corner of the page.
+
+// Page sizes in points
+const (
+	PageA4Width  = 595.28
+	PageA4Height = 841.89
+	mmToPt       = 72 / 25.4
+)
+
+// ErrNoPDFFont is returned when no TrueType font could be loaded
+var ErrNoPDFFont = errors.New("no TrueType font available for PDF output; set PDF_FONT")
+
+// PDF is a document under construction
+type PDF struct {
+	pages  []*pdfPage
+	page   *pdfPage
+	fonts  []*pdfFont
+	images []*PDFImage
+
+	font     *pdfFont
+	fontSize float64
+	fakeBold bool
+}
+
+type pdfPage struct {
+	width, height float64
+	bottom        float64
+	content       bytes.Buffer
+	fonts         map[int]bool
+	images        map[int]bool
+}
+
+type pdfFont struct {
+	ttf  *TrueTypeFont
+	used map[uint16]rune
+}
+
+// PDFImage is an image that can be placed in a PDF
+type PDFImage struct {
+	Width, Height int
+	colorSpace    string
+	filter        string
+	data          []byte
+	alpha         []byte
+}
+
+// NewPDF creates an empty document
+func NewPDF() *PDF {
+	return &PDF{}
+}
+
+// AddPage starts a new page of the given size in points
+func (p *PDF) AddPage(width, height float64) {
+	p.page = &pdfPage{width: width, height: height, fonts: make(map[int]bool), images: make(map[int]bool)}
+	p.pages = append(p.pages, p.page)
+}
+
+// FitHeight crops the current page to its top height points, for roll
+// paper documents whose length is only known after layout
+func (p *PDF) FitHeight(height float64) {
+	if height < p.page.height {
+		p.page.bottom = p.page.height - height
+	}
+}
+
+// SetFont selects the font for following text. When bold is requested and
+// the family has no bold face, the regular face is stroked instead.
+func (p *PDF) SetFont(family *PDFFontFamily, size float64, bold bool) {
+	ttf := family.Regular
+	p.fakeBold = false
+	if bold {
+		if family.Bold != nil {
+			ttf = family.Bold
+		} else {
+			p.fakeBold = true
+		}
+	}
+
+	p.font = nil
+	for _, f := range p.fonts {
+		if f.ttf == ttf {
+			p.font = f
+		}
+	}
+	if p.font == nil {
+		p.font = &pdfFont{ttf: ttf, used: make(map[uint16]rune)}
+		p.fonts = append(p.fonts, p.font)
+	}
+	p.fontSize = size
+}
+
+// TextWidth returns the width of s in the current font
+func (p *PDF) TextWidth(s string) float64 {
+	var width int
+	for _, r := range pdfVisual(s) {
+		width += int(p.font.ttf.advance(p.font.ttf.glyph(r)))
+	}
+	return float64(width) * p.fontSize / float64(p.font.ttf.unitsPerEm)
+}
+
+// Text draws s with its baseline at y
+func (p *PDF) Text(x, y float64, s string) {
+	if s == "" {
+		return
+	}
+
+	var hex strings.Builder
+	for _, r := range pdfVisual(s) {
+		gid := p.font.ttf.glyph(r)
+		if _, ok := p.font.used[gid]; !ok {
+			p.font.used[gid] = r
+		}
+		fmt.Fprintf(&hex, "%04X", gid)
+	}
+
+	index := p.fontIndex()
+	p.page.fonts[index] = true
+	c := &p.page.content
+	c.WriteString("BT\n")
+	if p.fakeBold {
+		fmt.Fprintf(c, "2 Tr 0 G %.2f w\n", p.fontSize/30)
+	} else {
+		c.WriteString("0 Tr\n")
+	}
+	fmt.Fprintf(c, "/F%d %.2f Tf\n%.2f %.2f Td\n<%s> Tj\nET\n", index, p.fontSize, x, p.page.height-y, hex.String())
+}
+
+// TextRight draws s ending at x
+func (p *PDF) TextRight(x, y float64, s string) {
+	p.Text(x-p.TextWidth(s), y, s)
+}
+
+// TextCenter draws s centered on x
+func (p *PDF) TextCenter(x, y float64, s string) {
+	p.Text(x-p.TextWidth(s)/2, y, s)
+}
+
+// Wrap splits s into lines no wider than width in the current font
+func (p *PDF) Wrap(s string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := strings.TrimSpace(line + " " + word)
+			if line != "" && p.TextWidth(candidate) > width {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Line draws a line of the given width and gray level (0 black, 1 white)
+func (p *PDF) Line(x1, y1, x2, y2, width, gray float64) {
+	fmt.Fprintf(&p.page.content, "%.2f G %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		gray, width, x1, p.page.height-y1, x2, p.page.height-y2)
+}
+
+// DashedLine draws a dashed line
+func (p *PDF) DashedLine(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.page.content, "[2 2] 0 d 0 G %.2f w %.2f %.2f m %.2f %.2f l S [] 0 d\n",
+		width, x1, p.page.height-y1, x2, p.page.height-y2)
+}
+
+// Rect fills a rectangle with a gray level
+func (p *PDF) Rect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.page.content, "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", gray, x, p.page.height-y-h, w, h)
+}
+
+// Image draws img scaled to w by h
+func (p *PDF) Image(img *PDFImage, x, y, w, h float64) {
+	index := 0
+	for i, existing := range p.images {
+		if existing == img {
+			index = i + 1
+		}
+	}
+	if index == 0 {
+		p.images = append(p.images, img)
+		index = len(p.images)
+	}
+	p.page.images[index] = true
+	fmt.Fprintf(&p.page.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, p.page.height-y-h, index)
+}
+
+// QR draws a QR code as a size by size square, without a quiet zone
+func (p *PDF) QR(q *QRCode, x, y, size float64) {
+	module := size / float64(q.Size)
+	c := &p.page.content
+	c.WriteString("0 g\n")
+	for row := 0; row < q.Size; row++ {
+		for col := 0; col < q.Size; col++ {
+			if q.Dark(col, row) {
+				// Slight overlap avoids hairline gaps between modules
+				fmt.Fprintf(c, "%.3f %.3f %.3f %.3f re\n", x+float64(col)*module,
+					p.page.height-y-float64(row+1)*module, module+0.05, module+0.05)
+			}
+		}
+	}
+	c.WriteString("f\n")
+}
+
+func (p *PDF) fontIndex() int {
+	for i, f := range p.fonts {
+		if f == p.font {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// pdfVisual shapes and reorders text for left-to-right glyph output
+func pdfVisual(s string) []rune {
+	return []rune(VisualOrder(ShapeArabic(s)))
+}
+
+// Bytes serializes the document
+func (p *PDF) Bytes() ([]byte, error) {
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Object numbers: 1 catalog, 2 page tree, then fonts, images and pages
+	next := 3
+	fontObj := make([]int, len(p.fonts))
+	for i := range p.fonts {
+		fontObj[i] = next
+		next += 5
+	}
+	imageObj := make([]int, len(p.images))
+	for i, img := range p.images {
+		imageObj[i] = next
+		next++
+		if img.alpha != nil {
+			next++
+		}
+	}
+	pageObj := make([]int, len(p.pages))
+	for i := range p.pages {
+		pageObj[i] = next
+		next += 2
+	}
+
+	w.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pageObj))
+	for i, obj := range pageObj {
+		kids[i] = fmt.Sprintf("%d 0 R", obj)
+	}
+	w.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pageObj)))
+
+	for i, f := range p.fonts {
+		if err := w.font(fontObj[i], f); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, img := range p.images {
+		dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s",
+			img.Width, img.Height, img.colorSpace, img.filter)
+		if img.alpha != nil {
+			dict += fmt.Sprintf(" /SMask %d 0 R", imageObj[i]+1)
+		}
+		w.stream(imageObj[i], dict, img.data)
+		if img.alpha != nil {
+			w.stream(imageObj[i]+1, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+				img.Width, img.Height), deflate(img.alpha))
+		}
+	}
+
+	for i, page := range p.pages {
+		var resources strings.Builder
+		resources.WriteString("/Font <<")
+		for index := range page.fonts {
+			fmt.Fprintf(&resources, " /F%d %d 0 R", index, fontObj[index-1])
+		}
+		resources.WriteString(" >> /XObject <<")
+		for index := range page.images {
+			fmt.Fprintf(&resources, " /Im%d %d 0 R", index, imageObj[index-1])
+		}
+		resources.WriteString(" >>")
+
+		w.object(pageObj[i], fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 %.2f %.2f %.2f] /Resources << %s >> /Contents %d 0 R >>",
+			page.bottom, page.width, page.height, resources.String(), pageObj[i]+1))
+		w.stream(pageObj[i]+1, "/Filter /FlateDecode", deflate(page.content.Bytes()))
+	}
+
+	return w.finish(next), nil
+}
+
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *pdfWriter) object(num int, body string) {
+	if w.offsets == nil {
+		w.offsets = make(map[int]int)
+	}
+	w.offsets[num] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (w *pdfWriter) stream(num int, dict string, data []byte) {
+	if w.offsets == nil {
+		w.offsets = make(map[int]int)
+	}
+	w.offsets[num] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", num, dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// font writes the five objects of an embedded Type0 font starting at num
+func (w *pdfWriter) font(num int, f *pdfFont) error {
+	ttf := f.ttf
+	gids := make([]int, 0, len(f.used))
+	for gid := range f.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	subset, err := ttf.subset(f.used)
+	if err != nil {
+		return err
+	}
+
+	// Subset fonts are named with a tag unique to the glyph set
+	hash := sha1.Sum([]byte(fmt.Sprint(gids)))
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + hash[i]%26
+	}
+	name := string(tag) + "+" + ttf.name
+
+	scale := 1000 / float64(ttf.unitsPerEm)
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", gid, int(float64(ttf.advance(uint16(gid)))*scale))
+	}
+
+	w.object(num, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		name, num+1, num+4))
+	w.object(num+1, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW %d /W [%s] /CIDToGIDMap /Identity >>",
+		name, num+2, int(float64(ttf.advance(0))*scale), widths.String()))
+	w.object(num+2, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 4 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, int(float64(ttf.bbox[0])*scale), int(float64(ttf.bbox[1])*scale), int(float64(ttf.bbox[2])*scale), int(float64(ttf.bbox[3])*scale),
+		int(float64(ttf.ascent)*scale), int(float64(ttf.descent)*scale), int(float64(ttf.ascent)*scale), num+3))
+	w.stream(num+3, fmt.Sprintf("/Filter /FlateDecode /Length1 %d", len(subset)), deflate(subset))
+
+	// ToUnicode keeps text searchable and copyable. Shaped Arabic maps back
+	// to presentation forms, which viewers normalize.
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	cmap.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	cmap.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		end := start + 100
+		if end > len(gids) {
+			end = len(gids)
+		}
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", gid, utf16Hex(f.used[uint16(gid)]))
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	w.stream(num+4, "/Filter /FlateDecode", deflate([]byte(cmap.String())))
+
+	return nil
+}
+
+func (w *pdfWriter) finish(objects int) []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", objects)
+	for i := 1; i < objects; i++ {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", w.offsets[i])
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", objects, xref)
+	return w.buf.Bytes()
+}
+
+func utf16Hex(r rune) string {
+	if r >= 0x10000 {
+		r -= 0x10000
+		return fmt.Sprintf("%04X%04X", 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+	}
+	return fmt.Sprintf("%04X", r)
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// NewPDFImage prepares an encoded JPEG, PNG or GIF for embedding. RGB and
+// grayscale JPEGs are embedded as-is; everything else is re-encoded.
+func NewPDFImage(data []byte) (*PDFImage, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		switch config.ColorModel {
+		case color.YCbCrModel:
+			return &PDFImage{Width: config.Width, Height: config.Height, colorSpace: "DeviceRGB", filter: "DCTDecode", data: data}, nil
+		case color.GrayModel:
+			return &PDFImage{Width: config.Width, Height: config.Height, colorSpace: "DeviceGray", filter: "DCTDecode", data: data}, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return imageToPDF(img), nil
+}
+
+// imageToPDF embeds a decoded image as Flate-compressed RGB with an alpha
+// mask when it has transparency
+func imageToPDF(img image.Image) *PDFImage {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgb := make([]byte, 0, w*h*3)
+	alpha := make([]byte, 0, w*h)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	out := &PDFImage{Width: w, Height: h, colorSpace: "DeviceRGB", filter: "FlateDecode", data: deflate(rgb)}
+	if !opaque {
+		out.alpha = alpha
+	}
+	return out
+}
+
+// ========================================
+// TRUETYPE FONTS
+// ========================================
+
+// PDFFontFamily is a regular face and an optional bold face
+type PDFFontFamily struct {
+	Regular *TrueTypeFont
+	Bold    *TrueTypeFont
+}
+
+// TrueTypeFont is a parsed TrueType font file
+type TrueTypeFont struct {
+	name       string
+	data       []byte
+	tables     map[string][]byte
+	unitsPerEm int
+	bbox       [4]int16
+	ascent     int16
+	descent    int16
+	numGlyphs  int
+	widths     []uint16
+	loca       []uint32
+	cmap       map[rune]uint16
+}
+
+var (
+	fontCacheMu sync.Mutex
+	fontCache   = make(map[string]*TrueTypeFont)
+)
+
+// defaultFontPaths are tried when PDF_FONT isn't set. DejaVu Sans covers
+// Latin and Arabic including the presentation forms shaping produces.
+var defaultFontPaths = []string{
+	"./fonts/DejaVuSans.ttf",
+	"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/TTF/DejaVuSans.ttf",
+	"/Library/Fonts/DejaVuSans.ttf",
+	"C:\\Windows\\Fonts\\DejaVuSans.ttf",
+}
+
+// LoadPDFFonts loads the configured font family. The bold face is looked up
+// next to the regular one as <name>-Bold.ttf when not configured.
+func LoadPDFFonts(regularPath, boldPath string) (*PDFFontFamily, error) {
+	paths := defaultFontPaths
+	if regularPath != "" {
+		paths = []string{regularPath}
+	}
+
+	family := &PDFFontFamily{}
+	for _, path := range paths {
+		font, err := LoadTrueTypeFont(path)
+		if err == nil {
+			family.Regular = font
+			regularPath = path
+			break
+		}
+		if !os.IsNotExist(err) || len(paths) == 1 {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if family.Regular == nil {
+		return nil, ErrNoPDFFont
+	}
+
+	if boldPath == "" {
+		ext := filepath.Ext(regularPath)
+		boldPath = strings.TrimSuffix(regularPath, ext) + "-Bold" + ext
+	}
+	if bold, err := LoadTrueTypeFont(boldPath); err == nil {
+		family.Bold = bold
+	}
+
+	return family, nil
+}
+
+// LoadTrueTypeFont reads and parses a font file, caching it by path
+func LoadTrueTypeFont(path string) (*TrueTypeFont, error) {
+	fontCacheMu.Lock()
+	defer fontCacheMu.Unlock()
+	if font, ok := fontCache[path]; ok {
+		return font, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	font, err := ParseTrueTypeFont(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// PostScript names can't contain spaces or delimiters
+	font.name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return -1
+	}, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+
+	fontCache[path] = font
+	return font, nil
+}
+
+// ParseTrueTypeFont parses the tables needed to measure, map and subset a
+// glyf-based TrueType font
+func ParseTrueTypeFont(data []byte) (*TrueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, errors.New("font file too short")
+	}
+	if v := binary.BigEndian.Uint32(data); v != 0x00010000 && v != 0x74727565 {
+		return nil, errors.New("not a TrueType font (CFF-based OpenType isn't supported)")
+	}
+
+	f := &TrueTypeFont{data: data, tables: make(map[string][]byte), name: "Font"}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, errors.New("truncated table directory")
+		}
+		tag := string(data[record : record+4])
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("table %s out of range", tag)
+		}
+		f.tables[tag] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "cmap", "loca", "glyf"} {
+		if f.tables[tag] == nil {
+			return nil, fmt.Errorf("font has no %s table", tag)
+		}
+	}
+
+	head := f.tables["head"]
+	if len(head) < 54 {
+		return nil, errors.New("invalid head table")
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("invalid unitsPerEm")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int16(binary.BigEndian.Uint16(head[36+2*i:]))
+	}
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+
+	if len(f.tables["maxp"]) < 6 || len(f.tables["hhea"]) < 36 {
+		return nil, errors.New("invalid maxp or hhea table")
+	}
+	f.numGlyphs = int(binary.BigEndian.Uint16(f.tables["maxp"][4:]))
+	hhea := f.tables["hhea"]
+	f.ascent = int16(binary.BigEndian.Uint16(hhea[4:]))
+	f.descent = int16(binary.BigEndian.Uint16(hhea[6:]))
+
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := f.tables["hmtx"]
+	if numMetrics == 0 || len(hmtx) < numMetrics*4 {
+		return nil, errors.New("invalid hmtx table")
+	}
+	f.widths = make([]uint16, numMetrics)
+	for i := range f.widths {
+		f.widths[i] = binary.BigEndian.Uint16(hmtx[4*i:])
+	}
+
+	loca := f.tables["loca"]
+	f.loca = make([]uint32, f.numGlyphs+1)
+	for i := range f.loca {
+		if longLoca {
+			if 4*i+4 > len(loca) {
+				return nil, errors.New("truncated loca table")
+			}
+			f.loca[i] = binary.BigEndian.Uint32(loca[4*i:])
+		} else {
+			if 2*i+2 > len(loca) {
+				return nil, errors.New("truncated loca table")
+			}
+			f.loca[i] = uint32(binary.BigEndian.Uint16(loca[2*i:])) * 2
+		}
+	}
+
+	if err := f.parseCmap(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseCmap reads a Unicode cmap subtable, format 12 or 4
+func (f *TrueTypeFont) parseCmap() error {
+	cmap := f.tables["cmap"]
+	if len(cmap) < 4 {
+		return errors.New("invalid cmap table")
+	}
+
+	var format4, format12 []byte
+	for i := 0; i < int(binary.BigEndian.Uint16(cmap[2:])); i++ {
+		record := 4 + 8*i
+		if record+8 > len(cmap) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(cmap[record:])
+		encoding := binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if offset+4 > len(cmap) || !(platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))) {
+			continue
+		}
+		switch binary.BigEndian.Uint16(cmap[offset:]) {
+		case 4:
+			format4 = cmap[offset:]
+		case 12:
+			format12 = cmap[offset:]
+		}
+	}
+
+	f.cmap = make(map[rune]uint16)
+	switch {
+	case format12 != nil && len(format12) >= 16:
+		groups := int(binary.BigEndian.Uint32(format12[12:]))
+		for i := 0; i < groups && 16+12*i+12 <= len(format12); i++ {
+			group := format12[16+12*i:]
+			start := binary.BigEndian.Uint32(group)
+			end := binary.BigEndian.Uint32(group[4:])
+			gid := binary.BigEndian.Uint32(group[8:])
+			for c := start; c <= end && c-start < 0x10000; c++ {
+				f.cmap[rune(c)] = uint16(gid + c - start)
+			}
+		}
+	case format4 != nil && len(format4) >= 14:
+		segments := int(binary.BigEndian.Uint16(format4[6:])) / 2
+		if len(format4) < 16+8*segments {
+			return errors.New("truncated cmap format 4")
+		}
+		ends := format4[14:]
+		starts := format4[16+2*segments:]
+		deltas := format4[16+4*segments:]
+		rangeOffsets := format4[16+6*segments:]
+		for s := 0; s < segments; s++ {
+			end := int(binary.BigEndian.Uint16(ends[2*s:]))
+			start := int(binary.BigEndian.Uint16(starts[2*s:]))
+			delta := int(binary.BigEndian.Uint16(deltas[2*s:]))
+			rangeOffset := int(binary.BigEndian.Uint16(rangeOffsets[2*s:]))
+			for c := start; c <= end && c != 0xffff; c++ {
+				var gid int
+				if rangeOffset == 0 {
+					gid = (c + delta) & 0xffff
+				} else {
+					at := 16 + 6*segments + 2*s + rangeOffset + 2*(c-start)
+					if at+2 > len(format4) {
+						continue
+					}
+					gid = int(binary.BigEndian.Uint16(format4[at:]))
+					if gid != 0 {
+						gid = (gid + delta) & 0xffff
+					}
+				}
+				if gid != 0 {
+					f.cmap[rune(c)] = uint16(gid)
+				}
+			}
+		}
+	default:
+		return errors.New("font has no Unicode cmap")
+	}
+	return nil
+}
+
+// glyph returns the glyph for r, or .notdef
+func (f *TrueTypeFont) glyph(r rune) uint16 {
+	return f.cmap[r]
+}
+
+// advance returns a glyph's advance width in font units
+func (f *TrueTypeFont) advance(gid uint16) uint16 {
+	if int(gid) < len(f.widths) {
+		return f.widths[gid]
+	}
+	return f.widths[len(f.widths)-1]
+}
+
+func (f *TrueTypeFont) glyphData(gid int) []byte {
+	if gid+1 >= len(f.loca) {
+		return nil
+	}
+	start, end := f.loca[gid], f.loca[gid+1]
+	if end <= start || int(end) > len(f.tables["glyf"]) {
+		return nil
+	}
+	return f.tables["glyf"][start:end]
+}
+
+// subset returns a font file containing only the used glyphs and the
+// components of composite ones. Glyph IDs are kept so the PDF can map CIDs
+// to glyphs with Identity; unused glyphs become empty.
+func (f *TrueTypeFont) subset(used map[uint16]rune) ([]byte, error) {
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for gid := range used {
+		if !keep[int(gid)] {
+			keep[int(gid)] = true
+			queue = append(queue, int(gid))
+		}
+	}
+	for len(queue) > 0 {
+		gid := queue[0]
+		queue = queue[1:]
+		for _, component := range compositeComponents(f.glyphData(gid)) {
+			if !keep[component] && component < f.numGlyphs {
+				keep[component] = true
+				queue = append(queue, component)
+			}
+		}
+	}
+
+	var glyf bytes.Buffer
+	loca := make([]byte, 4*(f.numGlyphs+1))
+	for gid := 0; gid < f.numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(loca[4*gid:], uint32(glyf.Len()))
+		if keep[gid] {
+			glyf.Write(f.glyphData(gid))
+			for glyf.Len()%4 != 0 {
+				glyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(loca[4*f.numGlyphs:], uint32(glyf.Len()))
+
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+
+	tables := map[string][]byte{
+		"cmap": subsetCmap(used),
+		"head": head,
+		"hhea": f.tables["hhea"],
+		"maxp": f.tables["maxp"],
+		"hmtx": f.tables["hmtx"],
+		"loca": loca,
+		"glyf": glyf.Bytes(),
+	}
+	for _, tag := range []string{"cvt ", "fpgm", "prep"} {
+		if table, ok := f.tables[tag]; ok {
+			tables[tag] = table
+		}
+	}
+
+	return writeSFNT(tables), nil
+}
+
+// subsetCmap builds a format 4 cmap for the used characters. PDF text
+// addresses glyphs directly, but some consumers expect a cmap in every
+// embedded TrueType font.
+func subsetCmap(used map[uint16]rune) []byte {
+	type mapping struct {
+		r   uint16
+		gid uint16
+	}
+	var mappings []mapping
+	for gid, r := range used {
+		if r > 0 && r < 0xffff && gid != 0 {
+			mappings = append(mappings, mapping{uint16(r), gid})
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].r < mappings[j].r })
+	mappings = append(mappings, mapping{0xffff, 0})
+
+	segments := len(mappings)
+	subtable := make([]byte, 16+8*segments)
+	binary.BigEndian.PutUint16(subtable, 4)
+	binary.BigEndian.PutUint16(subtable[2:], uint16(len(subtable)))
+	binary.BigEndian.PutUint16(subtable[6:], uint16(2*segments))
+	entrySelector := 0
+	for 1<<uint(entrySelector+1) <= segments {
+		entrySelector++
+	}
+	binary.BigEndian.PutUint16(subtable[8:], uint16(2<<uint(entrySelector)))
+	binary.BigEndian.PutUint16(subtable[10:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(subtable[12:], uint16(2*segments-2<<uint(entrySelector)))
+	for i, m := range mappings {
+		delta := m.gid - m.r
+		if m.r == 0xffff {
+			delta = 1
+		}
+		binary.BigEndian.PutUint16(subtable[14+2*i:], m.r)
+		binary.BigEndian.PutUint16(subtable[16+2*segments+2*i:], m.r)
+		binary.BigEndian.PutUint16(subtable[16+4*segments+2*i:], delta)
+	}
+
+	header := []byte{0, 0, 0, 1, 0, 3, 0, 1, 0, 0, 0, 12}
+	return append(header, subtable...)
+}
+
+// compositeComponents lists the glyphs a composite glyph is built from
+func compositeComponents(glyph []byte) []int {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return nil
+	}
+
+	var components []int
+	offset := 10
+	for offset+4 <= len(glyph) {
+		flags := binary.BigEndian.Uint16(glyph[offset:])
+		components = append(components, int(binary.BigEndian.Uint16(glyph[offset+2:])))
+		offset += 4
+		if flags&0x0001 != 0 {
+			offset += 4
+		} else {
+			offset += 2
+		}
+		switch {
+		case flags&0x0008 != 0:
+			offset += 2
+		case flags&0x0040 != 0:
+			offset += 4
+		case flags&0x0080 != 0:
+			offset += 8
+		}
+		if flags&0x0020 == 0 {
+			break
+		}
+	}
+	return components
+}
+
+// writeSFNT assembles a font file from tables
+func writeSFNT(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	n := len(tags)
+	entrySelector := 0
+	for 1<<uint(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := (1 << uint(entrySelector)) * 16
+
+	var out bytes.Buffer
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header, 0x00010000)
+	binary.BigEndian.PutUint16(header[4:], uint16(n))
+	binary.BigEndian.PutUint16(header[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(n*16-searchRange))
+	out.Write(header)
+
+	offset := 12 + 16*n
+	records := make([]byte, 16*n)
+	for i, tag := range tags {
+		table := tables[tag]
+		copy(records[16*i:], tag)
+		binary.BigEndian.PutUint32(records[16*i+4:], tableChecksum(table))
+		binary.BigEndian.PutUint32(records[16*i+8:], uint32(offset))
+		binary.BigEndian.PutUint32(records[16*i+12:], uint32(len(table)))
+		offset += (len(table) + 3) &^ 3
+	}
+	out.Write(records)
+
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	return out.Bytes()
+}
+
+func tableChecksum(table []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(table); i += 4 {
+		var word [4]byte
+		copy(word[:], table[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// PIN LOGIN
+// ========================================
+//
+// Staff on a shared terminal sign in with a numeric PIN instead of email
+// and password. The terminal authenticates itself with its device token;
+// the PIN then identifies the user among the staff of the device's
+// location, so PINs must be unique within a location (users without a
+// location are in every location). A PIN that is taken is refused without
+// saying so, and setting PINs is rate limited, so the check can't be used
+// to find other people's PINs. Signing in ends the previous user's
+// session on the device, and the new session locks after the device's
+// idle timeout.
+//
+// Failed attempts are counted on both the user and the device; reaching
+// Auth.PINMaxAttempts locks either for Auth.PINLockout.
+//
+// A PIN is only one factor. Users with 2FA also enter a TOTP or recovery
+// code, and users whose role requires 2FA but who haven't enrolled can't
+// use a PIN until they have.
+
+// PIN errors
+var (
+	ErrInvalidPIN = errors.New("invalid PIN")
+	ErrPINLocked  = errors.New("too many failed PIN attempts, try again later")
+	ErrPINTaken   = errors.New("PIN not accepted, choose a different one")
+)
+
+// maxPINLength caps PIN length
+const maxPINLength = 12
+
+// ValidatePIN checks that a PIN is all digits and long enough
+func (a *App) ValidatePIN(pin string) error {
+	if len(pin) < a.Config.Auth.PINMinLength || len(pin) > maxPINLength {
+		return fmt.Errorf("PIN must be %d to %d digits", a.Config.Auth.PINMinLength, maxPINLength)
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return errors.New("PIN must contain digits only")
+		}
+	}
+	return nil
+}
+
+// SetUserPIN validates, checks uniqueness of and stores a user's PIN
+func (a *App) SetUserPIN(user *User, pin string) error {
+	if err := a.ValidatePIN(pin); err != nil {
+		return err
+	}
+
+	// bcrypt hashes can't be indexed, so compare against everyone who could
+	// sign in on the same terminals
+	var others []User
+	query := a.DB.Select("id", "pin_hash").Where("id <> ? AND pin_hash <> ''", user.ID)
+	if user.Location != "" {
+		query = query.Where("location IN ?", []string{user.Location, ""})
+	}
+	if err := query.Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		if bcrypt.CompareHashAndPassword([]byte(other.PINHash), []byte(pin)) == nil {
+			return ErrPINTaken
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.PINHash = string(hash)
+	user.PINSetAt = &now
+	user.FailedPINAttempts = 0
+	user.PINLockedUntil = nil
+	return a.DB.Model(user).Select("pin_hash", "pin_set_at", "failed_pin_attempts", "pin_locked_until").
+		Updates(user).Error
+}
+
+// PINLogin identifies the user entering pin on device. With userID set
+// only that user's PIN is checked. The failure counts are left alone until
+// the caller has finished and calls clearPINFailures.
+func (a *App) PINLogin(device *Device, pin string, userID uint) (*User, error) {
+	now := time.Now()
+	if device.PINLockedUntil != nil && now.Before(*device.PINLockedUntil) {
+		return nil, ErrPINLocked
+	}
+
+	var candidates []User
+	query := a.DB.Where("is_active = ? AND pin_hash <> '' AND location IN ?", true, []string{device.Location, ""})
+	if userID != 0 {
+		query = query.Where("id = ?", userID)
+	}
+	if err := query.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		user := &candidates[i]
+		if bcrypt.CompareHashAndPassword([]byte(user.PINHash), []byte(pin)) != nil {
+			continue
+		}
+		if user.PINLockedUntil != nil && now.Before(*user.PINLockedUntil) {
+			return nil, ErrPINLocked
+		}
+		return user, nil
+	}
+
+	// A wrong PIN for a chosen user counts against that user as well
+	if userID != 0 && len(candidates) == 1 {
+		user := &candidates[0]
+		if user.PINLockedUntil != nil && now.Before(*user.PINLockedUntil) {
+			return nil, ErrPINLocked
+		}
+		a.pinFailure(&user.FailedPINAttempts, &user.PINLockedUntil, &User{}, "user", user.ID)
+	}
+	a.pinFailure(&device.FailedPINAttempts, &device.PINLockedUntil, &Device{}, "device", device.ID)
+	return nil, ErrInvalidPIN
+}
+
+// clearPINFailures resets the failure counts after a successful sign-in
+func (a *App) clearPINFailures(user *User, device *Device) {
+	a.DB.Model(user).Updates(map[string]interface{}{"failed_pin_attempts": 0, "pin_locked_until": nil})
+	a.DB.Model(device).Updates(map[string]interface{}{"failed_pin_attempts": 0, "pin_locked_until": nil})
+}
+
+// pinFailure counts a failed attempt on the row id of model's table and
+// locks it once the limit is reached. The count is incremented in the
+// database so concurrent attempts are all counted.
+func (a *App) pinFailure(attempts *int, lockedUntil **time.Time, model interface{}, entity string, id uint) {
+	a.DB.Model(model).Where("id = ?", id).
+		UpdateColumn("failed_pin_attempts", gorm.Expr("failed_pin_attempts + 1"))
+	var current int
+	if err := a.DB.Model(model).Where("id = ?", id).Select("failed_pin_attempts").Scan(&current).Error; err == nil {
+		*attempts = current
+	} else {
+		*attempts++
+	}
+
+	if *attempts < a.Config.Auth.PINMaxAttempts {
+		return
+	}
+	until := time.Now().Add(a.Config.Auth.PINLockout)
+	*attempts = 0
+	*lockedUntil = &until
+	a.DB.Model(model).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"failed_pin_attempts": 0,
+		"pin_locked_until":    until,
+	})
+	a.CreateAuditLog(0, "pin_locked", entity, &id, gin.H{"until": until})
+}
+
+// HandleGetPINUsers lists the staff who can sign in on the calling device,
+// for the terminal's user picker
+func (a *App) HandleGetPINUsers(c *gin.Context) {
+	device, err := a.AuthenticateDevice(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unknown device", Message: err.Error()})
+		return
+	}
+
+	var users []User
+	if err := a.DB.Select("id", "name", "name_ar", "role", "avatar", "location").
+		Where("is_active = ? AND pin_hash <> '' AND location IN ?", true, []string{device.Location, ""}).
+		Order("name ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// HandlePINLogin signs a user in on a registered device by PIN, ending the
+// previous user's session on that device
+func (a *App) HandlePINLogin(c *gin.Context) {
+	device, err := a.AuthenticateDevice(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unknown device", Message: err.Error()})
+		return
+	}
+
+	var req PINLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	user, err := a.PINLogin(device, req.PIN, req.UserID)
+	switch {
+	case errors.Is(err, ErrPINLocked):
+		a.Audit(c, AuditEntry{Action: "pin_login_locked", Entity: "device", EntityID: device.ID, UserID: req.UserID})
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Locked", Message: err.Error()})
+		return
+	case errors.Is(err, ErrInvalidPIN):
+		a.Audit(c, AuditEntry{Action: "pin_login_failed", Entity: "device", EntityID: device.ID, UserID: req.UserID})
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to sign in"})
+		return
+	}
+	if !a.checkPINSecondFactor(c, device, user, &req) {
+		return
+	}
+	a.clearPINFailures(user, device)
+
+	switched, _ := a.revokeDeviceSessions(device.ID, RevokedSwitched)
+	resp, err := a.StartSession(*user, c, device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate token"})
+		return
+	}
+
+	a.DB.Model(user).Update("LastLogin", a.GetCurrentTime())
+	a.Audit(c, AuditEntry{
+		Action:   "pin_login",
+		Entity:   "device",
+		EntityID: device.ID,
+		UserID:   user.ID,
+		Details:  gin.H{"switched_sessions": switched},
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// checkPINSecondFactor asks users with 2FA for their code and turns away
+// those who must enroll first. A wrong code counts as a failed PIN attempt.
+// When it returns false the response has been written.
+func (a *App) checkPINSecondFactor(c *gin.Context, device *Device, user *User, req *PINLoginRequest) bool {
+	if !user.TOTPEnabled {
+		if a.TwoFactorRequiredFor(user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrTwoFactorEnforced.Error(), "two_factor_setup_required": true})
+			return false
+		}
+		return true
+	}
+
+	err := a.VerifySecondFactor(user, req.TOTPCode, req.RecoveryCode)
+	switch {
+	case errors.Is(err, ErrTwoFactorRequired):
+		c.JSON(http.StatusUnauthorized, TwoFactorRequiredResponse{
+			Error:             "Two-factor code required",
+			Message:           "send totp_code or recovery_code with your PIN",
+			TwoFactorRequired: true,
+		})
+		return false
+	case errors.Is(err, ErrInvalidTwoFactor):
+		a.pinFailure(&user.FailedPINAttempts, &user.PINLockedUntil, &User{}, "user", user.ID)
+		a.pinFailure(&device.FailedPINAttempts, &device.PINLockedUntil, &Device{}, "device", device.ID)
+		a.Audit(c, AuditEntry{Action: "pin_login_2fa_failed", Entity: "device", EntityID: device.ID, UserID: user.ID})
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify two-factor code"})
+		return false
+	}
+	if req.TOTPCode == "" {
+		a.Audit(c, AuditEntry{Action: "2fa_recovery_code_used", Entity: "user", EntityID: user.ID, UserID: user.ID})
+	}
+	return true
+}
+
+// HandleSetOwnPIN sets the current user's PIN after confirming their password
+func (a *App) HandleSetOwnPIN(c *gin.Context) {
+	var req struct {
+		PIN      string `json:"pin" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var user User
+	if err := a.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	}
+
+	a.respondSetPIN(c, &user, req.PIN)
+}
+
+// HandleSetUserPIN sets or resets a staff member's PIN and clears any
+// lockout. Only staff in a lower role can be changed, and only a super admin
+// can change another super admin.
+func (a *App) HandleSetUserPIN(c *gin.Context) {
+	var req struct {
+		PIN string `json:"pin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var user User
+	if err := a.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if !a.Outranks(c.GetString("role"), user.Role) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Insufficient permissions", Message: "you can only set the PIN of staff in a lower role"})
+		return
+	}
+
+	a.respondSetPIN(c, &user, req.PIN)
+}
+
+func (a *App) respondSetPIN(c *gin.Context, user *User, pin string) {
+	if err := a.ValidatePIN(pin); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid PIN", Message: err.Error()})
+		return
+	}
+	if err := a.SetUserPIN(user, pin); err != nil {
+		if errors.Is(err, ErrPINTaken) {
+			a.Audit(c, AuditEntry{Action: "pin_rejected", Entity: "user", EntityID: user.ID})
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid PIN", Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to set PIN"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "pin_set", Entity: "user", EntityID: user.ID})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "PIN updated",
+	})
+}
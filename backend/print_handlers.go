@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// PRINTER HANDLERS
+// ========================================
+
+// HandleGetPrinters returns all printers
+func (a *App) HandleGetPrinters(c *gin.Context) {
+	var printers []Printer
+
+	query := a.DB.Order("type ASC, is_default DESC, name ASC")
+	if printerType := c.Query("type"); printerType != "" {
+		query = query.Where("type = ?", printerType)
+	}
+	if err := query.Find(&printers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch printers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, printers)
+}
+
+// HandleCreatePrinter creates a new printer
+func (a *App) HandleCreatePrinter(c *gin.Context) {
+	var printer Printer
+	if err := c.ShouldBindJSON(&printer); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	if err := validatePrinter(&printer); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid printer", Message: err.Error()})
+		return
+	}
+
+	if err := a.DB.Create(&printer).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create printer"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Printer created successfully",
+		Data:    printer,
+	})
+}
+
+// HandleUpdatePrinter updates a printer
+func (a *App) HandleUpdatePrinter(c *gin.Context) {
+	id := c.Param("id")
+
+	var printer Printer
+	if err := a.DB.First(&printer, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Printer not found"})
+		return
+	}
+
+	// Bind over the existing record so omitted fields keep their values
+	printerID := printer.ID
+	if err := c.ShouldBindJSON(&printer); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	printer.ID = printerID
+
+	if err := validatePrinter(&printer); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid printer", Message: err.Error()})
+		return
+	}
+
+	if err := a.DB.Save(&printer).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update printer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Printer updated successfully",
+		Data:    printer,
+	})
+}
+
+// HandleDeletePrinter deletes a printer
+func (a *App) HandleDeletePrinter(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := a.DB.Delete(&Printer{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete printer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Printer deleted successfully",
+	})
+}
+
+// HandleTestPrinter prints a test page
+func (a *App) HandleTestPrinter(c *gin.Context) {
+	id := c.Param("id")
+
+	var printer Printer
+	if err := a.DB.First(&printer, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Printer not found"})
+		return
+	}
+
+	service := a.printService()
+	if err := service.Send(&printer, service.RenderTestPage(&printer)); err != nil {
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: "Printer test failed", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Test page sent to " + printer.Name,
+	})
+}
+
+// ========================================
+// PRINT ACTION HANDLERS
+// ========================================
+
+// HandlePrintReceipt queues the customer receipt for an order
+func (a *App) HandlePrintReceipt(c *gin.Context) {
+	order, ok := a.loadPrintOrder(c)
+	if !ok {
+		return
+	}
+
+	printer, err := a.selectPrinter(c, c.Query("printer_id"), "receipt")
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "No receipt printer configured", Message: err.Error()})
+		return
+	}
+
+	// Cash receipts open the drawer on printers wired to one, unless the
+	// caller says otherwise
+	openDrawer := printer.OpenDrawer && order.PaymentMethod == "cash"
+	if value := c.Query("open_drawer"); value != "" {
+		openDrawer = value == "true"
+	}
+
+	job := PrintJob{
+		OrderID:     &order.ID,
+		Kind:        "receipt",
+		Data:        a.printService().RenderReceipt(order, printer, openDrawer),
+		RequestedBy: uint(getInt(a.GetUserIDFromContext(c))),
+	}
+	if err := a.PrintQueue.Enqueue(printer, &job); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue receipt", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Message: "Receipt queued on " + printer.Name,
+		Data:    job,
+	})
+}
+
+// HandleOpenDrawer opens the cash drawer without a sale. Needs drawer.open
+// or a manager override.
+func (a *App) HandleOpenDrawer(c *gin.Context) {
+	printer, err := a.selectPrinter(c, c.Query("printer_id"), "receipt")
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "No receipt printer configured", Message: err.Error()})
+		return
+	}
+
+	act := OverrideAction{
+		Action:     "drawer.open",
+		Permission: PermDrawerOpen,
+		Entity:     "printer",
+		EntityID:   printer.ID,
+		Summary:    "Open cash drawer on " + printer.Name + " (no sale)",
+		Details:    gin.H{"printer_id": printer.ID},
+	}
+	approverID, ok := a.RequireApproval(c, act)
+	if !ok {
+		return
+	}
+
+	job := PrintJob{
+		Kind:        "drawer",
+		Data:        NewESCPOS(printer.PaperWidth).Init().KickDrawer().Bytes(),
+		RequestedBy: uint(getInt(a.GetUserIDFromContext(c))),
+	}
+	if err := a.PrintQueue.Enqueue(printer, &job); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to open drawer", Message: err.Error()})
+		return
+	}
+	a.Audit(c, AuditEntry{
+		Action:     act.Action,
+		Entity:     "printer",
+		EntityID:   printer.ID,
+		Details:    act.Details,
+		ApproverID: approverID,
+	})
+
+	c.JSON(http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Message: "Drawer opening on " + printer.Name,
+		Data:    job,
+	})
+}
+
+// HandlePrintKitchen queues the kitchen tickets for an order
+func (a *App) HandlePrintKitchen(c *gin.Context) {
+	a.printPreparationTickets(c, "kitchen")
+}
+
+// HandlePrintBar queues the bar tickets for an order
+func (a *App) HandlePrintBar(c *gin.Context) {
+	a.printPreparationTickets(c, "bar")
+}
+
+// printPreparationTickets queues tickets on every printer of printerType the
+// order's items route to, or on the single printer in ?printer_id
+func (a *App) printPreparationTickets(c *gin.Context, printerType string) {
+	order, ok := a.loadPrintOrder(c)
+	if !ok {
+		return
+	}
+	userID := uint(getInt(a.GetUserIDFromContext(c)))
+
+	var jobs []PrintJob
+	if printerID := c.Query("printer_id"); printerID != "" {
+		var printer Printer
+		if err := a.DB.First(&printer, printerID).Error; err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Printer not found"})
+			return
+		}
+
+		items, err := a.itemsForPrinter(order, &printer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to route order items", Message: err.Error()})
+			return
+		}
+		if len(items) > 0 {
+			job := PrintJob{
+				OrderID:     &order.ID,
+				Kind:        printer.Type,
+				Data:        a.printService().RenderKitchen(order, items, &printer),
+				RequestedBy: userID,
+			}
+			if err := a.PrintQueue.Enqueue(&printer, &job); err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue ticket", Message: err.Error()})
+				return
+			}
+			jobs = append(jobs, job)
+		}
+	} else {
+		var err error
+		jobs, err = a.EnqueueOrderTickets(order, printerType, "", userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue tickets", Message: err.Error()})
+			return
+		}
+	}
+
+	if len(jobs) == 0 {
+		c.JSON(http.StatusOK, SuccessResponse{
+			Success: true,
+			Message: "No " + printerType + " items to print",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d tickets queued", len(jobs)),
+		Data:    jobs,
+	})
+}
+
+// printService returns a print service with the current restaurant settings
+func (a *App) printService() *PrintService {
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+	if settings.CurrencySymbol == "" {
+		settings.CurrencySymbol = "ج.م"
+	}
+	service := &PrintService{Settings: &settings, Templates: NewReceiptEngine(a.DB)}
+
+	// Printers without an Arabic code page get Arabic lines as images
+	if fonts, err := LoadPDFFonts(a.Config.Documents.FontPath, a.Config.Documents.BoldFontPath); err == nil {
+		service.Rasterizer = NewFontRasterizer(fonts.Regular, 24)
+	} else if !errors.Is(err, ErrNoPDFFont) {
+		log.Printf("Failed to load font for raster printing: %v", err)
+	}
+	return service
+}
+
+// loadPrintOrder loads the order in the :orderId param with everything a
+// ticket needs, writing a 404 if it doesn't exist
+func (a *App) loadPrintOrder(c *gin.Context) (*Order, bool) {
+	var order Order
+	if err := a.DB.Preload("Items").Preload("Payments").Preload("Table").
+		First(&order, c.Param("orderId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+		return nil, false
+	}
+	return &order, true
+}
+
+// selectPrinter returns the requested printer, else an active printer of
+// the given type assigned to the calling device, else the default one
+func (a *App) selectPrinter(c *gin.Context, printerID, printerType string) (*Printer, error) {
+	var printer Printer
+	if printerID != "" {
+		if err := a.DB.First(&printer, printerID).Error; err != nil {
+			return nil, err
+		}
+		return &printer, nil
+	}
+
+	if deviceID := deviceIDFromContext(c); deviceID != nil {
+		err := a.DB.Joins("JOIN device_printers ON device_printers.printer_id = printers.id").
+			Where("device_printers.device_id = ? AND printers.type = ? AND printers.is_active = ?", *deviceID, printerType, true).
+			Order("printers.is_default DESC, printers.id ASC").
+			First(&printer).Error
+		if err == nil {
+			return &printer, nil
+		}
+	}
+
+	err := a.DB.Where("type = ? AND is_active = ?", printerType, true).
+		Order("is_default DESC, id ASC").
+		First(&printer).Error
+	if err != nil {
+		return nil, err
+	}
+	return &printer, nil
+}
+
+// itemsForPrinter returns the order items a manually chosen printer should
+// print: its categories, or everything if it has none
+func (a *App) itemsForPrinter(order *Order, printer *Printer) ([]OrderItem, error) {
+	categories, err := printer.Categories()
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		var items []OrderItem
+		for _, item := range order.Items {
+			if item.Status != "cancelled" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+
+	routes, err := a.RouteOrderItems(order, []Printer{*printer})
+	if err != nil {
+		return nil, err
+	}
+	return routes[printer.ID], nil
+}
+
+// validatePrinter checks a printer's type and fills in defaults
+func validatePrinter(printer *Printer) error {
+	switch printer.Type {
+	case "receipt", "kitchen", "bar":
+	default:
+		return fmt.Errorf("type must be receipt, kitchen or bar")
+	}
+	if printer.IPAddress == "" {
+		return fmt.Errorf("ip_address is required")
+	}
+	if printer.Port == 0 {
+		printer.Port = 9100
+	}
+	if printer.PaperWidth != 58 {
+		printer.PaperWidth = 80
+	}
+	if printer.CodePage == "" {
+		printer.CodePage = CodePagePC864.Name
+	}
+	if printer.BackupPrinterID != nil && *printer.BackupPrinterID == printer.ID {
+		return fmt.Errorf("a printer can't be its own backup")
+	}
+	if printer.PrintCategories == "" {
+		printer.PrintCategories = "[]"
+	}
+	if _, err := printer.Categories(); err != nil {
+		return fmt.Errorf("print_categories must be a JSON array of category IDs")
+	}
+	return nil
+}
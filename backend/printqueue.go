@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// PRINT JOB QUEUE
+// ========================================
+
+// Print job statuses
+const (
+	PrintJobPending   = "pending"
+	PrintJobPrinting  = "printing"
+	PrintJobPrinted   = "printed"
+	PrintJobFailed    = "failed"
+	PrintJobCancelled = "cancelled"
+)
+
+// Printer connection statuses
+const (
+	PrinterOnline  = "online"
+	PrinterOffline = "offline"
+)
+
+// PrintQueue stores rendered print jobs and delivers them with one worker
+// per printer, so a jammed kitchen printer never holds up receipts
+type PrintQueue struct {
+	DB           *gorm.DB
+	WSManager    *WebSocketManager
+	PollInterval time.Duration
+	// MaxAttempts is the number of tries on one printer before failing over
+	MaxAttempts int
+	// StaleAfter releases jobs left printing by a crashed worker
+	StaleAfter time.Duration
+	// HealthInterval is how often idle kitchen and bar printers are probed
+	HealthInterval time.Duration
+
+	mu      sync.Mutex
+	workers map[uint]chan struct{}
+}
+
+// NewPrintQueue creates a print queue
+func NewPrintQueue(db *gorm.DB, ws *WebSocketManager) *PrintQueue {
+	return &PrintQueue{
+		DB:             db,
+		WSManager:      ws,
+		PollInterval:   5 * time.Second,
+		MaxAttempts:    4,
+		StaleAfter:     2 * time.Minute,
+		HealthInterval: time.Minute,
+		workers:        make(map[uint]chan struct{}),
+	}
+}
+
+// Enqueue stores a rendered job for printer. A non-empty job.Reference makes
+// the call idempotent per printer, so retried events don't print twice.
+func (q *PrintQueue) Enqueue(printer *Printer, job *PrintJob) error {
+	if job.Reference != "" {
+		var existing PrintJob
+		if err := q.DB.Where("reference = ? AND printer_id = ?", job.Reference, printer.ID).First(&existing).Error; err == nil {
+			*job = existing
+			return nil
+		}
+	}
+
+	job.PrinterID = printer.ID
+	job.Status = PrintJobPending
+	job.MaxAttempts = q.MaxAttempts
+	job.NextAttemptAt = time.Now()
+	if err := q.DB.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to queue %s job for %s: %w", job.Kind, printer.Name, err)
+	}
+
+	q.Wake(printer.ID)
+	return nil
+}
+
+// Wake starts the printer's worker if needed and asks it to poll now
+func (q *PrintQueue) Wake(printerID uint) {
+	q.mu.Lock()
+	wake, ok := q.workers[printerID]
+	if !ok {
+		wake = make(chan struct{}, 1)
+		q.workers[printerID] = wake
+		go q.worker(printerID, wake)
+	}
+	q.mu.Unlock()
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts workers for printers with queued jobs until the process exits
+func (q *PrintQueue) Run() {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+
+	var lastHealthCheck time.Time
+	for {
+		q.releaseStale()
+		if time.Since(lastHealthCheck) >= q.HealthInterval {
+			lastHealthCheck = time.Now()
+			q.checkPrinters()
+		}
+
+		var printerIDs []uint
+		if err := q.DB.Model(&PrintJob{}).
+			Where("status = ?", PrintJobPending).
+			Distinct().
+			Pluck("printer_id", &printerIDs).Error; err != nil {
+			log.Printf("Print queue poll failed: %v", err)
+		}
+		for _, id := range printerIDs {
+			q.Wake(id)
+		}
+
+		<-ticker.C
+	}
+}
+
+// worker prints jobs for one printer in order
+func (q *PrintQueue) worker(printerID uint, wake chan struct{}) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.workers, printerID)
+		q.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		var printer Printer
+		if err := q.DB.First(&printer, printerID).Error; err != nil {
+			// The printer was deleted; nothing can ever print its jobs
+			q.DB.Model(&PrintJob{}).
+				Where("printer_id = ? AND status = ?", printerID, PrintJobPending).
+				Updates(map[string]interface{}{"status": PrintJobFailed, "last_error": "printer deleted"})
+			return
+		}
+
+		for q.printNext(&printer) {
+			// Keep draining while jobs print
+		}
+
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// printNext claims and prints the printer's oldest due job. It returns
+// false when there is nothing to print or the printer failed.
+func (q *PrintQueue) printNext(printer *Printer) bool {
+	var job PrintJob
+	err := q.DB.Where("printer_id = ? AND status = ? AND next_attempt_at <= ?", printer.ID, PrintJobPending, time.Now()).
+		Order("id ASC").
+		First(&job).Error
+	if err != nil {
+		return false
+	}
+
+	claim := q.DB.Model(&PrintJob{}).
+		Where("id = ? AND status = ?", job.ID, PrintJobPending).
+		Updates(map[string]interface{}{"status": PrintJobPrinting, "locked_at": time.Now()})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return true
+	}
+
+	if !printer.IsActive {
+		err = fmt.Errorf("printer %s is disabled", printer.Name)
+		job.Attempts = job.MaxAttempts
+	} else {
+		err = NewNetworkPrinter(printer.IPAddress, printer.Port).Send(job.Data)
+		q.setPrinterStatus(printer, err)
+	}
+
+	if err == nil {
+		q.DB.Model(&PrintJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":     PrintJobPrinted,
+			"attempts":   job.Attempts + 1,
+			"printed_at": time.Now(),
+			"last_error": "",
+			"locked_at":  nil,
+		})
+		return true
+	}
+
+	attempts := job.Attempts + 1
+	if attempts >= job.MaxAttempts {
+		q.failover(&job, printer, err)
+		return false
+	}
+
+	q.DB.Model(&PrintJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":          PrintJobPending,
+		"attempts":        attempts,
+		"next_attempt_at": time.Now().Add(retryBackoff(attempts)),
+		"last_error":      err.Error(),
+		"locked_at":       nil,
+	})
+	return false
+}
+
+// failover moves a job that exhausted its attempts to the printer's backup,
+// or marks it failed when there is none
+func (q *PrintQueue) failover(job *PrintJob, printer *Printer, cause error) {
+	var backup Printer
+	canFailover := printer.BackupPrinterID != nil && job.FailedOverFrom == nil &&
+		q.DB.Where("id = ? AND is_active = ?", *printer.BackupPrinterID, true).First(&backup).Error == nil
+
+	if !canFailover {
+		q.DB.Model(&PrintJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":     PrintJobFailed,
+			"attempts":   job.Attempts + 1,
+			"last_error": cause.Error(),
+			"locked_at":  nil,
+		})
+		log.Printf("Print job %d failed on %s: %v", job.ID, printer.Name, cause)
+		return
+	}
+
+	q.DB.Model(&PrintJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":           PrintJobPending,
+		"printer_id":       backup.ID,
+		"failed_over_from": printer.ID,
+		"attempts":         0,
+		"next_attempt_at":  time.Now(),
+		"last_error":       fmt.Sprintf("failed over from %s: %v", printer.Name, cause),
+		"locked_at":        nil,
+	})
+	log.Printf("Print job %d failed over from %s to %s", job.ID, printer.Name, backup.Name)
+	q.Wake(backup.ID)
+}
+
+// setPrinterStatus records whether the printer answered and alerts staff
+// when a kitchen or bar printer goes offline or comes back. Transitions are
+// conditional updates, so concurrent probes alert only once.
+func (q *PrintQueue) setPrinterStatus(printer *Printer, err error) {
+	var transition *gorm.DB
+	if err != nil {
+		transition = q.DB.Model(&Printer{}).
+			Where("id = ? AND (status IS NULL OR status <> ?)", printer.ID, PrinterOffline).
+			Updates(map[string]interface{}{"status": PrinterOffline, "last_error": err.Error()})
+		printer.Status = PrinterOffline
+	} else {
+		transition = q.DB.Model(&Printer{}).
+			Where("id = ? AND status = ?", printer.ID, PrinterOffline).
+			Updates(map[string]interface{}{"status": PrinterOnline, "last_error": "", "last_seen_at": time.Now()})
+		if transition.RowsAffected == 0 {
+			q.DB.Model(&Printer{}).Where("id = ?", printer.ID).
+				Updates(map[string]interface{}{"status": PrinterOnline, "last_seen_at": time.Now()})
+		}
+		printer.Status = PrinterOnline
+	}
+
+	if transition.Error != nil || transition.RowsAffected == 0 {
+		return
+	}
+	if printer.Type == "receipt" || q.WSManager == nil {
+		return
+	}
+	q.WSManager.CreatePrinterStatusNotification(*printer, err)
+}
+
+// checkPrinters probes active kitchen and bar printers so staff hear about
+// an offline printer before the next ticket is lost
+func (q *PrintQueue) checkPrinters() {
+	var printers []Printer
+	if err := q.DB.Where("is_active = ? AND type IN ?", true, []string{"kitchen", "bar"}).Find(&printers).Error; err != nil {
+		return
+	}
+
+	for i := range printers {
+		go func(printer *Printer) {
+			q.setPrinterStatus(printer, NewNetworkPrinter(printer.IPAddress, printer.Port).Ping())
+		}(&printers[i])
+	}
+}
+
+// releaseStale returns jobs stuck in printing to the queue
+func (q *PrintQueue) releaseStale() {
+	q.DB.Model(&PrintJob{}).
+		Where("status = ? AND locked_at < ?", PrintJobPrinting, time.Now().Add(-q.StaleAfter)).
+		Updates(map[string]interface{}{"status": PrintJobPending, "locked_at": nil})
+}
+
+// ========================================
+// ORDER ROUTING
+// ========================================
+
+// RouteOrderItems splits an order's items between kitchen and bar printers.
+// Printers with print categories get the items in those categories; items
+// no printer claims go to the default kitchen printer among those without
+// categories. Bar printers only print the categories they list.
+func (a *App) RouteOrderItems(order *Order, printers []Printer) (map[uint][]OrderItem, error) {
+	var items []OrderItem
+	for _, item := range order.Items {
+		if item.Status != "cancelled" {
+			items = append(items, item)
+		}
+	}
+
+	routes := make(map[uint][]OrderItem)
+	if len(items) == 0 {
+		return routes, nil
+	}
+
+	menuItemIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		menuItemIDs = append(menuItemIDs, item.MenuItemID)
+	}
+	var menuItems []MenuItem
+	if err := a.DB.Select("id, category_id").Where("id IN ?", menuItemIDs).Find(&menuItems).Error; err != nil {
+		return nil, err
+	}
+	categoryOf := make(map[uint]uint, len(menuItems))
+	for _, m := range menuItems {
+		categoryOf[m.ID] = m.CategoryID
+	}
+
+	claimed := make(map[uint]bool, len(items))
+	var catchAll *Printer
+	for i := range printers {
+		printer := &printers[i]
+
+		categories, err := printer.Categories()
+		if err != nil {
+			return nil, err
+		}
+		if len(categories) == 0 {
+			if printer.Type == "kitchen" && (catchAll == nil || printer.IsDefault && !catchAll.IsDefault) {
+				catchAll = printer
+			}
+			continue
+		}
+
+		allowed := make(map[uint]bool, len(categories))
+		for _, id := range categories {
+			allowed[id] = true
+		}
+		for _, item := range items {
+			if allowed[categoryOf[item.MenuItemID]] {
+				routes[printer.ID] = append(routes[printer.ID], item)
+				claimed[item.ID] = true
+			}
+		}
+	}
+
+	if catchAll != nil {
+		for _, item := range items {
+			if !claimed[item.ID] {
+				routes[catchAll.ID] = append(routes[catchAll.ID], item)
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+// EnqueueOrderTickets queues preparation tickets for every kitchen and bar
+// printer an order's items route to. With printerType set only that type's
+// tickets are queued; items are still routed across all printers first, so
+// a bar item doesn't fall through to the kitchen ticket.
+func (a *App) EnqueueOrderTickets(order *Order, printerType, reference string, requestedBy uint) ([]PrintJob, error) {
+	var printers []Printer
+	if err := a.DB.Where("is_active = ? AND type IN ?", true, []string{"kitchen", "bar"}).
+		Order("id ASC").
+		Find(&printers).Error; err != nil {
+		return nil, err
+	}
+
+	routes, err := a.RouteOrderItems(order, printers)
+	if err != nil {
+		return nil, err
+	}
+
+	service := a.printService()
+	var jobs []PrintJob
+	for i := range printers {
+		items := routes[printers[i].ID]
+		if len(items) == 0 || printerType != "" && printers[i].Type != printerType {
+			continue
+		}
+
+		job := PrintJob{
+			OrderID:     &order.ID,
+			Kind:        printers[i].Type,
+			Reference:   reference,
+			Data:        service.RenderKitchen(order, items, &printers[i]),
+			RequestedBy: requestedBy,
+		}
+		if err := a.PrintQueue.Enqueue(&printers[i], &job); err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// deliverToPrinters queues kitchen and bar tickets for a new order
+func (a *App) deliverToPrinters(event *OutboxEvent) error {
+	var order Order
+	if err := a.DB.Preload("Items").Preload("Table").First(&order, event.AggregateID).Error; err != nil {
+		return err
+	}
+
+	_, err := a.EnqueueOrderTickets(&order, "", fmt.Sprintf("outbox:%d", event.ID), 0)
+	return err
+}
+
+// Categories decodes the printer's print categories
+func (p *Printer) Categories() ([]uint, error) {
+	var categories []uint
+	if p.PrintCategories == "" {
+		return categories, nil
+	}
+	if err := json.Unmarshal([]byte(p.PrintCategories), &categories); err != nil {
+		return nil, fmt.Errorf("printer %s has invalid print_categories: %w", p.Name, err)
+	}
+	return categories, nil
+}
+
+// ========================================
+// PRINT JOB HANDLERS
+// ========================================
+
+// HandleGetPrintJobs lists print jobs, newest first
+func (a *App) HandleGetPrintJobs(c *gin.Context) {
+	var jobs []PrintJob
+
+	query := a.DB.Preload("Printer").Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if printerID := c.Query("printer_id"); printerID != "" {
+		query = query.Where("printer_id = ?", printerID)
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+
+	page := c.DefaultQuery("page", "1")
+	limit := c.DefaultQuery("limit", "50")
+	query = query.Offset((getInt(page) - 1) * getInt(limit)).Limit(getInt(limit))
+
+	if err := query.Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch print jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// HandleGetPrintJob returns a single print job
+func (a *App) HandleGetPrintJob(c *gin.Context) {
+	id := c.Param("id")
+
+	var job PrintJob
+	if err := a.DB.Preload("Printer").First(&job, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Print job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// HandleReprintJob queues a copy of a job, optionally on another printer
+func (a *App) HandleReprintJob(c *gin.Context) {
+	id := c.Param("id")
+
+	var job PrintJob
+	if err := a.DB.First(&job, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Print job not found"})
+		return
+	}
+
+	printerID := c.Query("printer_id")
+	if printerID == "" {
+		printerID = fmt.Sprintf("%d", job.PrinterID)
+		if job.FailedOverFrom != nil {
+			// Reprints go back to the printer the job was meant for
+			printerID = fmt.Sprintf("%d", *job.FailedOverFrom)
+		}
+	}
+
+	var printer Printer
+	if err := a.DB.First(&printer, printerID).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Printer not found"})
+		return
+	}
+
+	reprint := PrintJob{
+		OrderID:     job.OrderID,
+		Kind:        job.Kind,
+		Data:        job.Data,
+		ReprintOf:   &job.ID,
+		RequestedBy: uint(getInt(a.GetUserIDFromContext(c))),
+	}
+	if err := a.PrintQueue.Enqueue(&printer, &reprint); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue reprint", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Message: "Reprint queued on " + printer.Name,
+		Data:    reprint,
+	})
+}
+
+// HandleCancelPrintJob cancels a job that hasn't printed yet
+func (a *App) HandleCancelPrintJob(c *gin.Context) {
+	id := c.Param("id")
+
+	result := a.DB.Model(&PrintJob{}).
+		Where("id = ? AND status IN ?", id, []string{PrintJobPending, PrintJobFailed}).
+		Updates(map[string]interface{}{"status": PrintJobCancelled, "locked_at": nil})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to cancel print job"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Print job is not pending or failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Print job cancelled",
+	})
+}
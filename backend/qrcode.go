@@ -0,0 +1,520 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// ========================================
+// QR CODE ENCODER
+// ========================================
+//
+// Printers draw QR codes natively (ESCPOS.QRCode), but PDFs and images need
+// the module matrix. This encodes byte-mode symbols at error correction
+// level M, versions 1-25 (up to 1,273 bytes), which covers receipt links
+// and e-receipt payloads.
+
+// ErrQRTooLong is returned when content doesn't fit in a version 25 symbol
+var ErrQRTooLong = errors.New("content too long for a QR code")
+
+// QRCode is an encoded QR symbol
+type QRCode struct {
+	Size    int
+	Version int
+	modules [][]bool
+}
+
+// qrBlocks describes the level M block structure of a version: EC codewords
+// per block, then count and data codewords of the two block groups
+type qrBlocks struct {
+	ec, blocks1, data1, blocks2, data2 int
+}
+
+var qrVersionsM = [...]qrBlocks{
+	{10, 1, 16, 0, 0}, {16, 1, 28, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 32, 0, 0},
+	{24, 2, 43, 0, 0}, {16, 4, 27, 0, 0}, {18, 4, 31, 0, 0}, {22, 2, 38, 2, 39},
+	{22, 3, 36, 2, 37}, {26, 4, 43, 1, 44}, {30, 1, 50, 4, 51}, {22, 6, 36, 2, 37},
+	{22, 8, 37, 1, 38}, {24, 4, 40, 5, 41}, {24, 5, 41, 5, 42}, {28, 7, 45, 3, 46},
+	{28, 10, 46, 1, 47}, {26, 9, 43, 4, 44}, {26, 3, 44, 11, 45}, {26, 3, 41, 13, 42},
+	{26, 17, 42, 0, 0}, {28, 17, 46, 0, 0}, {28, 4, 47, 14, 48}, {28, 6, 45, 14, 46},
+	{28, 8, 47, 13, 48},
+}
+
+var qrAlignment = [...][]int{
+	nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}, {6, 30, 54}, {6, 32, 58}, {6, 34, 62},
+	{6, 26, 46, 66}, {6, 26, 48, 70}, {6, 26, 50, 74}, {6, 30, 54, 78}, {6, 30, 56, 82}, {6, 30, 58, 86}, {6, 34, 62, 90},
+	{6, 28, 50, 72, 94}, {6, 26, 50, 74, 98}, {6, 30, 54, 78, 102}, {6, 28, 54, 80, 106}, {6, 32, 58, 84, 110},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	return b.blocks1*b.data1 + b.blocks2*b.data2
+}
+
+// EncodeQR encodes content in the smallest version that fits
+func EncodeQR(content string) (*QRCode, error) {
+	data := []byte(content)
+
+	version := 0
+	for v := 1; v <= len(qrVersionsM); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= qrVersionsM[v-1].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrQRTooLong
+	}
+
+	codewords := qrInterleave(qrDataCodewords(data, version), qrVersionsM[version-1])
+
+	q := &QRCode{Version: version, Size: 17 + 4*version}
+	q.modules = make([][]bool, q.Size)
+	reserved := make([][]bool, q.Size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, q.Size)
+		reserved[i] = make([]bool, q.Size)
+	}
+	q.drawFunctionPatterns(reserved)
+	q.placeData(codewords, reserved)
+
+	// Keep the mask with the lowest penalty score
+	best, bestScore := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask, reserved)
+		q.drawFormat(mask)
+		if score := q.penalty(); bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		q.applyMask(mask, reserved)
+	}
+	q.applyMask(best, reserved)
+	q.drawFormat(best)
+
+	return q, nil
+}
+
+// Dark reports whether the module at x, y is dark
+func (q *QRCode) Dark(x, y int) bool {
+	return q.modules[y][x]
+}
+
+// Image renders the code with scale pixels per module and a four module
+// quiet zone
+func (q *QRCode) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	size := (q.Size + 8) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+4)*scale+dx, (y+4)*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+	return img
+}
+
+// qrDataCodewords builds the padded byte-mode bit stream
+func qrDataCodewords(data []byte, version int) []byte {
+	capacity := qrVersionsM[version-1].dataCodewords()
+	var bits qrBitBuffer
+
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// Terminator, then pad to a byte boundary and fill with 0xEC 0x11
+	terminator := capacity*8 - bits.n
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	if bits.n%8 != 0 {
+		bits.append(0, 8-bits.n%8)
+	}
+	for pad := 0; len(bits.data) < capacity; pad++ {
+		if pad%2 == 0 {
+			bits.append(0xec, 8)
+		} else {
+			bits.append(0x11, 8)
+		}
+	}
+	return bits.data
+}
+
+// qrInterleave splits data into blocks, appends error correction and
+// interleaves the result
+func qrInterleave(data []byte, layout qrBlocks) []byte {
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < layout.blocks1+layout.blocks2; i++ {
+		size := layout.data1
+		if i >= layout.blocks1 {
+			size = layout.data2
+		}
+		block := data[offset : offset+size]
+		offset += size
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomon(block, layout.ec))
+	}
+
+	var out []byte
+	for i := 0; i < layout.data2 || i < layout.data1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ec; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+type qrBitBuffer struct {
+	data []byte
+	n    int
+}
+
+func (b *qrBitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if value>>uint(i)&1 == 1 {
+			b.data[b.n/8] |= 0x80 >> uint(b.n%8)
+		}
+		b.n++
+	}
+}
+
+// GF(256) tables for Reed-Solomon over the QR polynomial 0x11d
+var gfExp, gfLog [512]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+// reedSolomon returns the n error correction codewords for data
+func reedSolomon(data []byte, n int) []byte {
+	// Generator polynomial (x - a^0)(x - a^1)...(x - a^(n-1)), highest
+	// coefficient first
+	gen := []int{1}
+	for i := 0; i < n; i++ {
+		next := make([]int, len(gen)+1)
+		for j, coef := range gen {
+			next[j] ^= coef
+			next[j+1] ^= gfMul(coef, gfExp[i])
+		}
+		gen = next
+	}
+
+	remainder := make([]int, n)
+	for _, b := range data {
+		factor := int(b) ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[n-1] = 0
+		for j := 0; j < n; j++ {
+			remainder[j] ^= gfMul(gen[j+1], factor)
+		}
+	}
+
+	out := make([]byte, n)
+	for i, r := range remainder {
+		out[i] = byte(r)
+	}
+	return out
+}
+
+func (q *QRCode) set(reserved [][]bool, x, y int, dark bool) {
+	q.modules[y][x] = dark
+	reserved[y][x] = true
+}
+
+// drawFunctionPatterns draws finders, timing and alignment patterns and
+// reserves the format and version areas
+func (q *QRCode) drawFunctionPatterns(reserved [][]bool) {
+	n := q.Size
+
+	for _, corner := range [][2]int{{0, 0}, {n - 7, 0}, {0, n - 7}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= n || y >= n {
+					continue
+				}
+				ring := maxInt(absInt(dx-3), absInt(dy-3))
+				q.set(reserved, x, y, ring != 2 && ring != 4)
+			}
+		}
+	}
+
+	for i := 8; i < n-8; i++ {
+		q.set(reserved, i, 6, i%2 == 0)
+		q.set(reserved, 6, i, i%2 == 0)
+	}
+
+	positions := qrAlignment[q.Version-1]
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			// Skip the three that would overlap finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(reserved, cx+dx, cy+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Dark module and the format and version areas, drawn for real later
+	q.set(reserved, 8, n-8, true)
+	for i := 0; i < 9; i++ {
+		reserved[8][i] = true
+		reserved[i][8] = true
+	}
+	for i := 0; i < 8; i++ {
+		reserved[8][n-1-i] = true
+		reserved[n-1-i][8] = true
+	}
+	if q.Version >= 7 {
+		q.drawVersion(reserved)
+	}
+}
+
+// drawVersion draws the two 6x3 version blocks for versions 7 and up
+func (q *QRCode) drawVersion(reserved [][]bool) {
+	bits := q.Version << 12
+	rem := q.Version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	bits |= rem
+
+	for i := 0; i < 18; i++ {
+		dark := bits>>uint(i)&1 == 1
+		a, b := i/3, q.Size-11+i%3
+		q.set(reserved, a, b, dark)
+		q.set(reserved, b, a, dark)
+	}
+}
+
+// drawFormat writes the error correction level and mask bits
+func (q *QRCode) drawFormat(mask int) {
+	// Level M is 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	n := q.Size
+
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.modules[i][8] = bit(i)
+	}
+	q.modules[7][8] = bit(6)
+	q.modules[8][8] = bit(7)
+	q.modules[8][7] = bit(8)
+	for i := 9; i < 15; i++ {
+		q.modules[8][14-i] = bit(i)
+	}
+	for i := 0; i < 8; i++ {
+		q.modules[8][n-1-i] = bit(i)
+	}
+	for i := 8; i < 15; i++ {
+		q.modules[n-15+i][8] = bit(i)
+	}
+	q.modules[n-8][8] = true
+}
+
+// placeData lays codewords out in the zigzag column pairs, right to left
+func (q *QRCode) placeData(codewords []byte, reserved [][]bool) {
+	n := q.Size
+	i := 0
+	total := len(codewords) * 8
+	for right := n - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < n; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				upward := (right+1)&2 == 0
+				y := vert
+				if upward {
+					y = n - 1 - vert
+				}
+				if reserved[y][x] {
+					continue
+				}
+				// Remainder bits past the last codeword stay light
+				if i < total {
+					q.modules[y][x] = codewords[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask XORs the mask pattern over the data modules; applying it twice
+// undoes it
+func (q *QRCode) applyMask(mask int, reserved [][]bool) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if reserved[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the four rules of ISO 18004 section 7.8.3
+func (q *QRCode) penalty() int {
+	n := q.Size
+	score := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			// 1:1:3:1:1 finder-like patterns with four light modules on a side
+			for x := 0; x+6 < n; x++ {
+				pattern := true
+				for k, dark := range []bool{true, false, true, true, true, false, true} {
+					if at(x+k, y, transpose) != dark {
+						pattern = false
+						break
+					}
+				}
+				if !pattern {
+					continue
+				}
+				before := x >= 4 && !at(x-1, y, transpose) && !at(x-2, y, transpose) && !at(x-3, y, transpose) && !at(x-4, y, transpose)
+				after := x+10 < n && !at(x+7, y, transpose) && !at(x+8, y, transpose) && !at(x+9, y, transpose) && !at(x+10, y, transpose)
+				if before || after {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	deviation := absInt(dark*20-n*n*10) / (n * n)
+	score += deviation * 10
+
+	return score
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
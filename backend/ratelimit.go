@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ========================================
+// RATE LIMITING
+// ========================================
+//
+// Requests draw from token buckets: every client IP has one for all
+// requests, signed-in users have one per route group, and the sign-in
+// endpoints have a small one per IP. Buckets live in a RateLimitStore; the
+// memory store suits a single server, the database store shares buckets
+// between servers behind a load balancer. Another shared backend only needs
+// to implement RateLimitStore.
+
+// RateLimit is a bucket size and refill rate
+type RateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// rate returns the refill rate in tokens per second
+func (l RateLimit) rate() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// RateLimitStore keeps token buckets
+type RateLimitStore interface {
+	// Take removes a token from the bucket at key. When the bucket is
+	// empty it returns false and how long until a token is available.
+	Take(key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// NewRateLimitStore returns the store named by backend ("memory" or "database")
+func NewRateLimitStore(backend string, db *gorm.DB) (RateLimitStore, error) {
+	switch backend {
+	case "", "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "database":
+		return &DatabaseRateLimitStore{db: db}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit backend %q", backend)
+}
+
+// refillBucket tops up tokens for the time since last and takes one if it can
+func refillBucket(tokens float64, last, now time.Time, limit RateLimit) (float64, bool, time.Duration) {
+	rate := limit.rate()
+	tokens = math.Min(float64(limit.Burst), tokens+now.Sub(last).Seconds()*rate)
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	if rate <= 0 {
+		return tokens, false, time.Minute
+	}
+	return tokens, false, time.Duration((1 - tokens) / rate * float64(time.Second))
+}
+
+// ========================================
+// MEMORY STORE
+// ========================================
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in this process
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket), lastSweep: time.Now()}
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = bucket
+	}
+	var allowed bool
+	var wait time.Duration
+	bucket.tokens, allowed, wait = refillBucket(bucket.tokens, bucket.last, now, limit)
+	bucket.last = now
+	return allowed, wait, nil
+}
+
+// sweep drops buckets untouched for an hour; they would be full again
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.last) > time.Hour {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// ========================================
+// DATABASE STORE
+// ========================================
+
+// DatabaseRateLimitStore keeps buckets in the rate_limit_buckets table so
+// every server sees the same counts
+type DatabaseRateLimitStore struct {
+	db *gorm.DB
+}
+
+// Take implements RateLimitStore
+func (s *DatabaseRateLimitStore) Take(key string, limit RateLimit) (bool, time.Duration, error) {
+	var allowed bool
+	var wait time.Duration
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		bucket := RateLimitBucket{Key: key, Tokens: float64(limit.Burst), UpdatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&bucket).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bucket_key = ?", key).First(&bucket).Error; err != nil {
+			return err
+		}
+
+		bucket.Tokens, allowed, wait = refillBucket(bucket.Tokens, bucket.UpdatedAt, now, limit)
+		return tx.Model(&RateLimitBucket{}).Where("bucket_key = ?", key).
+			Updates(map[string]interface{}{"tokens": bucket.Tokens, "updated_at": now}).Error
+	})
+	return allowed, wait, err
+}
+
+// ========================================
+// MIDDLEWARE
+// ========================================
+
+// RateLimitMiddleware limits every request by client IP
+func (a *App) RateLimitMiddleware() gin.HandlerFunc {
+	limit := RateLimit{PerMinute: a.Config.RateLimit.IPPerMinute, Burst: a.Config.RateLimit.IPBurst}
+	return func(c *gin.Context) {
+		a.takeToken(c, "ip:"+c.ClientIP(), limit)
+	}
+}
+
+// RateLimitGroup limits a route group per signed-in user, or per IP before
+// sign-in. API keys with their own limit use it instead.
+func (a *App) RateLimitGroup(group string, limit RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := group + ":ip:" + c.ClientIP()
+		if userID := c.GetUint("user_id"); userID != 0 {
+			key = group + ":user:" + strconv.FormatUint(uint64(userID), 10)
+		}
+		if own, ok := c.Get("rate_limit"); ok {
+			a.takeToken(c, key, own.(RateLimit))
+			return
+		}
+		a.takeToken(c, key, limit)
+	}
+}
+
+// takeToken lets the request through or answers 429 with Retry-After. A
+// failing store lets requests through rather than taking the API down.
+func (a *App) takeToken(c *gin.Context, key string, limit RateLimit) {
+	if !a.Config.RateLimit.Enabled || a.RateLimits == nil || limit.Burst <= 0 {
+		c.Next()
+		return
+	}
+
+	allowed, wait, err := a.RateLimits.Take(key, limit)
+	if err != nil {
+		log.Printf("Rate limit store failed: %v", err)
+		c.Next()
+		return
+	}
+	if !allowed {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{
+			Error:   "Too many requests",
+			Message: fmt.Sprintf("try again in %d seconds", retryAfter),
+		})
+		return
+	}
+	c.Next()
+}
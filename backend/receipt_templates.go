@@ -0,0 +1,1209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// RECEIPT TEMPLATE ENGINE
+// ========================================
+//
+// A ReceiptTemplate has two bodies rendered against the same ReceiptData:
+//
+//   - TemplateHTML (html/template) for email receipts and on-screen preview.
+//     Everything is auto-escaped. CSSStyles goes into a <style> tag as it
+//     is, so CSS that could end the tag or run script is rejected.
+//   - TemplateText (text/template) for thermal printers and WhatsApp. Plain
+//     lines print as-is; printer layout uses the directive functions below.
+//     WhatsApp drops the directives and keeps the text.
+//
+// Templates only see ReceiptData and the functions in receiptFuncs, which
+// have no access to the database or the filesystem, plus the comparison and
+// formatting builtins in templateBuiltins. "call" and nested template
+// definitions are rejected. Output is capped at maxTemplateOutput bytes.
+//
+// Data model (field names as used in templates):
+//
+//	.Restaurant  Name NameAr Address Phone Email Logo Header Footer
+//	             Currency CurrencySymbol TaxNumber
+//	.Order       ID Number Type Status Priority Table CreatedAt Notes KitchenNotes
+//	.Items[]     Name NameAr Quantity UnitPrice Total Notes
+//	             Modifiers[] (Name NameAr Price)
+//	.Taxes[]     Name NameAr Rate Amount
+//	.Totals      Subtotal Tax Service Discount Total Paid Remaining Change
+//	.Payments[]  Method Amount Reference Tendered Change CreatedAt
+//	.Customer    Name Phone Email Address Points (nil for walk-ins)
+//	.QR          Content (empty when the template disables QR)
+//	.Language    "ar" or "en"; .RTL is true for Arabic
+//	.PrintedAt   render time
+//
+// Functions:
+//
+//	t "Total" "الإجمالي"      the string for the current language
+//	bi "Total" "الإجمالي"     both, as "Total / الإجمالي"
+//	local .Name .NameAr       NameAr in Arabic when set, otherwise Name
+//	money 12.5                "12.50 ج.م"      num 12.5 -> "12.50"
+//	date .Order.CreatedAt "2006-01-02 15:04"
+//	digits "12.50"            Arabic-Indic digits in Arabic, unchanged in English
+//	upper lower trim pad padLeft repeat add sub mul
+//
+// Printer directives (text templates only):
+//
+//	{{align "center"}} {{bold true}} {{size 2 2}} {{reverse true}}
+//	{{cols "left" "right"}} {{sep "-"}} {{feed 2}} {{qr .QR.Content}} {{cut}}
+
+// Template kinds
+const (
+	TemplateKindReceipt = "receipt"
+	TemplateKindKitchen = "kitchen"
+)
+
+const (
+	maxTemplateSize   = 64 * 1024
+	maxTemplateOutput = 256 * 1024
+)
+
+// Markers framing printer directives in rendered text. Data strings are
+// stripped of control characters, so order content can't forge them.
+const (
+	directiveStart = '\x1e'
+	directiveSep   = '\x1f'
+)
+
+// ReceiptData is the data model templates render against
+type ReceiptData struct {
+	Restaurant ReceiptRestaurant
+	Order      ReceiptOrder
+	Items      []ReceiptItem
+	Taxes      []ReceiptTax
+	Totals     ReceiptTotals
+	Payments   []ReceiptPayment
+	Customer   *ReceiptCustomer
+	QR         ReceiptQR
+	Language   string
+	RTL        bool
+	PrintedAt  time.Time
+}
+
+// ReceiptRestaurant holds the restaurant details printed on receipts
+type ReceiptRestaurant struct {
+	Name           string
+	NameAr         string
+	Address        string
+	Phone          string
+	Email          string
+	Logo           string
+	Header         string
+	Footer         string
+	Currency       string
+	CurrencySymbol string
+	TaxNumber      string
+}
+
+// ReceiptOrder holds order header fields
+type ReceiptOrder struct {
+	ID           uint
+	Number       string
+	Type         string
+	Status       string
+	Priority     string
+	Table        string
+	CreatedAt    time.Time
+	Notes        string
+	KitchenNotes string
+}
+
+// ReceiptItem is an order line
+type ReceiptItem struct {
+	Name      string
+	NameAr    string
+	Quantity  int
+	UnitPrice float64
+	Total     float64
+	Notes     string
+	Modifiers []ReceiptModifier
+}
+
+// ReceiptModifier is an option chosen for an order line
+type ReceiptModifier struct {
+	Name   string  `json:"name"`
+	NameAr string  `json:"name_ar"`
+	Price  float64 `json:"price"`
+}
+
+// ReceiptTax is a tax or charge line
+type ReceiptTax struct {
+	Name   string
+	NameAr string
+	Rate   float64
+	Amount float64
+}
+
+// ReceiptTotals holds the order totals
+type ReceiptTotals struct {
+	Subtotal  float64
+	Tax       float64
+	Service   float64
+	Discount  float64
+	Total     float64
+	Paid      float64
+	Remaining float64
+	Change    float64
+}
+
+// ReceiptPayment is a payment taken against the order
+type ReceiptPayment struct {
+	Method    string
+	Amount    float64
+	Reference string
+	Tendered  float64
+	Change    float64
+	CreatedAt time.Time
+}
+
+// ReceiptCustomer is the customer on the order
+type ReceiptCustomer struct {
+	Name    string
+	Phone   string
+	Email   string
+	Address string
+	Points  int
+}
+
+// ReceiptQR is the content encoded in the receipt QR code
+type ReceiptQR struct {
+	Content string
+}
+
+// ReceiptEngine loads templates and renders orders with them
+type ReceiptEngine struct {
+	DB *gorm.DB
+}
+
+// NewReceiptEngine creates a receipt engine. db may be nil, in which case
+// the built-in templates are used.
+func NewReceiptEngine(db *gorm.DB) *ReceiptEngine {
+	return &ReceiptEngine{DB: db}
+}
+
+// Template returns the default template of kind, or the built-in one
+func (e *ReceiptEngine) Template(kind string) *ReceiptTemplate {
+	if e.DB != nil {
+		var tpl ReceiptTemplate
+		if err := e.DB.Where("kind = ? AND is_default = ?", kind, true).First(&tpl).Error; err == nil {
+			return &tpl
+		}
+	}
+	return builtinTemplate(kind)
+}
+
+// BuildData assembles the template data for an order. order should have
+// Items, Payments and Table preloaded.
+func (e *ReceiptEngine) BuildData(order *Order, settings *RestaurantSettings) *ReceiptData {
+	data := &ReceiptData{
+		Restaurant: ReceiptRestaurant{
+			Name:           clean(settings.Name),
+			NameAr:         clean(settings.NameAr),
+			Address:        clean(settings.Address),
+			Phone:          clean(settings.Phone),
+			Email:          clean(settings.Email),
+			Logo:           settings.Logo,
+			Header:         clean(settings.ReceiptHeader),
+			Footer:         clean(settings.ReceiptFooter),
+			Currency:       settings.Currency,
+			CurrencySymbol: clean(settings.CurrencySymbol),
+			TaxNumber:      clean(settings.TaxNumber),
+		},
+		Order: ReceiptOrder{
+			ID:           order.ID,
+			Number:       clean(order.OrderNumber),
+			Type:         order.Type,
+			Status:       order.Status,
+			Priority:     order.Priority,
+			CreatedAt:    order.CreatedAt,
+			Notes:        clean(order.Notes),
+			KitchenNotes: clean(order.KitchenNotes),
+		},
+		Totals: ReceiptTotals{
+			Subtotal:  order.Subtotal,
+			Tax:       order.TaxAmount,
+			Service:   order.ServiceCharge,
+			Discount:  order.Discount,
+			Total:     order.Total,
+			Paid:      order.PaidAmount,
+			Remaining: order.Remaining,
+		},
+		Language:  settings.Language,
+		PrintedAt: time.Now(),
+	}
+	if data.Language != "en" {
+		data.Language = "ar"
+	}
+	data.RTL = data.Language == "ar"
+	if order.Table != nil {
+		data.Order.Table = clean(order.Table.Number)
+	}
+
+	// Arabic names live on the menu item, not the order line
+	namesAr := make(map[uint]string)
+	if e.DB != nil && len(order.Items) > 0 {
+		ids := make([]uint, 0, len(order.Items))
+		for _, item := range order.Items {
+			ids = append(ids, item.MenuItemID)
+		}
+		var menuItems []MenuItem
+		e.DB.Select("id, name_ar").Where("id IN ?", ids).Find(&menuItems)
+		for _, m := range menuItems {
+			namesAr[m.ID] = m.NameAr
+		}
+	}
+
+	for _, item := range order.Items {
+		if item.Status == "cancelled" {
+			continue
+		}
+		data.Items = append(data.Items, ReceiptItem{
+			Name:      clean(item.MenuItemName),
+			NameAr:    clean(namesAr[item.MenuItemID]),
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Total:     float64(item.Quantity) * item.UnitPrice,
+			Notes:     clean(item.Notes),
+			Modifiers: parseModifiers(item.Modifiers),
+		})
+	}
+
+	if order.TaxAmount > 0 {
+		data.Taxes = append(data.Taxes, ReceiptTax{Name: "VAT", NameAr: "ضريبة القيمة المضافة", Rate: settings.TaxRate * 100, Amount: order.TaxAmount})
+	}
+	if order.ServiceCharge > 0 {
+		data.Taxes = append(data.Taxes, ReceiptTax{Name: "Service", NameAr: "خدمة", Rate: settings.ServiceCharge * 100, Amount: order.ServiceCharge})
+	}
+
+	for _, payment := range order.Payments {
+		data.Payments = append(data.Payments, ReceiptPayment{
+			Method:    clean(payment.Method),
+			Amount:    payment.Amount,
+			Reference: clean(payment.Reference),
+			Tendered:  payment.CashTendered,
+			Change:    payment.ChangeAmount,
+			CreatedAt: payment.CreatedAt,
+		})
+		data.Totals.Change += payment.ChangeAmount
+	}
+
+	if order.CustomerName != "" || order.CustomerPhone != "" {
+		data.Customer = &ReceiptCustomer{
+			Name:    clean(order.CustomerName),
+			Phone:   clean(order.CustomerPhone.String()),
+			Address: clean(order.CustomerAddress.String()),
+		}
+		if e.DB != nil && order.CustomerPhone != "" {
+			if customer, err := FindCustomerByPhone(e.DB, order.CustomerPhone.String()); err == nil {
+				data.Customer.Email = clean(customer.Email.String())
+				data.Customer.Points = customer.Points
+			}
+		}
+	}
+
+	// Fiscal receipts carry the tax authority's verification link instead
+	if order.ETAQRURL != "" {
+		data.QR.Content = order.ETAQRURL
+	} else {
+		data.QR.Content = fmt.Sprintf("%s|%s|%s|%.2f", data.Restaurant.Name, data.Order.Number,
+			order.CreatedAt.Format(time.RFC3339), order.Total)
+	}
+
+	return data
+}
+
+// SampleReceiptData returns a representative order for previews
+func SampleReceiptData(settings *RestaurantSettings) *ReceiptData {
+	now := time.Now()
+	table := Table{Number: "12"}
+	order := &Order{
+		ID:            1001,
+		OrderNumber:   "ORD-1001",
+		Type:          "dine_in",
+		Status:        "completed",
+		Priority:      "normal",
+		Table:         &table,
+		CustomerName:  "أحمد محمد",
+		CustomerPhone: "+201000000000",
+		Subtotal:      215,
+		TaxAmount:     30.1,
+		ServiceCharge: 21.5,
+		Discount:      10,
+		Total:         256.6,
+		PaidAmount:    256.6,
+		CreatedAt:     now,
+		KitchenNotes:  "بدون بصل",
+		Items: []OrderItem{
+			{MenuItemName: "Mixed Grill", Quantity: 1, UnitPrice: 150, Modifiers: `[{"name":"Extra rice","name_ar":"أرز إضافي","price":15}]`},
+			{MenuItemName: "Fresh Lemon Mint", Quantity: 2, UnitPrice: 25, Notes: "no sugar"},
+		},
+		Payments: []Payment{
+			{Method: "cash", Amount: 256.6, CashTendered: 300, ChangeAmount: 43.4, CreatedAt: now},
+		},
+	}
+
+	data := NewReceiptEngine(nil).BuildData(order, settings)
+	data.Items[0].NameAr = "مشويات مشكلة"
+	data.Items[1].NameAr = "ليمون بالنعناع"
+	return data
+}
+
+// clean strips control characters from data so it can't inject printer
+// commands or template directives
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || r == '\r' {
+			return ' '
+		}
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// parseModifiers decodes an order item's modifiers JSON, which is either a
+// list of names or a list of modifier objects
+func parseModifiers(raw string) []ReceiptModifier {
+	if raw == "" {
+		return nil
+	}
+
+	var modifiers []ReceiptModifier
+	var names []string
+	if err := json.Unmarshal([]byte(raw), &names); err == nil {
+		for _, name := range names {
+			modifiers = append(modifiers, ReceiptModifier{Name: clean(name)})
+		}
+		return modifiers
+	}
+
+	if err := json.Unmarshal([]byte(raw), &modifiers); err != nil {
+		return nil
+	}
+	for i := range modifiers {
+		modifiers[i].Name = clean(modifiers[i].Name)
+		modifiers[i].NameAr = clean(modifiers[i].NameAr)
+	}
+	return modifiers
+}
+
+// ========================================
+// RENDERING
+// ========================================
+
+// errTemplateOutputTooLarge stops runaway templates
+var errTemplateOutputTooLarge = errors.New("template output exceeds limit")
+
+// limitedBuffer fails writes past maxTemplateOutput
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxTemplateOutput {
+		return 0, errTemplateOutputTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// receiptFuncs returns the helpers available to templates in language
+func receiptFuncs(language string, currencySymbol string) map[string]interface{} {
+	arabic := language != "en"
+	num := func(v float64) string { return fmt.Sprintf("%.2f", v) }
+
+	return map[string]interface{}{
+		"t": func(en, ar string) string {
+			if arabic {
+				return ar
+			}
+			return en
+		},
+		"bi": func(en, ar string) string {
+			return en + " / " + ar
+		},
+		"local": func(name, nameAr string) string {
+			if arabic && nameAr != "" {
+				return nameAr
+			}
+			return name
+		},
+		"money": func(v float64) string {
+			return num(v) + " " + currencySymbol
+		},
+		"num": num,
+		"date": func(t time.Time, layout string) string {
+			return t.Format(layout)
+		},
+		"digits": func(s string) string {
+			if !arabic {
+				return s
+			}
+			return strings.Map(func(r rune) rune {
+				if r >= '0' && r <= '9' {
+					return r - '0' + 0x0660
+				}
+				return r
+			}, s)
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+		"pad": func(s string, width int) string {
+			if n := width - len([]rune(s)); n > 0 && width <= 80 {
+				return s + strings.Repeat(" ", n)
+			}
+			return s
+		},
+		"padLeft": func(s string, width int) string {
+			if n := width - len([]rune(s)); n > 0 && width <= 80 {
+				return strings.Repeat(" ", n) + s
+			}
+			return s
+		},
+		"repeat": func(s string, n int) string {
+			if n < 0 || n > 80 {
+				n = 0
+			}
+			return strings.Repeat(s, n)
+		},
+		"add": func(a, b float64) float64 { return a + b },
+		"sub": func(a, b float64) float64 { return a - b },
+		"mul": func(a, b float64) float64 { return a * b },
+	}
+}
+
+// directiveFuncs returns the printer layout functions for text templates
+func directiveFuncs() map[string]interface{} {
+	directive := func(name string, args ...string) string {
+		var sb strings.Builder
+		sb.WriteRune(directiveStart)
+		sb.WriteString(name)
+		for _, arg := range args {
+			sb.WriteRune(directiveSep)
+			sb.WriteString(clean(arg))
+		}
+		sb.WriteByte('\n')
+		return sb.String()
+	}
+
+	return map[string]interface{}{
+		"align":   func(align string) string { return directive("align", align) },
+		"bold":    func(on bool) string { return directive("bold", fmt.Sprint(on)) },
+		"reverse": func(on bool) string { return directive("reverse", fmt.Sprint(on)) },
+		"size": func(width, height int) string {
+			return directive("size", fmt.Sprint(width), fmt.Sprint(height))
+		},
+		"cols": func(left, right string) string { return directive("cols", left, right) },
+		"sep": func(ch string) string {
+			if ch == "" {
+				ch = "-"
+			}
+			return directive("sep", ch)
+		},
+		"feed": func(n int) string { return directive("feed", fmt.Sprint(n)) },
+		"qr":   func(content string) string { return directive("qr", content) },
+		"cut":  func() string { return directive("cut") },
+	}
+}
+
+// templateBuiltins are the text/template builtins templates may use
+var templateBuiltins = map[string]bool{
+	"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"print": true, "printf": true, "println": true, "html": true, "js": true, "urlquery": true,
+}
+
+// unsafeCSS lists what CSSStyles may not contain: anything that could close
+// the style element, old IE script hooks, external loads and escapes that
+// could spell any of them
+var unsafeCSS = []string{"<", "expression(", "javascript:", "vbscript:", "behavior:", "-moz-binding", "@import", "\\"}
+
+// cssNoise matches comments and whitespace, which are ignored when looking
+// for unsafe CSS so they can't be used to split a keyword
+var cssNoise = regexp.MustCompile(`(?s)/\*.*?\*/|\s+`)
+
+// checkTemplateCSS rejects CSS that could escape the style element or run
+// script
+func checkTemplateCSS(css string) error {
+	compact := cssNoise.ReplaceAllString(strings.ToLower(css), "")
+	for _, bad := range unsafeCSS {
+		if strings.Contains(compact, bad) {
+			return fmt.Errorf("css_styles may not contain %q", bad)
+		}
+	}
+	return nil
+}
+
+// checkTemplateTree rejects nested template definitions and calls to
+// functions outside funcs and templateBuiltins
+func checkTemplateTree(templates int, tree *parse.Tree, funcs map[string]interface{}) error {
+	if templates > 1 {
+		return errors.New("define and block are not allowed")
+	}
+	if tree == nil {
+		return nil
+	}
+
+	var walk func(node parse.Node) error
+	walk = func(node parse.Node) error {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return nil
+			}
+			for _, child := range n.Nodes {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case *parse.ActionNode:
+			return walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return nil
+			}
+			for _, cmd := range n.Cmds {
+				if err := walk(cmd); err != nil {
+					return err
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				if err := walk(arg); err != nil {
+					return err
+				}
+			}
+		case *parse.ChainNode:
+			return walk(n.Node)
+		case *parse.IfNode:
+			return walkBranch(walk, &n.BranchNode)
+		case *parse.RangeNode:
+			return walkBranch(walk, &n.BranchNode)
+		case *parse.WithNode:
+			return walkBranch(walk, &n.BranchNode)
+		case *parse.TemplateNode:
+			return errors.New("template calls are not allowed")
+		case *parse.IdentifierNode:
+			if _, ok := funcs[n.Ident]; !ok && !templateBuiltins[n.Ident] {
+				return fmt.Errorf("function %q is not allowed", n.Ident)
+			}
+		}
+		return nil
+	}
+	return walk(tree.Root)
+}
+
+// walkBranch checks the pipeline and both lists of an if, range or with
+func walkBranch(walk func(parse.Node) error, n *parse.BranchNode) error {
+	if err := walk(n.Pipe); err != nil {
+		return err
+	}
+	if err := walk(n.List); err != nil {
+		return err
+	}
+	if n.ElseList != nil {
+		return walk(n.ElseList)
+	}
+	return nil
+}
+
+// ParseReceiptTemplate checks that both template bodies compile
+func ParseReceiptTemplate(tpl *ReceiptTemplate) error {
+	if len(tpl.TemplateHTML) > maxTemplateSize || len(tpl.TemplateText) > maxTemplateSize {
+		return fmt.Errorf("template exceeds %d bytes", maxTemplateSize)
+	}
+	if err := checkTemplateCSS(tpl.CSSStyles); err != nil {
+		return err
+	}
+	if tpl.TemplateHTML != "" {
+		if _, err := parseHTMLTemplate(tpl, "ar", ""); err != nil {
+			return fmt.Errorf("template_html: %w", err)
+		}
+	}
+	if tpl.TemplateText != "" {
+		if _, err := parseTextTemplate(tpl, "ar", ""); err != nil {
+			return fmt.Errorf("template_text: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseHTMLTemplate(tpl *ReceiptTemplate, language, currencySymbol string) (*htmltemplate.Template, error) {
+	funcs := receiptFuncs(language, currencySymbol)
+	t, err := htmltemplate.New("receipt").
+		Funcs(funcs).
+		Parse(tpl.TemplateHTML)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplateTree(len(t.Templates()), t.Tree, funcs); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseTextTemplate(tpl *ReceiptTemplate, language, currencySymbol string) (*texttemplate.Template, error) {
+	funcs := receiptFuncs(language, currencySymbol)
+	for name, fn := range directiveFuncs() {
+		funcs[name] = fn
+	}
+	t, err := texttemplate.New("receipt").
+		Option("missingkey=zero").
+		Funcs(funcs).
+		Parse(tpl.TemplateText)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplateTree(len(t.Templates()), t.Tree, funcs); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RenderHTML renders the template's HTML body as a complete document
+func (e *ReceiptEngine) RenderHTML(tpl *ReceiptTemplate, data *ReceiptData) (string, error) {
+	if tpl.TemplateHTML == "" {
+		builtin := builtinTemplate(tpl.Kind)
+		tpl = &ReceiptTemplate{Kind: tpl.Kind, TemplateHTML: builtin.TemplateHTML, CSSStyles: builtin.CSSStyles, ShowLogo: tpl.ShowLogo, ShowQR: tpl.ShowQR}
+	}
+
+	view := *data
+	if !tpl.ShowQR {
+		view.QR.Content = ""
+	}
+	if !tpl.ShowLogo {
+		view.Restaurant.Logo = ""
+	}
+
+	if err := checkTemplateCSS(tpl.CSSStyles); err != nil {
+		return "", err
+	}
+	t, err := parseHTMLTemplate(tpl, data.Language, data.Restaurant.CurrencySymbol)
+	if err != nil {
+		return "", err
+	}
+
+	var body limitedBuffer
+	if err := t.Execute(&body, &view); err != nil {
+		return "", err
+	}
+
+	direction := "ltr"
+	if data.RTL {
+		direction = "rtl"
+	}
+
+	// html/template trusts CSS values as they are; checkTemplateCSS has
+	// made sure this one can't close the style element
+	page := htmltemplate.Must(htmltemplate.New("page").Parse(`<!DOCTYPE html>
+<html dir="{{.Direction}}" lang="{{.Language}}">
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+<style>{{.CSS}}</style>
+</head>
+<body>
+{{.Body}}
+</body>
+</html>`))
+
+	var out bytes.Buffer
+	err = page.Execute(&out, map[string]interface{}{
+		"Direction": direction,
+		"Language":  data.Language,
+		"Title":     data.Order.Number,
+		"CSS":       htmltemplate.CSS(tpl.CSSStyles),
+		"Body":      htmltemplate.HTML(body.String()),
+	})
+	return out.String(), err
+}
+
+// RenderText renders the template's text body, directives included
+func (e *ReceiptEngine) RenderText(tpl *ReceiptTemplate, data *ReceiptData) (string, error) {
+	if tpl.TemplateText == "" {
+		tpl = &ReceiptTemplate{Kind: tpl.Kind, TemplateText: builtinTemplate(tpl.Kind).TemplateText, ShowQR: tpl.ShowQR}
+	}
+
+	view := *data
+	if !tpl.ShowQR {
+		view.QR.Content = ""
+	}
+
+	t, err := parseTextTemplate(tpl, data.Language, data.Restaurant.CurrencySymbol)
+	if err != nil {
+		return "", err
+	}
+
+	var out limitedBuffer
+	if err := t.Execute(&out, &view); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// RenderESCPOS renders the template's text body onto an ESC/POS document
+func (e *ReceiptEngine) RenderESCPOS(tpl *ReceiptTemplate, data *ReceiptData, doc *ESCPOS) error {
+	text, err := e.RenderText(tpl, data)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		name, args, ok := parseDirective(line)
+		if !ok {
+			doc.Line(line)
+			continue
+		}
+
+		switch name {
+		case "align":
+			switch arg(args, 0) {
+			case "center":
+				doc.Align(AlignCenter)
+			case "right":
+				doc.Align(AlignRight)
+			default:
+				doc.Align(AlignLeft)
+			}
+		case "bold":
+			doc.Bold(arg(args, 0) == "true")
+		case "reverse":
+			doc.Reverse(arg(args, 0) == "true")
+		case "size":
+			doc.Size(getInt(arg(args, 0)), getInt(arg(args, 1)))
+		case "cols":
+			doc.Columns2(arg(args, 0), arg(args, 1))
+		case "sep":
+			doc.Separator(arg(args, 0))
+		case "feed":
+			doc.Feed(getInt(arg(args, 0)))
+		case "qr":
+			if content := arg(args, 0); content != "" {
+				doc.QRCode(content, 6)
+			}
+		case "cut":
+			doc.Cut(true)
+		}
+	}
+
+	return nil
+}
+
+// RenderWhatsApp renders the template's text body as a chat message
+func (e *ReceiptEngine) RenderWhatsApp(tpl *ReceiptTemplate, data *ReceiptData) (string, error) {
+	text, err := e.RenderText(tpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		name, args, ok := parseDirective(line)
+		if !ok {
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+			continue
+		}
+
+		switch name {
+		case "cols":
+			sb.WriteString(arg(args, 0) + ": " + arg(args, 1) + "\n")
+		case "sep":
+			sb.WriteString("\n")
+		}
+	}
+
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// parseDirective splits a directive line into its name and arguments
+func parseDirective(line string) (string, []string, bool) {
+	if !strings.HasPrefix(line, string(directiveStart)) {
+		return "", nil, false
+	}
+	parts := strings.Split(line[1:], string(directiveSep))
+	return parts[0], parts[1:], true
+}
+
+// readableDirectives shows directive lines as [name args] for previews
+func readableDirectives(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if name, args, ok := parseDirective(line); ok {
+			lines[i] = "[" + strings.TrimSpace(name+" "+strings.Join(args, " ")) + "]"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func arg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// ========================================
+// BUILT-IN TEMPLATES
+// ========================================
+
+// builtinTemplate returns the template used when none is set as default
+func builtinTemplate(kind string) *ReceiptTemplate {
+	if kind == TemplateKindKitchen {
+		return &ReceiptTemplate{
+			Name:         "Built-in kitchen ticket",
+			Kind:         TemplateKindKitchen,
+			TemplateText: builtinKitchenText,
+			TemplateHTML: builtinKitchenHTML,
+			CSSStyles:    builtinReceiptCSS,
+		}
+	}
+	return &ReceiptTemplate{
+		Name:         "Built-in receipt",
+		Kind:         TemplateKindReceipt,
+		TemplateText: builtinReceiptText,
+		TemplateHTML: builtinReceiptHTML,
+		CSSStyles:    builtinReceiptCSS,
+		ShowLogo:     true,
+		ShowQR:       true,
+	}
+}
+
+const builtinReceiptText = `{{align "center"}}{{bold true}}{{size 2 2}}{{local .Restaurant.Name .Restaurant.NameAr}}
+{{size 1 1}}{{bold false}}{{with .Restaurant.Address}}{{.}}
+{{end}}{{with .Restaurant.Phone}}{{.}}
+{{end}}{{with .Restaurant.Header}}{{.}}
+{{end}}{{sep "-"}}{{align "left"}}{{cols (t "Order" "طلب") (print "#" .Order.Number)}}{{cols (t "Date" "التاريخ") (date .Order.CreatedAt "2006-01-02 15:04")}}{{with .Order.Table}}{{cols (t "Table" "طاولة") .}}{{end}}{{with .Customer}}{{cols (t "Customer" "العميل") .Name}}{{end}}{{sep "-"}}{{range .Items}}{{cols (print .Quantity " x " (local .Name .NameAr)) (num .Total)}}{{range .Modifiers}}  + {{local .Name .NameAr}}
+{{end}}{{end}}{{sep "-"}}{{cols (t "Subtotal" "المجموع") (num .Totals.Subtotal)}}{{range .Taxes}}{{cols (local .Name .NameAr) (num .Amount)}}{{end}}{{if .Totals.Discount}}{{cols (t "Discount" "الخصم") (print "-" (num .Totals.Discount))}}{{end}}{{bold true}}{{size 1 2}}{{cols (t "Total" "الإجمالي") (money .Totals.Total)}}{{size 1 1}}{{bold false}}{{range .Payments}}{{cols .Method (num .Amount)}}{{end}}{{if .Totals.Change}}{{cols (t "Change" "الباقي") (num .Totals.Change)}}{{end}}{{sep "-"}}{{align "center"}}{{with .Restaurant.Footer}}{{.}}
+{{end}}{{t "Thank you!" "شكراً لزيارتكم"}}
+{{qr .QR.Content}}{{feed 3}}{{cut}}`
+
+const builtinKitchenText = `{{align "center"}}{{bold true}}{{if or (eq .Order.Priority "urgent") (eq .Order.Priority "high")}}{{reverse true}} {{upper .Order.Priority}}
+{{reverse false}}{{end}}{{size 2 2}}#{{.Order.Number}}
+{{size 1 1}}{{bold false}}{{.Order.Type}}  {{date .Order.CreatedAt "15:04"}}
+{{with .Order.Table}}{{bold true}}{{size 2 1}}{{t "Table" "طاولة"}} {{.}}
+{{size 1 1}}{{bold false}}{{end}}{{sep "="}}{{align "left"}}{{range .Items}}{{bold true}}{{size 1 2}}{{.Quantity}} x {{local .Name .NameAr}}
+{{size 1 1}}{{bold false}}{{range .Modifiers}}   + {{local .Name .NameAr}}
+{{end}}{{with .Notes}}   * {{.}}
+{{end}}{{end}}{{with .Order.KitchenNotes}}{{sep "-"}}{{bold true}}{{.}}
+{{bold false}}{{end}}{{sep "="}}{{feed 3}}{{cut}}`
+
+const builtinReceiptHTML = `<div class="header">
+  {{with .Restaurant.Logo}}<img class="logo" src="{{.}}" alt="">{{end}}
+  <h1>{{local .Restaurant.Name .Restaurant.NameAr}}</h1>
+  {{with .Restaurant.Address}}<p>{{.}}</p>{{end}}
+  {{with .Restaurant.Phone}}<p>{{.}}</p>{{end}}
+  {{with .Restaurant.Header}}<p>{{.}}</p>{{end}}
+</div>
+<table class="meta">
+  <tr><td>{{t "Order" "طلب"}}</td><td>#{{.Order.Number}}</td></tr>
+  <tr><td>{{t "Date" "التاريخ"}}</td><td>{{date .Order.CreatedAt "2006-01-02 15:04"}}</td></tr>
+  {{with .Order.Table}}<tr><td>{{t "Table" "طاولة"}}</td><td>{{.}}</td></tr>{{end}}
+  {{with .Customer}}<tr><td>{{t "Customer" "العميل"}}</td><td>{{.Name}}</td></tr>{{end}}
+</table>
+<table class="items">
+  {{range .Items}}
+  <tr><td>{{.Quantity}} × {{local .Name .NameAr}}{{range .Modifiers}}<div class="modifier">+ {{local .Name .NameAr}}</div>{{end}}</td><td class="amount">{{num .Total}}</td></tr>
+  {{end}}
+</table>
+<table class="totals">
+  <tr><td>{{t "Subtotal" "المجموع"}}</td><td class="amount">{{num .Totals.Subtotal}}</td></tr>
+  {{range .Taxes}}<tr><td>{{local .Name .NameAr}} ({{num .Rate}}%)</td><td class="amount">{{num .Amount}}</td></tr>{{end}}
+  {{if .Totals.Discount}}<tr><td>{{t "Discount" "الخصم"}}</td><td class="amount">-{{num .Totals.Discount}}</td></tr>{{end}}
+  <tr class="grand"><td>{{t "Total" "الإجمالي"}}</td><td class="amount">{{money .Totals.Total}}</td></tr>
+  {{range .Payments}}<tr><td>{{.Method}}</td><td class="amount">{{num .Amount}}</td></tr>{{end}}
+  {{if .Totals.Change}}<tr><td>{{t "Change" "الباقي"}}</td><td class="amount">{{num .Totals.Change}}</td></tr>{{end}}
+</table>
+<div class="footer">
+  {{with .Restaurant.Footer}}<p>{{.}}</p>{{end}}
+  <p>{{t "Thank you!" "شكراً لزيارتكم"}}</p>
+</div>`
+
+const builtinKitchenHTML = `<div class="header">
+  <h1>#{{.Order.Number}}</h1>
+  <p>{{.Order.Type}} · {{date .Order.CreatedAt "15:04"}}{{with .Order.Table}} · {{t "Table" "طاولة"}} {{.}}{{end}}</p>
+</div>
+<table class="items">
+  {{range .Items}}
+  <tr><td class="qty">{{.Quantity}} ×</td><td>{{local .Name .NameAr}}{{range .Modifiers}}<div class="modifier">+ {{local .Name .NameAr}}</div>{{end}}{{with .Notes}}<div class="modifier">* {{.}}</div>{{end}}</td></tr>
+  {{end}}
+</table>
+{{with .Order.KitchenNotes}}<p class="notes">{{.}}</p>{{end}}`
+
+const builtinReceiptCSS = `body { font-family: 'Cairo', Arial, sans-serif; max-width: 80mm; margin: 0 auto; padding: 10px; color: #111; }
+.header { text-align: center; margin-bottom: 10px; }
+.header h1 { font-size: 18px; margin: 5px 0; }
+.logo { max-width: 40mm; }
+table { width: 100%; border-collapse: collapse; border-top: 1px dashed #000; margin-top: 8px; }
+td { padding: 3px 0; vertical-align: top; }
+.amount { text-align: end; white-space: nowrap; }
+.modifier { font-size: 12px; color: #555; }
+.grand td { font-weight: bold; font-size: 16px; border-top: 1px solid #000; }
+.footer { text-align: center; margin-top: 16px; font-size: 12px; }
+.qty { font-weight: bold; width: 3em; }
+.notes { font-weight: bold; border-top: 1px dashed #000; padding-top: 6px; }`
+
+// ========================================
+// RECEIPT TEMPLATE HANDLERS
+// ========================================
+
+// HandleGetReceiptTemplates returns all receipt templates
+func (a *App) HandleGetReceiptTemplates(c *gin.Context) {
+	var templates []ReceiptTemplate
+
+	query := a.DB.Order("kind ASC, is_default DESC, name ASC")
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if err := query.Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch receipt templates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// HandleCreateReceiptTemplate creates a receipt template
+func (a *App) HandleCreateReceiptTemplate(c *gin.Context) {
+	var tpl ReceiptTemplate
+	if err := c.ShouldBindJSON(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	tpl.ID = 0
+	tpl.IsDefault = false
+
+	if err := validateReceiptTemplate(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid template", Message: err.Error()})
+		return
+	}
+
+	if err := a.DB.Create(&tpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create receipt template"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Receipt template created successfully",
+		Data:    tpl,
+	})
+}
+
+// HandleUpdateReceiptTemplate updates a receipt template
+func (a *App) HandleUpdateReceiptTemplate(c *gin.Context) {
+	id := c.Param("id")
+
+	var tpl ReceiptTemplate
+	if err := a.DB.First(&tpl, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Receipt template not found"})
+		return
+	}
+
+	// Bind over the existing record so omitted fields keep their values;
+	// the default flag only changes through set-default
+	templateID, isDefault := tpl.ID, tpl.IsDefault
+	if err := c.ShouldBindJSON(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	tpl.ID, tpl.IsDefault = templateID, isDefault
+
+	if err := validateReceiptTemplate(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid template", Message: err.Error()})
+		return
+	}
+
+	if err := a.DB.Save(&tpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update receipt template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Receipt template updated successfully",
+		Data:    tpl,
+	})
+}
+
+// HandleDeleteReceiptTemplate deletes a receipt template. Deleting the
+// default falls back to the built-in template.
+func (a *App) HandleDeleteReceiptTemplate(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := a.DB.Delete(&ReceiptTemplate{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete receipt template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Receipt template deleted successfully",
+	})
+}
+
+// HandleSetDefaultReceiptTemplate makes a template the default for its kind
+func (a *App) HandleSetDefaultReceiptTemplate(c *gin.Context) {
+	id := c.Param("id")
+
+	var tpl ReceiptTemplate
+	if err := a.DB.First(&tpl, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Receipt template not found"})
+		return
+	}
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ReceiptTemplate{}).
+			Where("kind = ? AND id <> ?", tpl.Kind, tpl.ID).
+			Update("is_default", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&tpl).Update("is_default", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to set default template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Default " + tpl.Kind + " template updated",
+		Data:    tpl,
+	})
+}
+
+// TemplatePreviewRequest renders a saved or draft template. Without an
+// order_id the sample order is used.
+type TemplatePreviewRequest struct {
+	TemplateID   uint   `json:"template_id"`
+	Kind         string `json:"kind"`
+	TemplateHTML string `json:"template_html"`
+	TemplateText string `json:"template_text"`
+	CSSStyles    string `json:"css_styles"`
+	ShowLogo     bool   `json:"show_logo"`
+	ShowQR       bool   `json:"show_qr"`
+	OrderID      uint   `json:"order_id"`
+	Format       string `json:"format"`   // "html" (default), "text", "whatsapp"
+	Language     string `json:"language"` // overrides the restaurant language
+}
+
+// HandlePreviewReceiptTemplate renders a template against a sample or real order
+func (a *App) HandlePreviewReceiptTemplate(c *gin.Context) {
+	var req TemplatePreviewRequest
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+			return
+		}
+	}
+	if id := c.Param("id"); id != "" {
+		req.TemplateID = uint(getInt(id))
+	}
+	if orderID := c.Query("order_id"); orderID != "" {
+		req.OrderID = uint(getInt(orderID))
+	}
+	if format := c.Query("format"); format != "" {
+		req.Format = format
+	}
+	if language := c.Query("language"); language != "" {
+		req.Language = language
+	}
+
+	tpl := &ReceiptTemplate{
+		Kind:         req.Kind,
+		TemplateHTML: req.TemplateHTML,
+		TemplateText: req.TemplateText,
+		CSSStyles:    req.CSSStyles,
+		ShowLogo:     req.ShowLogo,
+		ShowQR:       req.ShowQR,
+	}
+	if req.TemplateID != 0 {
+		if err := a.DB.First(tpl, req.TemplateID).Error; err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Receipt template not found"})
+			return
+		}
+	} else if err := validateReceiptTemplate(tpl); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid template", Message: err.Error()})
+		return
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+	if req.Language != "" {
+		settings.Language = req.Language
+	}
+
+	engine := NewReceiptEngine(a.DB)
+	var data *ReceiptData
+	if req.OrderID != 0 {
+		var order Order
+		if err := a.DB.Preload("Items").Preload("Payments").Preload("Table").First(&order, req.OrderID).Error; err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Order not found"})
+			return
+		}
+		data = engine.BuildData(&order, &settings)
+	} else {
+		data = SampleReceiptData(&settings)
+	}
+
+	switch req.Format {
+	case "text", "whatsapp":
+		render := engine.RenderText
+		if req.Format == "whatsapp" {
+			render = engine.RenderWhatsApp
+		}
+		text, err := render(tpl, data)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Failed to render template", Message: err.Error()})
+			return
+		}
+		if req.Format == "text" {
+			text = readableDirectives(text)
+		}
+		c.JSON(http.StatusOK, gin.H{"format": req.Format, "text": text})
+	default:
+		html, err := engine.RenderHTML(tpl, data)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Failed to render template", Message: err.Error()})
+			return
+		}
+		// The preview is served from the API origin; keep it from running
+		// script or reaching anything but inline styles and images
+		c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data: https: http:; sandbox")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+	}
+}
+
+// validateReceiptTemplate checks the kind and that the bodies compile
+func validateReceiptTemplate(tpl *ReceiptTemplate) error {
+	if tpl.Kind == "" {
+		tpl.Kind = TemplateKindReceipt
+	}
+	if tpl.Kind != TemplateKindReceipt && tpl.Kind != TemplateKindKitchen {
+		return fmt.Errorf("kind must be receipt or kitchen")
+	}
+	if tpl.TemplateHTML == "" && tpl.TemplateText == "" {
+		return fmt.Errorf("template_html or template_text is required")
+	}
+	return ParseReceiptTemplate(tpl)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinTemplatesParse(t *testing.T) {
+	for _, kind := range []string{TemplateKindReceipt, TemplateKindKitchen} {
+		if err := ParseReceiptTemplate(builtinTemplate(kind)); err != nil {
+			t.Errorf("built-in %s template: %v", kind, err)
+		}
+	}
+}
+
+func TestTemplateCSSRejectsEscapes(t *testing.T) {
+	unsafe := []string{
+		"body{}</style><script>alert(1)</script>",
+		"body{}</STYLE >",
+		"p { width: expression(alert(1)) }",
+		"p { width: expr/**/ession(alert(1)) }",
+		"p { background: url(javascript:alert(1)) }",
+		"@import url(//evil.example/x.css);",
+		`p { content: "\3c/style\3e" }`,
+	}
+	for _, css := range unsafe {
+		if err := checkTemplateCSS(css); err == nil {
+			t.Errorf("accepted %q", css)
+		}
+	}
+	if err := checkTemplateCSS(builtinReceiptCSS); err != nil {
+		t.Errorf("built-in CSS rejected: %v", err)
+	}
+}
+
+func TestTemplateFunctionsSandboxed(t *testing.T) {
+	rejected := map[string]string{
+		"call":     `{{call .Restaurant.Name}}`,
+		"define":   `{{define "x"}}x{{end}}{{template "x"}}`,
+		"block":    `{{block "x" .}}x{{end}}`,
+		"template": `{{template "receipt" .}}`,
+		"nested":   `{{range .Items}}{{if true}}{{call .Name}}{{end}}{{end}}`,
+	}
+	for name, body := range rejected {
+		if err := ParseReceiptTemplate(&ReceiptTemplate{TemplateHTML: body}); err == nil {
+			t.Errorf("%s: HTML template accepted", name)
+		}
+		if err := ParseReceiptTemplate(&ReceiptTemplate{TemplateText: body}); err == nil {
+			t.Errorf("%s: text template accepted", name)
+		}
+	}
+
+	allowed := `{{range $i, $item := .Items}}{{if gt (len $.Items) 1}}{{printf "%d" $i}}{{end}}{{upper $item.Name}}{{end}}`
+	if err := ParseReceiptTemplate(&ReceiptTemplate{TemplateHTML: allowed, TemplateText: allowed}); err != nil {
+		t.Errorf("template using builtins rejected: %v", err)
+	}
+}
+
+func TestRenderHTMLKeepsStyleClosed(t *testing.T) {
+	engine := NewReceiptEngine(nil)
+	data := SampleReceiptData(&RestaurantSettings{})
+
+	tpl := &ReceiptTemplate{TemplateHTML: `<p>{{.Order.Number}}</p>`, CSSStyles: "p{}</style><script>alert(1)</script>"}
+	if _, err := engine.RenderHTML(tpl, data); err == nil {
+		t.Fatal("rendered CSS that closes the style element")
+	}
+
+	page, err := engine.RenderHTML(builtinTemplate(TemplateKindReceipt), data)
+	if err != nil {
+		t.Fatalf("render built-in: %v", err)
+	}
+	if strings.Count(page, "</style>") != 1 || strings.Contains(page, "<script") {
+		t.Errorf("unexpected markup in rendered page:\n%s", page)
+	}
+}
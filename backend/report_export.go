@@ -0,0 +1,556 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// REPORT EXPORT
+// ========================================
+
+// Report output formats
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+	ReportFormatXLSX = "xlsx"
+	ReportFormatPDF  = "pdf"
+)
+
+var validReportFormats = map[string]bool{
+	ReportFormatJSON: true,
+	ReportFormatCSV:  true,
+	ReportFormatXLSX: true,
+	ReportFormatPDF:  true,
+}
+
+// csvFlushRows is how many CSV rows are buffered before flushing to the client
+const csvFlushRows = 500
+
+// reportLabels are the English and Arabic headings of report columns
+var reportLabels = map[string][2]string{
+	"period":         {"Period", "الفترة"},
+	"item":           {"Item", "الصنف"},
+	"category":       {"Category", "الفئة"},
+	"method":         {"Payment method", "طريقة الدفع"},
+	"staff":          {"Staff", "الموظف"},
+	"orders":         {"Orders", "الطلبات"},
+	"revenue":        {"Revenue", "الإيرادات"},
+	"subtotal":       {"Subtotal", "المجموع الفرعي"},
+	"discount":       {"Discount", "الخصم"},
+	"tax":            {"Tax", "الضريبة"},
+	"service_charge": {"Service charge", "رسوم الخدمة"},
+	"average_order":  {"Average order", "متوسط الطلب"},
+	"quantity":       {"Quantity", "الكمية"},
+	"average_price":  {"Average price", "متوسط السعر"},
+	"share":          {"Share %", "النسبة %"},
+	"count":          {"Payments", "عدد المدفوعات"},
+	"amount":         {"Amount", "المبلغ"},
+	"refunds":        {"Refunds", "المرتجعات"},
+	"tips":           {"Tips", "الإكراميات"},
+	"net":            {"Net", "الصافي"},
+	"cancelled":      {"Cancelled", "الملغاة"},
+	"total":          {"Total", "الإجمالي"},
+	"previous":       {"previous", "السابق"},
+	"change":         {"change %", "التغير %"},
+	"previous_total": {"Previous period", "الفترة السابقة"},
+	"from":           {"From", "من"},
+	"to":             {"To", "إلى"},
+	"compared_with":  {"Compared with", "مقارنة بـ"},
+}
+
+// reportTitles are the English and Arabic titles of each report
+var reportTitles = map[string][2]string{
+	"sales":      {"Sales report", "تقرير المبيعات"},
+	"items":      {"Sales by item", "المبيعات حسب الصنف"},
+	"categories": {"Sales by category", "المبيعات حسب الفئة"},
+	"payments":   {"Payments by method", "المدفوعات حسب طريقة الدفع"},
+	"staff":      {"Sales by staff", "المبيعات حسب الموظف"},
+}
+
+// reportLabel returns the heading for key in lang, or key itself
+func reportLabel(labels map[string][2]string, key, lang string) string {
+	label, ok := labels[key]
+	if !ok {
+		return key
+	}
+	if lang == "ar" {
+		return label[1]
+	}
+	return label[0]
+}
+
+// ExportTable is tabular data that can be written as CSV or XLSX. Each
+// calls fn once per row, in order.
+type ExportTable interface {
+	Title() string
+	RTL() bool
+	Headers() []string
+	Each(fn func(cells []XLSXCell) error) error
+}
+
+// reportTable is a report flattened into header and rows for export.
+// Columns are the row label, the group (items only) and every metric in
+// spec order; with a comparison each metric is followed by its previous
+// value and percent change.
+type reportTable struct {
+	report *Report
+	spec   *reportSpec
+	lang   string
+}
+
+// Title returns the localized report title
+func (t *reportTable) Title() string {
+	return reportLabel(reportTitles, t.report.Name, t.lang)
+}
+
+// RTL reports whether the table reads right to left
+func (t *reportTable) RTL() bool {
+	return t.lang == "ar"
+}
+
+// Headers returns the localized column headings
+func (t *reportTable) Headers() []string {
+	headers := []string{reportLabel(reportLabels, t.spec.dimension, t.lang)}
+	if t.spec.group != "" {
+		headers = append(headers, reportLabel(reportLabels, t.spec.group, t.lang))
+	}
+	for _, col := range t.report.Columns {
+		label := reportLabel(reportLabels, col.Key, t.lang)
+		headers = append(headers, label)
+		if t.report.Compare != nil {
+			headers = append(headers,
+				label+" ("+reportLabel(reportLabels, "previous", t.lang)+")",
+				label+" ("+reportLabel(reportLabels, "change", t.lang)+")")
+		}
+	}
+	return headers
+}
+
+// Each calls fn for every row and then for the summary row
+func (t *reportTable) Each(fn func(cells []XLSXCell) error) error {
+	for i := range t.report.Rows {
+		if err := fn(t.cells(&t.report.Rows[i], false)); err != nil {
+			return err
+		}
+	}
+	return fn(t.cells(&t.report.Summary, true))
+}
+
+func (t *reportTable) cells(row *ReportRow, summary bool) []XLSXCell {
+	label := row.Label
+	switch {
+	case summary:
+		label = reportLabel(reportLabels, "total", t.lang)
+	case t.lang == "ar" && row.LabelAr != "":
+		label = row.LabelAr
+	}
+
+	cells := []XLSXCell{{Text: label, Bold: summary}}
+	if t.spec.group != "" {
+		cells = append(cells, XLSXCell{Text: row.Group, Bold: summary})
+	}
+	for _, col := range t.report.Columns {
+		decimals := reportDecimals(col)
+		cells = append(cells, XLSXCell{Number: row.Metrics[col.Key], Numeric: true, Decimals: decimals, Bold: summary})
+		if t.report.Compare == nil {
+			continue
+		}
+		cells = append(cells, XLSXCell{Number: row.Previous[col.Key], Numeric: true, Decimals: decimals, Bold: summary})
+		if change := row.Change[col.Key]; change != nil {
+			cells = append(cells, XLSXCell{Number: *change, Numeric: true, Decimals: 2, Bold: summary})
+		} else {
+			cells = append(cells, XLSXCell{Bold: summary})
+		}
+	}
+	return cells
+}
+
+// reportDecimals is the number of decimal places shown for a column
+func reportDecimals(col ReportColumn) int {
+	if col.Type == "count" || col.Type == "quantity" {
+		return 0
+	}
+	return 2
+}
+
+// reportFilename names an export after the report and its inclusive dates
+func reportFilename(name string, r ReportRange, format string) string {
+	last := r.To.Add(-time.Second)
+	return fmt.Sprintf("%s-report-%s-%s.%s", name, r.From.Format("2006-01-02"), last.Format("2006-01-02"), format)
+}
+
+// WriteReportCSV writes t as UTF-8 CSV with a byte order mark so Excel
+// detects the encoding. Numbers are written unformatted.
+func WriteReportCSV(w io.Writer, t ExportTable) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Headers()); err != nil {
+		return err
+	}
+
+	written := 0
+	record := make([]string, 0, len(t.Headers()))
+	err := t.Each(func(cells []XLSXCell) error {
+		record = record[:0]
+		for _, cell := range cells {
+			if cell.Numeric {
+				record = append(record, strconv.FormatFloat(cell.Number, 'f', cell.Decimals, 64))
+			} else {
+				record = append(record, cell.Text)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		if written++; written%csvFlushRows == 0 {
+			cw.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		return cw.Error()
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteReportXLSX writes t as a single-sheet workbook with typed numeric
+// cells and a bold header and total row
+func WriteReportXLSX(w io.Writer, t ExportTable) error {
+	x, err := NewXLSXWriter(w, t.Title(), t.RTL())
+	if err != nil {
+		return err
+	}
+
+	headers := t.Headers()
+	cells := make([]XLSXCell, len(headers))
+	for i, h := range headers {
+		cells[i] = XLSXCell{Text: h, Bold: true}
+	}
+	if err := x.WriteRow(cells); err != nil {
+		return err
+	}
+	if err := t.Each(x.WriteRow); err != nil {
+		return err
+	}
+	return x.Close()
+}
+
+// exportReport writes report as a CSV, XLSX or PDF download
+func (a *App) exportReport(c *gin.Context, name string, spec *reportSpec, report *Report, format string) {
+	settings, lang := a.exportLanguage(c)
+	table := &reportTable{report: report, spec: spec, lang: lang}
+	a.writeExport(c, reportFilename(name, report.Range, format), format, table, func() *PDFTable {
+		return table.PDF(settings.Name, settings.NameAr)
+	})
+}
+
+// exportLanguage returns the restaurant settings and the export language:
+// the lang query parameter, else the restaurant's language
+func (a *App) exportLanguage(c *gin.Context) (RestaurantSettings, string) {
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+
+	lang := c.Query("lang")
+	if lang != "ar" && lang != "en" {
+		lang = settings.Language
+	}
+	return settings, lang
+}
+
+// writeExport writes table as a CSV, XLSX or PDF attachment. CSV and XLSX
+// are streamed to the client; the PDF layout is built only when asked for.
+func (a *App) writeExport(c *gin.Context, filename, format string, table ExportTable, pdfTable func() *PDFTable) {
+	if format == ReportFormatPDF {
+		fonts, err := LoadPDFFonts(a.Config.Documents.FontPath, a.Config.Documents.BoldFontPath)
+		if errors.Is(err, ErrNoPDFFont) {
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "PDF output is not configured", Message: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load fonts", Message: err.Error()})
+			return
+		}
+		pdf, err := RenderTablePDF(pdfTable(), fonts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to render report", Message: err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Header("Cache-Control", "private, no-store")
+		c.Data(http.StatusOK, "application/pdf", pdf)
+		return
+	}
+
+	// Once the body has started an error can only be logged
+	contentType := "text/csv; charset=utf-8"
+	write := WriteReportCSV
+	if format == ReportFormatXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		write = WriteReportXLSX
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+	if err := write(c.Writer, table); err != nil {
+		log.Printf("Report export %s failed: %v", filename, err)
+	}
+}
+
+// ========================================
+// REPORT PDF
+// ========================================
+
+// PDFTable is a titled table for RenderTablePDF. The first Labels columns
+// are text, aligned to the start side; the rest are numbers, aligned to
+// the end side. Summary rows are drawn bold after the body.
+type PDFTable struct {
+	Title    string
+	Subtitle string
+	Heading  []string
+	Headers  []string
+	Labels   int
+	Rows     [][]string
+	Summary  [][]string
+	RTL      bool
+}
+
+// PDF lays the report out with metrics only; the comparison goes in the
+// summary rows
+func (t *reportTable) PDF(restaurant, restaurantAr string) *PDFTable {
+	arabic := t.lang == "ar"
+	table := &PDFTable{
+		Title:    restaurant,
+		Subtitle: reportLabel(reportTitles, t.report.Name, t.lang),
+		Heading:  []string{reportRangeText(t.report.Range, t.lang)},
+		Headers:  []string{reportLabel(reportLabels, t.spec.dimension, t.lang)},
+		Labels:   1,
+		RTL:      arabic,
+	}
+	if arabic && restaurantAr != "" {
+		table.Title = restaurantAr
+	}
+	if t.report.Compare != nil {
+		table.Heading = append(table.Heading, reportLabel(reportLabels, "compared_with", t.lang)+": "+reportRangeText(*t.report.Compare, t.lang))
+	}
+	if t.spec.group != "" {
+		table.Headers = append(table.Headers, reportLabel(reportLabels, t.spec.group, t.lang))
+		table.Labels++
+	}
+	for _, col := range t.report.Columns {
+		table.Headers = append(table.Headers, reportLabel(reportLabels, col.Key, t.lang))
+	}
+
+	format := func(group string, metrics map[string]float64, label string) []string {
+		cells := []string{label}
+		if t.spec.group != "" {
+			cells = append(cells, group)
+		}
+		for _, col := range t.report.Columns {
+			cells = append(cells, formatReportNumber(metrics[col.Key], reportDecimals(col)))
+		}
+		return cells
+	}
+
+	for _, row := range t.report.Rows {
+		label := row.Label
+		if arabic && row.LabelAr != "" {
+			label = row.LabelAr
+		}
+		table.Rows = append(table.Rows, format(row.Group, row.Metrics, label))
+	}
+
+	table.Summary = [][]string{format("", t.report.Summary.Metrics, reportLabel(reportLabels, "total", t.lang))}
+	if t.report.Compare != nil {
+		table.Summary = append(table.Summary, format("", t.report.Summary.Previous, reportLabel(reportLabels, "previous_total", t.lang)))
+		change := []string{reportLabel(reportLabels, "change", t.lang)}
+		if t.spec.group != "" {
+			change = append(change, "")
+		}
+		for _, col := range t.report.Columns {
+			s := "-"
+			if pct := t.report.Summary.Change[col.Key]; pct != nil {
+				s = fmt.Sprintf("%+.2f%%", *pct)
+			}
+			change = append(change, s)
+		}
+		table.Summary = append(table.Summary, change)
+	}
+	return table
+}
+
+// RenderTablePDF lays a table out on A4 pages, landscape when it has many
+// columns. Rows that do not fit start a new page under a repeated header;
+// for right-to-left tables the columns run from the right edge.
+func RenderTablePDF(t *PDFTable, fonts *PDFFontFamily) ([]byte, error) {
+	const (
+		margin    = 12 * mmToPt
+		rowHeight = 16.0
+		fontSize  = 8.5
+		padding   = 3.0
+	)
+
+	width, height := PageA4Width, PageA4Height
+	if len(t.Headers) > 6 {
+		width, height = height, width
+	}
+
+	pdf := NewPDF()
+	l := &docLayout{pdf: pdf, fonts: fonts, rtl: t.RTL, arabic: t.RTL, left: margin, right: width - margin}
+
+	// Text columns share up to half the width, numbers split the rest
+	tableWidth := l.right - l.left
+	labels := t.Labels
+	if labels < 1 {
+		labels = 1
+	}
+	labelWidth := math.Min(0.18*float64(labels)+0.12, 0.5) * tableWidth / float64(labels)
+	metricWidth := 0.0
+	if len(t.Headers) > labels {
+		metricWidth = (tableWidth - labelWidth*float64(labels)) / float64(len(t.Headers)-labels)
+	} else {
+		labelWidth = tableWidth / float64(len(t.Headers))
+	}
+	bounds := make([][2]float64, len(t.Headers))
+	x := l.left
+	for i := range t.Headers {
+		w := metricWidth
+		if i < labels {
+			w = labelWidth
+		}
+		bounds[i] = [2]float64{x, x + w}
+		if l.rtl {
+			bounds[i] = [2]float64{l.left + l.right - x - w, l.left + l.right - x}
+		}
+		x += w
+	}
+
+	drawRow := func(cells []string, bold bool, shade float64) {
+		if shade > 0 {
+			pdf.Rect(l.left, l.y, tableWidth, rowHeight, shade)
+		}
+		l.font(fontSize, bold)
+		for i, s := range cells {
+			if i >= len(bounds) {
+				break
+			}
+			left, right := bounds[i][0]+padding, bounds[i][1]-padding
+			s = fitText(pdf, s, right-left)
+			if i < labels {
+				l.start(left, right, l.y+rowHeight-5, s)
+			} else {
+				l.end(left, right, l.y+rowHeight-5, s)
+			}
+		}
+		l.y += rowHeight
+		pdf.Line(l.left, l.y, l.right, l.y, 0.3, 0.8)
+	}
+	newPage := func() {
+		pdf.AddPage(width, height)
+		l.y = margin
+		drawRow(t.Headers, true, 0.9)
+	}
+
+	// Title block
+	pdf.AddPage(width, height)
+	l.y = margin
+	if t.Title != "" {
+		l.font(14, true)
+		l.y += 14
+		l.start(l.left, l.right, l.y, t.Title)
+	}
+	l.font(11, true)
+	l.y += 16
+	l.start(l.left, l.right, l.y, t.Subtitle)
+	l.font(9, false)
+	for _, line := range t.Heading {
+		l.y += 13
+		l.start(l.left, l.right, l.y, line)
+	}
+	l.y += 10
+	drawRow(t.Headers, true, 0.9)
+
+	bottom := height - margin
+	for _, row := range t.Rows {
+		if l.y+rowHeight > bottom {
+			newPage()
+		}
+		drawRow(row, false, 0)
+	}
+	if l.y+rowHeight*float64(len(t.Summary)) > bottom {
+		newPage()
+	}
+	for _, row := range t.Summary {
+		drawRow(row, true, 0.95)
+	}
+
+	return pdf.Bytes()
+}
+
+// reportRangeText formats an inclusive date range for a report heading
+func reportRangeText(r ReportRange, lang string) string {
+	layout := "2006-01-02"
+	if r.GroupBy == GroupByHour || r.From.Hour() != 0 || r.To.Hour() != 0 {
+		layout = "2006-01-02 15:04"
+	}
+	last := r.To
+	if layout == "2006-01-02" {
+		last = r.To.Add(-time.Second)
+	}
+	return fmt.Sprintf("%s %s  %s %s  (%s)",
+		reportLabel(reportLabels, "from", lang), r.From.Format(layout),
+		reportLabel(reportLabels, "to", lang), last.Format(layout), r.Timezone)
+}
+
+// formatReportNumber formats v with thousands separators
+func formatReportNumber(v float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, fraction := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		whole, fraction = s[:dot], s[dot:]
+	}
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	b.WriteString(fraction)
+	return b.String()
+}
+
+// fitText shortens s with an ellipsis until it fits width
+func fitText(pdf *PDF, s string, width float64) string {
+	if pdf.TextWidth(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if candidate := string(runes) + "..."; pdf.TextWidth(candidate) <= width {
+			return candidate
+		}
+	}
+	return ""
+}
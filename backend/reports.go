@@ -0,0 +1,776 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // restaurant time zones on hosts without zoneinfo
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// REPORTS
+// ========================================
+
+// Report time buckets
+const (
+	GroupByHour  = "hour"
+	GroupByDay   = "day"
+	GroupByWeek  = "week"
+	GroupByMonth = "month"
+)
+
+// ReportWeekStart is the first day of a reporting week
+const ReportWeekStart = time.Saturday
+
+// maxReportBuckets caps the series length, e.g. hourly over three months
+const maxReportBuckets = 2500
+
+// ReportRange is a half-open [From, To) window in the restaurant's time zone
+type ReportRange struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Timezone string    `json:"timezone"`
+	GroupBy  string    `json:"group_by,omitempty"`
+
+	loc *time.Location
+}
+
+// ReportColumn describes one metric. Share columns are a percentage of
+// the report total of another metric.
+type ReportColumn struct {
+	Key  string `json:"key"`
+	Type string `json:"type"` // count, quantity, money or percent
+
+	derived bool   // computed from other metrics, not summed
+	shareOf string // metric this column is a percentage of
+}
+
+// ReportRow is one line of a report: a time bucket or a dimension value
+// (item, category, payment method, staff member) with its metrics, the
+// same metrics for the comparison period and the percent change
+type ReportRow struct {
+	Key      string              `json:"key"`
+	Label    string              `json:"label"`
+	LabelAr  string              `json:"label_ar,omitempty"`
+	Group    string              `json:"group,omitempty"`
+	Metrics  map[string]float64  `json:"metrics"`
+	Previous map[string]float64  `json:"previous,omitempty"`
+	Change   map[string]*float64 `json:"change,omitempty"`
+}
+
+// Report is the result of one report query
+type Report struct {
+	Name    string         `json:"name"`
+	Range   ReportRange    `json:"range"`
+	Compare *ReportRange   `json:"compare,omitempty"`
+	Columns []ReportColumn `json:"columns"`
+	Summary ReportRow      `json:"summary"`
+	Rows    []ReportRow    `json:"rows"`
+}
+
+// reportSpec defines a report as one aggregate query. Time series queries
+// select a "bucket" column (UNIX time / bucket seconds) and are folded into
+// GroupBy buckets in the restaurant's time zone; dimension queries select
+// "key", "label" and optionally "label_ar" and "grp". dimension and group
+// name the row label and grp columns in exports.
+type reportSpec struct {
+	name       string
+	dimension  string
+	group      string
+	columns    []ReportColumn
+	sql        string
+	timeSeries bool
+	sortBy     string
+	derive     func(m map[string]float64)
+	labelAr    func(key string) string
+}
+
+// reportEndpoint is a report with the defaults of one API endpoint
+type reportEndpoint struct {
+	spec          *reportSpec
+	defaultPeriod string
+	defaultGroup  string
+}
+
+var salesReport = &reportSpec{
+	name:      "sales",
+	dimension: "period",
+	columns: []ReportColumn{
+		{Key: "orders", Type: "count"},
+		{Key: "revenue", Type: "money"},
+		{Key: "subtotal", Type: "money"},
+		{Key: "discount", Type: "money"},
+		{Key: "tax", Type: "money"},
+		{Key: "service_charge", Type: "money"},
+		{Key: "average_order", Type: "money", derived: true},
+	},
+	sql: `SELECT FLOOR(UNIX_TIMESTAMP(o.created_at) / ?) AS bucket,
+		COUNT(*) AS orders,
+		COALESCE(SUM(o.total), 0) AS revenue,
+		COALESCE(SUM(o.subtotal), 0) AS subtotal,
+		COALESCE(SUM(o.discount), 0) AS discount,
+		COALESCE(SUM(o.tax_amount), 0) AS tax,
+		COALESCE(SUM(o.service_charge), 0) AS service_charge
+		FROM orders o
+		WHERE o.payment_status = 'paid' AND o.created_at >= ? AND o.created_at < ?
+		GROUP BY bucket`,
+	timeSeries: true,
+	derive: func(m map[string]float64) {
+		m["average_order"] = reportRatio(m["revenue"], m["orders"])
+	},
+}
+
+var itemsReport = &reportSpec{
+	name:      "items",
+	dimension: "item",
+	group:     "category",
+	columns: []ReportColumn{
+		{Key: "quantity", Type: "quantity"},
+		{Key: "revenue", Type: "money"},
+		{Key: "average_price", Type: "money", derived: true},
+		{Key: "share", Type: "percent", derived: true, shareOf: "revenue"},
+	},
+	sql: `SELECT oi.menu_item_id AS ` + "`key`" + `,
+		MAX(oi.menu_item_name) AS label,
+		MAX(mi.name_ar) AS label_ar,
+		MAX(c.name) AS grp,
+		SUM(oi.quantity) AS quantity,
+		COALESCE(SUM(oi.quantity * oi.unit_price), 0) AS revenue
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		LEFT JOIN menu_items mi ON mi.id = oi.menu_item_id
+		LEFT JOIN categories c ON c.id = mi.category_id
+		WHERE o.payment_status = 'paid' AND oi.status <> 'cancelled'
+			AND o.created_at >= ? AND o.created_at < ?
+		GROUP BY oi.menu_item_id`,
+	sortBy: "revenue",
+	derive: func(m map[string]float64) {
+		m["average_price"] = reportRatio(m["revenue"], m["quantity"])
+	},
+}
+
+var categoriesReport = &reportSpec{
+	name:      "categories",
+	dimension: "category",
+	columns: []ReportColumn{
+		{Key: "quantity", Type: "quantity"},
+		{Key: "revenue", Type: "money"},
+		{Key: "share", Type: "percent", derived: true, shareOf: "revenue"},
+	},
+	sql: `SELECT COALESCE(c.id, 0) AS ` + "`key`" + `,
+		COALESCE(MAX(c.name), 'Uncategorized') AS label,
+		MAX(c.name_ar) AS label_ar,
+		SUM(oi.quantity) AS quantity,
+		COALESCE(SUM(oi.quantity * oi.unit_price), 0) AS revenue
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		LEFT JOIN menu_items mi ON mi.id = oi.menu_item_id
+		LEFT JOIN categories c ON c.id = mi.category_id
+		WHERE o.payment_status = 'paid' AND oi.status <> 'cancelled'
+			AND o.created_at >= ? AND o.created_at < ?
+		GROUP BY c.id`,
+	sortBy: "revenue",
+}
+
+var paymentsReport = &reportSpec{
+	name:      "payments",
+	dimension: "method",
+	columns: []ReportColumn{
+		{Key: "count", Type: "count"},
+		{Key: "amount", Type: "money"},
+		{Key: "refunds", Type: "money"},
+		{Key: "tips", Type: "money"},
+		{Key: "net", Type: "money", derived: true},
+		{Key: "share", Type: "percent", derived: true, shareOf: "net"},
+	},
+	sql: `SELECT p.method AS ` + "`key`" + `,
+		p.method AS label,
+		SUM(CASE WHEN p.type = 'sale' THEN 1 ELSE 0 END) AS count,
+		COALESCE(SUM(CASE WHEN p.type = 'sale' THEN p.amount END), 0) AS amount,
+		COALESCE(SUM(CASE WHEN p.type = 'refund' THEN p.amount END), 0) AS refunds,
+		COALESCE(SUM(CASE WHEN p.type = 'tip' THEN p.amount END), 0) AS tips
+		FROM payments p
+		WHERE p.created_at >= ? AND p.created_at < ?
+		GROUP BY p.method`,
+	sortBy: "net",
+	derive: func(m map[string]float64) {
+		m["net"] = m["amount"] - m["refunds"]
+	},
+	labelAr: func(key string) string {
+		return paymentMethodNamesAr[key]
+	},
+}
+
+var staffReport = &reportSpec{
+	name:      "staff",
+	dimension: "staff",
+	columns: []ReportColumn{
+		{Key: "orders", Type: "count"},
+		{Key: "revenue", Type: "money"},
+		{Key: "discount", Type: "money"},
+		{Key: "cancelled", Type: "count"},
+		{Key: "average_order", Type: "money", derived: true},
+	},
+	sql: `SELECT o.user_id AS ` + "`key`" + `,
+		COALESCE(MAX(u.name), CONCAT('#', o.user_id)) AS label,
+		MAX(u.name_ar) AS label_ar,
+		SUM(CASE WHEN o.payment_status = 'paid' THEN 1 ELSE 0 END) AS orders,
+		COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.total END), 0) AS revenue,
+		COALESCE(SUM(CASE WHEN o.payment_status = 'paid' THEN o.discount END), 0) AS discount,
+		SUM(CASE WHEN o.status = 'cancelled' THEN 1 ELSE 0 END) AS cancelled
+		FROM orders o
+		LEFT JOIN users u ON u.id = o.user_id
+		WHERE o.created_at >= ? AND o.created_at < ?
+		GROUP BY o.user_id`,
+	sortBy: "revenue",
+	derive: func(m map[string]float64) {
+		m["average_order"] = reportRatio(m["revenue"], m["orders"])
+	},
+}
+
+var paymentMethodNamesAr = map[string]string{
+	"cash":          "نقدي",
+	"card":          "بطاقة",
+	"mobile_wallet": "محفظة",
+	"credit":        "آجل",
+	"split":         "مقسم",
+}
+
+// reportEndpoints maps report names, as used by /reports/export, to
+// their definitions
+var reportEndpoints = map[string]reportEndpoint{
+	"daily":      {salesReport, "today", GroupByHour},
+	"weekly":     {salesReport, "this_week", GroupByDay},
+	"monthly":    {salesReport, "this_month", GroupByDay},
+	"items":      {itemsReport, "today", ""},
+	"categories": {categoriesReport, "today", ""},
+	"payments":   {paymentsReport, "today", ""},
+	"staff":      {staffReport, "today", ""},
+}
+
+// ========================================
+// REPORT RANGES
+// ========================================
+
+// reportLocation returns the restaurant's configured time zone
+func (a *App) reportLocation() *time.Location {
+	var settings RestaurantSettings
+	a.DB.Select("timezone").First(&settings)
+	if settings.Timezone != "" {
+		if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// startOfDay returns midnight of t's day in t's location
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns midnight of the ReportWeekStart on or before t
+func startOfWeek(t time.Time) time.Time {
+	day := startOfDay(t)
+	return day.AddDate(0, 0, -((int(day.Weekday()) - int(ReportWeekStart) + 7) % 7))
+}
+
+// reportPeriod resolves a named period relative to now
+func reportPeriod(name string, now time.Time) (time.Time, time.Time, error) {
+	today := startOfDay(now)
+	week := startOfWeek(now)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	year := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+
+	switch name {
+	case "today":
+		return today, today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	case "this_week":
+		return week, week.AddDate(0, 0, 7), nil
+	case "last_week":
+		return week.AddDate(0, 0, -7), week, nil
+	case "this_month":
+		return month, month.AddDate(0, 1, 0), nil
+	case "last_month":
+		return month.AddDate(0, -1, 0), month, nil
+	case "this_year":
+		return year, year.AddDate(1, 0, 0), nil
+	case "last_year":
+		return year.AddDate(-1, 0, 0), year, nil
+	case "last_7_days":
+		return today.AddDate(0, 0, -6), today.AddDate(0, 0, 1), nil
+	case "last_30_days":
+		return today.AddDate(0, 0, -29), today.AddDate(0, 0, 1), nil
+	case "last_12_weeks":
+		return week.AddDate(0, 0, -77), week.AddDate(0, 0, 7), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown period %q", name)
+}
+
+// parseReportTime parses a date (whole day) or an RFC 3339 time. A date
+// used as the end of a range includes that whole day.
+func parseReportTime(value string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or RFC 3339", value)
+	}
+	return t.In(loc), nil
+}
+
+// parseReportRange reads from/to (or period), group_by and compare from
+// the query string
+func (a *App) parseReportRange(c *gin.Context, defaultPeriod, defaultGroup string) (*ReportRange, *ReportRange, error) {
+	loc := a.reportLocation()
+	r := &ReportRange{Timezone: loc.String(), GroupBy: c.DefaultQuery("group_by", defaultGroup), loc: loc}
+
+	from, to := c.Query("from"), c.Query("to")
+	var err error
+	switch {
+	case from != "" || to != "":
+		if from == "" {
+			from = to
+		}
+		if to == "" {
+			to = from
+		}
+		if r.From, err = parseReportTime(from, loc, false); err != nil {
+			return nil, nil, err
+		}
+		if r.To, err = parseReportTime(to, loc, true); err != nil {
+			return nil, nil, err
+		}
+	default:
+		if r.From, r.To, err = reportPeriod(c.DefaultQuery("period", defaultPeriod), time.Now().In(loc)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !r.From.Before(r.To) {
+		return nil, nil, errors.New("from must be before to")
+	}
+
+	switch r.GroupBy {
+	case "", GroupByHour, GroupByDay, GroupByWeek, GroupByMonth:
+	default:
+		return nil, nil, fmt.Errorf("group_by must be hour, day, week or month")
+	}
+	if r.GroupBy != "" && len(r.Buckets()) > maxReportBuckets {
+		return nil, nil, fmt.Errorf("range is too long to group by %s", r.GroupBy)
+	}
+
+	var compare *ReportRange
+	switch c.DefaultQuery("compare", "previous") {
+	case "previous":
+		compare = r.Previous()
+	case "year":
+		compare = r.Shift(-1, 0, 0)
+	case "none", "":
+	default:
+		return nil, nil, fmt.Errorf("compare must be previous, year or none")
+	}
+	return r, compare, nil
+}
+
+// Previous returns the period of the same length just before r: the
+// previous months for whole months, the previous days for whole days
+func (r *ReportRange) Previous() *ReportRange {
+	from, to := r.From.In(r.loc), r.To.In(r.loc)
+	if from.Equal(startOfDay(from)) && to.Equal(startOfDay(to)) {
+		if from.Day() == 1 && to.Day() == 1 {
+			months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+			return r.Shift(0, -months, 0)
+		}
+		days := int(math.Round(to.Sub(from).Hours() / 24))
+		return r.Shift(0, 0, -days)
+	}
+	prev := *r
+	prev.From, prev.To = r.From.Add(-r.To.Sub(r.From)), r.From
+	return &prev
+}
+
+// Shift moves the range by calendar years, months and days
+func (r *ReportRange) Shift(years, months, days int) *ReportRange {
+	shifted := *r
+	shifted.From = r.From.AddDate(years, months, days)
+	shifted.To = r.To.AddDate(years, months, days)
+	return &shifted
+}
+
+// bucketStart returns the start of the GroupBy bucket containing t
+func (r *ReportRange) bucketStart(t time.Time) time.Time {
+	t = t.In(r.loc)
+	switch r.GroupBy {
+	case GroupByHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, r.loc)
+	case GroupByWeek:
+		return startOfWeek(t)
+	case GroupByMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, r.loc)
+	default:
+		return startOfDay(t)
+	}
+}
+
+// nextBucket returns the start of the bucket after start
+func (r *ReportRange) nextBucket(start time.Time) time.Time {
+	switch r.GroupBy {
+	case GroupByHour:
+		return start.Add(time.Hour)
+	case GroupByWeek:
+		return start.AddDate(0, 0, 7)
+	case GroupByMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Buckets lists the bucket starts covering the range
+func (r *ReportRange) Buckets() []time.Time {
+	var buckets []time.Time
+	for t := r.bucketStart(r.From); t.Before(r.To); t = r.nextBucket(t) {
+		buckets = append(buckets, t)
+		if len(buckets) > maxReportBuckets {
+			break
+		}
+	}
+	return buckets
+}
+
+// BucketLabel formats a bucket start for display
+func (r *ReportRange) BucketLabel(t time.Time) string {
+	switch r.GroupBy {
+	case GroupByHour:
+		return t.Format("2006-01-02 15:00")
+	case GroupByMonth:
+		return t.Format("2006-01")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// bucketSeconds is the SQL grouping granularity: hours, or quarter hours
+// for zones with a fractional UTC offset
+func (r *ReportRange) bucketSeconds() int64 {
+	_, fromOffset := r.From.In(r.loc).Zone()
+	_, toOffset := r.To.In(r.loc).Zone()
+	if fromOffset%3600 != 0 || toOffset%3600 != 0 {
+		return 900
+	}
+	return 3600
+}
+
+// ========================================
+// REPORT ENGINE
+// ========================================
+
+// RunReport runs spec over r and, when compare is set, over the
+// comparison period, matching rows by key (or by position for time series)
+func (a *App) RunReport(spec *reportSpec, r, compare *ReportRange) (*Report, error) {
+	rows, summary, err := a.reportRows(spec, r)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{
+		Name:    spec.name,
+		Range:   *r,
+		Compare: compare,
+		Columns: spec.columns,
+		Summary: summary,
+		Rows:    rows,
+	}
+	if compare == nil {
+		return report, nil
+	}
+
+	previous, previousSummary, err := a.reportRows(spec, compare)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.timeSeries {
+		for i := range report.Rows {
+			if i < len(previous) {
+				report.Rows[i].Previous = previous[i].Metrics
+			}
+		}
+	} else {
+		index := map[string]int{}
+		for i, row := range report.Rows {
+			index[row.Key] = i
+		}
+		for _, prev := range previous {
+			i, ok := index[prev.Key]
+			if !ok {
+				// Sold last period but not this one
+				prev.Previous = prev.Metrics
+				prev.Metrics = spec.zeroMetrics()
+				report.Rows = append(report.Rows, prev)
+				continue
+			}
+			report.Rows[i].Previous = prev.Metrics
+		}
+	}
+	report.Summary.Previous = previousSummary.Metrics
+
+	for i := range report.Rows {
+		report.Rows[i].Change = spec.change(report.Rows[i].Metrics, report.Rows[i].Previous)
+	}
+	report.Summary.Change = spec.change(report.Summary.Metrics, report.Summary.Previous)
+	return report, nil
+}
+
+// reportRows runs the report query for one range and returns its rows and
+// summary with derived metrics filled in
+func (a *App) reportRows(spec *reportSpec, r *ReportRange) ([]ReportRow, ReportRow, error) {
+	args := []interface{}{r.From, r.To}
+	if spec.timeSeries {
+		args = append([]interface{}{r.bucketSeconds()}, args...)
+	}
+
+	var results []map[string]interface{}
+	if err := a.DB.Raw(spec.sql, args...).Scan(&results).Error; err != nil {
+		return nil, ReportRow{}, err
+	}
+
+	var rows []ReportRow
+	if spec.timeSeries {
+		if r.GroupBy == "" {
+			r.GroupBy = GroupByDay
+		}
+		buckets := r.Buckets()
+		position := map[int64]int{}
+		for i, start := range buckets {
+			position[start.Unix()] = i
+			rows = append(rows, ReportRow{
+				Key:     start.Format(time.RFC3339),
+				Label:   r.BucketLabel(start),
+				Metrics: spec.zeroMetrics(),
+			})
+		}
+		seconds := r.bucketSeconds()
+		for _, result := range results {
+			start := r.bucketStart(time.Unix(int64(reportFloat(result["bucket"]))*seconds, 0))
+			if i, ok := position[start.Unix()]; ok {
+				spec.add(rows[i].Metrics, result)
+			}
+		}
+	} else {
+		for _, result := range results {
+			row := ReportRow{
+				Key:     reportString(result["key"]),
+				Label:   reportString(result["label"]),
+				LabelAr: reportString(result["label_ar"]),
+				Group:   reportString(result["grp"]),
+				Metrics: spec.zeroMetrics(),
+			}
+			if row.LabelAr == "" && spec.labelAr != nil {
+				row.LabelAr = spec.labelAr(row.Key)
+			}
+			spec.add(row.Metrics, result)
+			rows = append(rows, row)
+		}
+	}
+
+	summary := ReportRow{Key: "total", Label: "Total", LabelAr: "الإجمالي", Metrics: spec.zeroMetrics()}
+	for i := range rows {
+		for _, col := range spec.columns {
+			if !col.derived {
+				summary.Metrics[col.Key] += rows[i].Metrics[col.Key]
+			}
+		}
+		if spec.derive != nil {
+			spec.derive(rows[i].Metrics)
+		}
+	}
+	if spec.derive != nil {
+		spec.derive(summary.Metrics)
+	}
+	for _, col := range spec.columns {
+		if col.shareOf == "" {
+			continue
+		}
+		for i := range rows {
+			rows[i].Metrics[col.Key] = reportRatio(rows[i].Metrics[col.shareOf]*100, summary.Metrics[col.shareOf])
+		}
+		summary.Metrics[col.Key] = 100
+	}
+
+	if spec.sortBy != "" {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].Metrics[spec.sortBy] > rows[j].Metrics[spec.sortBy]
+		})
+	}
+	return rows, summary, nil
+}
+
+// zeroMetrics returns a metric map with every column set to 0
+func (s *reportSpec) zeroMetrics() map[string]float64 {
+	metrics := make(map[string]float64, len(s.columns))
+	for _, col := range s.columns {
+		metrics[col.Key] = 0
+	}
+	return metrics
+}
+
+// add accumulates a result row's additive metrics
+func (s *reportSpec) add(metrics map[string]float64, result map[string]interface{}) {
+	for _, col := range s.columns {
+		if !col.derived {
+			metrics[col.Key] += reportFloat(result[col.Key])
+		}
+	}
+}
+
+// change returns the percent change per metric, nil where the previous
+// value is 0
+func (s *reportSpec) change(current, previous map[string]float64) map[string]*float64 {
+	if previous == nil {
+		return nil
+	}
+	change := make(map[string]*float64, len(s.columns))
+	for _, col := range s.columns {
+		change[col.Key] = nil
+		if prev := previous[col.Key]; prev != 0 {
+			pct := math.Round((current[col.Key]-prev)/math.Abs(prev)*10000) / 100
+			change[col.Key] = &pct
+		}
+	}
+	return change
+}
+
+// reportRatio divides, returning 0 for an empty denominator
+func reportRatio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return math.Round(a/b*100) / 100
+}
+
+// reportFloat converts a scanned SQL value to float64
+func reportFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case []byte:
+		f, _ := strconv.ParseFloat(string(n), 64)
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+// reportString converts a scanned SQL value to a string
+func reportString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// ========================================
+// REPORT HANDLERS
+// ========================================
+
+// serveReport runs the named report for the request's range and writes
+// it in the requested format
+func (a *App) serveReport(c *gin.Context, name, defaultFormat string) {
+	endpoint, ok := reportEndpoints[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Unknown report", Message: name})
+		return
+	}
+
+	r, compare, err := a.parseReportRange(c, endpoint.defaultPeriod, endpoint.defaultGroup)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid report range", Message: err.Error()})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", defaultFormat))
+	if !validReportFormats[format] {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Format must be json, csv, xlsx or pdf"})
+		return
+	}
+
+	report, err := a.RunReport(endpoint.spec, r, compare)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to run report", Message: err.Error()})
+		return
+	}
+	if format == ReportFormatJSON {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	a.exportReport(c, name, endpoint.spec, report, format)
+}
+
+// HandleDailyReport returns sales by hour for a day
+func (a *App) HandleDailyReport(c *gin.Context) {
+	a.serveReport(c, "daily", ReportFormatJSON)
+}
+
+// HandleWeeklyReport returns sales by day for a week
+func (a *App) HandleWeeklyReport(c *gin.Context) {
+	a.serveReport(c, "weekly", ReportFormatJSON)
+}
+
+// HandleMonthlyReport returns sales by day for a month
+func (a *App) HandleMonthlyReport(c *gin.Context) {
+	a.serveReport(c, "monthly", ReportFormatJSON)
+}
+
+// HandleItemsReport returns sales per menu item
+func (a *App) HandleItemsReport(c *gin.Context) {
+	a.serveReport(c, "items", ReportFormatJSON)
+}
+
+// HandleCategoriesReport returns sales per category
+func (a *App) HandleCategoriesReport(c *gin.Context) {
+	a.serveReport(c, "categories", ReportFormatJSON)
+}
+
+// HandlePaymentsReport returns payments per method
+func (a *App) HandlePaymentsReport(c *gin.Context) {
+	a.serveReport(c, "payments", ReportFormatJSON)
+}
+
+// HandleStaffReport returns sales per staff member
+func (a *App) HandleStaffReport(c *gin.Context) {
+	a.serveReport(c, "staff", ReportFormatJSON)
+}
+
+// HandleExportReport runs the report named by the report query parameter,
+// as a CSV file unless another format is given
+func (a *App) HandleExportReport(c *gin.Context) {
+	a.serveReport(c, c.DefaultQuery("report", "daily"), ReportFormatCSV)
+}
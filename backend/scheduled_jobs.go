@@ -0,0 +1,386 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ========================================
+// SCHEDULED JOBS
+// ========================================
+
+// RegisterScheduledJobs registers the built-in jobs with their default
+// schedules. Schedules and params can be changed through /scheduler/jobs.
+func (a *App) RegisterScheduledJobs() {
+	phones := "[]"
+	if len(a.Config.Scheduler.ManagerPhones) > 0 {
+		phones, _ = ToJSON(a.Config.Scheduler.ManagerPhones)
+	}
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "daily_report",
+		Description: "Generate the daily sales report at business-day close",
+		Schedule:    "55 23 * * *",
+		Params:      `{"day_offset": 0}`,
+		IsActive:    true,
+	}, a.jobDailyReport)
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "whatsapp_daily_report",
+		Description: "Send the daily sales report to the manager numbers",
+		Schedule:    "58 23 * * *",
+		Params:      `{"day_offset": 0, "phones": ` + phones + `}`,
+		IsActive:    a.Config.WhatsApp.Enabled,
+	}, a.jobWhatsAppDailyReport)
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "low_stock_sweep",
+		Description: "Flag stock items at or below their minimum and alert on new ones",
+		Schedule:    "*/15 * * * *",
+		IsActive:    true,
+	}, a.jobLowStockSweep)
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "stock_expiry_sweep",
+		Description: "Alert on stock items that expire soon or have expired",
+		Schedule:    "0 8 * * *",
+		Params:      `{"warn_days": 2}`,
+		IsActive:    true,
+	}, a.jobStockExpirySweep)
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "reservation_reminders",
+		Description: "Remind guests of upcoming reservations and mark no-shows",
+		Schedule:    "*/10 * * * *",
+		Params:      `{"lead_minutes": 120, "no_show_minutes": 60}`,
+		IsActive:    true,
+	}, a.jobReservationReminders)
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "database_backup",
+		Description: "Dump the database to the backup directory",
+		Schedule:    "0 4 * * *",
+		Params:      `{"keep": 14}`,
+		IsActive:    a.Config.Scheduler.BackupDir != "",
+	}, a.jobDatabaseBackup)
+
+	a.Scheduler.Register(ScheduledJob{
+		Name:        "housekeeping",
+		Description: "Delete delivered queue rows and old job history",
+		Schedule:    "30 4 * * *",
+		Params:      `{"retention_days": 30}`,
+		IsActive:    true,
+	}, a.jobHousekeeping)
+}
+
+// businessDate returns local midnight of the day offset days before now
+func businessDate(offset int) time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day()-offset, 0, 0, 0, 0, now.Location())
+}
+
+// jobDailyReport saves the DailyReport for the business day. Restaurants
+// that close after midnight set day_offset to 1 and schedule it after close.
+func (a *App) jobDailyReport(job *ScheduledJob) (string, error) {
+	params := struct {
+		DayOffset int `json:"day_offset"`
+	}{}
+	if err := job.Decode(&params); err != nil {
+		return "", err
+	}
+
+	reports := &ReportService{DB: a.DB}
+	report, err := reports.GenerateDailyReport(businessDate(params.DayOffset))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %d orders, %.2f revenue", report.ReportDate.Format("2006-01-02"), report.TotalOrders, report.TotalRevenue), nil
+}
+
+// jobWhatsAppDailyReport sends the business day's report to each manager
+func (a *App) jobWhatsAppDailyReport(job *ScheduledJob) (string, error) {
+	params := struct {
+		DayOffset int      `json:"day_offset"`
+		Phones    []string `json:"phones"`
+		Channel   string   `json:"channel"`
+	}{}
+	if err := job.Decode(&params); err != nil {
+		return "", err
+	}
+	if len(params.Phones) == 0 {
+		return "no manager phones configured", nil
+	}
+
+	reports := &ReportService{DB: a.DB}
+	report, err := reports.GenerateDailyReport(businessDate(params.DayOffset))
+	if err != nil {
+		return "", err
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+	body, values := DailyReportMessage(report, &settings)
+
+	var failures []string
+	for _, phone := range params.Phones {
+		if _, err := a.Messaging.Send(&MessageRequest{
+			Channel: params.Channel,
+			To:      phone,
+			Kind:    "daily_report",
+			Body:    body,
+			Params:  values,
+		}); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", phone, err))
+		}
+	}
+
+	sent := len(params.Phones) - len(failures)
+	if sent == 0 {
+		return "", errors.New(strings.Join(failures, "; "))
+	}
+	output := fmt.Sprintf("sent to %d of %d", sent, len(params.Phones))
+	if len(failures) > 0 {
+		output += " (" + strings.Join(failures, "; ") + ")"
+	}
+	return output, nil
+}
+
+// jobLowStockSweep recomputes is_low_stock and alerts on items that just
+// dropped to their minimum
+func (a *App) jobLowStockSweep(job *ScheduledJob) (string, error) {
+	var newlyLow []StockItem
+	if err := a.DB.Where("is_low_stock = ? AND current_stock <= minimum_stock", false).Find(&newlyLow).Error; err != nil {
+		return "", err
+	}
+
+	a.DB.Model(&StockItem{}).Where("is_low_stock = ? AND current_stock > minimum_stock", true).Update("is_low_stock", false)
+	for _, item := range newlyLow {
+		a.DB.Model(&item).Update("is_low_stock", true)
+		a.NotificationService.SendLowStockAlert(item)
+	}
+
+	var total int64
+	a.DB.Model(&StockItem{}).Where("is_low_stock = ?", true).Count(&total)
+	return fmt.Sprintf("%d low, %d new", total, len(newlyLow)), nil
+}
+
+// jobStockExpirySweep alerts managers about stock expiring within
+// warn_days, including anything already past its date
+func (a *App) jobStockExpirySweep(job *ScheduledJob) (string, error) {
+	params := struct {
+		WarnDays int `json:"warn_days"`
+	}{WarnDays: 2}
+	if err := job.Decode(&params); err != nil {
+		return "", err
+	}
+
+	var items []StockItem
+	if err := a.DB.Where("expiry_date IS NOT NULL AND expiry_date < ? AND current_stock > 0", businessDate(-params.WarnDays-1)).
+		Order("expiry_date ASC").
+		Find(&items).Error; err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "nothing expiring", nil
+	}
+
+	a.NotificationService.SendStockExpiryAlert(items)
+
+	expired := 0
+	for _, item := range items {
+		if item.ExpiryDate.Before(time.Now()) {
+			expired++
+		}
+	}
+	return fmt.Sprintf("%d expiring, %d expired", len(items)-expired, expired), nil
+}
+
+// jobReservationReminders messages guests whose reservation starts within
+// lead_minutes and marks reservations no_show_minutes past due as no-shows
+func (a *App) jobReservationReminders(job *ScheduledJob) (string, error) {
+	params := struct {
+		LeadMinutes   int    `json:"lead_minutes"`
+		NoShowMinutes int    `json:"no_show_minutes"`
+		Channel       string `json:"channel"`
+	}{LeadMinutes: 120, NoShowMinutes: 60}
+	if err := job.Decode(&params); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var noShows int64
+	if params.NoShowMinutes > 0 {
+		result := a.DB.Model(&Reservation{}).
+			Where("status IN ? AND reservation_time < ?", []string{"pending", "confirmed"}, now.Add(-time.Duration(params.NoShowMinutes)*time.Minute)).
+			Update("status", "no_show")
+		if result.Error != nil {
+			return "", result.Error
+		}
+		noShows = result.RowsAffected
+	}
+
+	var upcoming []Reservation
+	if err := a.DB.Where("status IN ? AND reminder_sent_at IS NULL AND reservation_time BETWEEN ? AND ?",
+		[]string{"pending", "confirmed"}, now, now.Add(time.Duration(params.LeadMinutes)*time.Minute)).
+		Find(&upcoming).Error; err != nil {
+		return "", err
+	}
+
+	var settings RestaurantSettings
+	a.DB.First(&settings)
+
+	reminded := 0
+	for _, reservation := range upcoming {
+		when := reservation.ReservationTime.Local().Format("15:04")
+		_, err := a.Messaging.Send(&MessageRequest{
+			Channel: params.Channel,
+			To:      reservation.CustomerPhone.String(),
+			Kind:    "reservation_reminder",
+			Body: fmt.Sprintf("مرحباً %s، نذكّرك بحجزك في %s اليوم الساعة %s لعدد %d أشخاص.",
+				reservation.CustomerName, settings.Name, when, reservation.PartySize),
+			Params: map[string]string{
+				"customer_name":   reservation.CustomerName,
+				"restaurant_name": settings.Name,
+				"time":            when,
+				"party_size":      fmt.Sprintf("%d", reservation.PartySize),
+			},
+		})
+		// Opted-out guests and bad numbers count as handled so they aren't retried
+		if err != nil && !errors.Is(err, ErrRecipientOptedOut) && !errors.Is(err, ErrInvalidPhone) {
+			continue
+		}
+		a.DB.Model(&reservation).Update("reminder_sent_at", now)
+		if err == nil {
+			reminded++
+		}
+	}
+
+	return fmt.Sprintf("%d reminded of %d upcoming, %d no-shows", reminded, len(upcoming), noShows), nil
+}
+
+// jobDatabaseBackup writes a gzipped mysqldump to the backup directory and
+// keeps the newest backups
+func (a *App) jobDatabaseBackup(job *ScheduledJob) (string, error) {
+	params := struct {
+		Keep int `json:"keep"`
+	}{Keep: 14}
+	if err := job.Decode(&params); err != nil {
+		return "", err
+	}
+
+	dir := a.Config.Scheduler.BackupDir
+	if dir == "" {
+		return "", errors.New("BACKUP_DIR is not set")
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+
+	db := a.Config.Database
+	name := fmt.Sprintf("%s-%s.sql.gz", db.Name, time.Now().Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path + ".tmp")
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	var stderr strings.Builder
+	cmd := exec.Command("mysqldump",
+		"--single-transaction", "--routines", "--triggers",
+		"-h", db.Host, "-P", fmt.Sprintf("%d", db.Port), "-u", db.User, db.Name)
+	// The password goes through the environment so it doesn't show in ps
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+db.Password)
+	cmd.Stdout = gz
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("mysqldump: %v: %s", err, TruncateString(strings.TrimSpace(stderr.String()), 500))
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return "", err
+	}
+
+	removed := pruneBackups(dir, db.Name, params.Keep)
+	info, _ := os.Stat(path)
+	size := int64(0)
+	if info != nil {
+		size = info.Size()
+	}
+	return fmt.Sprintf("%s (%d bytes), removed %d old", name, size, removed), nil
+}
+
+// pruneBackups deletes all but the newest keep backups of database
+func pruneBackups(dir, database string, keep int) int {
+	if keep < 1 {
+		return 0
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, database+"-*.sql.gz"))
+	if len(matches) <= keep {
+		return 0
+	}
+
+	// Names embed the timestamp, so lexical order is chronological
+	sort.Strings(matches)
+	removed := 0
+	for _, path := range matches[:len(matches)-keep] {
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// jobHousekeeping deletes finished queue rows, job history and ended auth
+// sessions older than retention_days
+func (a *App) jobHousekeeping(job *ScheduledJob) (string, error) {
+	params := struct {
+		RetentionDays int `json:"retention_days"`
+	}{RetentionDays: 30}
+	if err := job.Decode(&params); err != nil {
+		return "", err
+	}
+	if params.RetentionDays < 1 {
+		return "", errors.New("retention_days must be at least 1")
+	}
+	cutoff := time.Now().AddDate(0, 0, -params.RetentionDays)
+
+	var summary []string
+	prune := func(label string, model interface{}, where string, args ...interface{}) {
+		result := a.DB.Where(where, args...).Delete(model)
+		if result.Error != nil {
+			summary = append(summary, fmt.Sprintf("%s: %v", label, result.Error))
+			return
+		}
+		summary = append(summary, fmt.Sprintf("%s: %d", label, result.RowsAffected))
+	}
+
+	prune("outbox events", &OutboxEvent{}, "status = ? AND updated_at < ?", OutboxDelivered, cutoff)
+	prune("webhook deliveries", &WebhookDelivery{}, "status = ? AND updated_at < ?", WebhookSucceeded, cutoff)
+	prune("emails", &EmailMessage{}, "status = ? AND updated_at < ?", EmailSent, cutoff)
+	prune("job runs", &JobRun{}, "status <> ? AND started_at < ?", JobRunning, cutoff)
+	// Idle buckets are full again after an hour
+	prune("rate limit buckets", &RateLimitBucket{}, "updated_at < ?", time.Now().Add(-time.Hour))
+
+	if sessions, err := a.PruneAuthSessions(cutoff); err != nil {
+		summary = append(summary, fmt.Sprintf("auth sessions: %v", err))
+	} else {
+		summary = append(summary, fmt.Sprintf("auth sessions: %d", sessions))
+	}
+
+	return strings.Join(summary, ", "), nil
+}
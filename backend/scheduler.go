@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// SCHEDULER
+// ========================================
+
+// Job run statuses
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// ErrUnknownJob is returned when a stored job has no registered function
+var ErrUnknownJob = errors.New("no function is registered for this job")
+
+// JobFunc runs one scheduled job and returns a short summary for the run
+// history
+type JobFunc func(job *ScheduledJob) (string, error)
+
+// Scheduler runs jobs on cron schedules stored in scheduled_jobs. Each run
+// is claimed with a conditional UPDATE, so when several backend instances
+// share a database exactly one of them runs it.
+type Scheduler struct {
+	DB           *gorm.DB
+	Instance     string
+	PollInterval time.Duration
+	LockTTL      time.Duration
+
+	jobs map[string]JobFunc
+	wake chan struct{}
+}
+
+// NewScheduler creates a scheduler identified by this host and process
+func NewScheduler(db *gorm.DB) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		DB:           db,
+		Instance:     fmt.Sprintf("%s:%d", host, os.Getpid()),
+		PollInterval: 20 * time.Second,
+		LockTTL:      30 * time.Minute,
+		jobs:         map[string]JobFunc{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Register adds a job function and creates its row with the default
+// schedule the first time. Schedules edited through the API are kept.
+func (s *Scheduler) Register(defaults ScheduledJob, fn JobFunc) {
+	s.jobs[defaults.Name] = fn
+
+	schedule, err := ParseSchedule(defaults.Schedule)
+	if err != nil {
+		log.Printf("Job %s has an invalid default schedule: %v", defaults.Name, err)
+		return
+	}
+	if defaults.Params == "" {
+		defaults.Params = "{}"
+	}
+	defaults.NextRunAt = schedule.Next(time.Now())
+	if err := s.DB.Where(ScheduledJob{Name: defaults.Name}).FirstOrCreate(&defaults).Error; err != nil {
+		log.Printf("Failed to register job %s: %v", defaults.Name, err)
+	}
+}
+
+// Run polls for due jobs until the process exits
+func (s *Scheduler) Run() {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		var due []ScheduledJob
+		if err := s.DB.Where("is_active = ? AND next_run_at <= ?", true, time.Now()).
+			Order("next_run_at ASC").
+			Find(&due).Error; err != nil {
+			log.Printf("Scheduler poll failed: %v", err)
+		}
+
+		for i := range due {
+			if s.claim(&due[i], true) {
+				go s.execute(&due[i], "schedule")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// Wake asks the scheduler to poll now
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RunNow runs a job immediately without moving its next scheduled run.
+// It fails if the job is already running.
+func (s *Scheduler) RunNow(job *ScheduledJob) error {
+	if _, ok := s.jobs[job.Name]; !ok {
+		return ErrUnknownJob
+	}
+	if !s.claim(job, false) {
+		return fmt.Errorf("job %s is already running", job.Name)
+	}
+	go s.execute(job, "manual")
+	return nil
+}
+
+// claim locks the job for this instance. A scheduled claim also advances
+// next_run_at, and only succeeds if no other instance got there first.
+func (s *Scheduler) claim(job *ScheduledJob, scheduled bool) bool {
+	now := time.Now()
+	lockedUntil := now.Add(s.LockTTL)
+	updates := map[string]interface{}{
+		"locked_by":    s.Instance,
+		"locked_until": lockedUntil,
+	}
+
+	query := s.DB.Model(&ScheduledJob{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", job.ID, now)
+	if scheduled {
+		schedule, err := ParseSchedule(job.Schedule)
+		if err != nil {
+			log.Printf("Job %s has an invalid schedule, disabling: %v", job.Name, err)
+			s.DB.Model(job).Updates(map[string]interface{}{"is_active": false, "last_error": err.Error()})
+			return false
+		}
+		updates["next_run_at"] = schedule.Next(now)
+		query = query.Where("next_run_at = ?", job.NextRunAt)
+	}
+
+	result := query.Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
+	// A run left "running" by an instance whose lock expired never finished
+	s.DB.Model(&JobRun{}).
+		Where("job_id = ? AND status = ?", job.ID, JobRunning).
+		Updates(map[string]interface{}{"status": JobFailed, "error": "interrupted", "finished_at": now})
+
+	job.LockedBy = s.Instance
+	job.LockedUntil = &lockedUntil
+	return true
+}
+
+// execute runs a claimed job, records the run and releases the lock
+func (s *Scheduler) execute(job *ScheduledJob, trigger string) {
+	run := JobRun{
+		JobID:       job.ID,
+		JobName:     job.Name,
+		TriggeredBy: trigger,
+		Status:      JobRunning,
+		Instance:    s.Instance,
+		StartedAt:   time.Now(),
+	}
+	s.DB.Create(&run)
+
+	output, err := s.call(job)
+
+	finished := time.Now()
+	run.Status = JobSucceeded
+	run.Output = output
+	if err != nil {
+		run.Status = JobFailed
+		run.Error = err.Error()
+		log.Printf("Job %s failed: %v", job.Name, err)
+	}
+	s.DB.Model(&run).Updates(map[string]interface{}{
+		"status":      run.Status,
+		"output":      run.Output,
+		"error":       run.Error,
+		"finished_at": finished,
+		"duration_ms": finished.Sub(run.StartedAt).Milliseconds(),
+	})
+
+	s.DB.Model(&ScheduledJob{}).
+		Where("id = ? AND locked_by = ?", job.ID, s.Instance).
+		Updates(map[string]interface{}{
+			"last_run_at":  run.StartedAt,
+			"last_status":  run.Status,
+			"last_error":   run.Error,
+			"locked_by":    "",
+			"locked_until": nil,
+		})
+}
+
+// call runs the job function, turning a panic into a failed run
+func (s *Scheduler) call(job *ScheduledJob) (output string, err error) {
+	fn, ok := s.jobs[job.Name]
+	if !ok {
+		return "", ErrUnknownJob
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(job)
+}
+
+// Decode unmarshals the job's params over the defaults already in v
+func (j *ScheduledJob) Decode(v interface{}) error {
+	if j.Params == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(j.Params), v)
+}
+
+// ========================================
+// CRON SCHEDULES
+// ========================================
+
+// Schedule is a parsed cron expression: five fields (minute, hour, day of
+// month, month, day of week) with *, lists, ranges and steps, or one of
+// @hourly, @daily, @weekly, @monthly and "@every <duration>". Times are
+// evaluated in the server's local time zone.
+type Schedule struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// ParseSchedule parses a cron expression
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || every < time.Minute {
+			return nil, fmt.Errorf("@every needs a duration of at least 1m")
+		}
+		return &Schedule{every: every}, nil
+	}
+	if macro, ok := scheduleMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	s := &Schedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		set, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("field %d (%s): %v", i+1, fields[i], err)
+		}
+		*b.set = set
+	}
+	// 7 is Sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule never runs")
+	}
+	return s, nil
+}
+
+// parseCronField returns the bitset of values one field matches
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%d-%d is outside %d-%d", lo, hi, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first run time strictly after t, or the zero time if
+// there is none within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every).Truncate(time.Second)
+	}
+
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies cron's rule that a restricted day of month and day
+// of week match if either does
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// ========================================
+// SCHEDULER HANDLERS
+// ========================================
+
+// HandleGetScheduledJobs lists scheduled jobs
+func (a *App) HandleGetScheduledJobs(c *gin.Context) {
+	var jobs []ScheduledJob
+	if err := a.DB.Order("name ASC").Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// HandleUpdateScheduledJob changes a job's schedule, params or active flag
+func (a *App) HandleUpdateScheduledJob(c *gin.Context) {
+	var job ScheduledJob
+	if err := a.DB.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Job not found"})
+		return
+	}
+
+	var req struct {
+		Schedule *string         `json:"schedule"`
+		Params   json.RawMessage `json:"params"`
+		IsActive *bool           `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Schedule != nil {
+		schedule, err := ParseSchedule(*req.Schedule)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid schedule", Message: err.Error()})
+			return
+		}
+		updates["schedule"] = strings.TrimSpace(*req.Schedule)
+		updates["next_run_at"] = schedule.Next(time.Now())
+	}
+	if len(req.Params) > 0 {
+		var params map[string]interface{}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Params must be a JSON object"})
+			return
+		}
+		updates["params"] = string(req.Params)
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if len(updates) > 0 {
+		if err := a.DB.Model(&job).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update job", Message: err.Error()})
+			return
+		}
+	}
+	a.DB.First(&job, job.ID)
+	a.Scheduler.Wake()
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Job updated successfully",
+		Data:    job,
+	})
+}
+
+// HandleRunScheduledJob runs a job now
+func (a *App) HandleRunScheduledJob(c *gin.Context) {
+	var job ScheduledJob
+	if err := a.DB.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Job not found"})
+		return
+	}
+
+	if err := a.Scheduler.RunNow(&job); err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Failed to start job", Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Message: "Job started",
+		Data:    job,
+	})
+}
+
+// HandleGetJobRuns lists job run history, newest first
+func (a *App) HandleGetJobRuns(c *gin.Context) {
+	page := getInt(c.DefaultQuery("page", "1"))
+	limit := getInt(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := a.DB.Model(&JobRun{})
+	if jobID := c.Query("job_id"); jobID != "" {
+		query = query.Where("job_id = ?", jobID)
+	}
+	if name := c.Query("job"); name != "" {
+		query = query.Where("job_name = ?", name)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var runs []JobRun
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch job runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs":  runs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
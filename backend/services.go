@@ -1,16 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"net/url"
-	"os/exec"
-	"strings"
+	"log"
 	"time"
 
-	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"gorm.io/gorm"
 )
 
 // ========================================
@@ -49,348 +45,152 @@ func (s *OrderService) CreateOrderWithCalculations(order *Order) error {
 	return s.DB.Create(order).Error
 }
 
-// WhatsAppService handles WhatsApp messaging
-type WhatsAppService struct {
-	APIURL  string
-	APIKey  string
-	Enabled bool
-}
-
-// SendWhatsAppMessage sends message via WhatsApp API
-func (w *WhatsAppService) SendWhatsAppMessage(phoneNumber, message string) error {
-	if !w.Enabled {
-		return fmt.Errorf("WhatsApp is disabled")
-	}
-
-	// Example using Twilio or any WhatsApp API
-	// Replace with actual WhatsApp API integration
-	
-	// Example payload
-	payload := map[string]interface{}{
-		"to":      phoneNumber,
-		"message": message,
-	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	// Send HTTP request
-	req, _ := http.NewRequest("POST", w.APIURL, bytes.NewBuffer(jsonPayload))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+w.APIKey)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("WhatsApp API returned status %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
-// SendWhatsAppReceipt sends order receipt via WhatsApp
-func (w *WhatsAppService) SendWhatsAppReceipt(order *Order, settings *RestaurantSettings) error {
-	// Format receipt message
-	message := w.formatReceiptMessage(order, settings)
-	
-	// Get customer phone from order
-	phone := order.CustomerPhone
-	if phone == "" {
-		return fmt.Errorf("no customer phone number")
-	}
-
-	// Send message
-	return w.SendWhatsAppMessage(phone, message)
-}
-
-// formatReceiptMessage formats receipt for WhatsApp
-func (w *WhatsAppService) formatReceiptMessage(order *Order, settings *RestaurantSettings) string {
-	var sb strings.Builder
-
-	// Restaurant name
-	sb.WriteString(fmt.Sprintf("*%s*\n\n", settings.Name))
-
-	// Order number
-	sb.WriteString(fmt.Sprintf("📋 Order #%s\n", order.OrderNumber))
-	sb.WriteString(fmt.Sprintf("📅 %s\n\n", order.CreatedAt.Format("2006-01-02 15:04")))
-
-	// Items
-	sb.WriteString("*الطلب:*\n")
-	for _, item := range order.Items {
-		sb.WriteString(fmt.Sprintf("• %s x%d\n", item.MenuItemName, item.Quantity))
-		sb.WriteString(fmt.Sprintf("  %s%.2f\n", settings.CurrencySymbol, item.UnitPrice))
-	}
-
-	sb.WriteString("\n")
-
-	// Totals
-	sb.WriteString(fmt.Sprintf("المجموع: %s%.2f\n", settings.CurrencySymbol, order.Subtotal))
-	sb.WriteString(fmt.Sprintf("الضريبة: %s%.2f\n", settings.CurrencySymbol, order.TaxAmount))
-	sb.WriteString(fmt.Sprintf("*الإجمالي: %s%.2f*\n", settings.CurrencySymbol, order.Total))
-
-	sb.WriteString("\n")
-	sb.WriteString("شكراً لاختيارك! 🙏")
-
-	return sb.String()
-}
-
-// SendWhatsAppDailyReport sends daily sales report via WhatsApp
-func (w *WhatsAppService) SendWhatsAppDailyReport(report *DailyReport, settings *RestaurantSettings, phone string) error {
-	// Format daily report message
-	message := w.formatDailyReportMessage(report, settings)
-
-	// Send message
-	return w.SendWhatsAppMessage(phone, message)
-}
-
-// formatDailyReportMessage formats daily report for WhatsApp
-func (w *WhatsAppService) formatDailyReportMessage(report *DailyReport, settings *RestaurantSettings) string {
-	var sb strings.Builder
-
-	sb.WriteString(fmt.Sprintf("*📊 تقرير يومي - %s*\n\n", report.ReportDate.Format("2006-01-02")))
-	sb.WriteString(fmt.Sprintf("📈 إجمالي الإيرادات: %s%.2f\n", settings.CurrencySymbol, report.TotalRevenue))
-	sb.WriteString(fmt.Sprintf("📦 عدد الطلبات: %d\n", report.TotalOrders))
-	sb.WriteString(fmt.Sprintf("🍽️ طلبات صالون: %d\n", report.DineInOrders))
-	sb.WriteString(fmt.Sprintf("📦 طلبات تاك أواي: %d\n", report.TakeawayOrders))
-	sb.WriteString(fmt.Sprintf("🚗 طلبات دليفري: %d\n", report.DeliveryOrders))
-
-	sb.WriteString("\n")
-	sb.WriteString("💰 المدفوعات:\n")
-	sb.WriteString(fmt.Sprintf("  كاش: %s%.2f\n", settings.CurrencySymbol, report.CashPayments))
-	sb.WriteString(fmt.Sprintf("  بطاقة: %s%.2f\n", settings.CurrencySymbol, report.CardPayments))
-	sb.WriteString(fmt.Sprintf("  محفظة: %s%.2f\n", settings.CurrencySymbol, report.WalletPayments))
-
-	return sb.String()
-}
-
-// EmailService handles email sending
+// EmailService composes customer emails and hands them to the mail queue
 type EmailService struct {
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUser     string
-	SMTPPassword string
-	SMTPFrom     string
-	Enabled       bool
+	Queue     *EmailQueue
+	Enabled   bool
+	Templates *ReceiptEngine
 }
 
-// SendEmail sends an email
-func (e *EmailService) SendEmail(to, subject, body string) error {
+// SendEmail queues an HTML email with an optional plain-text alternative
+func (e *EmailService) SendEmail(to, subject, html, text string, attachments ...EmailAttachment) error {
 	if !e.Enabled {
 		return fmt.Errorf("Email is disabled")
 	}
 
-	// Use sendmail or any email library
-	// This is a simplified version
-	// In production, use gomail or similar library
-
-	cmd := exec.Command("sendmail", "-t")
-	cmd.Stdin = strings.NewReader(fmt.Sprintf("To: %s\nSubject: %s\n\n%s", to, subject, body))
-	
-	return cmd.Run()
+	_, err := e.Queue.Enqueue(&Email{
+		To:          []string{to},
+		Subject:     subject,
+		HTML:        html,
+		Text:        text,
+		Attachments: attachments,
+	})
+	return err
 }
 
 // SendReceiptByEmail sends order receipt via email
-func (e *EmailService) SendReceiptByEmail(order *Order, settings *RestaurantSettings, email string) error {
+func (e *EmailService) SendReceiptByEmail(order *Order, settings *RestaurantSettings, email string, attachments ...EmailAttachment) error {
 	subject := fmt.Sprintf("فاتورة - %s", order.OrderNumber)
-	body := e.formatReceiptEmail(order, settings)
+	html, text := e.formatReceiptEmail(order, settings)
 
-	return e.SendEmail(email, subject, body)
+	return e.SendEmail(email, subject, html, text, attachments...)
 }
 
-// formatReceiptEmail formats receipt for email
-func (e *EmailService) formatReceiptEmail(order *Order, settings *RestaurantSettings) string {
-	var sb strings.Builder
-
-	sb.WriteString(fmt.Sprintf("<h1>%s</h1>", settings.Name))
-	sb.WriteString(fmt.Sprintf("<p>Order #%s</p>", order.OrderNumber))
-	sb.WriteString(fmt.Sprintf("<p>Date: %s</p>", order.CreatedAt.Format("2006-01-02 15:04")))
-
-	sb.WriteString("<h3>Items:</h3><ul>")
-	for _, item := range order.Items {
-		sb.WriteString(fmt.Sprintf("<li>%s x%d - %s%.2f</li>",
-			item.MenuItemName, item.Quantity, settings.CurrencySymbol, item.UnitPrice))
+// formatReceiptEmail renders the receipt template as an HTML email body
+// and its text template as the plain-text alternative
+func (e *EmailService) formatReceiptEmail(order *Order, settings *RestaurantSettings) (string, string) {
+	engine := e.Templates
+	if engine == nil {
+		engine = NewReceiptEngine(nil)
 	}
-	sb.WriteString("</ul>")
-
-	sb.WriteString("<h3>Totals:</h3>")
-	sb.WriteString(fmt.Sprintf("<p>Subtotal: %s%.2f</p>", settings.CurrencySymbol, order.Subtotal))
-	sb.WriteString(fmt.Sprintf("<p>Tax: %s%.2f</p>", settings.CurrencySymbol, order.TaxAmount))
-	sb.WriteString(fmt.Sprintf("<p><strong>Total: %s%.2f</strong></p>", settings.CurrencySymbol, order.Total))
+	data := engine.BuildData(order, settings)
+	tpl := engine.Template(TemplateKindReceipt)
 
-	return sb.String()
+	html, err := engine.RenderHTML(tpl, data)
+	if err != nil {
+		log.Printf("Receipt template failed for email, using built-in: %v", err)
+		html, _ = engine.RenderHTML(builtinTemplate(TemplateKindReceipt), data)
+	}
+	text, err := engine.RenderWhatsApp(tpl, data)
+	if err != nil {
+		text, _ = engine.RenderWhatsApp(builtinTemplate(TemplateKindReceipt), data)
+	}
+	return html, text
 }
 
-// PrintService handles printing
+// PrintService renders ESC/POS tickets and sends them to network printers
 type PrintService struct {
-	Settings *RestaurantSettings
-	Runtime  *runtime.Runtime
+	Settings  *RestaurantSettings
+	Templates *ReceiptEngine
+	// Rasterizer prints lines the printer's code page can't encode as images
+	Rasterizer TextRasterizer
 }
 
-// PrintReceipt prints receipt directly to printer (no dialog)
-func (p *PrintService) PrintReceipt(order *Order, printer string) error {
-	// Generate receipt HTML
-	receiptHTML := p.generateReceiptHTML(order)
-
-	// Save to temporary file
-	tempFile := "/tmp/receipt_" + order.OrderNumber + ".html"
-	if err := os.WriteFile(tempFile, []byte(receiptHTML), 0644); err != nil {
-		return err
-	}
-
-	// Print using system print command (direct, no dialog)
-	// Linux: lp command
-	// Windows: powershell or lpr
-	// Mac: lp or lpr
-	
-	var cmd *exec.Cmd
-	if strings.Contains(runtime.GOOS, "linux") {
-		// Linux
-		if printer != "" {
-			cmd = exec.Command("lp", "-d", printer, tempFile)
-		} else {
-			cmd = exec.Command("lp", tempFile)
-		}
-	} else if strings.Contains(runtime.GOOS, "windows") {
-		// Windows
-		if printer != "" {
-			cmd = exec.Command("powershell", "-Command",
-				fmt.Sprintf("Get-Content %s | Out-Printer -Name %s", tempFile, printer))
-		} else {
-			cmd = exec.Command("powershell", "-Command",
-				fmt.Sprintf("Get-Content %s | Out-Printer", tempFile))
-		}
-	} else if strings.Contains(runtime.GOOS, "darwin") {
-		// Mac
-		if printer != "" {
-			cmd = exec.Command("lp", "-d", printer, tempFile)
-		} else {
-			cmd = exec.Command("lp", tempFile)
-		}
-	} else {
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+// Send delivers raw ESC/POS data to a printer over TCP
+func (p *PrintService) Send(printer *Printer, data []byte) error {
+	if printer.IPAddress == "" {
+		return fmt.Errorf("printer %s has no IP address", printer.Name)
 	}
+	return NewNetworkPrinter(printer.IPAddress, printer.Port).Send(data)
+}
 
-	return cmd.Run()
+// PrintReceipt prints a customer receipt directly to the printer (no dialog)
+func (p *PrintService) PrintReceipt(order *Order, printer *Printer, openDrawer bool) error {
+	return p.Send(printer, p.RenderReceipt(order, printer, openDrawer))
 }
 
-// PrintKitchen prints kitchen ticket
-func (p *PrintService) PrintKitchen(order *Order, printer string) error {
-	// Generate kitchen HTML
-	kitchenHTML := p.generateKitchenHTML(order)
+// PrintKitchen prints a preparation ticket with the given items
+func (p *PrintService) PrintKitchen(order *Order, items []OrderItem, printer *Printer) error {
+	return p.Send(printer, p.RenderKitchen(order, items, printer))
+}
 
-	// Save to temporary file
-	tempFile := "/tmp/kitchen_" + order.OrderNumber + ".html"
-	if err := os.WriteFile(tempFile, []byte(kitchenHTML), 0644); err != nil {
-		return err
-	}
+// newDocument starts an ESC/POS document configured for the printer
+func (p *PrintService) newDocument(printer *Printer) *ESCPOS {
+	doc := NewESCPOS(printer.PaperWidth)
+	doc.CodePage = CodePageByName(printer.CodePage, printer.CodePageTable)
+	doc.Rasterizer = p.Rasterizer
+	return doc.Init()
+}
 
-	// Print
-	var cmd *exec.Cmd
-	if strings.Contains(runtime.GOOS, "linux") {
-		cmd = exec.Command("lp", "-d", printer, tempFile)
-	} else if strings.Contains(runtime.GOOS, "windows") {
-		cmd = exec.Command("powershell", "-Command",
-			fmt.Sprintf("Get-Content %s | Out-Printer -Name %s", tempFile, printer))
-	} else {
-		cmd = exec.Command("lp", "-d", printer, tempFile)
+// RenderReceipt builds the ESC/POS customer receipt for an order from the
+// default receipt template
+func (p *PrintService) RenderReceipt(order *Order, printer *Printer, openDrawer bool) []byte {
+	doc := p.render(TemplateKindReceipt, order, printer)
+	if openDrawer {
+		doc.KickDrawer()
 	}
+	return doc.Bytes()
+}
 
-	return cmd.Run()
+// RenderKitchen builds a preparation ticket for the kitchen or bar from the
+// default kitchen template
+func (p *PrintService) RenderKitchen(order *Order, items []OrderItem, printer *Printer) []byte {
+	ticket := *order
+	ticket.Items = items
+	return p.render(TemplateKindKitchen, &ticket, printer).Bytes()
 }
 
-// generateReceiptHTML generates HTML receipt
-func (p *PrintService) generateReceiptHTML(order *Order) string {
-	direction := "rtl"
-	if p.Settings.Language == "en" {
-		direction = "ltr"
+// render renders an order with the default template of kind, falling back
+// to the built-in template if the configured one fails
+func (p *PrintService) render(kind string, order *Order, printer *Printer) *ESCPOS {
+	engine := p.Templates
+	if engine == nil {
+		engine = NewReceiptEngine(nil)
 	}
+	data := engine.BuildData(order, p.Settings)
 
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf(`<!DOCTYPE html>
-<html dir="%s">
-<head>
-    <meta charset="UTF-8">
-    <title>Receipt</title>
-    <style>
-        body { font-family: 'Cairo', Arial, sans-serif; width: 80mm; margin: 0; padding: 10px; }
-        .header { text-align: center; margin-bottom: 10px; }
-        .header h1 { font-size: 18px; margin: 5px 0; }
-        .line { border-bottom: 1px dashed #000; margin: 10px 0; }
-        .item { display: flex; justify-content: space-between; margin: 5px 0; }
-        .total { text-align: right; font-weight: bold; font-size: 16px; margin-top: 15px; }
-        .footer { text-align: center; margin-top: 20px; font-size: 12px; }
-    </style>
-</head>
-<body>
-    <div class="header">
-        <h1>%s</h1>
-        <p>%s</p>
-    </div>
-    <div class="line"></div>`, direction, p.Settings.Name, order.OrderNumber))
-
-	// Items
-	for _, item := range order.Items {
-		sb.WriteString(fmt.Sprintf(`<div class="item">
-            <span>%s x%d</span>
-            <span>%.2f</span>
-        </div>`, item.MenuItemName, item.Quantity, item.UnitPrice))
+	doc := p.newDocument(printer)
+	if err := engine.RenderESCPOS(engine.Template(kind), data, doc); err != nil {
+		log.Printf("Receipt template %q failed, using built-in: %v", kind, err)
+		doc = p.newDocument(printer)
+		engine.RenderESCPOS(builtinTemplate(kind), data, doc)
 	}
-
-	sb.WriteString(fmt.Sprintf(`    <div class="line"></div>
-    <div class="total">Total: %.2f</div>
-    <div class="footer">
-        <p>%s</p>
-        <p>%s</p>
-    </div>
-</body>
-</html>`, order.Total, p.Settings.Name, p.Settings.ReceiptFooter))
-
-	return sb.String()
+	return doc
 }
 
-// generateKitchenHTML generates kitchen ticket
-func (p *PrintService) generateKitchenHTML(order *Order) string {
-	var sb strings.Builder
-	sb.WriteString(`<!DOCTYPE html>
-<html dir="ltr">
-<head>
-    <meta charset="UTF-8">
-    <title>Kitchen Order</title>
-    <style>
-        body { font-family: Arial, sans-serif; width: 80mm; margin: 0; padding: 10px; }
-        .header { text-align: center; margin-bottom: 10px; }
-        .order-number { font-size: 20px; font-weight: bold; }
-        .line { border-bottom: 1px dashed #000; margin: 10px 0; }
-        .item { margin: 10px 0; }
-        .item-name { font-weight: bold; font-size: 14px; }
-        .item-qty { font-size: 18px; font-weight: bold; }
-        .urgent { background: #ff0000; color: white; }
-    </style>
-</head>
-<body>
-    <div class="header">
-        <p>KITCHEN ORDER</p>
-        <p class="order-number">#%s</p>
-    </div>
-    <div class="line"></div>`)
-
-	// Items
-	for _, item := range order.Items {
-		sb.WriteString(fmt.Sprintf(`<div class="item">
-        <div class="item-name">%s</div>
-        <div class="item-qty">x%d</div>
-    </div>`, item.MenuItemName, item.Quantity))
-	}
-
-	sb.WriteString(`    <div class="line"></div>
-</body>
-</html>`)
-
-	return sb.String()
+// RenderTestPage builds a page exercising sizes, alignment and Arabic text
+func (p *PrintService) RenderTestPage(printer *Printer) []byte {
+	doc := p.newDocument(printer)
+
+	doc.Align(AlignCenter).Bold(true).Size(2, 2).Line("TEST PAGE").Size(1, 1).Bold(false)
+	doc.Line(printer.Name)
+	doc.Line(fmt.Sprintf("%s:%d  %dmm  %s", printer.IPAddress, printer.Port, printer.PaperWidth, doc.CodePage.Name))
+	doc.Separator("-")
+
+	doc.Align(AlignLeft).Line("Left aligned")
+	doc.Align(AlignCenter).Line("Centered")
+	doc.Align(AlignRight).Line("Right aligned")
+	doc.Align(AlignLeft).Bold(true).Line("Bold").Bold(false)
+	doc.Size(2, 1).Line("Double width").Size(1, 2).Line("Double height").Size(1, 1)
+	doc.Separator("-")
+
+	doc.Align(AlignRight).Line("مرحباً بكم في مطعمنا")
+	doc.Columns2("شاي بالنعناع", "15.00")
+	doc.Columns2("Coffee", "25.00")
+	doc.Align(AlignLeft).Line(time.Now().Format("2006-01-02 15:04:05"))
+	doc.Feed(3).Cut(true)
+
+	return doc.Bytes()
 }
 
 // ReportService handles report generation
@@ -402,9 +202,9 @@ type ReportService struct {
 func (r *ReportService) GenerateDailyReport(date time.Time) (*DailyReport, error) {
 	var report DailyReport
 
-	// Get date range
-	startDate := date.Truncate(24 * time.Hour)
-	endDate := startDate.Add(24 * time.Hour)
+	// Get date range (local calendar day)
+	startDate := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	endDate := startDate.AddDate(0, 0, 1)
 
 	// Get total orders
 	r.DB.Model(&Order{}).Where("created_at >= ? AND created_at < ?", startDate, endDate).Count(&report.TotalOrders)
@@ -443,9 +243,16 @@ func (r *ReportService) GenerateDailyReport(date time.Time) (*DailyReport, error
 	topItemsJSON, _ := json.Marshal(topItems)
 	report.TopItems = string(topItemsJSON)
 
-	// Save report
-	report.ReportDate = date
-	r.DB.Save(&report)
+	// Save report, replacing an earlier run for the same day
+	report.ReportDate = startDate
+	var existing DailyReport
+	if r.DB.Where("report_date = ?", startDate).First(&existing).Error == nil {
+		report.ID = existing.ID
+		report.CreatedAt = existing.CreatedAt
+	}
+	if err := r.DB.Save(&report).Error; err != nil {
+		return nil, err
+	}
 
 	return &report, nil
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ========================================
+// MOCK SMTP SERVER
+// ========================================
+
+// ReceivedEmail is a message accepted by MockSMTPServer
+type ReceivedEmail struct {
+	From string
+	To   []string
+	Data []byte
+	User string // authenticated user, if any
+}
+
+// MockSMTPServer is a minimal local SMTP server for tests. It
+// speaks enough ESMTP for SMTPClient: EHLO, AUTH PLAIN and LOGIN, MAIL,
+// RCPT, DATA, RSET, NOOP and QUIT. It offers no STARTTLS, so clients must
+// connect with SMTPSecurityNone.
+type MockSMTPServer struct {
+	// OnMessage is called for every accepted message
+	OnMessage func(ReceivedEmail)
+
+	// RejectRecipients makes RCPT fail with a permanent 550 for these
+	// addresses
+	RejectRecipients map[string]bool
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []ReceivedEmail
+}
+
+// StartMockSMTPServer listens on a free local port and serves until Close
+func StartMockSMTPServer() (*MockSMTPServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &MockSMTPServer{listener: listener, RejectRecipients: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, nil
+}
+
+// Addr returns the host:port the server listens on
+func (s *MockSMTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Host returns the listening IP address
+func (s *MockSMTPServer) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+// Port returns the listening port
+func (s *MockSMTPServer) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// Messages returns the messages received so far
+func (s *MockSMTPServer) Messages() []ReceivedEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ReceivedEmail(nil), s.messages...)
+}
+
+// Close stops accepting connections
+func (s *MockSMTPServer) Close() error {
+	return s.listener.Close()
+}
+
+// serve runs one SMTP session
+func (s *MockSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			w.WriteString(line + "\r\n")
+		}
+		w.Flush()
+	}
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+
+	reply("220 localhost Mock SMTP ready")
+
+	var current ReceivedEmail
+	var user string
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		verb, rest, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-localhost greets "+rest, "250-8BITMIME", "250-AUTH PLAIN LOGIN", "250 SIZE 26214400")
+		case "HELO":
+			reply("250 localhost")
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(rest, " ")
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				if initial == "" {
+					reply("334 ")
+					if initial, ok = readLine(); !ok {
+						return
+					}
+				}
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				parts := bytes.Split(decoded, []byte{0})
+				if len(parts) != 3 {
+					reply("501 malformed AUTH PLAIN")
+					continue
+				}
+				user = string(parts[1])
+				reply("235 authenticated")
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				name, ok := readLine()
+				if !ok {
+					return
+				}
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				if _, ok := readLine(); !ok {
+					return
+				}
+				decoded, _ := base64.StdEncoding.DecodeString(name)
+				user = string(decoded)
+				reply("235 authenticated")
+			default:
+				reply("504 unrecognized authentication type")
+			}
+		case "MAIL":
+			current = ReceivedEmail{From: mockSMTPPath(rest), User: user}
+			reply("250 OK")
+		case "RCPT":
+			rcpt := mockSMTPPath(rest)
+			if s.RejectRecipients[rcpt] {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			current.To = append(current.To, rcpt)
+			reply("250 OK")
+		case "DATA":
+			if current.From == "" || len(current.To) == 0 {
+				reply("503 need MAIL and RCPT first")
+				continue
+			}
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, ok := readLine()
+				if !ok {
+					return
+				}
+				if line == "." {
+					break
+				}
+				// Undo dot-stuffing
+				line = strings.TrimPrefix(line, ".")
+				data.WriteString(line + "\r\n")
+			}
+			current.Data = data.Bytes()
+
+			s.mu.Lock()
+			s.messages = append(s.messages, current)
+			s.mu.Unlock()
+			if s.OnMessage != nil {
+				s.OnMessage(current)
+			}
+			current = ReceivedEmail{User: user}
+			reply("250 OK queued")
+		case "RSET":
+			current = ReceivedEmail{User: user}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// mockSMTPPath extracts the address from "FROM:<addr> ..." or "TO:<addr>"
+func mockSMTPPath(arg string) string {
+	start := strings.Index(arg, "<")
+	end := strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
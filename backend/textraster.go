@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// ========================================
+// TEXT RASTERIZER
+// ========================================
+//
+// Printers without an Arabic code page print Arabic lines as bitmaps. The
+// line is shaped and reordered the same way as for the code page, laid out
+// with the TrueType font used for PDFs and filled with the nonzero winding
+// rule, one dot per pixel, with no anti-aliasing.
+//
+// The font parsing (TrueTypeFont) and visual reordering (pdfVisual) are
+// shared with the PDF renderer in pdf.go.
+
+// rasterCurveSteps is how many line segments approximate each quadratic curve
+const rasterCurveSteps = 8
+
+// ErrGlyphOutline is returned for glyph data that can't be decoded
+var ErrGlyphOutline = errors.New("malformed glyph outline")
+
+// FontRasterizer renders lines of text with a TrueType font
+type FontRasterizer struct {
+	Font *TrueTypeFont
+	// Height is the line height in dots; 24 matches ESC/POS font A
+	Height int
+}
+
+// NewFontRasterizer creates a rasterizer drawing lines height dots tall
+func NewFontRasterizer(font *TrueTypeFont, height int) *FontRasterizer {
+	if height <= 0 {
+		height = 24
+	}
+	return &FontRasterizer{Font: font, Height: height}
+}
+
+// glyphPoint is a point of a glyph outline in font units
+type glyphPoint struct {
+	x, y    float64
+	onCurve bool
+}
+
+// rasterEdge is a line segment of a flattened outline in dots
+type rasterEdge struct {
+	x0, y0, x1, y1 float64
+}
+
+// RasterizeLine implements TextRasterizer. Lines wider than the paper are
+// scaled down to fit.
+func (r *FontRasterizer) RasterizeLine(text string, widthDots int, align int) (image.Image, error) {
+	f := r.Font
+	runes := pdfVisual(text)
+
+	lineUnits := float64(int(f.ascent) - int(f.descent))
+	if lineUnits <= 0 {
+		lineUnits = float64(f.unitsPerEm)
+	}
+	scale := float64(r.Height) / lineUnits
+
+	var advance float64
+	for _, ch := range runes {
+		advance += float64(f.advance(f.glyph(ch)))
+	}
+	if advance*scale > float64(widthDots) {
+		scale = float64(widthDots) / advance
+	}
+
+	pen := 0.0
+	switch align {
+	case AlignCenter:
+		pen = (float64(widthDots) - advance*scale) / 2
+	case AlignRight:
+		pen = float64(widthDots) - advance*scale
+	}
+	baseline := float64(f.ascent) * scale
+
+	var edges []rasterEdge
+	for _, ch := range runes {
+		gid := f.glyph(ch)
+		contours, err := f.glyphContours(int(gid), 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, contour := range contours {
+			edges = appendContourEdges(edges, contour, func(p glyphPoint) (float64, float64) {
+				return pen + p.x*scale, baseline - p.y*scale
+			})
+		}
+		pen += float64(f.advance(gid)) * scale
+	}
+
+	img := image.NewGray(image.Rect(0, 0, widthDots, r.Height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	fillEdges(img, edges)
+	return img, nil
+}
+
+// glyphContours returns the outline of a glyph as closed contours of
+// on- and off-curve points, resolving composite glyphs
+func (f *TrueTypeFont) glyphContours(gid, depth int) ([][]glyphPoint, error) {
+	data := f.glyphData(gid)
+	if len(data) < 10 {
+		return nil, nil // empty glyph, such as a space
+	}
+	numContours := int(int16(binary.BigEndian.Uint16(data)))
+	if numContours >= 0 {
+		return parseSimpleGlyph(data, numContours)
+	}
+	if depth > 4 {
+		return nil, ErrGlyphOutline
+	}
+
+	var contours [][]glyphPoint
+	offset := 10
+	for {
+		if offset+4 > len(data) {
+			return nil, ErrGlyphOutline
+		}
+		flags := binary.BigEndian.Uint16(data[offset:])
+		component := int(binary.BigEndian.Uint16(data[offset+2:]))
+		offset += 4
+
+		var dx, dy float64
+		if flags&0x0001 != 0 {
+			if offset+4 > len(data) {
+				return nil, ErrGlyphOutline
+			}
+			dx = float64(int16(binary.BigEndian.Uint16(data[offset:])))
+			dy = float64(int16(binary.BigEndian.Uint16(data[offset+2:])))
+			offset += 4
+		} else {
+			if offset+2 > len(data) {
+				return nil, ErrGlyphOutline
+			}
+			dx = float64(int8(data[offset]))
+			dy = float64(int8(data[offset+1]))
+			offset += 2
+		}
+		if flags&0x0002 == 0 {
+			// Anchored by point numbers; rare in text fonts, placed unshifted
+			dx, dy = 0, 0
+		}
+
+		a, b, c, d := 1.0, 0.0, 0.0, 1.0
+		f2dot14 := func(at int) float64 {
+			return float64(int16(binary.BigEndian.Uint16(data[at:]))) / 16384
+		}
+		switch {
+		case flags&0x0008 != 0 && offset+2 <= len(data):
+			a = f2dot14(offset)
+			d = a
+			offset += 2
+		case flags&0x0040 != 0 && offset+4 <= len(data):
+			a, d = f2dot14(offset), f2dot14(offset+2)
+			offset += 4
+		case flags&0x0080 != 0 && offset+8 <= len(data):
+			a, b, c, d = f2dot14(offset), f2dot14(offset+2), f2dot14(offset+4), f2dot14(offset+6)
+			offset += 8
+		}
+
+		parts, err := f.glyphContours(component, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			moved := make([]glyphPoint, len(part))
+			for i, p := range part {
+				moved[i] = glyphPoint{x: a*p.x + c*p.y + dx, y: b*p.x + d*p.y + dy, onCurve: p.onCurve}
+			}
+			contours = append(contours, moved)
+		}
+
+		if flags&0x0020 == 0 {
+			return contours, nil
+		}
+	}
+}
+
+// parseSimpleGlyph decodes the points of a non-composite glyph
+func parseSimpleGlyph(data []byte, numContours int) ([][]glyphPoint, error) {
+	offset := 10
+	if offset+2*numContours+2 > len(data) {
+		return nil, ErrGlyphOutline
+	}
+	ends := make([]int, numContours)
+	for i := range ends {
+		ends[i] = int(binary.BigEndian.Uint16(data[offset+2*i:]))
+	}
+	offset += 2 * numContours
+	if numContours == 0 {
+		return nil, nil
+	}
+	numPoints := ends[numContours-1] + 1
+	offset += 2 + int(binary.BigEndian.Uint16(data[offset:])) // skip instructions
+
+	flags := make([]byte, 0, numPoints)
+	for len(flags) < numPoints {
+		if offset >= len(data) {
+			return nil, ErrGlyphOutline
+		}
+		flag := data[offset]
+		offset++
+		flags = append(flags, flag)
+		if flag&0x08 != 0 {
+			if offset >= len(data) {
+				return nil, ErrGlyphOutline
+			}
+			for n := int(data[offset]); n > 0 && len(flags) < numPoints; n-- {
+				flags = append(flags, flag)
+			}
+			offset++
+		}
+	}
+
+	// readCoords decodes one axis; short and same bits differ per axis
+	readCoords := func(short, same byte) ([]float64, error) {
+		coords := make([]float64, numPoints)
+		value := 0
+		for i, flag := range flags {
+			switch {
+			case flag&short != 0:
+				if offset >= len(data) {
+					return nil, ErrGlyphOutline
+				}
+				delta := int(data[offset])
+				offset++
+				if flag&same == 0 {
+					delta = -delta
+				}
+				value += delta
+			case flag&same == 0:
+				if offset+2 > len(data) {
+					return nil, ErrGlyphOutline
+				}
+				value += int(int16(binary.BigEndian.Uint16(data[offset:])))
+				offset += 2
+			}
+			coords[i] = float64(value)
+		}
+		return coords, nil
+	}
+	xs, err := readCoords(0x02, 0x10)
+	if err != nil {
+		return nil, err
+	}
+	ys, err := readCoords(0x04, 0x20)
+	if err != nil {
+		return nil, err
+	}
+
+	contours := make([][]glyphPoint, 0, numContours)
+	start := 0
+	for _, end := range ends {
+		if end < start || end >= numPoints {
+			return nil, ErrGlyphOutline
+		}
+		contour := make([]glyphPoint, 0, end-start+1)
+		for i := start; i <= end; i++ {
+			contour = append(contour, glyphPoint{x: xs[i], y: ys[i], onCurve: flags[i]&0x01 != 0})
+		}
+		contours = append(contours, contour)
+		start = end + 1
+	}
+	return contours, nil
+}
+
+// appendContourEdges flattens a closed quadratic contour into line edges.
+// Two off-curve points in a row imply an on-curve point between them.
+func appendContourEdges(edges []rasterEdge, contour []glyphPoint, transform func(glyphPoint) (float64, float64)) []rasterEdge {
+	n := len(contour)
+	if n < 2 {
+		return edges
+	}
+
+	// Start from an on-curve point, making one up if there is none
+	first := -1
+	for i, p := range contour {
+		if p.onCurve {
+			first = i
+			break
+		}
+	}
+	var start glyphPoint
+	if first < 0 {
+		start = midpoint(contour[0], contour[1])
+		first = 0
+	} else {
+		start = contour[first]
+		first++
+	}
+
+	px, py := transform(start)
+	lineTo := func(x, y float64) {
+		edges = append(edges, rasterEdge{px, py, x, y})
+		px, py = x, y
+	}
+
+	var control *glyphPoint
+	for i := 0; i <= n; i++ {
+		var p glyphPoint
+		if i == n {
+			p = start
+		} else {
+			p = contour[(first+i)%n]
+		}
+		if !p.onCurve {
+			if control != nil {
+				mid := midpoint(*control, p)
+				edges = appendQuad(edges, &px, &py, *control, mid, transform)
+			}
+			copied := p
+			control = &copied
+			continue
+		}
+		if control != nil {
+			edges = appendQuad(edges, &px, &py, *control, p, transform)
+			control = nil
+		} else {
+			lineTo(transform(p))
+		}
+	}
+	return edges
+}
+
+// appendQuad flattens a quadratic curve from the current point
+func appendQuad(edges []rasterEdge, px, py *float64, control, end glyphPoint, transform func(glyphPoint) (float64, float64)) []rasterEdge {
+	cx, cy := transform(control)
+	ex, ey := transform(end)
+	sx, sy := *px, *py
+	for step := 1; step <= rasterCurveSteps; step++ {
+		t := float64(step) / rasterCurveSteps
+		u := 1 - t
+		x := u*u*sx + 2*u*t*cx + t*t*ex
+		y := u*u*sy + 2*u*t*cy + t*t*ey
+		edges = append(edges, rasterEdge{*px, *py, x, y})
+		*px, *py = x, y
+	}
+	return edges
+}
+
+func midpoint(a, b glyphPoint) glyphPoint {
+	return glyphPoint{x: (a.x + b.x) / 2, y: (a.y + b.y) / 2, onCurve: true}
+}
+
+// fillEdges blackens every pixel whose centre is inside the outline by the
+// nonzero winding rule
+func fillEdges(img *image.Gray, edges []rasterEdge) {
+	type crossing struct {
+		x       float64
+		winding int
+	}
+	bounds := img.Bounds()
+	var crossings []crossing
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		sy := float64(y) + 0.5
+		crossings = crossings[:0]
+		for _, e := range edges {
+			winding := 1
+			y0, y1, x0, x1 := e.y0, e.y1, e.x0, e.x1
+			if y0 > y1 {
+				y0, y1, x0, x1 = y1, y0, x1, x0
+				winding = -1
+			}
+			if sy < y0 || sy >= y1 {
+				continue
+			}
+			x := x0 + (sy-y0)/(y1-y0)*(x1-x0)
+			crossings = append(crossings, crossing{x, winding})
+		}
+		sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+		winding := 0
+		for i := 0; i+1 < len(crossings); i++ {
+			winding += crossings[i].winding
+			if winding == 0 {
+				continue
+			}
+			from := int(math.Ceil(crossings[i].x - 0.5))
+			to := int(math.Ceil(crossings[i+1].x - 0.5))
+			for x := maxInt(from, bounds.Min.X); x < to && x < bounds.Max.X; x++ {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			}
+		}
+	}
+}
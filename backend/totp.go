@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// TWO-FACTOR AUTHENTICATION
+// ========================================
+//
+// Time-based one-time passwords (RFC 6238: HMAC-SHA1, 6 digits, 30 second
+// steps) from any authenticator app. A user enrolls by requesting a secret,
+// scanning the provisioning URI and confirming a code; enrolling issues ten
+// single-use recovery codes. Once enabled, password sign-in also needs a
+// current code or a recovery code.
+//
+// Roles listed in Auth.TOTPRequiredRoles must use 2FA: until such a user
+// has enrolled, their sessions can only reach the enrollment endpoints.
+
+// TOTP parameters
+const (
+	totpDigits        = 6
+	totpPeriod        = 30
+	totpSkew          = 1 // steps accepted either side of now
+	recoveryCodeCount = 10
+)
+
+// 2FA errors
+var (
+	ErrTwoFactorRequired = errors.New("two-factor code required")
+	ErrInvalidTwoFactor  = errors.New("invalid two-factor code")
+	ErrTwoFactorEnforced = errors.New("two-factor authentication is required for your role")
+)
+
+// twoFactorSetupRoutes are the routes a user who must enroll in 2FA can
+// still reach
+var twoFactorSetupRoutes = map[string]bool{
+	"/api/auth/me":          true,
+	"/api/auth/permissions": true,
+	"/api/auth/logout-all":  true,
+	"/api/auth/2fa":         true,
+	"/api/auth/2fa/setup":   true,
+	"/api/auth/2fa/enable":  true,
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, base32 encoded
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpCode returns the code for a time step (RFC 4226 dynamic truncation)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP checks code against the steps around now and returns the
+// matching step. Steps up to lastStep were already used and are refused.
+func verifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth:// provisioning URI for authenticator apps
+func (a *App) totpURI(user *User, secret string) string {
+	issuer := a.Config.Auth.TOTPIssuer
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + user.Email)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TwoFactorRequiredFor reports whether role has to use 2FA
+func (a *App) TwoFactorRequiredFor(role string) bool {
+	return containsString(a.Config.Auth.TOTPRequiredRoles, role)
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, a recovery code,
+// and consumes it
+func (a *App) VerifySecondFactor(user *User, code, recoveryCode string) error {
+	if code == "" && recoveryCode == "" {
+		return ErrTwoFactorRequired
+	}
+	if code != "" {
+		step, ok := verifyTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return ErrInvalidTwoFactor
+		}
+		// Claim the step so the same code can't be replayed
+		result := a.DB.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactor
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+
+	result := a.DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(recoveryCode))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactor
+	}
+	return nil
+}
+
+// issueRecoveryCodes replaces a user's recovery codes and returns the new
+// ones; only their hashes are stored
+func issueRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode drops separators and case from a typed code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// qrDataURL renders content as a PNG data URL
+func qrDataURL(content string) (string, error) {
+	qr, err := EncodeQR(content)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qr.Image(6)); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// ========================================
+// 2FA HANDLERS
+// ========================================
+
+// HandleGetTwoFactor returns the current user's 2FA status
+func (a *App) HandleGetTwoFactor(c *gin.Context) {
+	var user User
+	if err := a.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+
+	var remaining int64
+	a.DB.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TOTPEnabled,
+		"enabled_at":               user.TOTPEnabledAt,
+		"required":                 a.TwoFactorRequiredFor(user.Role),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// HandleSetupTwoFactor starts enrollment: it stores a new pending secret and
+// returns it with its provisioning URI and QR code
+func (a *App) HandleSetupTwoFactor(c *gin.Context) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var user User
+	if err := a.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if !CheckPassword(req.Password, user.Password) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to start enrollment"})
+		return
+	}
+	if err := a.DB.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to start enrollment"})
+		return
+	}
+
+	uri := a.totpURI(&user, secret)
+	qr, _ := qrDataURL(uri)
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    uri,
+		"qr":     qr,
+	})
+}
+
+// HandleEnableTwoFactor confirms enrollment with a code from the app and
+// returns the recovery codes, which are shown only this once
+func (a *App) HandleEnableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	var user User
+	if err := a.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Start enrollment first"})
+		return
+	}
+	step, ok := verifyTOTP(user.TOTPSecret, req.Code, time.Now(), 0)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":    true,
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = issueRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to enable two-factor authentication"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "2fa_enabled", Entity: "user", EntityID: user.ID})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// twoFactorConfirmation is the body of requests that change an enrolled
+// user's 2FA
+type twoFactorConfirmation struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// confirmTwoFactor loads the current user and checks their password and
+// second factor
+func (a *App) confirmTwoFactor(c *gin.Context) (*User, bool) {
+	var req twoFactorConfirmation
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return nil, false
+	}
+
+	var user User
+	if err := a.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return nil, false
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Two-factor authentication is not enabled"})
+		return nil, false
+	}
+	if !CheckPassword(req.Password, user.Password) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return nil, false
+	}
+	if err := a.VerifySecondFactor(&user, req.Code, req.RecoveryCode); err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials", Message: err.Error()})
+		return nil, false
+	}
+	return &user, true
+}
+
+// HandleDisableTwoFactor turns 2FA off for the current user, unless their
+// role requires it
+func (a *App) HandleDisableTwoFactor(c *gin.Context) {
+	user, ok := a.confirmTwoFactor(c)
+	if !ok {
+		return
+	}
+	if a.TwoFactorRequiredFor(user.Role) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Not allowed", Message: ErrTwoFactorEnforced.Error()})
+		return
+	}
+
+	if err := a.clearTwoFactor(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to disable two-factor authentication"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "2fa_disabled", Entity: "user", EntityID: user.ID})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// HandleRegenerateRecoveryCodes replaces the current user's recovery codes
+func (a *App) HandleRegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := a.confirmTwoFactor(c)
+	if !ok {
+		return
+	}
+
+	var codes []string
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = issueRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate recovery codes"})
+		return
+	}
+	a.Audit(c, AuditEntry{Action: "2fa_recovery_codes_regenerated", Entity: "user", EntityID: user.ID})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// HandleResetTwoFactor removes a staff member's 2FA, for a lost device, and
+// ends their sessions. Users whose role requires 2FA enroll again at their
+// next sign-in.
+func (a *App) HandleResetTwoFactor(c *gin.Context) {
+	var user User
+	if err := a.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if user.Role == SuperAdminRole && c.GetString("role") != SuperAdminRole {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Insufficient permissions", Message: "only a super admin can reset a super admin"})
+		return
+	}
+
+	if err := a.clearTwoFactor(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to reset two-factor authentication"})
+		return
+	}
+	revoked, _ := a.RevokeUserSessions(user.ID, RevokedByAdmin)
+	a.Audit(c, AuditEntry{Action: "2fa_reset", Entity: "user", EntityID: user.ID, Details: gin.H{"revoked_sessions": revoked}})
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Two-factor authentication reset",
+	})
+}
+
+// clearTwoFactor removes a user's secret and recovery codes
+func (a *App) clearTwoFactor(userID uint) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_enabled":    false,
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
-	"database/sql"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,12 +33,6 @@ type DBUtils struct {
 
 // BackupDatabase creates a database backup
 func (d *DBUtils) BackupDatabase(filename string) error {
-	// Get database connection
-	sqlDB, err := d.DB.DB()
-	if err != nil {
-		return err
-	}
-
 	// Use mysqldump to create backup
 	backupFile := "/tmp/" + filename + ".sql"
 	cmd := exec.Command("mysqldump", "--opt", "--all", "--add-drop-database", "-u", "root", "restaurant_pos")
@@ -57,8 +56,7 @@ func (d *DBUtils) RestoreDatabase(filename string) error {
 	}
 
 	// Execute SQL
-	_, err = d.DB.Exec(string(content))
-	return err
+	return d.DB.Exec(string(content)).Error
 }
 
 // ========================================
@@ -238,22 +236,48 @@ func IsValidURL(url string) bool {
 // ENCRYPTION UTILS
 // ========================================
 
-// Encrypt encrypts string (simplified)
+// Encrypt encrypts plaintext with AES-256-GCM under a key derived from key.
+// Column values use FieldCipher instead, which supports key rotation.
 func Encrypt(plaintext, key string) (string, error) {
-	// In production, use proper encryption (AES, etc.)
-	// This is a simplified version
-	return base64.StdEncoding.EncodeToString([]byte(plaintext)), nil
+	aead, err := passphraseAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil
 }
 
-// Decrypt decrypts string (simplified)
+// Decrypt decrypts a value returned by Encrypt with the same key
 func Decrypt(ciphertext, key string) (string, error) {
-	// In production, use proper decryption
-	// This is a simplified version
+	aead, err := passphraseAEAD(key)
+	if err != nil {
+		return "", err
+	}
 	data, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	if len(data) < aead.NonceSize() {
+		return "", ErrMalformedCiphertext
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// passphraseAEAD returns AES-256-GCM keyed by the SHA-256 of key
+func passphraseAEAD(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 // ========================================
@@ -333,142 +357,6 @@ func SaveUploadedFile(file io.Reader, filename string) (string, error) {
 	return filePath, err
 }
 
-// ========================================
-// WHATSAPP API UTILS
-// ========================================
-
-// WhatsAppAPIService handles WhatsApp API
-type WhatsAppAPIService struct {
-	APIURL  string
-	APIKey  string
-	Enabled bool
-}
-
-// SendWhatsAppAPIMessage sends message via WhatsApp API
-func (w *WhatsAppAPIService) SendWhatsAppAPIMessage(phoneNumber, message string) error {
-	if !w.Enabled {
-		return fmt.Errorf("WhatsApp is disabled")
-	}
-
-	// Example using Twilio WhatsApp API
-	// Replace with actual WhatsApp API (Twilio, MessageBird, etc.)
-	
-	url := fmt.Sprintf("%s/messages", w.APIURL)
-
-	payload := map[string]interface{}{
-		"from": "whatsapp:+14155238886",
-		"to":   "whatsapp:" + phoneNumber,
-		"body": message,
-	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonPayload)))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+w.APIKey)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("WhatsApp API returned status %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
-// SendWhatsAppImage sends image via WhatsApp
-func (w *WhatsAppAPIService) SendWhatsAppImage(phoneNumber, mediaURL, caption string) error {
-	if !w.Enabled {
-		return fmt.Errorf("WhatsApp is disabled")
-	}
-
-	url := fmt.Sprintf("%s/messages", w.APIURL)
-
-	payload := map[string]interface{}{
-		"from": "whatsapp:+14155238886",
-		"to":   "whatsapp:" + phoneNumber,
-		"type": "image",
-		"media": map[string]interface{}{
-			"url": mediaURL,
-		},
-		"caption": caption,
-	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonPayload)))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+w.APIKey)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("WhatsApp API returned status %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
-// SendWhatsAppDocument sends document via WhatsApp
-func (w *WhatsAppAPIService) SendWhatsAppDocument(phoneNumber, mediaURL, filename string) error {
-	if !w.Enabled {
-		return fmt.Errorf("WhatsApp is disabled")
-	}
-
-	url := fmt.Sprintf("%s/messages", w.APIURL)
-
-	payload := map[string]interface{}{
-		"from": "whatsapp:+14155238886",
-		"to":   "whatsapp:" + phoneNumber,
-		"type": "document",
-		"media": map[string]interface{}{
-			"url":  mediaURL,
-			"filename": filename,
-		},
-	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonPayload)))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+w.APIKey)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("WhatsApp API returned status %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
 // ========================================
 // CONFIG UTILS
 // ========================================
@@ -515,8 +403,9 @@ func GetEnvBool(key string, defaultValue bool) bool {
 // EXPORT/IMPORT UTILS
 // ========================================
 
-// ExportToCSV exports data to CSV
-func ExportToCSV(data []map[string]interface{}, filename string) error {
+// ExportToCSV exports data to a UTF-8 CSV file with a byte order mark.
+// Columns follow the given order, or sorted key order when none is given.
+func ExportToCSV(data []map[string]interface{}, filename string, columns ...string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -527,23 +416,39 @@ func ExportToCSV(data []map[string]interface{}, filename string) error {
 		return nil
 	}
 
-	// Write headers
-	headers := make([]string, 0)
-	for k := range data[0] {
-		headers = append(headers, k)
+	headers := columns
+	if len(headers) == 0 {
+		for k := range data[0] {
+			headers = append(headers, k)
+		}
+		sort.Strings(headers)
 	}
-	file.WriteString(strings.Join(headers, ",") + "\n")
 
-	// Write data
+	if _, err := file.WriteString("\ufeff"); err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	if err := w.Write(headers); err != nil {
+		return err
+	}
+	values := make([]string, len(headers))
 	for _, row := range data {
-		values := make([]string, 0)
-		for _, h := range headers {
-			values = append(values, fmt.Sprintf("%v", row[h]))
+		for i, h := range headers {
+			if v, ok := row[h]; ok && v != nil {
+				values[i] = fmt.Sprintf("%v", v)
+			} else {
+				values[i] = ""
+			}
+		}
+		if err := w.Write(values); err != nil {
+			return err
 		}
-		file.WriteString(strings.Join(values, ",") + "\n")
 	}
-
-	return nil
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
 }
 
 // ExportToJSON exports data to JSON
@@ -570,24 +475,6 @@ func ImportFromJSON(filename string, v interface{}) error {
 // RESPONSE UTILS
 // ========================================
 
-// SuccessResponse creates success response
-func SuccessResponse(message string, data interface{}) map[string]interface{} {
-	return map[string]interface{}{
-		"success": true,
-		"message": message,
-		"data":    data,
-	}
-}
-
-// ErrorResponse creates error response
-func ErrorResponse(message, details string) map[string]interface{} {
-	return map[string]interface{}{
-		"success": false,
-		"error":   message,
-		"details": details,
-	}
-}
-
 // PaginationResponse creates paginated response
 func PaginationResponse(data interface{}, page, limit int, total int64) map[string]interface{} {
 	return map[string]interface{}{
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ========================================
+// OUTBOUND WEBHOOKS
+// ========================================
+
+// Webhook delivery statuses
+const (
+	WebhookPending    = "pending"
+	WebhookProcessing = "processing"
+	WebhookSucceeded  = "succeeded"
+	WebhookFailed     = "failed"
+)
+
+// WebhookEventTypes lists the events partners can subscribe to
+var WebhookEventTypes = []string{
+	"order.created",
+	"order.status_changed",
+	"order.completed",
+	"payment.created",
+	"stock.changed",
+}
+
+// WebhookPayload is the JSON body POSTed to subscribers
+type WebhookPayload struct {
+	ID        uint            `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookService delivers signed webhook requests with retries
+type WebhookService struct {
+	DB           *gorm.DB
+	Client       *http.Client
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	// StaleAfter releases deliveries left in processing by a crashed worker
+	StaleAfter time.Duration
+
+	wake chan struct{}
+}
+
+// NewWebhookService creates a webhook service. Its client only connects to
+// public addresses, see webhookDialer.
+func NewWebhookService(db *gorm.DB) *WebhookService {
+	return &WebhookService{
+		DB: db,
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: webhookDialer().DialContext},
+		},
+		PollInterval: 5 * time.Second,
+		BatchSize:    50,
+		MaxAttempts:  8,
+		StaleAfter:   2 * time.Minute,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// ErrWebhookAddress is returned for webhook targets on an internal address
+var ErrWebhookAddress = errors.New("webhook url must resolve to a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598)
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddress reports whether ip is reachable on the internet, so webhook
+// requests can't be aimed at the server itself or the internal network
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// checkWebhookURL resolves the URL's host and refuses it if any of its
+// addresses isn't public
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid url")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", u.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr.IP) {
+			return fmt.Errorf("%w: %s is %s", ErrWebhookAddress, u.Hostname(), addr.IP)
+		}
+	}
+	return nil
+}
+
+// webhookDialer checks every connection's resolved address, so a hostname
+// that passed checkWebhookURL can't later be re-pointed (or redirected) at
+// an internal one before a delivery
+func webhookDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature header value for body:
+// "t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">". Receivers recompute
+// the HMAC with their secret and reject stale timestamps.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// GenerateWebhookSecret creates a random signing secret
+func GenerateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Fanout queues a delivery for every active subscription to the event. It
+// runs as an outbox handler, so it happens once per committed event.
+func (w *WebhookService) Fanout(event *OutboxEvent) error {
+	var subscriptions []WebhookSubscription
+	if err := w.DB.Where("is_active = ?", true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	err := w.DB.Transaction(func(tx *gorm.DB) error {
+		for _, sub := range subscriptions {
+			if !sub.Subscribes(event.EventType) {
+				continue
+			}
+
+			// The outbox may redeliver after a crash; skip what's already queued
+			var existing int64
+			tx.Model(&WebhookDelivery{}).
+				Where("subscription_id = ? AND outbox_event_id = ?", sub.ID, event.ID).
+				Count(&existing)
+			if existing > 0 {
+				continue
+			}
+
+			outboxID := event.ID
+			delivery := WebhookDelivery{
+				SubscriptionID: sub.ID,
+				OutboxEventID:  &outboxID,
+				EventType:      event.EventType,
+				Payload:        event.Payload,
+				Status:         WebhookPending,
+				MaxAttempts:    w.MaxAttempts,
+				NextAttemptAt:  time.Now(),
+			}
+			if err := tx.Create(&delivery).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		w.Wake()
+	}
+	return err
+}
+
+// Wake asks the worker to poll now
+func (w *WebhookService) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due webhooks until the process exits
+func (w *WebhookService) Run() {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.releaseStale()
+
+		var due []WebhookDelivery
+		if err := w.DB.Where("status = ? AND next_attempt_at <= ?", WebhookPending, time.Now()).
+			Order("id ASC").
+			Limit(w.BatchSize).
+			Find(&due).Error; err != nil {
+			log.Printf("Webhook poll failed: %v", err)
+		}
+
+		for i := range due {
+			claim := w.DB.Model(&WebhookDelivery{}).
+				Where("id = ? AND status = ?", due[i].ID, WebhookPending).
+				Updates(map[string]interface{}{"status": WebhookProcessing, "locked_at": time.Now()})
+			if claim.Error != nil || claim.RowsAffected == 0 {
+				continue
+			}
+			w.Attempt(&due[i])
+		}
+
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// releaseStale returns deliveries stuck in processing to the queue
+func (w *WebhookService) releaseStale() {
+	w.DB.Model(&WebhookDelivery{}).
+		Where("status = ? AND locked_at < ?", WebhookProcessing, time.Now().Add(-w.StaleAfter)).
+		Updates(map[string]interface{}{"status": WebhookPending, "locked_at": nil})
+}
+
+// Attempt sends one delivery and records the outcome, scheduling a retry
+// with exponential backoff on failure
+func (w *WebhookService) Attempt(delivery *WebhookDelivery) {
+	var sub WebhookSubscription
+	if err := w.DB.First(&sub, delivery.SubscriptionID).Error; err != nil {
+		w.DB.Model(delivery).Updates(map[string]interface{}{
+			"status":     WebhookFailed,
+			"last_error": "subscription no longer exists",
+			"locked_at":  nil,
+		})
+		return
+	}
+
+	updates := w.attempt(&sub, delivery)
+	w.DB.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates)
+}
+
+// attempt sends delivery to sub and applies the outcome to delivery,
+// returning the columns to save
+func (w *WebhookService) attempt(sub *WebhookSubscription, delivery *WebhookDelivery) map[string]interface{} {
+	start := time.Now()
+	code, body, err := w.send(sub, delivery)
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.ResponseBody = body
+	delivery.DurationMs = time.Since(start).Milliseconds()
+
+	updates := map[string]interface{}{
+		"attempts":      delivery.Attempts,
+		"response_code": code,
+		"response_body": body,
+		"duration_ms":   delivery.DurationMs,
+		"locked_at":     nil,
+	}
+
+	if err == nil {
+		now := time.Now()
+		delivery.Status = WebhookSucceeded
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+		updates["status"] = WebhookSucceeded
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+	} else {
+		delivery.LastError = err.Error()
+		updates["last_error"] = delivery.LastError
+		if delivery.Attempts >= delivery.MaxAttempts {
+			delivery.Status = WebhookFailed
+		} else {
+			delivery.Status = WebhookPending
+			delivery.NextAttemptAt = time.Now().Add(retryBackoff(delivery.Attempts))
+			updates["next_attempt_at"] = delivery.NextAttemptAt
+		}
+		updates["status"] = delivery.Status
+	}
+
+	return updates
+}
+
+// send POSTs the signed payload and returns the response code and a
+// truncated response body. Any non-2xx status counts as a failure.
+func (w *WebhookService) send(sub *WebhookSubscription, delivery *WebhookDelivery) (int, string, error) {
+	body, err := json.Marshal(WebhookPayload{
+		ID:        delivery.ID,
+		Event:     delivery.EventType,
+		CreatedAt: delivery.CreatedAt.Format(time.RFC3339),
+		Data:      json.RawMessage(delivery.Payload),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "RestaurantPOS-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Signature", SignWebhookPayload(sub.Secret.String(), timestamp, body))
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(respBody), fmt.Errorf("subscriber returned status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, string(respBody), nil
+}
+
+// ========================================
+// WEBHOOK HANDLERS
+// ========================================
+
+// WebhookSubscriptionRequest is the body for creating or updating a subscription
+type WebhookSubscriptionRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	URL         string   `json:"url" binding:"required"`
+	EventTypes  []string `json:"event_types" binding:"required"`
+	Description string   `json:"description"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// validate checks the target URL and event types
+func (r *WebhookSubscriptionRequest) validate(ctx context.Context) error {
+	if !IsValidURL(r.URL) {
+		return fmt.Errorf("url must start with http:// or https://")
+	}
+	if err := checkWebhookURL(ctx, r.URL); err != nil {
+		return err
+	}
+	if len(r.EventTypes) == 0 {
+		return fmt.Errorf("at least one event type is required")
+	}
+	for _, t := range r.EventTypes {
+		if t == "*" {
+			continue
+		}
+		known := false
+		for _, e := range WebhookEventTypes {
+			if e == t {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return nil
+}
+
+// encodedEventTypes returns the event types as a JSON array
+func (r *WebhookSubscriptionRequest) encodedEventTypes() string {
+	data, _ := json.Marshal(r.EventTypes)
+	return string(data)
+}
+
+// Subscribes reports whether the subscription wants eventType
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	var types []string
+	if err := json.Unmarshal([]byte(s.EventTypes), &types); err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleGetWebhooks lists webhook subscriptions
+func (a *App) HandleGetWebhooks(c *gin.Context) {
+	var subscriptions []WebhookSubscription
+	if err := a.DB.Order("id ASC").Find(&subscriptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscriptions": subscriptions,
+		"event_types":   WebhookEventTypes,
+	})
+}
+
+// HandleCreateWebhook creates a subscription and returns its secret once
+func (a *App) HandleCreateWebhook(c *gin.Context) {
+	var req WebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := req.validate(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate secret"})
+		return
+	}
+
+	sub := WebhookSubscription{
+		Name:        req.Name,
+		URL:         req.URL,
+		EventTypes:  req.encodedEventTypes(),
+		Description: req.Description,
+		Secret:      EncryptedString(secret),
+		IsActive:    req.IsActive == nil || *req.IsActive,
+	}
+	if err := a.DB.Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SuccessResponse{
+		Success: true,
+		Message: "Webhook created successfully",
+		Data: gin.H{
+			"subscription": sub,
+			"secret":       secret,
+		},
+	})
+}
+
+// HandleUpdateWebhook updates a subscription's target, events or state
+func (a *App) HandleUpdateWebhook(c *gin.Context) {
+	id := c.Param("id")
+
+	var sub WebhookSubscription
+	if err := a.DB.First(&sub, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Webhook not found"})
+		return
+	}
+
+	var req WebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+	if err := req.validate(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Message: err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{
+		"name":        req.Name,
+		"url":         req.URL,
+		"event_types": req.encodedEventTypes(),
+		"description": req.Description,
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if err := a.DB.Model(&sub).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update webhook"})
+		return
+	}
+	a.DB.First(&sub, sub.ID)
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Webhook updated successfully",
+		Data:    sub,
+	})
+}
+
+// HandleDeleteWebhook deletes a subscription
+func (a *App) HandleDeleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := a.DB.Delete(&WebhookSubscription{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Webhook deleted successfully",
+	})
+}
+
+// HandleRotateWebhookSecret issues a new signing secret
+func (a *App) HandleRotateWebhookSecret(c *gin.Context) {
+	id := c.Param("id")
+
+	var sub WebhookSubscription
+	if err := a.DB.First(&sub, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Webhook not found"})
+		return
+	}
+
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate secret"})
+		return
+	}
+
+	if err := a.DB.Model(&sub).Update("secret", EncryptedString(secret)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to rotate secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Secret rotated successfully",
+		Data:    gin.H{"secret": secret},
+	})
+}
+
+// HandleTestWebhook sends a webhook.test event right away and returns the result
+func (a *App) HandleTestWebhook(c *gin.Context) {
+	id := c.Param("id")
+
+	var sub WebhookSubscription
+	if err := a.DB.First(&sub, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Webhook not found"})
+		return
+	}
+
+	payload, _ := json.Marshal(gin.H{
+		"message":      "Test event from Restaurant POS",
+		"subscription": sub.Name,
+	})
+	now := time.Now()
+
+	delivery := WebhookDelivery{
+		SubscriptionID: sub.ID,
+		EventType:      "webhook.test",
+		Payload:        string(payload),
+		Status:         WebhookProcessing,
+		MaxAttempts:    1,
+		NextAttemptAt:  now,
+		LockedAt:       &now,
+	}
+	if err := a.DB.Create(&delivery).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create test delivery"})
+		return
+	}
+
+	a.Webhooks.Attempt(&delivery)
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: delivery.Status == WebhookSucceeded,
+		Message: "Test event sent",
+		Data:    delivery,
+	})
+}
+
+// HandleGetWebhookDeliveries returns the delivery log of a subscription
+func (a *App) HandleGetWebhookDeliveries(c *gin.Context) {
+	id := c.Param("id")
+
+	var deliveries []WebhookDelivery
+	query := a.DB.Where("subscription_id = ?", id).Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	page := c.DefaultQuery("page", "1")
+	limit := c.DefaultQuery("limit", "50")
+	query = query.Offset((getInt(page) - 1) * getInt(limit)).Limit(getInt(limit))
+
+	if err := query.Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// HandleRedeliverWebhook queues a past delivery to be sent again
+func (a *App) HandleRedeliverWebhook(c *gin.Context) {
+	var delivery WebhookDelivery
+	if err := a.DB.Where("subscription_id = ?", c.Param("id")).
+		First(&delivery, c.Param("deliveryId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Delivery not found"})
+		return
+	}
+
+	if delivery.Status == WebhookProcessing {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Delivery is in progress"})
+		return
+	}
+
+	if err := a.DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":          WebhookPending,
+		"attempts":        0,
+		"max_attempts":    a.Webhooks.MaxAttempts,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue delivery"})
+		return
+	}
+
+	a.Webhooks.Wake()
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Success: true,
+		Message: "Delivery queued",
+	})
+}
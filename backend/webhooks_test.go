@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a local subscriber that verifies signatures and
+// answers with the queued status codes, then 200
+type webhookReceiver struct {
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header  http.Header
+	payload WebhookPayload
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("reading body: %v", err)
+	}
+	if req.Method != http.MethodPost {
+		r.t.Errorf("method = %s, want POST", req.Method)
+	}
+	if err := verifyWebhookSignature(r.secret, req.Header.Get("X-Webhook-Signature"), body); err != "" {
+		r.t.Errorf("signature: %s", err)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.t.Errorf("payload is not JSON: %v", err)
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, receivedWebhook{header: req.Header.Clone(), payload: payload})
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+	io.WriteString(w, strings.Repeat("x", 4096))
+}
+
+// verifyWebhookSignature checks a header the way a subscriber would and
+// returns what is wrong with it, or ""
+func verifyWebhookSignature(secret, header string, body []byte) string {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "missing timestamp in " + header
+	}
+	if age := time.Since(time.Unix(unix, 0)); age < -time.Minute || age > 5*time.Minute {
+		return "stale timestamp " + timestamp
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return "signature does not match body"
+	}
+	return ""
+}
+
+func newTestDelivery(maxAttempts int) *WebhookDelivery {
+	return &WebhookDelivery{
+		ID:             42,
+		SubscriptionID: 1,
+		EventType:      "order.created",
+		Payload:        `{"order_number":"ORD-1"}`,
+		Status:         WebhookProcessing,
+		MaxAttempts:    maxAttempts,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// newLocalWebhookService returns a service whose client may reach the
+// loopback test receivers the default client refuses
+func newLocalWebhookService() *WebhookService {
+	w := NewWebhookService(nil)
+	w.Client = &http.Client{Timeout: 5 * time.Second}
+	return w
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	header := SignWebhookPayload("secret", time.Now().Unix(), body)
+
+	if err := verifyWebhookSignature("secret", header, body); err != "" {
+		t.Fatalf("valid signature rejected: %s", err)
+	}
+	if err := verifyWebhookSignature("other", header, body); err == "" {
+		t.Error("signature verified with the wrong secret")
+	}
+	if err := verifyWebhookSignature("secret", header, []byte(`{"id":2}`)); err == "" {
+		t.Error("signature verified for a different body")
+	}
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	receiver := &webhookReceiver{t: t, secret: "whsec_test"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := newLocalWebhookService()
+	sub := &WebhookSubscription{ID: 1, URL: server.URL, Secret: EncryptedString(receiver.secret)}
+	delivery := newTestDelivery(3)
+
+	updates := w.attempt(sub, delivery)
+
+	if delivery.Status != WebhookSucceeded || updates["status"] != WebhookSucceeded {
+		t.Fatalf("status = %s, want %s (%s)", delivery.Status, WebhookSucceeded, delivery.LastError)
+	}
+	if delivery.Attempts != 1 || delivery.ResponseCode != http.StatusOK || delivery.DeliveredAt == nil {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if len(delivery.ResponseBody) != 2048 {
+		t.Errorf("response body kept %d bytes, want 2048", len(delivery.ResponseBody))
+	}
+	if _, ok := updates["locked_at"]; !ok {
+		t.Error("claim lock not cleared")
+	}
+
+	if len(receiver.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(receiver.requests))
+	}
+	got := receiver.requests[0]
+	if got.header.Get("X-Webhook-Event") != "order.created" || got.header.Get("X-Webhook-Delivery") != "42" {
+		t.Errorf("headers = %v", got.header)
+	}
+	if got.payload.ID != 42 || got.payload.Event != "order.created" || string(got.payload.Data) != delivery.Payload {
+		t.Errorf("payload = %+v", got.payload)
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	receiver := &webhookReceiver{t: t, secret: "whsec_test", statuses: []int{500, 502}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := newLocalWebhookService()
+	sub := &WebhookSubscription{ID: 1, URL: server.URL, Secret: EncryptedString(receiver.secret)}
+	delivery := newTestDelivery(5)
+
+	var lastDelay time.Duration
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		w.attempt(sub, delivery)
+		if delivery.Status != WebhookPending {
+			t.Fatalf("attempt %d: status = %s, want %s", attempt, delivery.Status, WebhookPending)
+		}
+		if !strings.Contains(delivery.LastError, "status 50") {
+			t.Errorf("attempt %d: last error = %q", attempt, delivery.LastError)
+		}
+		delay := delivery.NextAttemptAt.Sub(before)
+		if delay < 5*time.Second || delay <= lastDelay {
+			t.Errorf("attempt %d: retry in %v after %v, want a growing backoff", attempt, delay, lastDelay)
+		}
+		lastDelay = delay
+	}
+
+	w.attempt(sub, delivery)
+	if delivery.Status != WebhookSucceeded || delivery.Attempts != 3 || delivery.LastError != "" {
+		t.Fatalf("after recovery: %+v", delivery)
+	}
+	if len(receiver.requests) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(receiver.requests))
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	receiver := &webhookReceiver{t: t, secret: "whsec_test", statuses: []int{503, 503}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := newLocalWebhookService()
+	sub := &WebhookSubscription{ID: 1, URL: server.URL, Secret: EncryptedString(receiver.secret)}
+	delivery := newTestDelivery(2)
+
+	w.attempt(sub, delivery)
+	updates := w.attempt(sub, delivery)
+
+	if delivery.Status != WebhookFailed || updates["status"] != WebhookFailed {
+		t.Fatalf("status = %s, want %s", delivery.Status, WebhookFailed)
+	}
+	if _, ok := updates["next_attempt_at"]; ok {
+		t.Error("failed delivery was rescheduled")
+	}
+	if delivery.ResponseCode != http.StatusServiceUnavailable {
+		t.Errorf("response code = %d", delivery.ResponseCode)
+	}
+}
+
+func TestWebhookDeliveryUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	w := newLocalWebhookService()
+	w.Client.Timeout = time.Second
+	delivery := newTestDelivery(3)
+
+	w.attempt(&WebhookSubscription{ID: 1, URL: url, Secret: "s"}, delivery)
+
+	if delivery.Status != WebhookPending || delivery.ResponseCode != 0 || delivery.LastError == "" {
+		t.Errorf("delivery = %+v", delivery)
+	}
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	sub := WebhookSubscription{EventTypes: `["order.created","payment.created"]`}
+	if !sub.Subscribes("payment.created") || sub.Subscribes("stock.changed") {
+		t.Errorf("explicit event types not matched correctly")
+	}
+	all := WebhookSubscription{EventTypes: `["*"]`}
+	if !all.Subscribes("stock.changed") {
+		t.Error("wildcard subscription missed an event")
+	}
+}
+
+func TestWebhookPublicAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.10":     false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := publicAddress(net.ParseIP(addr)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestWebhookURLRejectsInternalHosts(t *testing.T) {
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+	} {
+		req := WebhookSubscriptionRequest{URL: url, EventTypes: []string{"*"}}
+		if err := req.validate(context.Background()); !errors.Is(err, ErrWebhookAddress) {
+			t.Errorf("validate(%s) = %v, want ErrWebhookAddress", url, err)
+		}
+	}
+}
+
+func TestWebhookDeliveryRefusesInternalAddress(t *testing.T) {
+	receiver := &webhookReceiver{t: t, secret: "whsec_test"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	w := NewWebhookService(nil)
+	delivery := newTestDelivery(3)
+
+	w.attempt(&WebhookSubscription{ID: 1, URL: server.URL, Secret: "s"}, delivery)
+
+	if delivery.Status != WebhookPending || !strings.Contains(delivery.LastError, ErrWebhookAddress.Error()) {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.requests) != 0 {
+		t.Error("request reached an internal address")
+	}
+}
@@ -2,67 +2,173 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"gorm.io/gorm"
 )
 
 // ========================================
 // WEBSOCKET MANAGER
 // ========================================
 
+// Each connection has a buffered send queue drained by its own writer, so a
+// slow or stalled client delays nobody else. A client whose queue fills up
+// is disconnected; it catches up from the event log when it reconnects.
+//
+// Clients identify themselves before the upgrade, with a user's access token
+// or a device token, and may only subscribe to the rooms that allows.
+
+const (
+	// clientQueueSize is how many messages may wait for a slow client
+	clientQueueSize = 256
+	// clientWriteTimeout bounds a single write to a client
+	clientWriteTimeout = 10 * time.Second
+)
+
 // WebSocketManager manages all WebSocket connections
 type WebSocketManager struct {
-	Clients   map[*websocket.Conn]bool
-	Broadcast  chan []byte
-	Register   chan *websocket.Conn
+	Clients    map[*websocket.Conn]*wsClient
+	Rooms      map[string]map[*websocket.Conn]bool
 	Unregister chan *websocket.Conn
+	Events     *EventLog
+
+	// Authorize identifies a client before the upgrade and returns the check
+	// for the rooms it may join; it writes the response and returns nil to
+	// refuse the connection
+	Authorize func(c *gin.Context) func(room string) bool
+	// AllowedOrigins are browser origins other than the server's own that
+	// may connect
+	AllowedOrigins []string
+
+	// OnHeartbeat resolves and records a device heartbeat, returning the
+	// device ID; OnDeviceGone is told when a device's last connection closes
+	OnHeartbeat  func(hb DeviceHeartbeat) (uint, error)
+	OnDeviceGone func(deviceID uint)
+
+	// mu guards Clients, Rooms and devices and orders queueing, so a replay
+	// sent to a subscribing client can't interleave with live events
+	mu      sync.Mutex
+	devices map[*websocket.Conn]uint
+}
+
+// wsClient is a connection and its outgoing queue
+type wsClient struct {
+	conn    *websocket.Conn
+	send    chan []byte
+	canJoin func(room string) bool
+	// closed is set once send is closed; guarded by WebSocketManager.mu
+	closed bool
+}
+
+// DeviceHeartbeat is sent by registered devices every 30 seconds or so
+type DeviceHeartbeat struct {
+	Type        string `json:"type"`
+	DeviceToken string `json:"device_token"`
+	AppVersion  string `json:"app_version"`
 }
 
 // NewWebSocketManager creates new WebSocket manager
-func NewWebSocketManager() *WebSocketManager {
+func NewWebSocketManager(events *EventLog) *WebSocketManager {
 	return &WebSocketManager{
-		Clients:   make(map[*websocket.Conn]bool),
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *websocket.Conn),
+		Clients:    make(map[*websocket.Conn]*wsClient),
+		Rooms:      make(map[string]map[*websocket.Conn]bool),
 		Unregister: make(chan *websocket.Conn),
+		Events:     events,
+		devices:    make(map[*websocket.Conn]uint),
 	}
 }
 
 // HandleWebSocket handles WebSocket connection
 func (m *WebSocketManager) HandleWebSocket() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if m.Authorize == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WebSocket is not available"})
+			return
+		}
+		canJoin := m.Authorize(c)
+		if canJoin == nil {
+			return
+		}
+
 		// Upgrade HTTP to WebSocket
 		upgrader := websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins (in production, validate origin)
-			},
+			CheckOrigin:     m.checkOrigin,
 		}
 
-		conn, err := upgrader.Upgrade(c.Writer, c.Request)
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			log.Println("WebSocket upgrade error:", err)
 			return
 		}
 
-		// Register client
-		m.Register <- conn
+		// Register client before reading, so its first subscribe finds it
+		m.register(conn, canJoin)
 
 		// Handle incoming messages
 		go m.handleConnection(conn)
 	}
 }
 
+// checkOrigin accepts native clients, which send no Origin, the server's
+// own origin and AllowedOrigins
+func (m *WebSocketManager) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return containsString(m.AllowedOrigins, origin)
+}
+
+// register adds a connection and starts its writer
+func (m *WebSocketManager) register(conn *websocket.Conn, canJoin func(room string) bool) {
+	client := &wsClient{conn: conn, send: make(chan []byte, clientQueueSize), canJoin: canJoin}
+
+	m.mu.Lock()
+	m.Clients[conn] = client
+	log.Println("Client connected. Total clients:", len(m.Clients))
+	m.mu.Unlock()
+
+	go client.writePump()
+}
+
+// writePump sends queued messages until the queue is closed or a write
+// fails, then closes the connection
+func (c *wsClient) writePump() {
+	defer c.conn.Close()
+	for data := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Println("Error sending message to client:", err)
+			return
+		}
+	}
+}
+
+// SubscribeMessage is sent by clients to join a room. LastSeq is the last
+// sequence the client saw in that room; when set, missed events are replayed.
+type SubscribeMessage struct {
+	Type    string  `json:"type"`
+	Room    string  `json:"room"`
+	LastSeq *uint64 `json:"last_seq,omitempty"`
+}
+
+// handleConnection reads client messages. When it returns the client is
+// unregistered; its writer flushes what is queued and closes the connection.
 func (m *WebSocketManager) handleConnection(conn *websocket.Conn) {
 	defer func() {
 		m.Unregister <- conn
-		conn.Close()
 	}()
 
 	// Read messages
@@ -73,81 +179,314 @@ func (m *WebSocketManager) handleConnection(conn *websocket.Conn) {
 		}
 
 		if messageType == websocket.TextMessage {
-			var message map[string]interface{}
-			json.Unmarshal(p, &message)
+			var message SubscribeMessage
+			if err := json.Unmarshal(p, &message); err != nil {
+				continue
+			}
 
 			// Handle client requests
-			if message["type"] == "subscribe" {
-				// Client wants to subscribe to a room
-				// In production, implement room logic
-				log.Printf("Client subscribed to: %v", message["room"])
+			switch message.Type {
+			case "subscribe":
+				m.subscribe(conn, message.Room, message.LastSeq)
+			case "unsubscribe":
+				m.unsubscribe(conn, message.Room)
+			case "heartbeat":
+				if !m.heartbeat(conn, p) {
+					return
+				}
 			}
 		}
 	}
 }
 
+// subscribe adds conn to room and, if lastSeq is given, replays what the
+// client missed or tells it to resync when the gap can't be served or
+// wouldn't fit in the client's send queue
+func (m *WebSocketManager) subscribe(conn *websocket.Conn, room string, lastSeq *uint64) {
+	if room == "" {
+		return
+	}
+
+	// Missed events may come from the database, so look them up before
+	// taking the lock
+	var replay [][]byte
+	replayOK := true
+	var replayed uint64
+	if lastSeq != nil && m.Events != nil {
+		replay, replayOK = m.Events.Since(room, *lastSeq)
+		replayed = *lastSeq + uint64(len(replay))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client := m.Clients[conn]
+	if client == nil || client.closed {
+		return
+	}
+	if client.canJoin == nil || !client.canJoin(room) {
+		m.writeJSON(conn, map[string]interface{}{
+			"type": "subscribe_denied",
+			"room": room,
+		})
+		return
+	}
+
+	if m.Rooms[room] == nil {
+		m.Rooms[room] = make(map[*websocket.Conn]bool)
+	}
+	m.Rooms[room][conn] = true
+
+	var head uint64
+	if m.Events != nil {
+		head = m.Events.Head(room)
+	}
+
+	if lastSeq != nil && m.Events != nil {
+		// Events published since the lookup are still in memory
+		var latest [][]byte
+		if replayOK {
+			latest, replayOK = m.Events.Recent(room, replayed)
+		}
+		// Leave room for the subscribed message
+		free := cap(client.send) - len(client.send) - 1
+		if !replayOK || len(replay)+len(latest) > free {
+			m.writeJSON(conn, map[string]interface{}{
+				"type": "resync_required",
+				"room": room,
+				"seq":  head,
+			})
+			return
+		}
+		for _, event := range replay {
+			m.write(conn, event)
+		}
+		for _, event := range latest {
+			m.write(conn, event)
+		}
+	}
+
+	m.writeJSON(conn, map[string]interface{}{
+		"type": "subscribed",
+		"room": room,
+		"seq":  head,
+	})
+}
+
+// heartbeat records a device heartbeat and acknowledges it. Unknown and
+// revoked devices are told so and false is returned to drop the connection.
+func (m *WebSocketManager) heartbeat(conn *websocket.Conn, p []byte) bool {
+	var hb DeviceHeartbeat
+	if m.OnHeartbeat == nil || json.Unmarshal(p, &hb) != nil {
+		return true
+	}
+	deviceID, err := m.OnHeartbeat(hb)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.writeJSON(conn, map[string]interface{}{
+			"type":  "device_rejected",
+			"error": err.Error(),
+		})
+		return false
+	}
+	m.devices[conn] = deviceID
+	m.writeJSON(conn, map[string]interface{}{
+		"type":        "heartbeat_ack",
+		"device_id":   deviceID,
+		"server_time": time.Now(),
+	})
+	return true
+}
+
+// DisconnectDevice tells a device's connections why and closes them, as
+// when the device is revoked
+func (m *WebSocketManager) DisconnectDevice(deviceID uint, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for conn, id := range m.devices {
+		if id != deviceID {
+			continue
+		}
+		m.writeJSON(conn, map[string]interface{}{
+			"type":  "device_rejected",
+			"error": reason,
+		})
+		if client := m.Clients[conn]; client != nil {
+			client.close()
+		}
+	}
+}
+
+// unsubscribe removes conn from room
+func (m *WebSocketManager) unsubscribe(conn *websocket.Conn, room string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if clients, ok := m.Rooms[room]; ok {
+		delete(clients, conn)
+	}
+}
+
 // Run starts the WebSocket manager
 func (m *WebSocketManager) Run() {
-	for {
-		select {
-		case client := <-m.Register:
-			m.Clients[client] = true
-			log.Println("Client connected. Total clients:", len(m.Clients))
-
-		case client := <-m.Unregister:
-			delete(m.Clients, client)
-			log.Println("Client disconnected. Total clients:", len(m.Clients))
-
-		case message := <-m.Broadcast:
-			// Send message to all clients
-			for client := range m.Clients {
-				err := client.WriteMessage(websocket.TextMessage, message)
-				if err != nil {
-					log.Println("Error sending message to client:", err)
-					delete(m.Clients, client)
-				}
-			}
+	for client := range m.Unregister {
+		m.mu.Lock()
+		deviceID, gone := m.removeClient(client)
+		log.Println("Client disconnected. Total clients:", len(m.Clients))
+		m.mu.Unlock()
+		if gone && m.OnDeviceGone != nil {
+			m.OnDeviceGone(deviceID)
 		}
 	}
 }
 
-// Broadcast sends message to all clients
-func (m *WebSocketManager) Broadcast(message interface{}) error {
-	data, err := json.Marshal(message)
-	if err != nil {
-		return err
+// close stops the client's writer once it has sent what is queued. Callers
+// must hold m.mu.
+func (c *wsClient) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.send)
 	}
+}
 
-	m.Broadcast <- data
-	return nil
+// removeClient drops a client from every room and closes its queue. When it
+// was a device's last connection it returns the device ID and true. Callers
+// must hold m.mu.
+func (m *WebSocketManager) removeClient(client *websocket.Conn) (uint, bool) {
+	if c := m.Clients[client]; c != nil {
+		c.close()
+	}
+	delete(m.Clients, client)
+	for _, clients := range m.Rooms {
+		delete(clients, client)
+	}
+
+	deviceID, ok := m.devices[client]
+	if !ok {
+		return 0, false
+	}
+	delete(m.devices, client)
+	for _, id := range m.devices {
+		if id == deviceID {
+			return 0, false
+		}
+	}
+	return deviceID, true
+}
+
+// Broadcast sends message to all clients
+func (m *WebSocketManager) Broadcast(message interface{}) error {
+	return m.publish("all", message)
 }
 
 // SendToClient sends message to specific client
 func (m *WebSocketManager) SendToClient(client *websocket.Conn, message interface{}) error {
-	data, err := json.Marshal(message)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.writeJSON(client, message)
+}
+
+// SendToRoom sends message to clients subscribed to a room
+func (m *WebSocketManager) SendToRoom(room string, message interface{}) error {
+	return m.publish(room, message)
+}
+
+// publish stamps message with the room's next sequence number, records it in
+// the event log and queues it for delivery. Room "all" goes to every
+// connected client. The event is saved to the database after the lock is
+// released, so a slow database doesn't hold up other broadcasts.
+func (m *WebSocketManager) publish(room string, message interface{}) error {
+	m.mu.Lock()
+
+	var event *WSEvent
+	var data []byte
+	var err error
+	if m.Events != nil {
+		event, err = m.Events.Append(room, func(seq uint64) interface{} {
+			return withSequence(message, room, seq)
+		})
+		if event != nil {
+			data = []byte(event.Payload)
+		}
+	} else {
+		data, err = json.Marshal(message)
+	}
 	if err != nil {
+		m.mu.Unlock()
 		return err
 	}
 
-	return client.WriteMessage(websocket.TextMessage, data)
+	if room == "all" {
+		for client := range m.Clients {
+			m.write(client, data)
+		}
+	} else {
+		for client := range m.Rooms[room] {
+			m.write(client, data)
+		}
+	}
+	m.mu.Unlock()
+
+	if event != nil {
+		m.Events.Persist(event)
+	}
+	return nil
 }
 
-// SendToRoom sends message to clients in a room (simplified - in production, use room management)
-func (m *WebSocketManager) SendToRoom(room string, message interface{}) error {
+// write queues raw data for a client. A client whose queue is full is
+// disconnected rather than allowed to hold up everyone else. Callers must
+// hold m.mu.
+func (m *WebSocketManager) write(client *websocket.Conn, data []byte) error {
+	c := m.Clients[client]
+	if c == nil || c.closed {
+		return errors.New("client is disconnected")
+	}
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		log.Println("Client is not keeping up; disconnecting it")
+		c.close()
+		return errors.New("client send queue is full")
+	}
+}
+
+// writeJSON marshals and sends message to a client. Callers must hold m.mu.
+func (m *WebSocketManager) writeJSON(client *websocket.Conn, message interface{}) error {
 	data, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
+	return m.write(client, data)
+}
 
-	// Send to all clients for now (in production, implement room filtering)
-	for client := range m.Clients {
-		err := client.WriteMessage(websocket.TextMessage, data)
-		if err != nil {
-			delete(m.Clients, client)
+// withSequence returns message with its room and sequence filled in
+func withSequence(message interface{}, room string, seq uint64) interface{} {
+	switch msg := message.(type) {
+	case Notification:
+		msg.Room = room
+		msg.Seq = seq
+		return msg
+	case *Notification:
+		copied := *msg
+		copied.Room = room
+		copied.Seq = seq
+		return copied
+	case map[string]interface{}:
+		stamped := make(map[string]interface{}, len(msg)+2)
+		for k, v := range msg {
+			stamped[k] = v
 		}
+		stamped["room"] = room
+		stamped["seq"] = seq
+		return stamped
+	default:
+		return message
 	}
-
-	return nil
 }
 
 // ========================================
@@ -165,6 +504,8 @@ type Notification struct {
 	UserID    uint                   `json:"user_id,omitempty"`
 	Timestamp string                 `json:"timestamp"`
 	Room      string                 `json:"room,omitempty"`
+	Seq       uint64                 `json:"seq,omitempty"`
+	Sound     string                 `json:"sound,omitempty"`
 }
 
 // ========================================
@@ -322,6 +663,26 @@ func (m *WebSocketManager) CreateKitchenTicketNotification(order Order) error {
 	return m.SendToRoom("kitchen", soundNotification)
 }
 
+// CreatePrinterStatusNotification alerts the kitchen that a printer went
+// offline (err set) or came back online
+func (m *WebSocketManager) CreatePrinterStatusNotification(printer Printer, err error) error {
+	notification := Notification{
+		Type:      "printer_status",
+		Action:    PrinterOnline,
+		Data:      printer,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Room:      "kitchen",
+	}
+	if err != nil {
+		notification.Action = PrinterOffline
+		notification.Data = gin.H{"printer": printer, "error": err.Error()}
+		notification.Sound = "alert.mp3"
+	}
+
+	m.SendToRoom("dashboard", notification)
+	return m.SendToRoom("kitchen", notification)
+}
+
 // CreateCustomerNotification creates customer notification
 func (m *WebSocketManager) CreateCustomerNotification(customer Customer) error {
 	notification := Notification{
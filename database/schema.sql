@@ -16,18 +16,197 @@ CREATE TABLE IF NOT EXISTS users (
     password VARCHAR(255) NOT NULL,
     name VARCHAR(255) NOT NULL,
     name_ar VARCHAR(255),
-    role ENUM('super_admin', 'manager', 'cashier', 'waiter', 'kitchen', 'bar') DEFAULT 'staff',
+    role VARCHAR(50) NOT NULL DEFAULT 'cashier',
     phone VARCHAR(20),
     avatar VARCHAR(500),
     is_active BOOLEAN DEFAULT TRUE,
     last_login TIMESTAMP NULL,
+    location VARCHAR(100) DEFAULT '',
+    pin_hash VARCHAR(255),
+    pin_set_at TIMESTAMP NULL,
+    failed_pin_attempts INT DEFAULT 0,
+    pin_locked_until TIMESTAMP NULL,
+    failed_login_attempts INT DEFAULT 0,
+    last_failed_login_at TIMESTAMP NULL,
+    login_locked_until TIMESTAMP NULL,
+    must_change_password BOOLEAN DEFAULT FALSE,
+    password_changed_at TIMESTAMP NULL,
+    totp_secret VARCHAR(255),
+    totp_enabled BOOLEAN DEFAULT FALSE,
+    totp_enabled_at TIMESTAMP NULL,
+    totp_last_step BIGINT DEFAULT 0,
+    -- service users act for an API key and can't sign in
+    is_service BOOLEAN DEFAULT FALSE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX idx_email (email),
+    INDEX idx_location (location),
     INDEX idx_role (role),
     INDEX idx_is_active (is_active)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+CREATE TABLE IF NOT EXISTS roles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    name_ar VARCHAR(255),
+    description VARCHAR(500),
+    is_system BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(100) UNIQUE NOT NULL,
+    category VARCHAR(50),
+    description VARCHAR(500)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT NOT NULL,
+    permission_id INT NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS devices (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    kind ENUM('pos', 'kds', 'handheld', 'customer_display') NOT NULL DEFAULT 'pos',
+    location VARCHAR(100) DEFAULT '',
+    station VARCHAR(50) DEFAULT '',
+    token_hash CHAR(64) UNIQUE NULL,
+    pairing_code_hash CHAR(64) NULL,
+    pairing_expires_at TIMESTAMP NULL,
+    paired_at TIMESTAMP NULL,
+    auto_lock_minutes INT DEFAULT 5,
+    failed_pin_attempts INT DEFAULT 0,
+    pin_locked_until TIMESTAMP NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    revoked_at TIMESTAMP NULL,
+    app_version VARCHAR(50) DEFAULT '',
+    connected BOOLEAN DEFAULT FALSE,
+    last_seen_at TIMESTAMP NULL,
+    last_ip VARCHAR(45) DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_kind (kind),
+    INDEX idx_location (location),
+    INDEX idx_pairing_code_hash (pairing_code_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    device_id INT NULL,
+    idle_timeout INT DEFAULT 0,
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    revoked_reason VARCHAR(50),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE SET NULL,
+    INDEX idx_user_id (user_id),
+    INDEX idx_device_id (device_id),
+    INDEX idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS manager_overrides (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    action VARCHAR(50) NOT NULL,
+    permission VARCHAR(100) NOT NULL,
+    entity VARCHAR(50),
+    entity_id INT,
+    summary VARCHAR(500),
+    details JSON,
+    fingerprint CHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    requested_by INT NOT NULL,
+    session_id INT,
+    device_id INT NULL,
+    decided_by INT NULL,
+    method VARCHAR(10),
+    reason VARCHAR(500),
+    decided_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (requested_by) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_action (action),
+    INDEX idx_fingerprint (fingerprint),
+    INDEX idx_status (status),
+    INDEX idx_requested_by (requested_by)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    session_id INT NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES auth_sessions(id) ON DELETE CASCADE,
+    INDEX idx_session_id (session_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Single-use 2FA recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_id (user_id),
+    INDEX idx_code_hash (code_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Credentials of integrations and machine clients; each acts as a service user
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    previous_key_hash CHAR(64),
+    previous_expires_at TIMESTAMP NULL,
+    scopes JSON NOT NULL,
+    user_id INT NOT NULL,
+    rate_limit_per_minute INT DEFAULT 0,
+    rate_limit_burst INT DEFAULT 0,
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    last_used_ip VARCHAR(45),
+    revoked_at TIMESTAMP NULL,
+    created_by INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    INDEX idx_previous_key_hash (previous_key_hash),
+    INDEX idx_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Provider secrets, encrypted; they override the matching environment variables
+CREATE TABLE IF NOT EXISTS integration_credentials (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    value TEXT NOT NULL,
+    updated_by INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Token buckets of the database rate limit store (RATE_LIMIT_BACKEND=database)
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key VARCHAR(191) PRIMARY KEY,
+    tokens DOUBLE NOT NULL,
+    updated_at DATETIME(3) NOT NULL,
+    INDEX idx_updated_at (updated_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- ========================================
 -- RESTAURANT SETTINGS
 -- ========================================
@@ -40,11 +219,13 @@ CREATE TABLE IF NOT EXISTS restaurant_settings (
     address TEXT,
     phone VARCHAR(20),
     email VARCHAR(255),
+    tax_number VARCHAR(50),
     currency VARCHAR(10) DEFAULT 'EGP',
     currency_symbol VARCHAR(10) DEFAULT 'ج.م',
     tax_rate DECIMAL(5,4) DEFAULT 0.1400,
     service_charge DECIMAL(5,4) DEFAULT 0.1000,
     language VARCHAR(10) DEFAULT 'ar',
+    timezone VARCHAR(64) DEFAULT 'Africa/Cairo',
     theme_color VARCHAR(20) DEFAULT '#10b981',
     is_open BOOLEAN DEFAULT TRUE,
     receipt_header TEXT,
@@ -96,6 +277,8 @@ CREATE TABLE IF NOT EXISTS menu_items (
     preparation_time INT,
     is_modifier_only BOOLEAN DEFAULT FALSE,
     order_count INT DEFAULT 0,
+    eta_item_type VARCHAR(10),
+    eta_item_code VARCHAR(100),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
@@ -171,12 +354,13 @@ CREATE TABLE IF NOT EXISTS tables (
 CREATE TABLE IF NOT EXISTS reservations (
     id INT AUTO_INCREMENT PRIMARY KEY,
     customer_name VARCHAR(255) NOT NULL,
-    customer_phone VARCHAR(20) NOT NULL,
+    customer_phone VARCHAR(255) NOT NULL, -- encrypted
     table_id INT,
     party_size INT NOT NULL,
     reservation_time TIMESTAMP NOT NULL,
     status ENUM('pending', 'confirmed', 'seated', 'cancelled', 'no_show') DEFAULT 'pending',
     notes TEXT,
+    reminder_sent_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     FOREIGN KEY (table_id) REFERENCES tables(id) ON DELETE SET NULL,
@@ -193,13 +377,15 @@ CREATE TABLE IF NOT EXISTS orders (
     order_number VARCHAR(50) UNIQUE NOT NULL,
     table_id INT,
     user_id INT NOT NULL,
+    device_id INT NULL,
     type ENUM('dine_in', 'takeaway', 'delivery', 'online') DEFAULT 'dine_in',
     status ENUM('pending', 'confirmed', 'preparing', 'ready', 'served', 'completed', 'cancelled') DEFAULT 'pending',
     priority ENUM('low', 'normal', 'high', 'urgent') DEFAULT 'normal',
+    guests INT DEFAULT 0,
 
     customer_name VARCHAR(255),
-    customer_phone VARCHAR(20),
-    customer_address TEXT,
+    customer_phone VARCHAR(255), -- encrypted
+    customer_address TEXT, -- encrypted
 
     subtotal DECIMAL(10,2) DEFAULT 0,
     tax_amount DECIMAL(10,2) DEFAULT 0,
@@ -222,10 +408,16 @@ CREATE TABLE IF NOT EXISTS orders (
     kitchen_notes TEXT,
     voice_note_url VARCHAR(500),
 
+    eta_uuid VARCHAR(64),
+    eta_status VARCHAR(20),
+    eta_qr_url VARCHAR(500),
+
     FOREIGN KEY (table_id) REFERENCES tables(id) ON DELETE SET NULL,
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE SET NULL,
     INDEX idx_table_id (table_id),
     INDEX idx_user_id (user_id),
+    INDEX idx_device_id (device_id),
     INDEX idx_status (status),
     INDEX idx_created_at (created_at),
     INDEX idx_order_number (order_number)
@@ -256,6 +448,7 @@ CREATE TABLE IF NOT EXISTS payments (
     id INT AUTO_INCREMENT PRIMARY KEY,
     order_id INT NOT NULL,
     user_id INT NOT NULL,
+    device_id INT NULL,
     type ENUM('sale', 'refund', 'tip') DEFAULT 'sale',
     method ENUM('cash', 'card', 'mobile_wallet', 'credit', 'split') DEFAULT 'cash',
     amount DECIMAL(10,2) NOT NULL,
@@ -265,6 +458,7 @@ CREATE TABLE IF NOT EXISTS payments (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE SET NULL,
     INDEX idx_order_id (order_id),
     INDEX idx_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -285,10 +479,12 @@ CREATE TABLE IF NOT EXISTS stock_items (
     supplier VARCHAR(255),
     is_low_stock BOOLEAN DEFAULT FALSE,
     last_reorder_at TIMESTAMP NULL,
+    expiry_date TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX idx_sku (sku),
-    INDEX idx_is_low_stock (is_low_stock)
+    INDEX idx_is_low_stock (is_low_stock),
+    INDEX idx_expiry_date (expiry_date)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 CREATE TABLE IF NOT EXISTS stock_movements (
@@ -305,6 +501,33 @@ CREATE TABLE IF NOT EXISTS stock_movements (
     INDEX idx_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+-- Forecast overrides: holidays and events that scale the sales forecast
+CREATE TABLE IF NOT EXISTS forecast_overrides (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    date VARCHAR(10) NOT NULL,
+    daypart VARCHAR(50) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    name_ar VARCHAR(255),
+    factor DECIMAL(5,2) NOT NULL DEFAULT 1,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_forecast_override (date, daypart)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Recipe lines: stock used by one unit of a menu item
+CREATE TABLE IF NOT EXISTS recipe_lines (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    menu_item_id INT NOT NULL,
+    stock_item_id INT NOT NULL,
+    quantity DECIMAL(10,3) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (menu_item_id) REFERENCES menu_items(id) ON DELETE CASCADE,
+    FOREIGN KEY (stock_item_id) REFERENCES stock_items(id) ON DELETE CASCADE,
+    UNIQUE KEY idx_recipe_item_stock (menu_item_id, stock_item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- ========================================
 -- STAFF
 -- ========================================
@@ -329,17 +552,22 @@ CREATE TABLE IF NOT EXISTS shifts (
 CREATE TABLE IF NOT EXISTS customers (
     id INT AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
-    phone VARCHAR(20) UNIQUE NOT NULL,
-    email VARCHAR(255),
+    -- phone, email and address are encrypted; phone_index is an HMAC of the
+    -- E.164 phone for lookups
+    phone VARCHAR(255) NOT NULL,
+    phone_index CHAR(64) UNIQUE,
+    email TEXT,
     address TEXT,
     points INT DEFAULT 0,
     total_spent DECIMAL(10,2) DEFAULT 0,
     visits_count INT DEFAULT 0,
     birthday DATE,
     preferences JSON,
+    whatsapp_opt_out BOOLEAN DEFAULT FALSE,
+    sms_opt_out BOOLEAN DEFAULT FALSE,
+    opted_out_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    INDEX idx_phone (phone)
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 CREATE TABLE IF NOT EXISTS loyalty_transactions (
@@ -388,8 +616,10 @@ CREATE TABLE IF NOT EXISTS discounts (
 CREATE TABLE IF NOT EXISTS receipt_templates (
     id INT AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'receipt',
     is_default BOOLEAN DEFAULT FALSE,
     template_html TEXT NOT NULL,
+    template_text TEXT,
     css_styles TEXT,
     show_logo BOOLEAN DEFAULT TRUE,
     show_qr BOOLEAN DEFAULT FALSE,
@@ -406,8 +636,50 @@ CREATE TABLE IF NOT EXISTS printers (
     is_default BOOLEAN DEFAULT FALSE,
     is_active BOOLEAN DEFAULT TRUE,
     print_categories JSON,
+    paper_width INT DEFAULT 80,
+    code_page VARCHAR(20) DEFAULT 'PC864',
+    code_page_table INT DEFAULT 0,
+    open_drawer BOOLEAN DEFAULT FALSE,
+    backup_printer_id INT,
+    status VARCHAR(20) DEFAULT 'unknown',
+    last_error TEXT,
+    last_seen_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (backup_printer_id) REFERENCES printers(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS device_printers (
+    device_id INT NOT NULL,
+    printer_id INT NOT NULL,
+    PRIMARY KEY (device_id, printer_id),
+    FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE,
+    FOREIGN KEY (printer_id) REFERENCES printers(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS print_jobs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    printer_id INT NOT NULL,
+    failed_over_from INT,
+    order_id INT,
+    kind VARCHAR(20) NOT NULL,
+    reference VARCHAR(100),
+    reprint_of INT,
+    data MEDIUMBLOB NOT NULL,
+    status ENUM('pending', 'printing', 'printed', 'failed', 'cancelled') DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 4,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMP NULL,
+    last_error TEXT,
+    printed_at TIMESTAMP NULL,
+    requested_by INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (printer_id) REFERENCES printers(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL,
+    INDEX idx_print_jobs_due (printer_id, status, next_attempt_at),
+    INDEX idx_reference (reference)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- ========================================
@@ -438,22 +710,239 @@ CREATE TABLE IF NOT EXISTS daily_reports (
 -- AUDIT LOGS
 -- ========================================
 
+-- Entries are hash-chained (prev_hash -> hash) and must never be updated or
+-- deleted, so there are no foreign keys; user_id 0 is the system.
 CREATE TABLE IF NOT EXISTS audit_logs (
     id INT AUTO_INCREMENT PRIMARY KEY,
-    user_id INT NOT NULL,
+    user_id INT NOT NULL DEFAULT 0,
+    approver_id INT,
     action VARCHAR(100) NOT NULL,
     entity VARCHAR(100),
     entity_id INT,
     changes JSON,
     metadata JSON,
+    request_id VARCHAR(64),
+    session_id INT,
+    api_key_id INT,
+    device_id INT,
     ip_address VARCHAR(45),
     user_agent TEXT,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
     INDEX idx_user_id (user_id),
+    INDEX idx_approver_id (approver_id),
+    INDEX idx_action (action),
+    INDEX idx_audit_entity (entity, entity_id),
+    INDEX idx_request_id (request_id),
+    INDEX idx_api_key_id (api_key_id),
+    INDEX idx_device_id (device_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The newest entry of the chain, in a single row (id 1) that is locked
+-- while appending
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+    id INT PRIMARY KEY,
+    entry_id INT NOT NULL DEFAULT 0,
+    hash VARCHAR(64),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- ========================================
+-- REAL-TIME EVENTS
+-- ========================================
+
+CREATE TABLE IF NOT EXISTS ws_events (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    room VARCHAR(100) NOT NULL,
+    seq BIGINT UNSIGNED NOT NULL,
+    type VARCHAR(100),
+    payload JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_room_seq (room, seq),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    aggregate_type VARCHAR(50) NOT NULL,
+    aggregate_id INT NOT NULL,
+    destination VARCHAR(100) NOT NULL,
+    payload JSON NOT NULL,
+    status ENUM('pending', 'processing', 'delivered', 'dead') DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 10,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMP NULL,
+    last_error TEXT,
+    delivered_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_outbox_due (status, next_attempt_at),
+    INDEX idx_event_type (event_type)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- ========================================
+-- INTEGRATIONS
+-- ========================================
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    url VARCHAR(1000) NOT NULL,
+    event_types JSON NOT NULL,
+    secret TEXT NOT NULL, -- encrypted
+    description TEXT,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_is_active (is_active)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    subscription_id INT NOT NULL,
+    outbox_event_id INT,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSON NOT NULL,
+    status ENUM('pending', 'processing', 'succeeded', 'failed') DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 8,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMP NULL,
+    response_code INT,
+    response_body TEXT,
+    duration_ms BIGINT,
+    last_error TEXT,
+    delivered_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    INDEX idx_webhook_due (status, next_attempt_at),
+    INDEX idx_outbox_event_id (outbox_event_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS eta_receipts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    order_id INT NOT NULL,
+    receipt_number VARCHAR(100) NOT NULL,
+    uuid VARCHAR(64) NOT NULL UNIQUE,
+    previous_uuid VARCHAR(64),
+    device_serial VARCHAR(100) NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    total DECIMAL(12, 5) DEFAULT 0,
+    status ENUM('pending', 'submitting', 'submitted', 'valid', 'invalid', 'rejected') DEFAULT 'pending',
+    submission_id VARCHAR(100),
+    long_id VARCHAR(255),
+    qr_url VARCHAR(500),
+    attempts INT DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    submitted_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    INDEX idx_order_id (order_id),
+    INDEX idx_device_serial (device_serial),
+    INDEX idx_eta_receipts_due (status, next_attempt_at),
+    INDEX idx_submission_id (submission_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS email_messages (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    sender VARCHAR(255) NOT NULL,
+    recipients TEXT NOT NULL,
+    subject VARCHAR(500),
+    message MEDIUMTEXT NOT NULL,
+    size INT DEFAULT 0,
+    status ENUM('pending', 'sending', 'sent', 'failed') DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 6,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    sent_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_email_due (status, next_attempt_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS message_templates (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    channel ENUM('whatsapp', 'sms') NOT NULL,
+    external_name VARCHAR(255) NOT NULL,
+    language VARCHAR(10) DEFAULT 'ar',
+    params JSON,
+    status ENUM('approved', 'pending', 'rejected') DEFAULT 'approved',
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_message_template (kind, channel)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS message_logs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    channel ENUM('whatsapp', 'sms') NOT NULL,
+    provider VARCHAR(50),
+    recipient VARCHAR(30) NOT NULL,
+    customer_id INT,
+    order_id INT,
+    kind VARCHAR(50),
+    template_name VARCHAR(255),
+    body TEXT,
+    status ENUM('queued', 'sent', 'delivered', 'read', 'failed', 'blocked') DEFAULT 'queued',
+    provider_message_id VARCHAR(255),
+    error TEXT,
+    sent_at TIMESTAMP NULL,
+    delivered_at TIMESTAMP NULL,
+    read_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE SET NULL,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL,
+    INDEX idx_recipient (recipient),
+    INDEX idx_provider_message_id (provider_message_id),
     INDEX idx_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description VARCHAR(255),
+    schedule VARCHAR(100) NOT NULL,
+    params JSON,
+    is_active BOOLEAN DEFAULT TRUE,
+    next_run_at TIMESTAMP NULL,
+    last_run_at TIMESTAMP NULL,
+    last_status VARCHAR(20),
+    last_error TEXT,
+    locked_by VARCHAR(255),
+    locked_until TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_next_run_at (next_run_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS job_runs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    job_id INT NOT NULL,
+    job_name VARCHAR(100) NOT NULL,
+    triggered_by VARCHAR(20),
+    status ENUM('running', 'succeeded', 'failed') DEFAULT 'running',
+    instance VARCHAR(255),
+    output TEXT,
+    error TEXT,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    duration_ms BIGINT DEFAULT 0,
+    FOREIGN KEY (job_id) REFERENCES scheduled_jobs(id) ON DELETE CASCADE,
+    INDEX idx_job_id (job_id),
+    INDEX idx_started_at (started_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- ========================================
 -- DONE
 -- ========================================